@@ -0,0 +1,377 @@
+package caddyjwt
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+	"go.uber.org/zap"
+)
+
+func init() {
+	caddy.RegisterModule(adminAPI{})
+}
+
+// adminAPI is a module that serves the /jwtauth/ endpoints of the Caddy
+// admin API.
+type adminAPI struct{}
+
+// CaddyModule implements caddy.Module interface.
+func (adminAPI) CaddyModule() caddy.ModuleInfo {
+	return caddy.ModuleInfo{
+		ID:  "admin.api.jwtauth",
+		New: func() caddy.Module { return new(adminAPI) },
+	}
+}
+
+// Routes implements caddy.AdminRouter interface.
+func (a adminAPI) Routes() []caddy.AdminRoute {
+	return []caddy.AdminRoute{
+		{
+			Pattern: "/jwtauth/stats",
+			Handler: caddy.AdminHandlerFunc(a.handleStats),
+		},
+		{
+			Pattern: "/jwtauth/cache/purge",
+			Handler: caddy.AdminHandlerFunc(a.handlePurgeCache),
+		},
+		{
+			Pattern: "/jwtauth/revocations",
+			Handler: caddy.AdminHandlerFunc(a.handleRevoke),
+		},
+		{
+			Pattern: "/jwtauth/blocked-kids",
+			Handler: caddy.AdminHandlerFunc(a.handleBlockedKIDs),
+		},
+		{
+			Pattern: "/jwtauth/maintenance",
+			Handler: caddy.AdminHandlerFunc(a.handleMaintenance),
+		},
+		{
+			Pattern: "/jwtauth/keys",
+			Handler: caddy.AdminHandlerFunc(a.handleKeys),
+		},
+		{
+			Pattern: "/jwtauth/health",
+			Handler: caddy.AdminHandlerFunc(a.handleHealth),
+		},
+		{
+			Pattern: "/jwtauth/providers/",
+			Handler: caddy.AdminHandlerFunc(a.handleProviderPolicy),
+		},
+	}
+}
+
+// handleStats reports the rolling-window counts of the authentication
+// outcomes. Query parameters:
+//
+//   - window: the rolling window, e.g. "15m", defaults to "5m", at most "1h"
+//   - top: the number of top failing issuers to report, defaults to 10
+func (adminAPI) handleStats(w http.ResponseWriter, r *http.Request) error {
+	if r.Method != http.MethodGet {
+		return caddy.APIError{
+			HTTPStatus: http.StatusMethodNotAllowed,
+			Err:        fmt.Errorf("method not allowed"),
+		}
+	}
+
+	window := 5 * time.Minute
+	if raw := r.URL.Query().Get("window"); raw != "" {
+		d, err := time.ParseDuration(raw)
+		if err != nil {
+			return caddy.APIError{
+				HTTPStatus: http.StatusBadRequest,
+				Err:        fmt.Errorf("invalid window: %w", err),
+			}
+		}
+		window = d
+	}
+	top := 10
+	if raw := r.URL.Query().Get("top"); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil || n < 0 {
+			return caddy.APIError{
+				HTTPStatus: http.StatusBadRequest,
+				Err:        fmt.Errorf("invalid top: %q", raw),
+			}
+		}
+		top = n
+	}
+
+	return writeJSON(w, stats.snapshot(window, top))
+}
+
+// handlePurgeCache removes the cached enrichment and userinfo results of a
+// subject, given by the query parameter "sub", or all of them if absent.
+func (adminAPI) handlePurgeCache(w http.ResponseWriter, r *http.Request) error {
+	if r.Method != http.MethodPost {
+		return caddy.APIError{
+			HTTPStatus: http.StatusMethodNotAllowed,
+			Err:        fmt.Errorf("method not allowed"),
+		}
+	}
+	purged := purgeSubjectCaches(r.URL.Query().Get("sub"))
+	return writeJSON(w, map[string]int{"purged": purged})
+}
+
+// handleRevoke adds a token to the in-memory blocklists of all the providers.
+// Query parameters:
+//
+//   - jti: the "jti" claim of the token to revoke, required
+//   - exp: the expiry of the token in unix seconds, after which the entry is
+//     dropped, defaults to never
+func (adminAPI) handleRevoke(w http.ResponseWriter, r *http.Request) error {
+	if r.Method != http.MethodPost {
+		return caddy.APIError{
+			HTTPStatus: http.StatusMethodNotAllowed,
+			Err:        fmt.Errorf("method not allowed"),
+		}
+	}
+	jti := r.URL.Query().Get("jti")
+	if jti == "" {
+		return caddy.APIError{
+			HTTPStatus: http.StatusBadRequest,
+			Err:        fmt.Errorf("missing jti"),
+		}
+	}
+	var until time.Time
+	if raw := r.URL.Query().Get("exp"); raw != "" {
+		exp, err := strconv.ParseInt(raw, 10, 64)
+		if err != nil {
+			return caddy.APIError{
+				HTTPStatus: http.StatusBadRequest,
+				Err:        fmt.Errorf("invalid exp: %q", raw),
+			}
+		}
+		until = time.Unix(exp, 0)
+	}
+	return writeJSON(w, map[string]int{"blocklists": revokeInMemoryBlocklists(jti, until)})
+}
+
+// handleBlockedKIDs serves the key IDs blocked at runtime, see
+// JWTAuth.BlockKIDs:
+//
+//   - GET lists the blocked key IDs
+//   - POST ?kid=<kid> blocks the key ID
+//   - DELETE ?kid=<kid> unblocks the key ID
+func (adminAPI) handleBlockedKIDs(w http.ResponseWriter, r *http.Request) error {
+	switch r.Method {
+	case http.MethodGet:
+		return writeJSON(w, listBlockedKIDs())
+	case http.MethodPost, http.MethodDelete:
+		kid := r.URL.Query().Get("kid")
+		if kid == "" {
+			return caddy.APIError{
+				HTTPStatus: http.StatusBadRequest,
+				Err:        fmt.Errorf("missing kid"),
+			}
+		}
+		if err := setKIDBlocked(kid, r.Method == http.MethodPost); err != nil {
+			return caddy.APIError{
+				HTTPStatus: http.StatusInternalServerError,
+				Err:        err,
+			}
+		}
+		return writeJSON(w, listBlockedKIDs())
+	default:
+		return caddy.APIError{
+			HTTPStatus: http.StatusMethodNotAllowed,
+			Err:        fmt.Errorf("method not allowed"),
+		}
+	}
+}
+
+// handleMaintenance switches the maintenance mode of the providers with
+// maintenance configured. Query parameters:
+//
+//   - enabled: "true" or "false", required
+//   - provider: the name of the providers to switch, see JWTAuth.Name,
+//     defaults to all
+func (adminAPI) handleMaintenance(w http.ResponseWriter, r *http.Request) error {
+	if r.Method != http.MethodPost {
+		return caddy.APIError{
+			HTTPStatus: http.StatusMethodNotAllowed,
+			Err:        fmt.Errorf("method not allowed"),
+		}
+	}
+	raw := r.URL.Query().Get("enabled")
+	on, err := strconv.ParseBool(raw)
+	if err != nil {
+		return caddy.APIError{
+			HTTPStatus: http.StatusBadRequest,
+			Err:        fmt.Errorf("invalid enabled: %q", raw),
+		}
+	}
+	name := r.URL.Query().Get("provider")
+	switched := switchMaintenance(name, on)
+	if switched == 0 && name != "" {
+		return caddy.APIError{
+			HTTPStatus: http.StatusNotFound,
+			Err:        fmt.Errorf("unknown provider: %q", name),
+		}
+	}
+	return writeJSON(w, map[string]int{"switched": switched})
+}
+
+// handleProviderPolicy serves the live policies of the providers of a name,
+// see JWTAuth.Name:
+//
+//   - GET /jwtauth/providers/<name> reports the policies
+//   - PATCH /jwtauth/providers/<name>/<path> replaces the value at path with
+//     the JSON body, e.g. "issuer_whitelist" or "claim_policies/admin"
+func (adminAPI) handleProviderPolicy(w http.ResponseWriter, r *http.Request) error {
+	path := strings.Split(strings.Trim(strings.TrimPrefix(r.URL.Path, "/jwtauth/providers/"), "/"), "/")
+	providers := lookupNamedProviders(path[0])
+	if len(providers) == 0 {
+		return caddy.APIError{
+			HTTPStatus: http.StatusNotFound,
+			Err:        fmt.Errorf("unknown provider: %q", path[0]),
+		}
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		policies := make([]*livePolicy, 0, len(providers))
+		for _, ja := range providers {
+			policies = append(policies, ja.policy())
+		}
+		return writeJSON(w, policies)
+
+	case http.MethodPatch:
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			return caddy.APIError{
+				HTTPStatus: http.StatusBadRequest,
+				Err:        fmt.Errorf("reading request body: %w", err),
+			}
+		}
+		if err := patchPolicies(providers, path[1:], body); err != nil {
+			return caddy.APIError{
+				HTTPStatus: http.StatusBadRequest,
+				Err:        fmt.Errorf("patching provider %q: %w", path[0], err),
+			}
+		}
+		for _, ja := range providers {
+			ja.logger.Warn("policy patched via admin API", zap.Strings("path", path[1:]))
+		}
+		return writeJSON(w, map[string]int{"patched": len(providers)})
+
+	default:
+		return caddy.APIError{
+			HTTPStatus: http.StatusMethodNotAllowed,
+			Err:        fmt.Errorf("method not allowed"),
+		}
+	}
+}
+
+// handleKeys inspects the JWKs of the providers verifying the tokens by the
+// JWKs, e.g. during a key rotation:
+//
+//   - GET lists the keys loaded (kid, alg, kty and use), where from and when
+//   - POST ?action=refresh refreshes the JWKs at once
+//   - POST ?action=flush flushes the validation cache, see ValidationCache
+//
+// The query parameter "provider" selects the providers of the name, see
+// JWTAuth.Name, defaults to all.
+func (adminAPI) handleKeys(w http.ResponseWriter, r *http.Request) error {
+	name := r.URL.Query().Get("provider")
+	providers := lookupJWKProviders(name)
+	if len(providers) == 0 && name != "" {
+		return caddy.APIError{
+			HTTPStatus: http.StatusNotFound,
+			Err:        fmt.Errorf("unknown provider: %q", name),
+		}
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		reports := make([]keysReport, 0, len(providers))
+		for _, ja := range providers {
+			reports = append(reports, ja.keysReport())
+		}
+		sort.Slice(reports, func(i, j int) bool { return reports[i].Provider < reports[j].Provider })
+		return writeJSON(w, reports)
+
+	case http.MethodPost:
+		switch action := r.URL.Query().Get("action"); action {
+		case "refresh":
+			for _, ja := range providers {
+				if err := ja.refreshJWKCache(); err != nil {
+					return caddy.APIError{
+						HTTPStatus: http.StatusBadGateway,
+						Err:        fmt.Errorf("refreshing JWKs of provider %q: %w", ja.Name, err),
+					}
+				}
+				ja.logger.Warn("JWKs refreshed via admin API")
+			}
+			return writeJSON(w, map[string]int{"refreshed": len(providers)})
+		case "flush":
+			flushed := 0
+			for _, ja := range providers {
+				flushed += ja.flushValidationCache()
+			}
+			return writeJSON(w, map[string]int{"flushed": flushed})
+		default:
+			return caddy.APIError{
+				HTTPStatus: http.StatusBadRequest,
+				Err:        fmt.Errorf("invalid action: %q", action),
+			}
+		}
+
+	default:
+		return caddy.APIError{
+			HTTPStatus: http.StatusMethodNotAllowed,
+			Err:        fmt.Errorf("method not allowed"),
+		}
+	}
+}
+
+// handleHealth reports whether the JWKs of the providers verifying the tokens
+// by the JWKs have been fetched at least once, e.g. for a readiness probe.
+// It responds 503 unless all of them have been. The query parameter
+// "provider" selects the providers of the name, see JWTAuth.Name, defaults
+// to all.
+func (adminAPI) handleHealth(w http.ResponseWriter, r *http.Request) error {
+	if r.Method != http.MethodGet {
+		return caddy.APIError{
+			HTTPStatus: http.StatusMethodNotAllowed,
+			Err:        fmt.Errorf("method not allowed"),
+		}
+	}
+	name := r.URL.Query().Get("provider")
+	providers := lookupJWKProviders(name)
+	if len(providers) == 0 && name != "" {
+		return caddy.APIError{
+			HTTPStatus: http.StatusNotFound,
+			Err:        fmt.Errorf("unknown provider: %q", name),
+		}
+	}
+	report := checkHealth(providers)
+	if !report.Ready {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	return writeJSON(w, report)
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) error {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		return caddy.APIError{
+			HTTPStatus: http.StatusInternalServerError,
+			Err:        err,
+		}
+	}
+	return nil
+}
+
+// Interface guards
+var (
+	_ caddy.AdminRouter = (*adminAPI)(nil)
+)
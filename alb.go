@@ -0,0 +1,213 @@
+package caddyjwt
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/lestrrat-go/jwx/v2/jwa"
+	"github.com/lestrrat-go/jwx/v2/jws"
+	"github.com/lestrrat-go/jwx/v2/jwt"
+	"golang.org/x/sync/singleflight"
+)
+
+// sourceALB is the source of the user claims signed by AWS ALB, see
+// ALBOIDCData.
+const sourceALB tokenSource = "alb"
+
+// defaultALBKeyURL is the default of ALBOIDCData.KeyURL.
+const defaultALBKeyURL = "https://public-keys.auth.elb.{region}.amazonaws.com/{kid}"
+
+// albKeyTTL is how long the public keys of ALB are cached. A kid never
+// changes its key.
+const albKeyTTL = 24 * time.Hour
+
+// ALBOIDCData verifies the user claims AWS Application Load Balancer passes
+// to the targets in the "x-amzn-oidc-data" header, once it has
+// authenticated the user, e.g. by Cognito. They are a JWT, but of the
+// base64url segments padded, which the standard parsing rejects, signed by
+// ES256 with the key of the region of the ALB published at KeyURL, by kid.
+//
+// The claims are then verified as if they were the claims of any JWT, e.g.
+// "exp", IssuerWhitelist and UserClaims.
+type ALBOIDCData struct {
+	// Signers are the ARNs of the ALBs whose user claims are accepted, i.e.
+	// the "signer" header of the tokens. Required, as ALB of any AWS account
+	// signs by the same keys of a region.
+	Signers []string `json:"signers"`
+
+	// Header is the header of the user claims. Defaults to
+	// "X-Amzn-Oidc-Data".
+	Header string `json:"header,omitempty"`
+
+	// KeyURL is the URL of the public keys, of the placeholders {region},
+	// of the ARN of the signer, and {kid}. Defaults to
+	// "https://public-keys.auth.elb.{region}.amazonaws.com/{kid}", e.g. of
+	// GovCloud, it's
+	// "https://s3-us-gov-west-1.amazonaws.com/aws-elb-public-keys-prod-us-gov-west-1/{kid}".
+	KeyURL string `json:"key_url,omitempty"`
+
+	// Timeout is the timeout of fetching a key. Defaults to 2s.
+	Timeout caddy.Duration `json:"timeout,omitempty"`
+
+	signers map[string]string // ARN -> region
+	client  *http.Client
+	keys    *ttlCache // URL -> *ecdsa.PublicKey
+	group   singleflight.Group
+}
+
+func (a *ALBOIDCData) provision() error {
+	if len(a.Signers) == 0 {
+		return fmt.Errorf("missing signers")
+	}
+	a.signers = make(map[string]string, len(a.Signers))
+	for _, arn := range a.Signers {
+		// arn:aws:elasticloadbalancing:<region>:<account>:loadbalancer/app/<name>/<id>
+		parts := strings.SplitN(arn, ":", 6)
+		if len(parts) != 6 || parts[0] != "arn" || parts[2] != "elasticloadbalancing" || parts[3] == "" {
+			return fmt.Errorf("invalid signer: %q is not an ARN of ALB", arn)
+		}
+		a.signers[arn] = parts[3]
+	}
+	if a.Header == "" {
+		a.Header = "X-Amzn-Oidc-Data"
+	}
+	if a.KeyURL == "" {
+		a.KeyURL = defaultALBKeyURL
+	}
+	if !strings.Contains(a.KeyURL, "{kid}") {
+		return fmt.Errorf("invalid key_url: %q has no {kid}", a.KeyURL)
+	}
+	if a.Timeout < 0 {
+		return fmt.Errorf("invalid timeout: %s", time.Duration(a.Timeout))
+	}
+	if a.Timeout == 0 {
+		a.Timeout = caddy.Duration(2 * time.Second)
+	}
+	a.client = &http.Client{Timeout: time.Duration(a.Timeout)}
+	a.keys = newTTLCache(0, 0)
+	return nil
+}
+
+// getToken returns the user claims of the request, if any.
+func (a *ALBOIDCData) getToken(r *http.Request) []candidateToken {
+	if value := r.Header.Get(a.Header); value != "" {
+		return []candidateToken{{sourceALB, a.Header, value}}
+	}
+	return nil
+}
+
+// verify verifies the user claims by the key of the signer, it records the
+// provenance of the key into kp.
+func (a *ALBOIDCData) verify(ctx context.Context, token string, kp *keyProvenance) (Token, error) {
+	segments := strings.Split(token, ".")
+	if len(segments) != 3 {
+		return nil, fmt.Errorf("%w: %w (segments): expect 3, got %d", ErrInvalidToken, ErrMalformedToken, len(segments))
+	}
+	var decoded [3][]byte
+	for i, segment := range segments {
+		var err error
+		if decoded[i], err = decodePaddedSegment(segment); err != nil {
+			return nil, fmt.Errorf("%w: %w (base64): segment #%d: %v", ErrInvalidToken, ErrMalformedToken, i, err)
+		}
+	}
+	var header struct {
+		Alg    string `json:"alg"`
+		KID    string `json:"kid"`
+		Signer string `json:"signer"`
+	}
+	if err := json.Unmarshal(decoded[0], &header); err != nil {
+		return nil, fmt.Errorf("%w: %w (header): %v", ErrInvalidToken, ErrMalformedToken, err)
+	}
+	kp.KeyID = header.KID
+	if header.Alg != jwa.ES256.String() {
+		return nil, fmt.Errorf("%w: alg %q, expect ES256", ErrInvalidToken, header.Alg)
+	}
+	region, ok := a.signers[header.Signer]
+	if !ok {
+		return nil, fmt.Errorf("%w: signer %q not allowed", ErrInvalidToken, header.Signer)
+	}
+	if !jwkTenantValue.MatchString(header.KID) {
+		return nil, fmt.Errorf("%w: invalid kid %q", ErrInvalidToken, header.KID)
+	}
+
+	kp.Location = strings.NewReplacer("{region}", region, "{kid}", url.PathEscape(header.KID)).Replace(a.KeyURL)
+	key, err := a.fetchKey(ctx, kp.Location)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrKeyNotFound, err)
+	}
+	verifier, err := jws.NewVerifier(jwa.ES256)
+	if err != nil {
+		return nil, err
+	}
+	// signed over the segments as presented, i.e. padded
+	signingInput := token[:len(segments[0])+1+len(segments[1])]
+	if err := verifier.Verify([]byte(signingInput), decoded[2], key); err != nil {
+		return nil, fmt.Errorf("%w: %w: %v", ErrInvalidToken, ErrSignatureInvalid, err)
+	}
+	claims, err := jwt.Parse(decoded[1], jwt.WithVerify(false), jwt.WithValidate(false))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w (claims): %v", ErrInvalidToken, ErrMalformedToken, err)
+	}
+	return claims, nil
+}
+
+// decodePaddedSegment decodes a base64url segment, padded or not. ALB pads
+// the segments, unlike RFC 7515.
+func decodePaddedSegment(segment string) ([]byte, error) {
+	return base64.RawURLEncoding.DecodeString(strings.TrimRight(segment, "="))
+}
+
+// fetchKey returns the PEM public key at the URL, from the cache if
+// possible. Concurrent cache misses of the same key share one fetch.
+func (a *ALBOIDCData) fetchKey(ctx context.Context, keyURL string) (*ecdsa.PublicKey, error) {
+	if cached, ok := a.keys.Get(keyURL); ok {
+		return cached.(*ecdsa.PublicKey), nil
+	}
+	v, err, _ := a.group.Do(keyURL, func() (interface{}, error) {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, keyURL, nil)
+		if err != nil {
+			return nil, err
+		}
+		resp, err := a.client.Do(req)
+		if err != nil {
+			return nil, err
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("GET %s: %s", keyURL, resp.Status)
+		}
+		data, err := io.ReadAll(io.LimitReader(resp.Body, 16<<10))
+		if err != nil {
+			return nil, err
+		}
+		block, _ := pem.Decode(data)
+		if block == nil {
+			return nil, fmt.Errorf("GET %s: not a PEM key", keyURL)
+		}
+		parsed, err := x509.ParsePKIXPublicKey(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("GET %s: %w", keyURL, err)
+		}
+		key, ok := parsed.(*ecdsa.PublicKey)
+		if !ok {
+			return nil, fmt.Errorf("GET %s: %T is not an ECDSA key", keyURL, parsed)
+		}
+		a.keys.Set(keyURL, key, albKeyTTL)
+		return key, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return v.(*ecdsa.PublicKey), nil
+}
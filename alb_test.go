@@ -0,0 +1,144 @@
+package caddyjwt
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"github.com/caddyserver/caddy/v2/caddyconfig/httpcaddyfile"
+	"github.com/caddyserver/caddy/v2/modules/caddyhttp/caddyauth"
+	"github.com/lestrrat-go/jwx/v2/jwa"
+	"github.com/lestrrat-go/jwx/v2/jws"
+	"github.com/stretchr/testify/assert"
+)
+
+const testALBSigner = "arn:aws:elasticloadbalancing:eu-west-1:123456789012:loadbalancer/app/my-alb/50dc6c495c0c9188"
+
+// issueALBToken signs the claims as ALB does, of the segments padded.
+func issueALBToken(key *ecdsa.PrivateKey, kid, signer string, claims MapClaims) string {
+	header, err := json.Marshal(map[string]interface{}{
+		"alg": "ES256", "kid": kid, "signer": signer, "iss": "https://cognito-idp.eu-west-1.amazonaws.com/pool", "client": "client",
+	})
+	panicOnError(err)
+	payload, err := json.Marshal(claims)
+	panicOnError(err)
+	signingInput := base64.URLEncoding.EncodeToString(header) + "." + base64.URLEncoding.EncodeToString(payload)
+	es256, err := jws.NewSigner(jwa.ES256)
+	panicOnError(err)
+	sig, err := es256.Sign([]byte(signingInput), key)
+	panicOnError(err)
+	return signingInput + "." + base64.URLEncoding.EncodeToString(sig)
+}
+
+func TestAuthenticate_ALBOIDCData(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	panicOnError(err)
+	der, err := x509.MarshalPKIXPublicKey(key.Public())
+	panicOnError(err)
+	var fetches atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/eu-west-1/kid-1" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		fetches.Add(1)
+		_ = pem.Encode(w, &pem.Block{Type: "PUBLIC KEY", Bytes: der})
+	}))
+	defer server.Close()
+
+	ja := &JWTAuth{
+		ALBOIDCData: &ALBOIDCData{Signers: []string{testALBSigner}, KeyURL: server.URL + "/{region}/{kid}"},
+		StripToken:  true,
+		logger:      testLogger,
+	}
+	assert.Nil(t, ja.Validate())
+
+	authenticate := func(token string) (User, *http.Request, error) {
+		r, _ := http.NewRequest("GET", "/", nil)
+		r.Header.Set("X-Amzn-Oidc-Data", token)
+		user, _, err := ja.Authenticate(httptest.NewRecorder(), r)
+		return user, r, err
+	}
+	exp := time.Now().Add(time.Minute).Unix()
+
+	token := issueALBToken(key, "kid-1", testALBSigner, MapClaims{"sub": "ggicci", "exp": exp})
+	assert.Contains(t, token, "=", "padded")
+	user, r, err := authenticate(token)
+	assert.Nil(t, err)
+	assert.Equal(t, "ggicci", user.ID)
+	assert.Empty(t, r.Header.Get("X-Amzn-Oidc-Data"), "stripped")
+	_, _, err = authenticate(issueALBToken(key, "kid-1", testALBSigner, MapClaims{"sub": "ggicci", "exp": exp}))
+	assert.Nil(t, err)
+	assert.EqualValues(t, 1, fetches.Load(), "key cached")
+
+	_, _, err = authenticate(issueALBToken(key, "kid-1", testALBSigner, MapClaims{"sub": "ggicci", "exp": time.Now().Add(-time.Minute).Unix()}))
+	assert.ErrorIs(t, err, ErrTokenExpired)
+
+	other := "arn:aws:elasticloadbalancing:eu-west-1:999999999999:loadbalancer/app/evil/1"
+	_, _, err = authenticate(issueALBToken(key, "kid-1", other, MapClaims{"sub": "ggicci", "exp": exp}))
+	assert.ErrorIs(t, err, ErrInvalidToken)
+
+	_, _, err = authenticate(issueALBToken(key, "kid-2", testALBSigner, MapClaims{"sub": "ggicci", "exp": exp}))
+	assert.ErrorIs(t, err, ErrKeyNotFound)
+
+	_, _, err = authenticate(issueALBToken(key, "../kid-1", testALBSigner, MapClaims{"sub": "ggicci", "exp": exp}))
+	assert.ErrorIs(t, err, ErrInvalidToken)
+
+	forger, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	panicOnError(err)
+	_, _, err = authenticate(issueALBToken(forger, "kid-1", testALBSigner, MapClaims{"sub": "ggicci", "exp": exp}))
+	assert.ErrorIs(t, err, ErrSignatureInvalid)
+}
+
+func TestALBOIDCData_Provision(t *testing.T) {
+	for _, tc := range []struct {
+		alb     *ALBOIDCData
+		wantErr string
+	}{
+		{&ALBOIDCData{}, "missing signers"},
+		{&ALBOIDCData{Signers: []string{"arn:aws:iam::123456789012:role/x"}}, "not an ARN of ALB"},
+		{&ALBOIDCData{Signers: []string{testALBSigner}, KeyURL: "https://keys.example.com/"}, "has no {kid}"},
+	} {
+		assert.ErrorContains(t, tc.alb.provision(), tc.wantErr)
+	}
+
+	alb := &ALBOIDCData{Signers: []string{testALBSigner}}
+	assert.Nil(t, alb.provision())
+	assert.Equal(t, "X-Amzn-Oidc-Data", alb.Header)
+	assert.Equal(t, "eu-west-1", alb.signers[testALBSigner])
+}
+
+func TestParsingCaddyfileALBOIDCData(t *testing.T) {
+	helper := httpcaddyfile.Helper{
+		Dispenser: caddyfile.NewTestDispenser(`
+	jwtauth {
+		alb_oidc_data ` + testALBSigner + ` {
+			key_url https://s3-us-gov-west-1.amazonaws.com/aws-elb-public-keys-prod-us-gov-west-1/{kid}
+			timeout 5s
+		}
+	}
+	`),
+	}
+	h, err := parseCaddyfile(helper)
+	assert.Nil(t, err)
+	var ja JWTAuth
+	assert.Nil(t, json.Unmarshal(h.(caddyauth.Authentication).ProvidersRaw["jwt"], &ja))
+	assert.Equal(t, []string{testALBSigner}, ja.ALBOIDCData.Signers)
+	assert.Equal(t, "https://s3-us-gov-west-1.amazonaws.com/aws-elb-public-keys-prod-us-gov-west-1/{kid}", ja.ALBOIDCData.KeyURL)
+	assert.EqualValues(t, 5e9, ja.ALBOIDCData.Timeout)
+
+	_, err = parseCaddyfile(httpcaddyfile.Helper{Dispenser: caddyfile.NewTestDispenser(`jwtauth {
+		alb_oidc_data
+	}`)})
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,104 @@
+package caddyjwt
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"fmt"
+
+	"github.com/lestrrat-go/jwx/v2/jwa"
+	"github.com/lestrrat-go/jwx/v2/jwk"
+	"github.com/lestrrat-go/jwx/v2/jws"
+)
+
+// keyTypeAlgorithms are the signature algorithms applicable to the keys of
+// each type. A token is only verified by a key of the type of its
+// algorithm, e.g. never by the HMAC of an RSA public key.
+var keyTypeAlgorithms = map[jwa.KeyType][]jwa.SignatureAlgorithm{
+	jwa.RSA:      {jwa.RS256, jwa.RS384, jwa.RS512, jwa.PS256, jwa.PS384, jwa.PS512},
+	jwa.EC:       {jwa.ES256, jwa.ES384, jwa.ES512, jwa.ES256K},
+	jwa.OKP:      {jwa.EdDSA},
+	jwa.OctetSeq: {jwa.HS256, jwa.HS384, jwa.HS512},
+}
+
+// validateAllowedAlgorithms checks AllowedAlgorithms are signature
+// algorithms, not "none", and admit SignAlgorithm.
+func (ja *JWTAuth) validateAllowedAlgorithms() error {
+	for _, raw := range ja.AllowedAlgorithms {
+		var alg jwa.SignatureAlgorithm
+		if err := alg.Accept(raw); err != nil || alg == jwa.NoSignature {
+			return fmt.Errorf("unsupported algorithm %q", raw)
+		}
+	}
+	if ja.SignAlgorithm != "" && !ja.algorithmAllowed(jwa.SignatureAlgorithm(ja.SignAlgorithm)) {
+		return fmt.Errorf("sign_alg %s not allowed", ja.SignAlgorithm)
+	}
+	return nil
+}
+
+func (ja *JWTAuth) algorithmAllowed(alg jwa.SignatureAlgorithm) bool {
+	if alg == jwa.NoSignature {
+		return false
+	}
+	if len(ja.AllowedAlgorithms) == 0 {
+		return true
+	}
+	for _, allowed := range ja.AllowedAlgorithms {
+		if allowed == alg.String() {
+			return true
+		}
+	}
+	return false
+}
+
+// checkAlgorithm checks the "alg" header of a token before it's verified,
+// see AllowedAlgorithms.
+func (ja *JWTAuth) checkAlgorithm(alg jwa.SignatureAlgorithm) error {
+	if !ja.algorithmAllowed(alg) {
+		return fmt.Errorf("%w: %q", ErrAlgorithmNotAllowed, alg)
+	}
+	return nil
+}
+
+// sinkKey provides the key to verify a token by the algorithm, if allowed
+// and applicable to the type of the key. An empty algorithm fails the
+// verification anyway.
+func (ja *JWTAuth) sinkKey(sink jws.KeySink, alg jwa.SignatureAlgorithm, key interface{}) error {
+	if alg != "" {
+		if err := ja.checkAlgorithm(alg); err != nil {
+			return err
+		}
+		if kty := keyTypeOf(key); kty != jwa.InvalidKeyType && !algorithmOfKeyType(alg, kty) {
+			return fmt.Errorf("%w: %s with a key of type %s", ErrAlgorithmNotAllowed, alg, kty)
+		}
+	}
+	sink.Key(alg, key)
+	return nil
+}
+
+func algorithmOfKeyType(alg jwa.SignatureAlgorithm, kty jwa.KeyType) bool {
+	for _, applicable := range keyTypeAlgorithms[kty] {
+		if applicable == alg {
+			return true
+		}
+	}
+	return false
+}
+
+// keyTypeOf returns the type of a key, either a jwk.Key or a raw key, or
+// jwa.InvalidKeyType if unknown, e.g. a crypto.Signer of a hardware module.
+func keyTypeOf(key interface{}) jwa.KeyType {
+	switch k := key.(type) {
+	case jwk.Key:
+		return k.KeyType()
+	case []byte:
+		return jwa.OctetSeq
+	case *rsa.PublicKey, rsa.PublicKey, *rsa.PrivateKey:
+		return jwa.RSA
+	case *ecdsa.PublicKey, ecdsa.PublicKey, *ecdsa.PrivateKey:
+		return jwa.EC
+	case ed25519.PublicKey, ed25519.PrivateKey:
+		return jwa.OKP
+	}
+	return jwa.InvalidKeyType
+}
@@ -0,0 +1,69 @@
+package caddyjwt
+
+import (
+	"context"
+	"crypto/rsa"
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/lestrrat-go/jwx/v2/jwa"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAuthenticate_AllowedAlgorithms(t *testing.T) {
+	authenticate := func(ja *JWTAuth, token string) error {
+		r, _ := http.NewRequest("GET", "/", nil)
+		r.Header.Add("Authorization", token)
+		_, _, err := ja.Authenticate(httptest.NewRecorder(), r)
+		return err
+	}
+
+	ja := &JWTAuth{JWKURL: TestJWKSetURL, AllowedAlgorithms: []string{"RS256", "PS256"}, logger: testLogger}
+	assert.Nil(t, ja.Validate())
+	defer ja.Cleanup()
+	assert.Nil(t, authenticate(ja, issueTokenStringJWK(MapClaims{"sub": "ggicci"})))
+
+	ja.AllowedAlgorithms = []string{"ES256"}
+	err := authenticate(ja, issueTokenStringJWK(MapClaims{"sub": "ggicci"}))
+	assert.ErrorIs(t, err, ErrAlgorithmNotAllowed)
+	assert.Equal(t, "algorithm_not_allowed", failureReason(err))
+
+	// "none" is always rejected
+	unsecured := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"none"}`)) + "." +
+		base64.RawURLEncoding.EncodeToString([]byte(`{"sub":"ggicci"}`)) + "."
+	ja = &JWTAuth{SignKey: TestSignKey, logger: testLogger}
+	assert.Nil(t, ja.Validate())
+	assert.ErrorIs(t, authenticate(ja, unsecured), ErrAlgorithmNotAllowed)
+
+	// an RSA key never verifies an HMAC
+	var rsaKey rsa.PublicKey
+	assert.Nil(t, jwkPubKey.Raw(&rsaKey))
+	ja = &JWTAuth{
+		KeyResolver: func(ctx context.Context, kid, alg string) (interface{}, error) {
+			return &rsaKey, nil
+		},
+		logger: testLogger,
+	}
+	assert.Nil(t, ja.Validate())
+	assert.ErrorIs(t, authenticate(ja, issueTokenString(MapClaims{"sub": "ggicci"})), ErrAlgorithmNotAllowed)
+}
+
+func TestValidate_AllowedAlgorithms(t *testing.T) {
+	for _, algs := range [][]string{{"none"}, {"RS256", "XX999"}} {
+		ja := &JWTAuth{SignKey: TestSignKey, AllowedAlgorithms: algs}
+		assert.ErrorContains(t, ja.Validate(), "invalid allowed_algorithms", algs)
+	}
+	ja := &JWTAuth{SignKey: TestSignKey, SignAlgorithm: "HS256", AllowedAlgorithms: []string{"HS512"}}
+	assert.ErrorContains(t, ja.Validate(), "sign_alg HS256 not allowed")
+}
+
+func TestKeyTypeOf(t *testing.T) {
+	var rsaKey rsa.PublicKey
+	assert.Nil(t, jwkPubKey.Raw(&rsaKey))
+	assert.Equal(t, jwa.RSA, keyTypeOf(jwkPubKey))
+	assert.Equal(t, jwa.RSA, keyTypeOf(&rsaKey))
+	assert.Equal(t, jwa.OctetSeq, keyTypeOf(RawTestSignKey))
+	assert.Equal(t, jwa.InvalidKeyType, keyTypeOf("unknown"))
+}
@@ -0,0 +1,131 @@
+package caddyjwt
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+	"go.uber.org/zap"
+)
+
+// maxClaimsCombinations bounds the combinations learnt per subject. The
+// combinations beyond are reported but not learnt.
+const maxClaimsCombinations = 16
+
+// ClaimsAnomaly tracks a compact fingerprint of the typical claims of each
+// subject, e.g. the roles, the issuer and the client, and reports a subject
+// showing up with a combination never seen before, e.g. a sudden admin
+// role, by a warning log entry and the claims_anomalies_total metric. It
+// gives an early signal of privilege escalation via token manipulation
+// upstream. The first combination of a subject is learnt silently.
+//
+// The combinations are kept in memory, so they are forgotten on restart.
+type ClaimsAnomaly struct {
+	// Claims are the claims fingerprinted. Nested claims can be accessed by
+	// dot notation. Defaults to "iss", "roles", "azp" and "client_id".
+	Claims []string `json:"claims"`
+
+	// TTL is how long the combinations of a subject are remembered since
+	// the subject was last seen. Defaults to 24h.
+	TTL caddy.Duration `json:"ttl"`
+
+	// MaxSubjects bounds the number of the subjects tracked. Defaults to
+	// 10000.
+	MaxSubjects int `json:"max_subjects"`
+
+	mu    sync.Mutex // serializes the updates of the combinations
+	cache *ttlCache  // subject -> set of fingerprints
+}
+
+func (ca *ClaimsAnomaly) provision() error {
+	if len(ca.Claims) == 0 {
+		ca.Claims = []string{"iss", "roles", "azp", "client_id"}
+	}
+	if ca.TTL == 0 {
+		ca.TTL = caddy.Duration(24 * time.Hour)
+	}
+	if ca.MaxSubjects < 0 {
+		return fmt.Errorf("invalid max_subjects: %d", ca.MaxSubjects)
+	}
+	ca.cache = newTTLCache(ca.MaxSubjects, 0)
+	registerSubjectCache(ca.cache, func(key, sub string) bool { return key == sub })
+	return nil
+}
+
+func (ca *ClaimsAnomaly) cleanup() {
+	unregisterSubjectCache(ca.cache)
+}
+
+// observe learns the combination of the claims of the authenticated user,
+// and reports it if new to a known subject.
+func (ca *ClaimsAnomaly) observe(logger *zap.Logger, id string, token Token) {
+	values := make(map[string]string, len(ca.Claims))
+	for _, name := range ca.Claims {
+		if val, ok := getClaim(token, name); ok {
+			values[name] = canonicalClaim(val)
+		}
+	}
+	fingerprint := claimsFingerprint(ca.Claims, values)
+
+	ca.mu.Lock()
+	var known map[string]string
+	if cached, ok := ca.cache.Get(id); ok {
+		known = cached.(map[string]string)
+	}
+	_, seen := known[fingerprint]
+	before := len(known)
+	if !seen && before < maxClaimsCombinations {
+		learnt := make(map[string]string, len(known)+1)
+		for fp := range known {
+			learnt[fp] = ""
+		}
+		learnt[fingerprint] = ""
+		known = learnt
+	}
+	ca.cache.Set(id, known, time.Duration(ca.TTL))
+	ca.mu.Unlock()
+
+	if !seen && before > 0 {
+		metrics.claimsAnomalies.Inc()
+		logger.Warn("claims anomaly",
+			zap.String("id", id),
+			zap.String("fingerprint", fingerprint),
+			zap.Any("claims", values),
+			zap.Int("known_combinations", before),
+		)
+	}
+}
+
+// canonicalClaim stringifies the claim, sorting the lists, so the order of
+// e.g. the roles doesn't matter.
+func canonicalClaim(val interface{}) string {
+	list, ok := val.([]interface{})
+	if !ok {
+		return stringify(val)
+	}
+	items := make([]string, len(list))
+	for i, item := range list {
+		items[i] = stringify(item)
+	}
+	sort.Strings(items)
+	return strings.Join(items, ",")
+}
+
+// claimsFingerprint returns a short hash of the values of the claims. An
+// absent claim differs from an empty one.
+func claimsFingerprint(claims []string, values map[string]string) string {
+	h := sha256.New()
+	for _, name := range claims {
+		if val, ok := values[name]; ok {
+			fmt.Fprintf(h, "%s=%q;", name, val)
+		} else {
+			fmt.Fprintf(h, "%s;", name)
+		}
+	}
+	return hex.EncodeToString(h.Sum(nil)[:8])
+}
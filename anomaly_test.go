@@ -0,0 +1,58 @@
+package caddyjwt
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+func TestAuthenticate_ClaimsAnomaly(t *testing.T) {
+	core, logs := observer.New(zap.WarnLevel)
+	ja := &JWTAuth{
+		SignKey:       TestSignKey,
+		ClaimsAnomaly: &ClaimsAnomaly{},
+		logger:        zap.New(core),
+	}
+	assert.Nil(t, ja.Validate())
+	defer ja.Cleanup()
+
+	authenticate := func(claims MapClaims) int {
+		r, _ := http.NewRequest("GET", "/", nil)
+		r.Header.Add("Authorization", issueTokenString(claims))
+		_, authenticated, err := ja.Authenticate(httptest.NewRecorder(), r)
+		assert.Nil(t, err)
+		assert.True(t, authenticated)
+		n := logs.FilterMessage("claims anomaly").Len()
+		logs.TakeAll()
+		return n
+	}
+
+	// learnt silently
+	assert.Equal(t, 0, authenticate(MapClaims{"sub": "ggicci", "roles": []string{"viewer", "editor"}}))
+	assert.Equal(t, 0, authenticate(MapClaims{"sub": "ggicci", "roles": []string{"editor", "viewer"}}))
+	assert.Equal(t, 0, authenticate(MapClaims{"sub": "alice", "roles": []string{"admin"}}))
+
+	// a sudden admin role
+	assert.Equal(t, 1, authenticate(MapClaims{"sub": "ggicci", "roles": []string{"admin"}}))
+	assert.Equal(t, 0, authenticate(MapClaims{"sub": "ggicci", "roles": []string{"admin"}}))
+
+	// forgotten once purged
+	purgeSubjectCaches("ggicci")
+	assert.Equal(t, 0, authenticate(MapClaims{"sub": "ggicci", "roles": []string{"owner"}}))
+}
+
+func TestClaimsFingerprint(t *testing.T) {
+	claims := []string{"iss", "roles"}
+	assert.Equal(t,
+		claimsFingerprint(claims, map[string]string{"roles": canonicalClaim([]interface{}{"b", "a"})}),
+		claimsFingerprint(claims, map[string]string{"roles": canonicalClaim([]interface{}{"a", "b"})}),
+	)
+	assert.NotEqual(t,
+		claimsFingerprint(claims, map[string]string{"iss": ""}),
+		claimsFingerprint(claims, map[string]string{}),
+	)
+}
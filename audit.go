@@ -0,0 +1,63 @@
+package caddyjwt
+
+import (
+	"net"
+	"net/http"
+
+	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
+	"go.uber.org/zap"
+)
+
+// auditLoggerName is the name of the Caddy log receiving the audit entries,
+// see Audit.
+const auditLoggerName = "jwtauth.audit"
+
+// audit logs the authentication decision of the request, if Audit is
+// enabled. The token is always redacted.
+func (ja *JWTAuth) audit(r *http.Request, result *authResult, issuer string, err error) {
+	if ja.auditLogger == nil {
+		return
+	}
+	decision, candidate, token, kid := "allow", result.candidate, result.token, ""
+	if result.provenance != nil {
+		kid = result.provenance.KeyID
+	}
+	if err != nil {
+		decision, candidate, token, kid = "deny", result.rejected, result.rejectedToken, result.rejectedKID
+	}
+	fields := []zap.Field{
+		zap.String("decision", decision),
+		zap.String("request_id", result.requestID),
+		zap.String("client_ip", clientIP(r)),
+		zap.String("method", r.Method),
+		zap.String("host", r.Host),
+		zap.String("path", r.URL.Path),
+		zap.String("source", string(candidate.source)),
+		zap.String("kid", kid),
+	}
+	if candidate.value != "" {
+		fields = append(fields, ja.tokenField(normToken(candidate.value)))
+	}
+	if token != nil {
+		issuer = token.Issuer()
+		fields = append(fields, zap.String("subject", token.Subject()), zap.String("jti", token.JwtID()))
+	}
+	fields = append(fields, zap.String("issuer", issuer))
+	if err != nil {
+		fields = append(fields, zap.String("reason", failureReason(err)), zap.Error(err))
+	}
+	ja.auditLogger.Info("authentication decision", fields...)
+}
+
+// clientIP returns the IP of the client, as determined by Caddy, i.e.
+// respecting the trusted proxies, or the remote IP of the connection.
+func clientIP(r *http.Request) string {
+	if ip, ok := caddyhttp.GetVar(r.Context(), caddyhttp.ClientIPVarKey).(string); ok && ip != "" {
+		return ip
+	}
+	ip, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return ip
+}
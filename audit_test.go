@@ -0,0 +1,56 @@
+package caddyjwt
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+func TestAuthenticate_Audit(t *testing.T) {
+	core, logs := observer.New(zap.InfoLevel)
+	ja := &JWTAuth{
+		SignKey:         TestSignKey,
+		IssuerWhitelist: []string{"https://issuer.example.com"},
+		FromCookies:     []string{"session"},
+		logger:          testLogger,
+		auditLogger:     zap.New(core),
+	}
+	assert.Nil(t, ja.Validate())
+
+	authenticate := func(claims MapClaims) map[string]interface{} {
+		r, _ := http.NewRequest("GET", "/orders", nil)
+		r.RemoteAddr = "192.0.2.1:4321"
+		if claims != nil {
+			r.AddCookie(&http.Cookie{Name: "session", Value: issueTokenString(claims)})
+		}
+		ja.Authenticate(httptest.NewRecorder(), r)
+		entries := logs.TakeAll()
+		assert.Len(t, entries, 1)
+		return entries[0].ContextMap()
+	}
+
+	entry := authenticate(MapClaims{"sub": "ggicci", "iss": "https://issuer.example.com", "jti": "1"})
+	assert.Equal(t, "allow", entry["decision"])
+	assert.Equal(t, "ggicci", entry["subject"])
+	assert.Equal(t, "1", entry["jti"])
+	assert.Equal(t, "cookie", entry["source"])
+	assert.Equal(t, "192.0.2.1", entry["client_ip"])
+	assert.Equal(t, "https://issuer.example.com", entry["issuer"])
+	assert.Contains(t, entry["token_string"], "…")
+
+	entry = authenticate(MapClaims{"sub": "ggicci", "iss": "https://evil.example.com", "jti": "2"})
+	assert.Equal(t, "deny", entry["decision"])
+	assert.Equal(t, "invalid_issuer", entry["reason"])
+	assert.Equal(t, "ggicci", entry["subject"])
+	assert.Equal(t, "2", entry["jti"])
+	assert.Equal(t, "https://evil.example.com", entry["issuer"])
+
+	entry = authenticate(nil)
+	assert.Equal(t, "deny", entry["decision"])
+	assert.Equal(t, "missing_token", entry["reason"])
+	assert.NotContains(t, entry, "token_string")
+}
@@ -0,0 +1,56 @@
+package caddyjwt
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// BenchmarkAuthenticate measures the common case, a valid token in the
+// Authorization header verified by a sign key, under concurrency.
+func BenchmarkAuthenticate(b *testing.B) {
+	ja := &JWTAuth{SignKey: TestSignKey, logger: zap.NewNop()}
+	if err := ja.Validate(); err != nil {
+		b.Fatal(err)
+	}
+	token := issueTokenString(MapClaims{"sub": "ggicci", "exp": time.Now().Add(time.Hour).Unix()})
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		r, _ := http.NewRequest("GET", "/", nil)
+		r.Header.Set("Authorization", token)
+		rw := httptest.NewRecorder()
+		for pb.Next() {
+			if _, ok, err := ja.Authenticate(rw, r); !ok || err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+// BenchmarkAuthenticate_Rejected measures the tokens rejected by their
+// claims, e.g. of another audience.
+func BenchmarkAuthenticate_Rejected(b *testing.B) {
+	ja := &JWTAuth{SignKey: TestSignKey, AudienceWhitelist: []string{"api"}, logger: zap.NewNop()}
+	if err := ja.Validate(); err != nil {
+		b.Fatal(err)
+	}
+	token := issueTokenString(MapClaims{"sub": "ggicci", "aud": "web", "exp": time.Now().Add(time.Hour).Unix()})
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		r, _ := http.NewRequest("GET", "/", nil)
+		r.Header.Set("Authorization", token)
+		rw := httptest.NewRecorder()
+		for pb.Next() {
+			if _, ok, _ := ja.Authenticate(rw, r); ok {
+				b.Fatal("authenticated")
+			}
+		}
+	})
+}
@@ -0,0 +1,88 @@
+package caddyjwt
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/caddyserver/caddy/v2"
+)
+
+// bindingClientIP is the value of BindClaims for the IP of the client, as
+// determined by Caddy, i.e. respecting the trusted proxies.
+const bindingClientIP = "client_ip"
+
+// bindingSHA256 prefixes a value of BindClaims whose SHA-256 is the claim.
+const bindingSHA256 = "sha256:"
+
+// checkBindings verifies the claims of the token bound to the attributes of
+// the request, see BindClaims.
+func (ja *JWTAuth) checkBindings(r *http.Request, token Token) error {
+	repl, _ := r.Context().Value(caddy.ReplacerCtxKey).(*caddy.Replacer)
+	for claim, spec := range ja.BindClaims {
+		val, ok := getClaim(token, claim)
+		if !ok {
+			return fmt.Errorf("%w: missing claim %q", ErrBindingMismatch, claim)
+		}
+		hashed := strings.HasPrefix(spec, bindingSHA256)
+		attr := strings.TrimPrefix(spec, bindingSHA256)
+		switch {
+		case attr == bindingClientIP:
+			attr = clientIP(r)
+		case repl != nil:
+			attr = repl.ReplaceAll(attr, "")
+		}
+		if attr == "" {
+			return fmt.Errorf("%w: %q of the request is empty", ErrBindingMismatch, spec)
+		}
+		values, isArray := val.([]interface{})
+		if !isArray {
+			values = []interface{}{val}
+		}
+		bound := false
+		for _, v := range values {
+			if s, _ := v.(string); s != "" && matchBinding(s, attr, hashed) {
+				bound = true
+				break
+			}
+		}
+		if !bound {
+			return fmt.Errorf("%w: claim %q mismatches %q of the request", ErrBindingMismatch, claim, spec)
+		}
+	}
+	return nil
+}
+
+// matchBinding reports whether the claim binds the attribute of the
+// request. The claim is either the SHA-256, hex or base64url encoded, of the
+// attribute if hashed, or an IP or a CIDR containing the attribute if an
+// IP, or the attribute itself.
+func matchBinding(claim, attr string, hashed bool) bool {
+	if hashed {
+		sum := sha256.Sum256([]byte(attr))
+		return strings.EqualFold(claim, hex.EncodeToString(sum[:])) ||
+			claim == base64.RawURLEncoding.EncodeToString(sum[:])
+	}
+	if ip := net.ParseIP(attr); ip != nil {
+		if _, network, err := net.ParseCIDR(claim); err == nil {
+			return network.Contains(ip)
+		}
+		if claimIP := net.ParseIP(claim); claimIP != nil {
+			return claimIP.Equal(ip)
+		}
+	}
+	return claim == attr
+}
+
+func validateBindClaims(bindings map[string]string) error {
+	for claim, spec := range bindings {
+		if claim == "" || strings.TrimPrefix(spec, bindingSHA256) == "" {
+			return fmt.Errorf("%q -> %q", claim, spec)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,67 @@
+package caddyjwt
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAuthenticate_BindClaims(t *testing.T) {
+	ja := &JWTAuth{
+		SignKey: TestSignKey,
+		BindClaims: map[string]string{
+			"cip": "client_ip",
+			"fgp": "sha256:{fingerprint}",
+		},
+		logger: testLogger,
+	}
+	assert.Nil(t, ja.Validate())
+
+	sum := sha256.Sum256([]byte("s3cr3t"))
+	fgp := hex.EncodeToString(sum[:])
+	authenticate := func(remoteAddr, fingerprint string, claims MapClaims) error {
+		r, repl := newRequestWithReplacer("GET", "/")
+		r.RemoteAddr = remoteAddr
+		repl.Set("fingerprint", fingerprint)
+		r.Header.Add("Authorization", issueTokenString(claims))
+		_, _, err := ja.Authenticate(httptest.NewRecorder(), r)
+		return err
+	}
+
+	assert.Nil(t, authenticate("10.1.2.3:5678", "s3cr3t", MapClaims{"sub": "ggicci", "cip": "10.1.2.3", "fgp": fgp}))
+	assert.Nil(t, authenticate("10.1.2.3:5678", "s3cr3t", MapClaims{"sub": "ggicci", "cip": "10.1.0.0/16", "fgp": fgp}))
+	assert.Nil(t, authenticate("[2001:db8::1]:443", "s3cr3t", MapClaims{"sub": "ggicci", "cip": []string{"10.1.0.0/16", "2001:db8::/32"}, "fgp": fgp}))
+
+	for name, c := range map[string]struct {
+		remoteAddr, fingerprint string
+		claims                  MapClaims
+	}{
+		"other ip":          {"10.2.0.1:5678", "s3cr3t", MapClaims{"sub": "ggicci", "cip": "10.1.0.0/16", "fgp": fgp}},
+		"other fingerprint": {"10.1.2.3:5678", "stolen", MapClaims{"sub": "ggicci", "cip": "10.1.2.3", "fgp": fgp}},
+		"no fingerprint":    {"10.1.2.3:5678", "", MapClaims{"sub": "ggicci", "cip": "10.1.2.3", "fgp": fgp}},
+		"unbound token":     {"10.1.2.3:5678", "s3cr3t", MapClaims{"sub": "ggicci", "cip": "10.1.2.3"}},
+	} {
+		err := authenticate(c.remoteAddr, c.fingerprint, c.claims)
+		assert.ErrorIs(t, err, ErrBindingMismatch, name)
+		assert.Equal(t, "binding_mismatch", failureReason(err), name)
+	}
+}
+
+func TestMatchBinding(t *testing.T) {
+	sum := sha256.Sum256([]byte("Mozilla/5.0"))
+	assert.True(t, matchBinding(hex.EncodeToString(sum[:]), "Mozilla/5.0", true))
+	assert.False(t, matchBinding("Mozilla/5.0", "Mozilla/5.0", true))
+	assert.True(t, matchBinding("Mozilla/5.0", "Mozilla/5.0", false))
+	assert.True(t, matchBinding("::ffff:10.0.0.1", "10.0.0.1", false))
+	assert.False(t, matchBinding("10.0.0.0/8", "not-an-ip", false))
+}
+
+func TestValidate_BindClaims(t *testing.T) {
+	for _, bindings := range []map[string]string{{"": "client_ip"}, {"fgp": ""}, {"fgp": "sha256:"}} {
+		ja := &JWTAuth{SignKey: TestSignKey, BindClaims: bindings}
+		assert.ErrorContains(t, ja.Validate(), "invalid bind_claims")
+	}
+}
@@ -0,0 +1,129 @@
+package caddyjwt
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+
+	"github.com/caddyserver/caddy/v2"
+)
+
+// blockedKIDsPath returns the file persisting the key IDs blocked via the
+// admin API, so they stay blocked across restarts. A var for testing.
+var blockedKIDsPath = func() string {
+	return filepath.Join(caddy.AppDataDir(), "jwtauth", "blocked_kids.json")
+}
+
+// blockedKIDs are the key IDs blocked via the admin API, shared by all the
+// JWT providers in this process, see JWTAuth.BlockKIDs.
+var blockedKIDs = struct {
+	mu     sync.RWMutex
+	kids   map[string]struct{}
+	loaded bool
+}{kids: make(map[string]struct{})}
+
+// loadBlockedKIDs loads the persisted blocked key IDs once.
+func loadBlockedKIDs() error {
+	blockedKIDs.mu.Lock()
+	defer blockedKIDs.mu.Unlock()
+	if blockedKIDs.loaded {
+		return nil
+	}
+	data, err := os.ReadFile(blockedKIDsPath())
+	if errors.Is(err, fs.ErrNotExist) {
+		blockedKIDs.loaded = true
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var kids []string
+	if err := json.Unmarshal(data, &kids); err != nil {
+		return fmt.Errorf("parsing %s: %w", blockedKIDsPath(), err)
+	}
+	for _, kid := range kids {
+		blockedKIDs.kids[kid] = struct{}{}
+	}
+	blockedKIDs.loaded = true
+	return nil
+}
+
+// setKIDBlocked blocks or unblocks the key ID, and persists the change.
+func setKIDBlocked(kid string, blocked bool) error {
+	blockedKIDs.mu.Lock()
+	defer blockedKIDs.mu.Unlock()
+	_, was := blockedKIDs.kids[kid]
+	if was == blocked {
+		return nil
+	}
+	if blocked {
+		blockedKIDs.kids[kid] = struct{}{}
+	} else {
+		delete(blockedKIDs.kids, kid)
+	}
+	if err := persistBlockedKIDs(); err != nil {
+		// the change applies in memory anyway, it's a containment lever
+		return fmt.Errorf("persisting blocked kids: %w", err)
+	}
+	return nil
+}
+
+// listBlockedKIDs returns the blocked key IDs in order.
+func listBlockedKIDs() []string {
+	blockedKIDs.mu.RLock()
+	defer blockedKIDs.mu.RUnlock()
+	kids := make([]string, 0, len(blockedKIDs.kids))
+	for kid := range blockedKIDs.kids {
+		kids = append(kids, kid)
+	}
+	sort.Strings(kids)
+	return kids
+}
+
+// persistBlockedKIDs writes the blocked key IDs to the file atomically. The
+// lock must be held.
+func persistBlockedKIDs() error {
+	kids := make([]string, 0, len(blockedKIDs.kids))
+	for kid := range blockedKIDs.kids {
+		kids = append(kids, kid)
+	}
+	sort.Strings(kids)
+	data, err := json.Marshal(kids)
+	if err != nil {
+		return err
+	}
+	path := blockedKIDsPath()
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// checkKID returns ErrKeyBlocked if the key ID is on BlockKIDs, or blocked
+// via the admin API.
+func (ja *JWTAuth) checkKID(kid string) error {
+	if kid == "" {
+		return nil
+	}
+	for _, blocked := range ja.BlockKIDs {
+		if kid == blocked {
+			return fmt.Errorf("%w: %q", ErrKeyBlocked, kid)
+		}
+	}
+	blockedKIDs.mu.RLock()
+	_, blocked := blockedKIDs.kids[kid]
+	blockedKIDs.mu.RUnlock()
+	if blocked {
+		return fmt.Errorf("%w: %q", ErrKeyBlocked, kid)
+	}
+	return nil
+}
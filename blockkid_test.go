@@ -0,0 +1,63 @@
+package caddyjwt
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAuthenticate_BlockKIDs(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "jwtauth", "blocked_kids.json")
+	defer func(original func() string) { blockedKIDsPath = original }(blockedKIDsPath)
+	blockedKIDsPath = func() string { return path }
+
+	ja := &JWTAuth{JWKURL: TestJWKSetURL, BlockKIDs: []string{"compromised"}, logger: testLogger}
+	assert.Nil(t, ja.Validate())
+	defer ja.Cleanup()
+
+	authenticate := func() error {
+		r, _ := http.NewRequest("GET", "/", nil)
+		r.Header.Add("Authorization", issueTokenStringJWK(MapClaims{"sub": "ggicci"}))
+		_, _, err := ja.Authenticate(httptest.NewRecorder(), r)
+		return err
+	}
+	assert.Nil(t, authenticate())
+
+	// blocked at runtime, and persisted
+	r, _ := http.NewRequest("POST", "/jwtauth/blocked-kids?kid="+jwkKey.KeyID(), nil)
+	assert.Nil(t, adminAPI{}.handleBlockedKIDs(httptest.NewRecorder(), r))
+	assert.ErrorIs(t, authenticate(), ErrKeyBlocked)
+	data, err := os.ReadFile(path)
+	assert.Nil(t, err)
+	var persisted []string
+	assert.Nil(t, json.Unmarshal(data, &persisted))
+	assert.Equal(t, []string{jwkKey.KeyID()}, persisted)
+
+	r, _ = http.NewRequest("DELETE", "/jwtauth/blocked-kids?kid="+jwkKey.KeyID(), nil)
+	assert.Nil(t, adminAPI{}.handleBlockedKIDs(httptest.NewRecorder(), r))
+	assert.Nil(t, authenticate())
+
+	// blocked by config
+	ja.BlockKIDs = []string{jwkKey.KeyID()}
+	assert.ErrorIs(t, authenticate(), ErrKeyBlocked)
+}
+
+func TestLoadBlockedKIDs(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "blocked_kids.json")
+	defer func(original func() string) { blockedKIDsPath = original }(blockedKIDsPath)
+	blockedKIDsPath = func() string { return path }
+	assert.Nil(t, os.WriteFile(path, []byte(`["a", "b"]`), 0600))
+
+	blockedKIDs.mu.Lock()
+	blockedKIDs.loaded = false
+	blockedKIDs.mu.Unlock()
+	assert.Nil(t, loadBlockedKIDs())
+	assert.Subset(t, listBlockedKIDs(), []string{"a", "b"})
+	assert.Nil(t, setKIDBlocked("a", false))
+	assert.Nil(t, setKIDBlocked("b", false))
+}
@@ -0,0 +1,102 @@
+package caddyjwt
+
+import (
+	"bytes"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// maxBodyTokenScan is the maximum size of a request body scanned for the
+// tokens, see FromBody. The larger bodies are not scanned.
+const maxBodyTokenScan = 1 << 20
+
+// replayBody is a request body put back after being read, see
+// getTokensFromBody.
+type replayBody struct {
+	io.Reader
+	io.Closer
+}
+
+// getTokensFromBody extracts the tokens from the fields of a form in the
+// request body, either application/x-www-form-urlencoded or
+// multipart/form-data. The body is restored after read, so it's intact for
+// the handlers downstream.
+func getTokensFromBody(r *http.Request, names []string) []candidateToken {
+	tokens := make([]candidateToken, 0)
+	if len(names) == 0 || r.Body == nil || r.Body == http.NoBody {
+		return tokens
+	}
+	mediaType, params, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil || (mediaType != "application/x-www-form-urlencoded" && mediaType != "multipart/form-data") {
+		return tokens
+	}
+
+	data, err := io.ReadAll(io.LimitReader(r.Body, maxBodyTokenScan+1))
+	r.Body = replayBody{io.MultiReader(bytes.NewReader(data), r.Body), r.Body}
+	if err != nil || len(data) > maxBodyTokenScan {
+		return tokens
+	}
+
+	fields := make(url.Values)
+	if mediaType == "multipart/form-data" {
+		reader := multipart.NewReader(bytes.NewReader(data), params["boundary"])
+		for {
+			part, err := reader.NextPart()
+			if err != nil {
+				break
+			}
+			if part.FileName() == "" {
+				value, _ := io.ReadAll(part)
+				fields.Add(part.FormName(), string(value))
+			}
+		}
+	} else if fields, err = url.ParseQuery(string(data)); err != nil {
+		return tokens
+	}
+	for _, key := range names {
+		if token := fields.Get(key); token != "" {
+			tokens = append(tokens, candidateToken{sourceBody, key, token})
+		}
+	}
+	return tokens
+}
+
+// stripTokensFromBody removes the fields of the names from the request body
+// of application/x-www-form-urlencoded. The multipart/form-data bodies and
+// the bodies too large to scan are left intact.
+func stripTokensFromBody(r *http.Request, names []string) {
+	if len(names) == 0 || r.Body == nil || r.Body == http.NoBody {
+		return
+	}
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil || mediaType != "application/x-www-form-urlencoded" {
+		return
+	}
+
+	body := r.Body
+	data, err := io.ReadAll(io.LimitReader(body, maxBodyTokenScan+1))
+	r.Body = replayBody{io.MultiReader(bytes.NewReader(data), body), body}
+	if err != nil || len(data) > maxBodyTokenScan {
+		return
+	}
+	fields, err := url.ParseQuery(string(data))
+	if err != nil {
+		return
+	}
+	stripped := false
+	for _, key := range names {
+		if fields.Has(key) {
+			fields.Del(key)
+			stripped = true
+		}
+	}
+	if stripped {
+		encoded := fields.Encode()
+		r.Body = replayBody{strings.NewReader(encoded), body}
+		r.ContentLength = int64(len(encoded))
+	}
+}
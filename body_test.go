@@ -0,0 +1,68 @@
+package caddyjwt
+
+import (
+	"bytes"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAuthenticate_FromBody(t *testing.T) {
+	ja := &JWTAuth{SignKey: TestSignKey, FromBody: []string{"id_token", "assertion"}, logger: testLogger}
+	assert.Nil(t, ja.Validate())
+	token := issueTokenString(MapClaims{"sub": "ggicci"})
+
+	authenticate := func(r *http.Request) (bool, string) {
+		_, authenticated, _ := ja.Authenticate(httptest.NewRecorder(), r)
+		body, _ := io.ReadAll(r.Body)
+		return authenticated, string(body)
+	}
+
+	// url-encoded
+	form := url.Values{"state": {"xyz"}, "assertion": {token}}.Encode()
+	r, _ := http.NewRequest("POST", "/callback", strings.NewReader(form))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	authenticated, body := authenticate(r)
+	assert.True(t, authenticated)
+	assert.Equal(t, form, body)
+
+	// multipart
+	var buf bytes.Buffer
+	mw := multipart.NewWriter(&buf)
+	assert.Nil(t, mw.WriteField("id_token", token))
+	assert.Nil(t, mw.Close())
+	multipartBody := buf.String()
+	r, _ = http.NewRequest("POST", "/callback", strings.NewReader(multipartBody))
+	r.Header.Set("Content-Type", mw.FormDataContentType())
+	authenticated, body = authenticate(r)
+	assert.True(t, authenticated)
+	assert.Equal(t, multipartBody, body)
+
+	// not a form
+	r, _ = http.NewRequest("POST", "/callback", strings.NewReader(`{"id_token": "`+token+`"}`))
+	r.Header.Set("Content-Type", "application/json")
+	authenticated, _ = authenticate(r)
+	assert.False(t, authenticated)
+
+	// too large to scan
+	large := "assertion=" + token + "&padding=" + strings.Repeat("x", maxBodyTokenScan)
+	r, _ = http.NewRequest("POST", "/callback", strings.NewReader(large))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	authenticated, body = authenticate(r)
+	assert.False(t, authenticated)
+	assert.Equal(t, large, body)
+
+	// from_query doesn't drain the form of the body
+	ja.FromQuery = []string{"access_token"}
+	r, _ = http.NewRequest("POST", "/callback?state=xyz", strings.NewReader(form))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	authenticated, body = authenticate(r)
+	assert.True(t, authenticated)
+	assert.Equal(t, form, body)
+}
@@ -0,0 +1,290 @@
+package caddyjwt
+
+import (
+	"container/heap"
+	"container/list"
+	"runtime/debug"
+	runtimemetrics "runtime/metrics"
+	"sync"
+	"time"
+)
+
+// defaultCacheMaxEntries is the default capacity of a ttlCache.
+const defaultCacheMaxEntries = 10000
+
+// cacheEntryOverhead approximates the bytes taken by an entry besides its
+// key and value, i.e. the map bucket and the expiry.
+const cacheEntryOverhead = 64
+
+// ttlCache is a simple in-memory LRU cache whose entries expire after a TTL.
+// Its capacity is bounded by the number of entries and, optionally, by the
+// approximate bytes of the entries. Under memory pressure, see
+// underMemoryPressure, it shrinks to half of its capacity. All the
+// operations but DeleteFunc take O(log n) at most.
+type ttlCache struct {
+	mu         sync.Mutex
+	entries    map[string]*list.Element // of *ttlCacheEntry
+	lru        *list.List               // the most recently used first
+	expiry     expiryHeap               // the soonest to expire first
+	maxEntries int
+	maxBytes   int // 0 means unlimited
+	bytes      int
+	now        func() time.Time
+	pressure   func() bool
+}
+
+type ttlCacheEntry struct {
+	key     string
+	value   interface{}
+	size    int
+	expires time.Time
+	index   int // in the expiry heap
+}
+
+func newTTLCache(maxEntries, maxBytes int) *ttlCache {
+	if maxEntries <= 0 {
+		maxEntries = defaultCacheMaxEntries
+	}
+	return &ttlCache{
+		entries:    make(map[string]*list.Element),
+		lru:        list.New(),
+		maxEntries: maxEntries,
+		maxBytes:   maxBytes,
+		now:        time.Now,
+		pressure:   underMemoryPressure,
+	}
+}
+
+// Get returns the value of the key if it's present and not expired, and
+// marks it as the most recently used.
+func (c *ttlCache) Get(key string) (interface{}, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	elem, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+	entry := elem.Value.(*ttlCacheEntry)
+	if !c.now().Before(entry.expires) {
+		c.remove(elem)
+		return nil, false
+	}
+	c.lru.MoveToFront(elem)
+	return entry.value, true
+}
+
+// Set stores the value of the key for ttl. When the cache is full, the
+// expired entries are evicted first, then the least recently used ones.
+func (c *ttlCache) Set(key string, value interface{}, ttl time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.set(key, value, ttl, true)
+}
+
+// TrySet stores the value of the key for ttl, like Set, but evicts only the
+// expired entries. It reports false, storing nothing, if the cache is full
+// of the live entries, e.g. of the jtis which must not be forgotten before
+// they expire.
+func (c *ttlCache) TrySet(key string, value interface{}, ttl time.Duration) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.set(key, value, ttl, false)
+}
+
+// set does the job of Set and TrySet. The caller must hold the lock.
+func (c *ttlCache) set(key string, value interface{}, ttl time.Duration, evictLive bool) bool {
+	if elem, ok := c.entries[key]; ok {
+		c.remove(elem)
+	}
+	size := approxSize(key, value)
+	if c.maxBytes > 0 && size > c.maxBytes {
+		return false // never fits
+	}
+
+	maxEntries, maxBytes := c.maxEntries, c.maxBytes
+	if c.pressure() {
+		maxEntries, maxBytes = maxEntries/2, maxBytes/2
+	}
+	fits := func() bool {
+		return len(c.entries) < maxEntries && (c.maxBytes == 0 || c.bytes+size <= maxBytes)
+	}
+	if !fits() {
+		now := c.now()
+		for len(c.expiry) > 0 && !now.Before(c.expiry[0].expires) && !fits() {
+			c.remove(c.entries[c.expiry[0].key])
+		}
+	}
+	for !fits() {
+		if !evictLive || c.lru.Len() == 0 {
+			return false
+		}
+		c.remove(c.lru.Back())
+	}
+	entry := &ttlCacheEntry{key: key, value: value, size: size, expires: c.now().Add(ttl)}
+	c.entries[key] = c.lru.PushFront(entry)
+	heap.Push(&c.expiry, entry)
+	c.bytes += size
+	return true
+}
+
+// Delete removes the key from the cache.
+func (c *ttlCache) Delete(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if elem, ok := c.entries[key]; ok {
+		c.remove(elem)
+	}
+}
+
+// Len returns the number of entries, including the expired ones not evicted.
+func (c *ttlCache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.entries)
+}
+
+// Bytes returns the approximate bytes taken by the entries.
+func (c *ttlCache) Bytes() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.bytes
+}
+
+// remove deletes the entry. The caller must hold the lock.
+func (c *ttlCache) remove(elem *list.Element) {
+	entry := c.lru.Remove(elem).(*ttlCacheEntry)
+	heap.Remove(&c.expiry, entry.index)
+	delete(c.entries, entry.key)
+	c.bytes -= entry.size
+}
+
+// expiryHeap is a min-heap of the entries of a ttlCache by their expiry.
+type expiryHeap []*ttlCacheEntry
+
+func (h expiryHeap) Len() int           { return len(h) }
+func (h expiryHeap) Less(i, j int) bool { return h[i].expires.Before(h[j].expires) }
+
+func (h expiryHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index, h[j].index = i, j
+}
+
+func (h *expiryHeap) Push(x interface{}) {
+	entry := x.(*ttlCacheEntry)
+	entry.index = len(*h)
+	*h = append(*h, entry)
+}
+
+func (h *expiryHeap) Pop() interface{} {
+	old := *h
+	entry := old[len(old)-1]
+	old[len(old)-1] = nil
+	*h = old[:len(old)-1]
+	return entry
+}
+
+// approxSize approximates the bytes taken by an entry of the cache.
+func approxSize(key string, value interface{}) int {
+	size := cacheEntryOverhead + len(key)
+	switch v := value.(type) {
+	case string:
+		size += len(v)
+	case map[string]string:
+		for k, val := range v {
+			size += len(k) + len(val) + 2*16 // string headers
+		}
+	default:
+		size += 16
+	}
+	return size
+}
+
+// memoryPressureRatio is the ratio of the Go memory limit (GOMEMLIMIT) above
+// which the process is considered under memory pressure.
+const memoryPressureRatio = 0.9
+
+var memoryPressure struct {
+	mu        sync.Mutex
+	checkedAt time.Time
+	under     bool
+}
+
+// underMemoryPressure reports whether the memory used by the Go runtime
+// exceeds memoryPressureRatio of the memory limit, if any is set. The result
+// is refreshed at most once per second.
+func underMemoryPressure() bool {
+	memoryPressure.mu.Lock()
+	defer memoryPressure.mu.Unlock()
+	if time.Since(memoryPressure.checkedAt) < time.Second {
+		return memoryPressure.under
+	}
+	memoryPressure.checkedAt = time.Now()
+	memoryPressure.under = false
+
+	limit := debug.SetMemoryLimit(-1) // only reads the limit
+	if limit <= 0 || limit == int64(^uint64(0)>>1) {
+		return false // no limit
+	}
+	sample := []runtimemetrics.Sample{{Name: "/memory/classes/total:bytes"}}
+	runtimemetrics.Read(sample)
+	if sample[0].Value.Kind() == runtimemetrics.KindUint64 {
+		memoryPressure.under = float64(sample[0].Value.Uint64()) > memoryPressureRatio*float64(limit)
+	}
+	return memoryPressure.under
+}
+
+// DeleteFunc removes the keys for which match returns true, and returns the
+// number of removed entries.
+func (c *ttlCache) DeleteFunc(match func(key string) bool) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	n := 0
+	for key, elem := range c.entries {
+		if match(key) {
+			c.remove(elem)
+			n++
+		}
+	}
+	return n
+}
+
+// subjectCache is a ttlCache whose entries belong to subjects, e.g. the
+// enrichment results. isSubject tells whether a key belongs to a subject.
+type subjectCache struct {
+	cache     *ttlCache
+	isSubject func(key, sub string) bool
+}
+
+// subjectCaches are the subject caches of all the JWT providers in this
+// process, which can be purged via the admin API.
+var subjectCaches = struct {
+	mu     sync.Mutex
+	caches map[*ttlCache]subjectCache
+}{caches: make(map[*ttlCache]subjectCache)}
+
+func registerSubjectCache(c *ttlCache, isSubject func(key, sub string) bool) {
+	subjectCaches.mu.Lock()
+	defer subjectCaches.mu.Unlock()
+	subjectCaches.caches[c] = subjectCache{cache: c, isSubject: isSubject}
+}
+
+func unregisterSubjectCache(c *ttlCache) {
+	subjectCaches.mu.Lock()
+	defer subjectCaches.mu.Unlock()
+	delete(subjectCaches.caches, c)
+}
+
+// purgeSubjectCaches removes the entries of the subject from all the subject
+// caches, or all the entries if sub is empty. Returns the number of removed
+// entries.
+func purgeSubjectCaches(sub string) int {
+	subjectCaches.mu.Lock()
+	defer subjectCaches.mu.Unlock()
+	n := 0
+	for _, sc := range subjectCaches.caches {
+		n += sc.cache.DeleteFunc(func(key string) bool {
+			return sub == "" || sc.isSubject(key, sub)
+		})
+	}
+	return n
+}
@@ -1,14 +1,22 @@
 package caddyjwt
 
 import (
+	"encoding/json"
 	"fmt"
+	"reflect"
+	"strconv"
 	"strings"
+	"unsafe"
+
+	"github.com/dustin/go-humanize"
 
 	"github.com/caddyserver/caddy/v2"
 	"github.com/caddyserver/caddy/v2/caddyconfig"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
 	"github.com/caddyserver/caddy/v2/caddyconfig/httpcaddyfile"
 	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
 	"github.com/caddyserver/caddy/v2/modules/caddyhttp/caddyauth"
+	"go.uber.org/zap"
 )
 
 func init() {
@@ -23,67 +31,1939 @@ func init() {
 //	}
 func parseCaddyfile(h httpcaddyfile.Helper) (caddyhttp.MiddlewareHandler, error) {
 	var ja JWTAuth
-
+	seen := make(optionLines)
 	for h.Next() {
 		for h.NextBlock(0) {
+			opt, ok, err := migrateDeprecatedOption(h, &ja, seen)
+			if err != nil {
+				return nil, err
+			}
+			if !ok {
+				continue
+			}
+			if err := parseOption(h, &ja, opt, seen); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return caddyauth.Authentication{
+		ProvidersRaw: caddy.ModuleMap{
+			"jwt": caddyconfig.JSON(ja, nil),
+		},
+	}, nil
+}
+
+// parseOption parses an option of the jwtauth directive. An option may only
+// be given once unless it's repeatable, see caddyfileOptions, and must not
+// be followed by a block unless it takes one.
+func parseOption(h httpcaddyfile.Helper, ja *JWTAuth, opt string, seen optionLines) error {
+	if err := seen.add(h, opt); err != nil {
+		return err
+	}
+	var err error
+	switch opt {
+	case "sign_key":
+		if !h.AllArgs(&ja.SignKey) {
+			return h.Errf("invalid sign_key: %q", ja.SignKey)
+		}
+	case "sign_alg":
+		if !h.AllArgs(&ja.SignAlgorithm) {
+			return h.Errf("invalid sign_alg: %q", ja.SignAlgorithm)
+		}
+	case "allowed_algorithms":
+		ja.AllowedAlgorithms = append(ja.AllowedAlgorithms, h.RemainingArgs()...)
+		if len(ja.AllowedAlgorithms) == 0 {
+			return h.Errf("invalid allowed_algorithms: missing algorithms")
+		}
+	case "sign_key_file":
+		if !h.AllArgs(&ja.SignKeyFile) {
+			return h.Errf("invalid sign_key_file: %q", ja.SignKeyFile)
+		}
+	case "jwk_file":
+		if !h.AllArgs(&ja.JWKFile) {
+			return h.Errf("invalid jwk_file: %q", ja.JWKFile)
+		}
+	case "jwk_sets":
+		for _, raw := range h.RemainingArgs() {
+			if !json.Valid([]byte(raw)) {
+				return h.Errf("invalid jwk_sets: malformed JSON")
+			}
+			ja.JWKSets = append(ja.JWKSets, json.RawMessage(raw))
+		}
+		if len(ja.JWKSets) == 0 {
+			return h.ArgErr()
+		}
+	case "jwk_files":
+		ja.JWKFiles = append(ja.JWKFiles, h.RemainingArgs()...)
+		if len(ja.JWKFiles) == 0 {
+			return h.ArgErr()
+		}
+	case "jwk_url":
+		if !h.AllArgs(&ja.JWKURL) {
+			return h.Errf("invalid jwk_url: %q", ja.JWKURL)
+		}
+	case "jwk_url_tenants":
+		if ja.JWKURLTenants, err = parseJWKURLTenants(h); err != nil {
+			return err
+		}
+	case "key_not_found_backoff":
+		if ja.KeyNotFoundBackoff, err = parseDurationArg(h); err != nil {
+			return h.Errf("invalid key_not_found_backoff: %w", err)
+		}
+	case "jwk_refresh_interval":
+		if ja.JWKRefreshInterval, err = parseDurationArg(h); err != nil {
+			return h.Errf("invalid jwk_refresh_interval: %w", err)
+		}
+	case "issuers":
+		if ja.Issuers, err = parseIssuers(h); err != nil {
+			return err
+		}
+	case "decrypt_key":
+		if !h.AllArgs(&ja.DecryptKey) {
+			return h.Errf("invalid decrypt_key: %q", ja.DecryptKey)
+		}
+	case "decrypt_key_file":
+		if !h.AllArgs(&ja.DecryptKeyFile) {
+			return h.Errf("invalid decrypt_key_file: %q", ja.DecryptKeyFile)
+		}
+	case "max_decompressed_size":
+		var raw string
+		if !h.AllArgs(&raw) {
+			return h.Errf("invalid max_decompressed_size: %q", raw)
+		}
+		if ja.MaxDecompressedSize, err = strconv.Atoi(raw); err != nil {
+			return h.Errf("invalid max_decompressed_size: %w", err)
+		}
+	case "oidc_issuer":
+		if !h.AllArgs(&ja.OIDCIssuer) {
+			return h.Errf("invalid oidc_issuer: %q", ja.OIDCIssuer)
+		}
+	case "from_query":
+		if ja.FromQuery = h.RemainingArgs(); len(ja.FromQuery) == 0 {
+			return h.Errf("invalid from_query: expect <param...>")
+		}
+
+	case "from_header":
+		if ja.FromHeader = h.RemainingArgs(); len(ja.FromHeader) == 0 {
+			return h.Errf("invalid from_header: expect <header...>")
+		}
+
+	case "from_cookies":
+		if ja.FromCookies = h.RemainingArgs(); len(ja.FromCookies) == 0 {
+			return h.Errf("invalid from_cookies: expect <cookie...>")
+		}
+
+	case "from_body":
+		if ja.FromBody = h.RemainingArgs(); len(ja.FromBody) == 0 {
+			return h.Errf("invalid from_body: expect <field...>")
+		}
+
+	case "source_priority":
+		if ja.SourcePriority = h.RemainingArgs(); len(ja.SourcePriority) == 0 {
+			return h.Errf("invalid source_priority: expect <source...>")
+		}
+
+	case "sources_exclusive":
+		if h.NextArg() {
+			return h.ArgErr()
+		}
+		ja.SourcesExclusive = true
+
+	case "block_kids":
+		ja.BlockKIDs = append(ja.BlockKIDs, h.RemainingArgs()...)
+		if len(ja.BlockKIDs) == 0 {
+			return h.Errf("invalid block_kids: expect <kid...>")
+		}
+
+	case "audience_whitelist":
+		if ja.AudienceWhitelist = h.RemainingArgs(); len(ja.AudienceWhitelist) == 0 {
+			return h.Errf("invalid audience_whitelist: expect <audience...>")
+		}
+
+	case "audience":
+		if !h.AllArgs(&ja.Audience) {
+			return h.Errf("invalid audience: %q", ja.Audience)
+		}
+
+	case "issuer_whitelist":
+		if ja.IssuerWhitelist = h.RemainingArgs(); len(ja.IssuerWhitelist) == 0 {
+			return h.Errf("invalid issuer_whitelist: expect <issuer...>")
+		}
+
+	case "issuer_aliases":
+		args := h.RemainingArgs()
+		if len(args) != 2 {
+			return h.Errf("invalid issuer_aliases: expect <alias> <canonical>")
+		}
+		if ja.IssuerAliases == nil {
+			ja.IssuerAliases = make(map[string]string)
+		}
+		ja.IssuerAliases[args[0]] = args[1]
+
+	case "except_paths":
+		ja.ExceptPaths = append(ja.ExceptPaths, h.RemainingArgs()...)
+		if len(ja.ExceptPaths) == 0 {
+			return h.Errf("invalid except_paths: expect <path...>")
+		}
+
+	case "allow_options_preflight":
+		if h.NextArg() {
+			return h.ArgErr()
+		}
+		ja.AllowOptionsPreflight = true
+
+	case "subject_pattern":
+		if ja.SubjectPattern = h.RemainingArgs(); len(ja.SubjectPattern) == 0 {
+			return h.Errf("invalid subject_pattern: expect <pattern...>")
+		}
+
+	case "user_claims":
+		if ja.UserClaims = h.RemainingArgs(); len(ja.UserClaims) == 0 {
+			return h.Errf("invalid user_claims: expect <claim...>")
+		}
+
+	case "user_id_format":
+		if !h.AllArgs(&ja.UserIDFormat) {
+			return h.Errf("invalid user_id_format: expect <format>")
+		}
+
+	case "meta_claims":
+		if ja.MetaClaims, err = parseMetaClaims(h); err != nil {
+			return h.Errf("invalid meta_claims: %w", err)
+		}
+	case "array_format":
+		if ja.ArrayFormat, err = parseArrayFormat(h); err != nil {
+			return err
+		}
+	case "meta_claims_json":
+		if h.NextArg() {
+			return h.ArgErr()
+		}
+		ja.MetaClaimsJSON = true
+	case "forward_claims_header":
+		ja.ForwardClaimsHeader = make(map[string]string)
+		for _, mapping := range h.RemainingArgs() {
+			claim, header, err := parseMetaClaim(mapping)
+			if err != nil {
+				return h.Errf("invalid forward_claims_header: %w", err)
+			}
+			if _, ok := ja.ForwardClaimsHeader[claim]; ok {
+				return h.Errf("invalid forward_claims_header: duplicate claim: %s", claim)
+			}
+			ja.ForwardClaimsHeader[claim] = header
+		}
+	case "forwarded_claims":
+		ja.ForwardedClaims = make(map[string]string)
+		for _, mapping := range h.RemainingArgs() {
+			claim, param, err := parseMetaClaim(mapping)
+			if err != nil {
+				return h.Errf("invalid forwarded_claims: %w", err)
+			}
+			if _, ok := ja.ForwardedClaims[claim]; ok {
+				return h.Errf("invalid forwarded_claims: duplicate claim: %s", claim)
+			}
+			ja.ForwardedClaims[claim] = param
+		}
+	case "strip_token":
+		if h.NextArg() {
+			return h.ArgErr()
+		}
+		ja.StripToken = true
+
+	case "validate_exp":
+		if ja.ValidateExp, err = parseBoolArg(h); err != nil {
+			return h.Errf("invalid validate_exp: %w", err)
+		}
+	case "validate_nbf":
+		if ja.ValidateNbf, err = parseBoolArg(h); err != nil {
+			return h.Errf("invalid validate_nbf: %w", err)
+		}
+	case "validate_iat":
+		if ja.ValidateIat, err = parseBoolArg(h); err != nil {
+			return h.Errf("invalid validate_iat: %w", err)
+		}
+	case "normalize_token":
+		args := h.RemainingArgs()
+		if len(args) < 2 {
+			return h.Errf("invalid normalize_token: expect <source> <rule...>")
+		}
+		if ja.NormalizeToken == nil {
+			ja.NormalizeToken = make(map[string][]string)
+		}
+		if _, ok := ja.NormalizeToken[args[0]]; ok {
+			return h.Errf("invalid normalize_token: duplicate source: %s", args[0])
+		}
+		ja.NormalizeToken[args[0]] = args[1:]
+	case "header_scheme":
+		ja.HeaderScheme = append(ja.HeaderScheme, h.RemainingArgs()...)
+		if len(ja.HeaderScheme) == 0 {
+			return h.Errf("invalid header_scheme: expect <scheme...>")
+		}
+	case "header_prefix":
+		args := h.RemainingArgs()
+		if len(args) != 2 {
+			return h.Errf("invalid header_prefix: expect <header> <pattern>")
+		}
+		if ja.HeaderPrefix == nil {
+			ja.HeaderPrefix = make(map[string]string)
+		}
+		ja.HeaderPrefix[args[0]] = args[1]
+	case "expired_redirect":
+		if !h.AllArgs(&ja.ExpiredRedirect) {
+			return h.Errf("invalid expired_redirect: %q", ja.ExpiredRedirect)
+		}
+	case "expired_flash_cookie":
+		if !h.AllArgs(&ja.ExpiredFlashCookie) {
+			return h.Errf("invalid expired_flash_cookie: %q", ja.ExpiredFlashCookie)
+		}
+	case "shared_jwks":
+		ja.SharedJWKs = &SharedJWKs{}
+		if h.NextArg() {
+			d, err := caddy.ParseDuration(h.Val())
+			if err != nil {
+				return h.Errf("invalid shared_jwks: %w", err)
+			}
+			ja.SharedJWKs.MaxAge = caddy.Duration(d)
+		}
+		if h.NextArg() {
+			return h.ArgErr()
+		}
+	case "require_keys_at_startup":
+		ja.RequireKeysAtStartup = &KeysAtStartup{}
+		if h.NextArg() {
+			if h.Val() != "lenient" {
+				return h.Errf("invalid require_keys_at_startup: unknown flag %q", h.Val())
+			}
+			ja.RequireKeysAtStartup.Lenient = true
+		}
+		if h.NextArg() {
+			return h.ArgErr()
+		}
+	case "leeway":
+		if ja.Leeway, err = parseDurationArg(h); err != nil {
+			return h.Errf("invalid leeway: %w", err)
+		}
+	case "max_token_age":
+		if ja.MaxTokenAge, err = parseDurationArg(h); err != nil {
+			return h.Errf("invalid max_token_age: %w", err)
+		}
+	case "require_exp":
+		if h.NextArg() {
+			return h.ArgErr()
+		}
+		ja.RequireExp = true
+	case "strict_rfc9068":
+		if h.NextArg() {
+			return h.ArgErr()
+		}
+		ja.StrictRFC9068 = true
+	case "require_typ":
+		if h.NextArg() {
+			return h.ArgErr()
+		}
+		ja.RequireTyp = true
+	case "allowed_typ":
+		ja.AllowedTyp = append(ja.AllowedTyp, h.RemainingArgs()...)
+		if len(ja.AllowedTyp) == 0 {
+			return h.Errf("invalid allowed_typ: expect <typ...>")
+		}
+	case "allowed_crit":
+		ja.AllowedCrit = append(ja.AllowedCrit, h.RemainingArgs()...)
+		if len(ja.AllowedCrit) == 0 {
+			return h.Errf("invalid allowed_crit: expect <param...>")
+		}
+	case "expired_grace":
+		if ja.ExpiredGrace, err = parseDurationArg(h); err != nil {
+			return h.Errf("invalid expired_grace: %w", err)
+		}
+	case "expiring_window":
+		if ja.ExpiringWindow, err = parseDurationArg(h); err != nil {
+			return h.Errf("invalid expiring_window: %w", err)
+		}
+	case "query_token_no_store":
+		if h.NextArg() {
+			return h.ArgErr()
+		}
+		ja.QueryTokenNoStore = true
+	case "query_token_policy":
+		if !h.AllArgs(&ja.QueryTokenPolicy) {
+			return h.Errf("invalid query_token_policy: expect allow, warn, redact or deny")
+		}
+	case "expiring_header":
+		if !h.AllArgs(&ja.ExpiringHeader) {
+			return h.Errf("invalid expiring_header: %q", ja.ExpiringHeader)
+		}
+	case "matched_audience_header":
+		if !h.AllArgs(&ja.MatchedAudienceHeader) {
+			return h.Errf("invalid matched_audience_header: %q", ja.MatchedAudienceHeader)
+		}
+	case "name":
+		if !h.AllArgs(&ja.Name) {
+			return h.Errf("invalid name: %q", ja.Name)
+		}
+	case "principal_type":
+		if !h.AllArgs(&ja.PrincipalType) {
+			return h.Errf("invalid principal_type: %q", ja.PrincipalType)
+		}
+	case "bind_claims":
+		args := h.RemainingArgs()
+		if len(args) != 2 {
+			return h.Errf("invalid bind_claims: expect <claim> <value>")
+		}
+		if ja.BindClaims == nil {
+			ja.BindClaims = make(map[string]string)
+		}
+		ja.BindClaims[args[0]] = args[1]
+	case "require_env":
+		args := h.RemainingArgs()
+		if len(args) == 0 || len(args) > 2 {
+			return h.Errf("invalid require_env: expect <env> [<claim>]")
+		}
+		ja.RequireEnv = args[0]
+		if len(args) == 2 {
+			ja.EnvClaim = args[1]
+		}
+	case "verification_workers":
+		var raw string
+		if !h.AllArgs(&raw) {
+			return h.Errf("invalid verification_workers: %q", raw)
+		}
+		if ja.VerificationWorkers, err = strconv.Atoi(raw); err != nil {
+			return h.Errf("invalid verification_workers: %w", err)
+		}
+	case "claim_policies":
+		name, policy, err := parseClaimPolicy(h)
+		if err != nil {
+			return err
+		}
+		if ja.ClaimPolicies == nil {
+			ja.ClaimPolicies = make(map[string]ClaimPolicy)
+		}
+		if _, ok := ja.ClaimPolicies[name]; ok {
+			return h.Errf("invalid claim_policies: duplicate policy: %s", name)
+		}
+		ja.ClaimPolicies[name] = policy
+	case "require":
+		args := h.RemainingArgs()
+		if len(args) < 2 {
+			return h.Errf("invalid require: expect <claim> <value...>")
+		}
+		ja.Require = append(ja.Require, ClaimRequirement{Claim: args[0], Values: args[1:]})
+	case "verify_claims":
+		args := h.RemainingArgs()
+		if len(args) < 2 {
+			return h.Errf("invalid verify_claims: expect <claim> <op><value> or <claim> exists")
+		}
+		expr := strings.Join(args, " ")
+		if _, err := compileClaimCheck(expr); err != nil {
+			return h.Errf("invalid verify_claims: %v", err)
+		}
+		ja.VerifyClaims = append(ja.VerifyClaims, expr)
+	case "require_scope":
+		ja.RequireScope = append(ja.RequireScope, h.RemainingArgs()...)
+		if len(ja.RequireScope) == 0 {
+			return h.Errf("invalid require_scope: expect <scope...>")
+		}
+	case "scope_match":
+		if !h.AllArgs(&ja.ScopeMatch) {
+			return h.Errf("invalid scope_match: %q", ja.ScopeMatch)
+		}
+	case "roles_claim":
+		if !h.AllArgs(&ja.RolesClaim) {
+			return h.Errf("invalid roles_claim: %q", ja.RolesClaim)
+		}
+	case "require_role":
+		ja.RequireRole = append(ja.RequireRole, h.RemainingArgs()...)
+		if len(ja.RequireRole) == 0 {
+			return h.Errf("invalid require_role: expect <role...>")
+		}
+	case "map_groups":
+		if ja.MapGroups, err = parseMapGroups(h); err != nil {
+			return err
+		}
+	case "request_id_header":
+		if !h.AllArgs(&ja.RequestIDHeader) {
+			return h.Errf("invalid request_id_header: %q", ja.RequestIDHeader)
+		}
+	case "expose_request_id":
+		if h.NextArg() {
+			return h.ArgErr()
+		}
+		ja.ExposeRequestID = true
+	case "policy_trace":
+		if h.NextArg() {
+			return h.ArgErr()
+		}
+		ja.PolicyTrace = true
+	case "redaction":
+		if !h.AllArgs(&ja.Redaction) {
+			return h.Errf("invalid redaction: %q", ja.Redaction)
+		}
+	case "audit":
+		if h.NextArg() {
+			return h.ArgErr()
+		}
+		ja.Audit = true
+	case "policy_trace_header":
+		if !h.AllArgs(&ja.PolicyTraceHeader, &ja.PolicyTraceSecret) {
+			return h.Errf("invalid policy_trace_header: expect <header> <secret>")
+		}
+	case "claims_schema":
+		if !h.AllArgs(&ja.ClaimsSchema) {
+			return h.Errf("invalid claims_schema: %q", ja.ClaimsSchema)
+		}
+	case "validate_expression":
+		if !h.AllArgs(&ja.ValidateExpression) {
+			return h.Errf("invalid validate_expression: expect exactly one quoted expression")
+		}
+	case "claim_policy":
+		if !h.AllArgs(&ja.ClaimPolicyName) {
+			return h.Errf("invalid claim_policy: %q", ja.ClaimPolicyName)
+		}
+	case "enrich":
+		if ja.Enrich, err = parseEnrichment(h); err != nil {
+			return err
+		}
+	case "validation_cache":
+		if ja.ValidationCache, err = parseValidationCache(h); err != nil {
+			return err
+		}
+	case "issue_session_cookie":
+		if ja.IssueSessionCookie, err = parseSessionCookie(h); err != nil {
+			return err
+		}
+	case "failure_rate_limit":
+		if ja.FailureRateLimit, err = parseFailureRateLimit(h); err != nil {
+			return err
+		}
+	case "dpop":
+		if ja.DPoP, err = parseDPoP(h); err != nil {
+			return err
+		}
+	case "claims_anomaly":
+		if ja.ClaimsAnomaly, err = parseClaimsAnomaly(h); err != nil {
+			return err
+		}
+	case "userinfo":
+		if ja.UserInfo, err = parseUserInfo(h); err != nil {
+			return err
+		}
+	case "vault":
+		if ja.Vault, err = parseVault(h); err != nil {
+			return err
+		}
+	case "alb_oidc_data":
+		if ja.ALBOIDCData, err = parseALBOIDCData(h); err != nil {
+			return err
+		}
+	case "introspection":
+		if ja.Introspection, err = parseIntrospection(h); err != nil {
+			return err
+		}
+	case "revocation":
+		if ja.Revocation, err = parseRevocation(h); err != nil {
+			return err
+		}
+	case "one_time_tokens":
+		if ja.OneTimeTokens, err = parseOneTimeTokens(h); err != nil {
+			return err
+		}
+	case "maintenance":
+		if ja.Maintenance, err = parseMaintenance(h); err != nil {
+			return err
+		}
+	case "context_token":
+		if ja.ContextToken, err = parseContextToken(h); err != nil {
+			return err
+		}
+	case "bearer_challenge":
+		ja.BearerChallenge = &BearerChallenge{}
+		if h.NextArg() {
+			ja.BearerChallenge.Realm = h.Val()
+		}
+		if h.NextArg() {
+			return h.ArgErr()
+		}
+	case "failure_response":
+		if ja.FailureResponse, err = parseFailureResponse(h); err != nil {
+			return err
+		}
+	case "sandbox_parsing":
+		if ja.SandboxParsing, err = parseSandboxParsing(h); err != nil {
+			return err
+		}
+	case "deny_webhook":
+		if ja.DenyWebhook, err = parseDenyWebhook(h); err != nil {
+			return err
+		}
+	case "upstream_basic_auth":
+		if ja.UpstreamBasicAuth, err = parseUpstreamBasicAuth(h); err != nil {
+			return err
+		}
+	case "preset":
+		if err := expandPreset(h, ja, seen); err != nil {
+			return err
+		}
+	case "jwk":
+		if h.NextArg() {
+			return h.ArgErr()
+		}
+		for nesting := h.Nesting(); h.NextBlock(nesting); {
+			flat, ok := jwkBlockOptions[h.Val()]
+			if !ok {
+				return h.Errf("unrecognized jwk option: %s", h.Val())
+			}
+			if err := parseOption(h, ja, flat, seen); err != nil {
+				return err
+			}
+		}
+	default:
+		return unrecognizedOption(h, opt)
+	}
+	if h.NextBlock(h.Nesting()) {
+		return h.Errf("unexpected block: %s takes none", opt)
+	}
+	return nil
+}
+
+// caddyfileOptions are the options of the jwtauth directive, true if
+// repeatable, i.e. accumulating, e.g. one require per claim. The others may
+// only be given once, rather than the latter silently overriding the former.
+var caddyfileOptions = map[string]bool{
+	"preset": false, "sign_key": false, "sign_alg": false, "allowed_algorithms": true, "sign_key_file": false,
+	"jwk": false, "jwk_file": false, "jwk_sets": true, "jwk_files": true, "jwk_url": false, "vault": false,
+	"jwk_url_tenants": false, "jwk_refresh_interval": false, "key_not_found_backoff": false, "issuers": false,
+	"decrypt_key": false, "decrypt_key_file": false, "max_decompressed_size": false, "oidc_issuer": false,
+	"from_query": false, "from_header": false, "from_cookies": false, "from_body": false,
+	"source_priority": false, "sources_exclusive": false,
+	"block_kids": true, "audience_whitelist": false, "audience": false, "issuer_whitelist": false,
+	"issuer_aliases": true, "except_paths": true, "allow_options_preflight": false,
+	"subject_pattern": false, "user_claims": false, "user_id_format": false, "meta_claims": false, "array_format": false,
+	"meta_claims_json": false, "forward_claims_header": false, "forwarded_claims": false, "strip_token": false,
+	"validate_exp": false, "validate_nbf": false, "validate_iat": false,
+	"normalize_token": true, "header_scheme": true, "header_prefix": true,
+	"expired_redirect": false, "expired_flash_cookie": false, "shared_jwks": false,
+	"require_keys_at_startup": false, "leeway": false, "max_token_age": false, "require_exp": false,
+	"strict_rfc9068": false, "require_typ": false, "allowed_typ": true, "allowed_crit": true,
+	"expired_grace": false, "expiring_window": false, "query_token_no_store": false, "query_token_policy": false,
+	"expiring_header": false, "matched_audience_header": false, "name": false, "principal_type": false,
+	"bind_claims": true, "require_env": false, "verification_workers": false, "claim_policies": true,
+	"require": true, "verify_claims": true, "require_scope": true, "scope_match": false,
+	"roles_claim": false, "require_role": true, "map_groups": false, "request_id_header": false, "expose_request_id": false,
+	"policy_trace": false, "redaction": false, "audit": false, "policy_trace_header": false,
+	"claims_schema": false, "validate_expression": false, "claim_policy": false, "enrich": false,
+	"validation_cache": false, "issue_session_cookie": false, "failure_rate_limit": false, "dpop": false,
+	"claims_anomaly": false, "userinfo": false, "introspection": false, "alb_oidc_data": false, "revocation": false,
+	"one_time_tokens": false, "maintenance": false, "context_token": false, "bearer_challenge": false, "failure_response": false,
+	"sandbox_parsing": false, "deny_webhook": false, "upstream_basic_auth": false,
+}
+
+// jwkBlockOptions are the options of the jwk block, by the options of the
+// jwtauth directive they stand for. Syntax:
+//
+//	jwk {
+//	    url <jwk_url>
+//	    tenants { ... }
+//	    file <jwk_file>
+//	    files <jwk_file...>
+//	    sets <jwks_json...>
+//	    refresh <interval>
+//	    key_not_found_backoff <duration>
+//	    shared [<max_age>]
+//	    require_at_startup [lenient]
+//	}
+var jwkBlockOptions = map[string]string{
+	"url":                   "jwk_url",
+	"tenants":               "jwk_url_tenants",
+	"file":                  "jwk_file",
+	"files":                 "jwk_files",
+	"sets":                  "jwk_sets",
+	"refresh":               "jwk_refresh_interval",
+	"key_not_found_backoff": "key_not_found_backoff",
+	"shared":                "shared_jwks",
+	"require_at_startup":    "require_keys_at_startup",
+}
+
+// optionLines are the lines of the options parsed, to tell where a
+// duplicate was first given.
+type optionLines map[string]int
+
+// add records the current option, failing if it's a duplicate.
+func (ol optionLines) add(h httpcaddyfile.Helper, opt string) error {
+	if caddyfileOptions[opt] {
+		return nil
+	}
+	if line, ok := ol[opt]; ok {
+		return h.Errf("duplicate option: %s, already given at line %d", opt, line)
+	}
+	ol[opt] = h.Line()
+	return nil
+}
+
+// unrecognizedOption returns the error of an unrecognized option, which
+// suggests the closest of the options, if any, e.g. of a typo.
+func unrecognizedOption(h httpcaddyfile.Helper, opt string) error {
+	if opt == "{" {
+		return h.Errf("unexpected block: the option before takes none")
+	}
+	suggestion, best := "", 3 // at most 2 edits away
+	for known := range caddyfileOptions {
+		if d := editDistance(opt, known); d < best || d == best && known < suggestion {
+			suggestion, best = known, d
+		}
+	}
+	if suggestion != "" {
+		return h.Errf("unrecognized option: %s, did you mean %s?", opt, suggestion)
+	}
+	return h.Errf("unrecognized option: %s", opt)
+}
+
+// editDistance returns the Levenshtein distance of a and b.
+func editDistance(a, b string) int {
+	prev := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		curr := make([]int, len(b)+1)
+		curr[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			curr[j] = prev[j-1] + cost
+			if d := prev[j] + 1; d < curr[j] {
+				curr[j] = d
+			}
+			if d := curr[j-1] + 1; d < curr[j] {
+				curr[j] = d
+			}
+		}
+		prev = curr
+	}
+	return prev[len(b)]
+}
+
+// parseUpstreamBasicAuth parses the upstream_basic_auth block. Syntax:
+//
+//	upstream_basic_auth {
+//	    username_claim <claim>
+//	    password_claim <claim>
+//	    password <password>
+//	}
+func parseUpstreamBasicAuth(h httpcaddyfile.Helper) (*UpstreamBasicAuth, error) {
+	ba := &UpstreamBasicAuth{}
+	if h.NextArg() {
+		return nil, h.ArgErr()
+	}
+	for h.NextBlock(1) {
+		opt := h.Val()
+		switch opt {
+		case "username_claim":
+			if !h.AllArgs(&ba.UsernameClaim) {
+				return nil, h.Errf("invalid upstream_basic_auth username_claim: %q", ba.UsernameClaim)
+			}
+		case "password_claim":
+			if !h.AllArgs(&ba.PasswordClaim) {
+				return nil, h.Errf("invalid upstream_basic_auth password_claim: %q", ba.PasswordClaim)
+			}
+		case "password":
+			if !h.AllArgs(&ba.Password) {
+				return nil, h.Errf("invalid upstream_basic_auth password: %q", ba.Password)
+			}
+		default:
+			return nil, h.Errf("unrecognized upstream_basic_auth option: %s", opt)
+		}
+	}
+	return ba, nil
+}
+
+// parseVault parses the vault block. Syntax:
+//
+//	vault [<address>] {
+//	    namespace <namespace>
+//	    token <token>
+//	    approle <role_id> [<secret_id>]
+//	    approle_mount <path>
+//	    transit_key <name>
+//	    transit_mount <path>
+//	    identity_provider <name>
+//	    refresh <duration>
+//	    timeout <duration>
+//	}
+func parseVault(h httpcaddyfile.Helper) (*VaultKeys, error) {
+	v := &VaultKeys{}
+	if h.NextArg() {
+		v.Address = h.Val()
+		if h.NextArg() {
+			return nil, h.ArgErr()
+		}
+	}
+	var appRoleMount string
+	for h.NextBlock(1) {
+		opt := h.Val()
+		switch opt {
+		case "address", "namespace", "token", "approle_mount", "transit_key", "transit_mount", "identity_provider":
+			var value string
+			if !h.AllArgs(&value) {
+				return nil, h.Errf("invalid vault %s: expect exactly one argument", opt)
+			}
+			switch opt {
+			case "address":
+				v.Address = value
+			case "namespace":
+				v.Namespace = value
+			case "token":
+				v.Token = value
+			case "approle_mount":
+				appRoleMount = value
+			case "transit_key":
+				v.TransitKey = value
+			case "transit_mount":
+				v.TransitMount = value
+			case "identity_provider":
+				v.IdentityProvider = value
+			}
+		case "approle":
+			args := h.RemainingArgs()
+			if len(args) == 0 || len(args) > 2 {
+				return nil, h.Errf("invalid vault approle: expect <role_id> [<secret_id>]")
+			}
+			v.AppRole = &VaultAppRole{RoleID: args[0]}
+			if len(args) == 2 {
+				v.AppRole.SecretID = args[1]
+			}
+		case "refresh", "timeout":
+			d, err := parseDurationArg(h)
+			if err != nil {
+				return nil, h.Errf("invalid vault %s: %w", opt, err)
+			}
+			if opt == "refresh" {
+				v.Refresh = d
+			} else {
+				v.Timeout = d
+			}
+		default:
+			return nil, h.Errf("unrecognized vault option: %s", opt)
+		}
+	}
+	if appRoleMount != "" {
+		if v.AppRole == nil {
+			return nil, h.Errf("invalid vault approle_mount: without approle")
+		}
+		v.AppRole.Mount = appRoleMount
+	}
+	return v, nil
+}
+
+// parseALBOIDCData parses the alb_oidc_data option. Syntax:
+//
+//	alb_oidc_data <signer_arn...> [{
+//	    header <header>
+//	    key_url <url>
+//	    timeout <duration>
+//	}]
+func parseALBOIDCData(h httpcaddyfile.Helper) (*ALBOIDCData, error) {
+	a := &ALBOIDCData{Signers: h.RemainingArgs()}
+	if len(a.Signers) == 0 {
+		return nil, h.Errf("invalid alb_oidc_data: expect at least one signer ARN")
+	}
+	for h.NextBlock(1) {
+		opt := h.Val()
+		switch opt {
+		case "header":
+			if !h.AllArgs(&a.Header) {
+				return nil, h.Errf("invalid alb_oidc_data header: %q", a.Header)
+			}
+		case "key_url":
+			if !h.AllArgs(&a.KeyURL) {
+				return nil, h.Errf("invalid alb_oidc_data key_url: %q", a.KeyURL)
+			}
+		case "timeout":
+			var err error
+			if a.Timeout, err = parseDurationArg(h); err != nil {
+				return nil, h.Errf("invalid alb_oidc_data timeout: %w", err)
+			}
+		default:
+			return nil, h.Errf("unrecognized alb_oidc_data option: %s", opt)
+		}
+	}
+	return a, nil
+}
+
+// parseMapGroups parses the map_groups block. Syntax:
+//
+//	map_groups [<claim>] {
+//	    <group> => <role...>
+//	    keep_unmapped
+//	}
+func parseMapGroups(h httpcaddyfile.Helper) (*GroupMapping, error) {
+	gm := &GroupMapping{Groups: make(map[string][]string)}
+	if h.NextArg() {
+		gm.Claim = h.Val()
+	}
+	if h.NextArg() {
+		return nil, h.Errf("invalid map_groups: expect [<claim>]")
+	}
+	for h.NextBlock(1) {
+		group := h.Val()
+		if group == "keep_unmapped" {
+			if h.NextArg() {
+				return nil, h.ArgErr()
+			}
+			gm.KeepUnmapped = true
+			continue
+		}
+		args := h.RemainingArgs()
+		if len(args) < 2 || args[0] != "=>" {
+			return nil, h.Errf("invalid map_groups %s: expect <group> => <role...>", group)
+		}
+		if _, ok := gm.Groups[group]; ok {
+			return nil, h.Errf("invalid map_groups: duplicate group %q", group)
+		}
+		gm.Groups[group] = args[1:]
+	}
+	if len(gm.Groups) == 0 {
+		return nil, h.Errf("invalid map_groups: expect at least one <group> => <role...>")
+	}
+	return gm, nil
+}
+
+// parseIntrospection parses the introspection block. Syntax:
+//
+//	introspection <endpoint> {
+//	    client_id <client_id>
+//	    client_secret <client_secret>
+//	    timeout <duration>
+//	    cache_ttl <duration>
+//	    cache_max_size <size>
+//	}
+func parseIntrospection(h httpcaddyfile.Helper) (*Introspection, error) {
+	in := &Introspection{}
+	if !h.AllArgs(&in.Endpoint) {
+		return nil, h.Errf("invalid introspection: expect exactly one endpoint")
+	}
+	for h.NextBlock(1) {
+		opt := h.Val()
+		switch opt {
+		case "client_id":
+			if !h.AllArgs(&in.ClientID) {
+				return nil, h.Errf("invalid introspection client_id: %q", in.ClientID)
+			}
+		case "client_secret":
+			if !h.AllArgs(&in.ClientSecret) {
+				return nil, h.Errf("invalid introspection client_secret")
+			}
+		case "timeout", "cache_ttl":
+			d, err := parseDurationArg(h)
+			if err != nil {
+				return nil, h.Errf("invalid introspection %s: %w", opt, err)
+			}
+			if opt == "timeout" {
+				in.Timeout = d
+			} else {
+				in.CacheTTL = d
+			}
+		case "cache_max_size":
+			var err error
+			if in.CacheMaxBytes, err = parseBytesArg(h); err != nil {
+				return nil, h.Errf("invalid introspection cache_max_size: %w", err)
+			}
+		default:
+			return nil, h.Errf("unrecognized introspection option: %s", opt)
+		}
+	}
+	return in, nil
+}
+
+// parseMaintenance parses the maintenance block. Syntax:
+//
+//	maintenance {
+//	    enabled
+//	    allow <claim> <value...>
+//	    status <code>
+//	    body <text>
+//	    content_type <type>
+//	    retry_after <duration>
+//	}
+func parseMaintenance(h httpcaddyfile.Helper) (*Maintenance, error) {
+	m := &Maintenance{}
+	if h.NextArg() {
+		return nil, h.ArgErr()
+	}
+	for h.NextBlock(1) {
+		opt := h.Val()
+		switch opt {
+		case "enabled":
+			if h.NextArg() {
+				return nil, h.ArgErr()
+			}
+			m.Enabled = true
+		case "allow":
+			args := h.RemainingArgs()
+			if len(args) < 2 {
+				return nil, h.Errf("invalid maintenance allow: expect <claim> <value...>")
+			}
+			m.Allow = append(m.Allow, ClaimRequirement{Claim: args[0], Values: args[1:]})
+		case "status":
+			var raw string
+			if !h.AllArgs(&raw) {
+				return nil, h.Errf("invalid maintenance status: %q", raw)
+			}
+			code, err := strconv.Atoi(raw)
+			if err != nil {
+				return nil, h.Errf("invalid maintenance status: %w", err)
+			}
+			m.StatusCode = code
+		case "body":
+			if !h.AllArgs(&m.Body) {
+				return nil, h.Errf("invalid maintenance body: expect exactly one quoted text")
+			}
+		case "content_type":
+			if !h.AllArgs(&m.ContentType) {
+				return nil, h.Errf("invalid maintenance content_type: %q", m.ContentType)
+			}
+		case "retry_after":
+			d, err := parseDurationArg(h)
+			if err != nil {
+				return nil, h.Errf("invalid maintenance retry_after: %w", err)
+			}
+			m.RetryAfter = d
+		default:
+			return nil, h.Errf("unrecognized maintenance option: %s", opt)
+		}
+	}
+	return m, nil
+}
+
+// parseMetaClaims parses the remaining arguments as "claim -> placeholder"
+// mappings.
+func parseMetaClaims(h httpcaddyfile.Helper) (map[string]string, error) {
+	metaClaims := make(map[string]string)
+	for _, metaClaim := range h.RemainingArgs() {
+		claim, placeholder, err := parseMetaClaim(metaClaim)
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := metaClaims[claim]; ok {
+			return nil, fmt.Errorf("duplicate claim: %s", claim)
+		}
+		metaClaims[claim] = placeholder
+	}
+	return metaClaims, nil
+}
+
+// parseContextToken parses the context_token block. Syntax:
+//
+//	context_token <header> {
+//	    sign_key <sign_key>
+//	    sign_alg <sign_alg>
+//	    jwk_url <jwk_url>
+//	    issuer_whitelist <issuer...>
+//	    audience_whitelist <audience...>
+//	    user_claims <claim...>
+//	    meta_claims <claim -> placeholder...>
+//	    require <claim> <value...>
+//	    meta_prefix <prefix>
+//	    access_meta_prefix <prefix>
+//	}
+func parseContextToken(h httpcaddyfile.Helper) (*ContextToken, error) {
+	ct := &ContextToken{Provider: &JWTAuth{}}
+	if !h.AllArgs(&ct.Header) {
+		return nil, h.Errf("invalid context_token: expect <header>")
+	}
+	p := ct.Provider
+	for h.NextBlock(1) {
+		opt := h.Val()
+		switch opt {
+		case "sign_key":
+			if !h.AllArgs(&p.SignKey) {
+				return nil, h.Errf("invalid context_token sign_key: %q", p.SignKey)
+			}
+		case "sign_alg":
+			if !h.AllArgs(&p.SignAlgorithm) {
+				return nil, h.Errf("invalid context_token sign_alg: %q", p.SignAlgorithm)
+			}
+		case "jwk_url":
+			if !h.AllArgs(&p.JWKURL) {
+				return nil, h.Errf("invalid context_token jwk_url: %q", p.JWKURL)
+			}
+		case "issuer_whitelist":
+			p.IssuerWhitelist = h.RemainingArgs()
+		case "audience_whitelist":
+			p.AudienceWhitelist = h.RemainingArgs()
+		case "user_claims":
+			p.UserClaims = h.RemainingArgs()
+		case "meta_claims":
+			var err error
+			if p.MetaClaims, err = parseMetaClaims(h); err != nil {
+				return nil, h.Errf("invalid context_token meta_claims: %w", err)
+			}
+		case "require":
+			args := h.RemainingArgs()
+			if len(args) < 2 {
+				return nil, h.Errf("invalid context_token require: expect <claim> <value...>")
+			}
+			p.Require = append(p.Require, ClaimRequirement{Claim: args[0], Values: args[1:]})
+		case "meta_prefix":
+			if !h.AllArgs(&ct.MetaPrefix) {
+				return nil, h.Errf("invalid context_token meta_prefix: %q", ct.MetaPrefix)
+			}
+		case "access_meta_prefix":
+			if !h.AllArgs(&ct.AccessMetaPrefix) {
+				return nil, h.Errf("invalid context_token access_meta_prefix: %q", ct.AccessMetaPrefix)
+			}
+		default:
+			return nil, h.Errf("unrecognized context_token option: %s", opt)
+		}
+	}
+	return ct, nil
+}
+
+// parseFailureResponse parses the failure_response block. Syntax:
+//
+//	failure_response {
+//	    status <code>
+//	    body <template>
+//	    content_type <type>
+//	    header <name> <value>
+//	}
+func parseFailureResponse(h httpcaddyfile.Helper) (*FailureResponse, error) {
+	f := &FailureResponse{}
+	if h.NextArg() {
+		return nil, h.ArgErr()
+	}
+	for h.NextBlock(1) {
+		opt := h.Val()
+		switch opt {
+		case "status":
+			var raw string
+			if !h.AllArgs(&raw) {
+				return nil, h.Errf("invalid failure_response status: %q", raw)
+			}
+			code, err := strconv.Atoi(raw)
+			if err != nil {
+				return nil, h.Errf("invalid failure_response status: %w", err)
+			}
+			f.StatusCode = code
+		case "body":
+			if !h.AllArgs(&f.Body) {
+				return nil, h.Errf("invalid failure_response body: expect exactly one quoted text")
+			}
+		case "content_type":
+			if !h.AllArgs(&f.ContentType) {
+				return nil, h.Errf("invalid failure_response content_type: %q", f.ContentType)
+			}
+		case "header":
+			var name, value string
+			if !h.AllArgs(&name, &value) {
+				return nil, h.Errf("invalid failure_response header: expect <name> <value>")
+			}
+			if f.Headers == nil {
+				f.Headers = make(map[string]string)
+			}
+			f.Headers[name] = value
+		default:
+			return nil, h.Errf("unrecognized failure_response option: %s", opt)
+		}
+	}
+	return f, nil
+}
+
+// parseSandboxParsing parses the sandbox_parsing block. Syntax:
+//
+//	sandbox_parsing {
+//	    workers <n>
+//	    max_token_size <size>
+//	    timeout <duration>
+//	}
+func parseSandboxParsing(h httpcaddyfile.Helper) (*SandboxParsing, error) {
+	s := &SandboxParsing{}
+	if h.NextArg() {
+		return nil, h.ArgErr()
+	}
+	for h.NextBlock(1) {
+		opt := h.Val()
+		switch opt {
+		case "workers":
+			var raw string
+			if !h.AllArgs(&raw) {
+				return nil, h.Errf("invalid sandbox_parsing workers: %q", raw)
+			}
+			n, err := strconv.Atoi(raw)
+			if err != nil {
+				return nil, h.Errf("invalid sandbox_parsing workers: %w", err)
+			}
+			s.Workers = n
+		case "max_token_size":
+			size, err := parseBytesArg(h)
+			if err != nil {
+				return nil, h.Errf("invalid sandbox_parsing max_token_size: %w", err)
+			}
+			s.MaxTokenSize = size
+		case "timeout":
+			d, err := parseDurationArg(h)
+			if err != nil {
+				return nil, h.Errf("invalid sandbox_parsing timeout: %w", err)
+			}
+			s.Timeout = d
+		default:
+			return nil, h.Errf("unrecognized sandbox_parsing option: %s", opt)
+		}
+	}
+	return s, nil
+}
+
+// parseDenyWebhook parses the deny_webhook block. Syntax:
+//
+//	deny_webhook <url> {
+//	    batch_size <n>
+//	    flush_interval <duration>
+//	    queue_size <n>
+//	    timeout <duration>
+//	}
+func parseDenyWebhook(h httpcaddyfile.Helper) (*DenyWebhook, error) {
+	wh := &DenyWebhook{}
+	if !h.AllArgs(&wh.URL) {
+		return nil, h.Errf("invalid deny_webhook: expect exactly one url")
+	}
+	for h.NextBlock(1) {
+		opt := h.Val()
+		switch opt {
+		case "batch_size", "queue_size":
+			var raw string
+			if !h.AllArgs(&raw) {
+				return nil, h.Errf("invalid deny_webhook %s: %q", opt, raw)
+			}
+			n, err := strconv.Atoi(raw)
+			if err != nil {
+				return nil, h.Errf("invalid deny_webhook %s: %w", opt, err)
+			}
+			if opt == "batch_size" {
+				wh.BatchSize = n
+			} else {
+				wh.QueueSize = n
+			}
+		case "flush_interval", "timeout":
+			d, err := parseDurationArg(h)
+			if err != nil {
+				return nil, h.Errf("invalid deny_webhook %s: %w", opt, err)
+			}
+			if opt == "flush_interval" {
+				wh.FlushInterval = d
+			} else {
+				wh.Timeout = d
+			}
+		default:
+			return nil, h.Errf("unrecognized deny_webhook option: %s", opt)
+		}
+	}
+	return wh, nil
+}
+
+// parseRevocation parses the revocation block. Syntax:
+//
+//	revocation {
+//	    backend <name> {
+//	        ...
+//	    }
+//	    require_jti
+//	    fail_open
+//	}
+func parseRevocation(h httpcaddyfile.Helper) (*Revocation, error) {
+	rv := &Revocation{}
+	if h.NextArg() {
+		return nil, h.ArgErr()
+	}
+	for h.NextBlock(1) {
+		opt := h.Val()
+		switch opt {
+		case "backend":
+			if !h.NextArg() {
+				return nil, h.ArgErr()
+			}
+			name := h.Val()
+			unm, err := caddyfile.UnmarshalModule(h.Dispenser, "http.authentication.providers.jwt.revocation."+name)
+			if err != nil {
+				return nil, err
+			}
+			rv.CheckerRaw = caddyconfig.JSONModuleObject(unm, "backend", name, nil)
+		case "require_jti", "fail_open":
+			if h.NextArg() {
+				return nil, h.ArgErr()
+			}
+			if opt == "require_jti" {
+				rv.RequireJTI = true
+			} else {
+				rv.FailOpen = true
+			}
+		default:
+			return nil, h.Errf("unrecognized revocation option: %s", opt)
+		}
+	}
+	return rv, nil
+}
+
+// parseOneTimeTokens parses the one_time_tokens block. Syntax:
+//
+//	one_time_tokens [{
+//	    backend <name> {
+//	        ...
+//	    }
+//	    fail_open
+//	}]
+func parseOneTimeTokens(h httpcaddyfile.Helper) (*OneTimeTokens, error) {
+	ot := &OneTimeTokens{}
+	if h.NextArg() {
+		return nil, h.ArgErr()
+	}
+	for h.NextBlock(1) {
+		opt := h.Val()
+		switch opt {
+		case "backend":
+			if !h.NextArg() {
+				return nil, h.ArgErr()
+			}
+			name := h.Val()
+			unm, err := caddyfile.UnmarshalModule(h.Dispenser, "http.authentication.providers.jwt.replay."+name)
+			if err != nil {
+				return nil, err
+			}
+			ot.StoreRaw = caddyconfig.JSONModuleObject(unm, "backend", name, nil)
+		case "fail_open":
+			if h.NextArg() {
+				return nil, h.ArgErr()
+			}
+			ot.FailOpen = true
+		default:
+			return nil, h.Errf("unrecognized one_time_tokens option: %s", opt)
+		}
+	}
+	return ot, nil
+}
+
+// parseEnrichment parses the enrich block. Syntax:
+//
+//	enrich <url> {
+//	    attributes <field>[-> <placeholder>]...
+//	    timeout <duration>
+//	    cache_ttl <duration>
+//	    cache_max_size <size>
+//	    volatile <placeholder...>
+//	    stable <placeholder...>
+//	    failure_threshold <n>
+//	    cooldown <duration>
+//	    required
+//	}
+func parseEnrichment(h httpcaddyfile.Helper) (*Enrichment, error) {
+	e := &Enrichment{}
+	if !h.AllArgs(&e.URL) {
+		return nil, h.Errf("invalid enrich: expect exactly one url")
+	}
+	for h.NextBlock(1) {
+		opt := h.Val()
+		switch opt {
+		case "attributes":
+			e.Attributes = make(map[string]string)
+			for _, attr := range h.RemainingArgs() {
+				field, placeholder, err := parseMetaClaim(attr)
+				if err != nil {
+					return nil, h.Errf("invalid enrich attributes: %w", err)
+				}
+				e.Attributes[field] = placeholder
+			}
+		case "timeout", "cache_ttl", "cooldown":
+			d, err := parseDurationArg(h)
+			if err != nil {
+				return nil, h.Errf("invalid enrich %s: %w", opt, err)
+			}
+			switch opt {
+			case "timeout":
+				e.Timeout = d
+			case "cache_ttl":
+				e.CacheTTL = d
+			case "cooldown":
+				e.Cooldown = d
+			}
+		case "failure_threshold":
+			var raw string
+			if !h.AllArgs(&raw) {
+				return nil, h.Errf("invalid enrich failure_threshold: %q", raw)
+			}
+			n, err := strconv.Atoi(raw)
+			if err != nil {
+				return nil, h.Errf("invalid enrich failure_threshold: %w", err)
+			}
+			e.FailureThreshold = n
+		case "cache_max_size":
+			var err error
+			if e.CacheMaxBytes, err = parseBytesArg(h); err != nil {
+				return nil, h.Errf("invalid enrich cache_max_size: %w", err)
+			}
+		case "volatile":
+			e.Volatile = append(e.Volatile, h.RemainingArgs()...)
+		case "stable":
+			e.Stable = append(e.Stable, h.RemainingArgs()...)
+		case "required":
+			if h.NextArg() {
+				return nil, h.ArgErr()
+			}
+			e.Required = true
+		default:
+			return nil, h.Errf("unrecognized enrich option: %s", opt)
+		}
+	}
+	return e, nil
+}
+
+// parseClaimPolicy parses a named claim policy. Syntax:
+//
+//	claim_policies <name> {
+//	    <claim> <value...>
+//	}
+func parseClaimPolicy(h httpcaddyfile.Helper) (string, ClaimPolicy, error) {
+	var name string
+	if !h.AllArgs(&name) {
+		return "", nil, h.Errf("invalid claim_policies: expect <name>")
+	}
+	policy := make(ClaimPolicy)
+	for h.NextBlock(1) {
+		claim := h.Val()
+		values := h.RemainingArgs()
+		if len(values) == 0 {
+			return "", nil, h.Errf("invalid claim_policies %s: missing values of claim %s", name, claim)
+		}
+		policy[claim] = values
+	}
+	return name, policy, nil
+}
+
+// parseIssuers parses the issuers block. Syntax:
+//
+//	issuers {
+//	    <issuer> {
+//	        sign_key <sign_key>
+//	        sign_alg <sign_alg>
+//	        jwk_url <jwk_url>
+//	    }
+//	    ...
+//	}
+func parseIssuers(h httpcaddyfile.Helper) (map[string]*IssuerKeys, error) {
+	if h.NextArg() {
+		return nil, h.ArgErr()
+	}
+	issuers := make(map[string]*IssuerKeys)
+	for h.NextBlock(1) {
+		issuer := h.Val()
+		if h.NextArg() {
+			return nil, h.ArgErr()
+		}
+		keys := &IssuerKeys{}
+		for h.NextBlock(2) {
 			opt := h.Val()
+			var ok bool
 			switch opt {
 			case "sign_key":
-				if !h.AllArgs(&ja.SignKey) {
-					return nil, h.Errf("invalid sign_key: %q", ja.SignKey)
-				}
+				ok = h.AllArgs(&keys.SignKey)
 			case "sign_alg":
-				if !h.AllArgs(&ja.SignAlgorithm) {
-					return nil, h.Errf("invalid sign_alg: %q", ja.SignAlgorithm)
-				}
+				ok = h.AllArgs(&keys.SignAlgorithm)
 			case "jwk_url":
-				if !h.AllArgs(&ja.JWKURL) {
-					return nil, h.Errf("invalid jwk_url: %q", ja.JWKURL)
-				}
-			case "from_query":
-				ja.FromQuery = h.RemainingArgs()
-
-			case "from_header":
-				ja.FromHeader = h.RemainingArgs()
-
-			case "from_cookies":
-				ja.FromCookies = h.RemainingArgs()
-
-			case "audience_whitelist":
-				ja.AudienceWhitelist = h.RemainingArgs()
-
-			case "issuer_whitelist":
-				ja.IssuerWhitelist = h.RemainingArgs()
-
-			case "user_claims":
-				ja.UserClaims = h.RemainingArgs()
-
-			case "meta_claims":
-				ja.MetaClaims = make(map[string]string)
-				for _, metaClaim := range h.RemainingArgs() {
-					claim, placeholder, err := parseMetaClaim(metaClaim)
-					if err != nil {
-						return nil, h.Errf("invalid meta_claims: %w", err)
-					}
-					if _, ok := ja.MetaClaims[claim]; ok {
-						return nil, h.Errf("invalid meta_claims: duplicate claim: %s", claim)
-					}
-					ja.MetaClaims[claim] = placeholder
-				}
-			case "header_first":
-				return nil, h.Err("option header_first deprecated, the priority now defaults to from_query > from_header > from_cookies")
-
+				ok = h.AllArgs(&keys.JWKURL)
 			default:
-				return nil, h.Errf("unrecognized option: %s", opt)
+				return nil, h.Errf("unrecognized issuers option: %s", opt)
+			}
+			if !ok {
+				return nil, h.Errf("invalid issuers %s %s: expect exactly one argument", issuer, opt)
 			}
 		}
+		issuers[issuer] = keys
 	}
+	return issuers, nil
+}
 
-	return caddyauth.Authentication{
-		ProvidersRaw: caddy.ModuleMap{
-			"jwt": caddyconfig.JSON(ja, nil),
+// parseValidationCache parses the validation_cache block. Syntax:
+//
+//	validation_cache {
+//	    max_entries <n>
+//	    max_ttl <duration>
+//	    negative_ttl <duration>
+//	}
+func parseValidationCache(h httpcaddyfile.Helper) (*ValidationCache, error) {
+	vc := &ValidationCache{}
+	if h.NextArg() {
+		return nil, h.ArgErr()
+	}
+	for h.NextBlock(1) {
+		opt := h.Val()
+		switch opt {
+		case "max_entries":
+			var raw string
+			if !h.AllArgs(&raw) {
+				return nil, h.Errf("invalid validation_cache max_entries: %q", raw)
+			}
+			n, err := strconv.Atoi(raw)
+			if err != nil {
+				return nil, h.Errf("invalid validation_cache max_entries: %w", err)
+			}
+			vc.MaxEntries = n
+		case "max_ttl", "negative_ttl":
+			d, err := parseDurationArg(h)
+			if err != nil {
+				return nil, h.Errf("invalid validation_cache %s: %w", opt, err)
+			}
+			if opt == "max_ttl" {
+				vc.MaxTTL = d
+			} else {
+				vc.NegativeTTL = d
+			}
+		default:
+			return nil, h.Errf("unrecognized validation_cache option: %s", opt)
+		}
+	}
+	return vc, nil
+}
+
+// parseSessionCookie parses the issue_session_cookie block. Syntax:
+//
+//	issue_session_cookie [<name>] {
+//	    ttl <duration>
+//	    secret <secret>
+//	    same_site lax|strict|none
+//	    secure true|false
+//	}
+func parseSessionCookie(h httpcaddyfile.Helper) (*SessionCookie, error) {
+	sc := &SessionCookie{}
+	if h.NextArg() {
+		sc.Name = h.Val()
+	}
+	if h.NextArg() {
+		return nil, h.ArgErr()
+	}
+	for h.NextBlock(1) {
+		opt := h.Val()
+		switch opt {
+		case "ttl":
+			ttl, err := parseDurationArg(h)
+			if err != nil {
+				return nil, h.Errf("invalid issue_session_cookie ttl: %w", err)
+			}
+			sc.TTL = ttl
+		case "secret":
+			if !h.AllArgs(&sc.Secret) {
+				return nil, h.Errf("invalid issue_session_cookie secret")
+			}
+		case "same_site":
+			if !h.AllArgs(&sc.SameSite) {
+				return nil, h.Errf("invalid issue_session_cookie same_site: %q", sc.SameSite)
+			}
+		case "secure":
+			var raw string
+			if !h.AllArgs(&raw) {
+				return nil, h.Errf("invalid issue_session_cookie secure: %q", raw)
+			}
+			secure, err := strconv.ParseBool(raw)
+			if err != nil {
+				return nil, h.Errf("invalid issue_session_cookie secure: %w", err)
+			}
+			sc.Secure = &secure
+		default:
+			return nil, h.Errf("unrecognized issue_session_cookie option: %s", opt)
+		}
+	}
+	return sc, nil
+}
+
+// parseFailureRateLimit parses the failure_rate_limit block. Syntax:
+//
+//	failure_rate_limit <max_failures> [<window>] {
+//	    key client_ip|token
+//	    max_clients <n>
+//	}
+func parseFailureRateLimit(h httpcaddyfile.Helper) (*FailureRateLimit, error) {
+	rl := &FailureRateLimit{}
+	args := h.RemainingArgs()
+	if len(args) == 0 || len(args) > 2 {
+		return nil, h.Errf("invalid failure_rate_limit: expect <max_failures> [<window>]")
+	}
+	n, err := strconv.Atoi(args[0])
+	if err != nil {
+		return nil, h.Errf("invalid failure_rate_limit max_failures: %w", err)
+	}
+	rl.MaxFailures = n
+	if len(args) == 2 {
+		d, err := caddy.ParseDuration(args[1])
+		if err != nil {
+			return nil, h.Errf("invalid failure_rate_limit window: %w", err)
+		}
+		rl.Window = caddy.Duration(d)
+	}
+	for h.NextBlock(1) {
+		opt := h.Val()
+		switch opt {
+		case "key":
+			if !h.AllArgs(&rl.Key) {
+				return nil, h.Errf("invalid failure_rate_limit key: %q", rl.Key)
+			}
+		case "max_clients":
+			var raw string
+			if !h.AllArgs(&raw) {
+				return nil, h.Errf("invalid failure_rate_limit max_clients: %q", raw)
+			}
+			if rl.MaxClients, err = strconv.Atoi(raw); err != nil {
+				return nil, h.Errf("invalid failure_rate_limit max_clients: %w", err)
+			}
+		default:
+			return nil, h.Errf("unrecognized failure_rate_limit option: %s", opt)
+		}
+	}
+	return rl, nil
+}
+
+// parseJWKURLTenants parses the jwk_url_tenants block. Syntax:
+//
+//	jwk_url_tenants {
+//	    allow <value...>
+//	    max_tenants <n>
+//	}
+func parseJWKURLTenants(h httpcaddyfile.Helper) (*JWKURLTenants, error) {
+	jt := &JWKURLTenants{}
+	if h.NextArg() {
+		return nil, h.ArgErr()
+	}
+	for nesting := h.Nesting(); h.NextBlock(nesting); { // also nested in jwk
+		opt := h.Val()
+		switch opt {
+		case "allow":
+			values := h.RemainingArgs()
+			if len(values) == 0 {
+				return nil, h.Errf("invalid jwk_url_tenants allow: expect at least one value")
+			}
+			jt.Allow = append(jt.Allow, values...)
+		case "max_tenants":
+			var raw string
+			if !h.AllArgs(&raw) {
+				return nil, h.Errf("invalid jwk_url_tenants max_tenants: %q", raw)
+			}
+			n, err := strconv.Atoi(raw)
+			if err != nil {
+				return nil, h.Errf("invalid jwk_url_tenants max_tenants: %w", err)
+			}
+			jt.MaxTenants = n
+		default:
+			return nil, h.Errf("unrecognized jwk_url_tenants option: %s", opt)
+		}
+	}
+	return jt, nil
+}
+
+// parseArrayFormat parses the array_format option. Syntax:
+//
+//	array_format [<separator>] {
+//	    separator <separator>
+//	    first_only
+//	}
+func parseArrayFormat(h httpcaddyfile.Helper) (*ArrayFormat, error) {
+	af := &ArrayFormat{}
+	if h.NextArg() {
+		af.Separator = h.Val()
+		if h.NextArg() {
+			return nil, h.ArgErr()
+		}
+	}
+	for h.NextBlock(1) {
+		opt := h.Val()
+		switch opt {
+		case "separator":
+			if !h.AllArgs(&af.Separator) {
+				return nil, h.Errf("invalid array_format separator: expect exactly one argument")
+			}
+		case "first_only":
+			if h.NextArg() {
+				return nil, h.ArgErr()
+			}
+			af.FirstOnly = true
+		default:
+			return nil, h.Errf("unrecognized array_format option: %s", opt)
+		}
+	}
+	return af, nil
+}
+
+// parseDPoP parses the dpop block. Syntax:
+//
+//	dpop {
+//	    required
+//	    max_age <duration>
+//	    max_entries <n>
+//	}
+func parseDPoP(h httpcaddyfile.Helper) (*DPoP, error) {
+	d := &DPoP{}
+	if h.NextArg() {
+		return nil, h.ArgErr()
+	}
+	for h.NextBlock(1) {
+		opt := h.Val()
+		switch opt {
+		case "required":
+			if h.NextArg() {
+				return nil, h.ArgErr()
+			}
+			d.Required = true
+		case "max_age":
+			maxAge, err := parseDurationArg(h)
+			if err != nil {
+				return nil, h.Errf("invalid dpop max_age: %w", err)
+			}
+			d.MaxAge = maxAge
+		case "max_entries":
+			var raw string
+			if !h.AllArgs(&raw) {
+				return nil, h.Errf("invalid dpop max_entries: %q", raw)
+			}
+			n, err := strconv.Atoi(raw)
+			if err != nil {
+				return nil, h.Errf("invalid dpop max_entries: %w", err)
+			}
+			d.MaxEntries = n
+		default:
+			return nil, h.Errf("unrecognized dpop option: %s", opt)
+		}
+	}
+	return d, nil
+}
+
+// parseClaimsAnomaly parses the claims_anomaly block. Syntax:
+//
+//	claims_anomaly {
+//	    claims <claim...>
+//	    ttl <duration>
+//	    max_subjects <n>
+//	}
+func parseClaimsAnomaly(h httpcaddyfile.Helper) (*ClaimsAnomaly, error) {
+	ca := &ClaimsAnomaly{}
+	if h.NextArg() {
+		return nil, h.ArgErr()
+	}
+	for h.NextBlock(1) {
+		opt := h.Val()
+		switch opt {
+		case "claims":
+			ca.Claims = append(ca.Claims, h.RemainingArgs()...)
+		case "ttl":
+			d, err := parseDurationArg(h)
+			if err != nil {
+				return nil, h.Errf("invalid claims_anomaly ttl: %w", err)
+			}
+			ca.TTL = d
+		case "max_subjects":
+			var raw string
+			if !h.AllArgs(&raw) {
+				return nil, h.Errf("invalid claims_anomaly max_subjects: %q", raw)
+			}
+			n, err := strconv.Atoi(raw)
+			if err != nil {
+				return nil, h.Errf("invalid claims_anomaly max_subjects: %w", err)
+			}
+			ca.MaxSubjects = n
+		default:
+			return nil, h.Errf("unrecognized claims_anomaly option: %s", opt)
+		}
+	}
+	return ca, nil
+}
+
+// parseUserInfo parses the userinfo block. Syntax:
+//
+//	userinfo [<endpoint>] {
+//	    claims <claim>[-> <placeholder>]...
+//	    timeout <duration>
+//	    cache_ttl <duration>
+//	    cache_max_size <size>
+//	    required
+//	}
+func parseUserInfo(h httpcaddyfile.Helper) (*UserInfoEnrichment, error) {
+	u := &UserInfoEnrichment{}
+	args := h.RemainingArgs()
+	if len(args) > 1 {
+		return nil, h.Errf("invalid userinfo: expect at most one endpoint")
+	}
+	if len(args) == 1 {
+		u.Endpoint = args[0]
+	}
+	for h.NextBlock(1) {
+		opt := h.Val()
+		switch opt {
+		case "claims":
+			u.Claims = make(map[string]string)
+			for _, item := range h.RemainingArgs() {
+				claim, placeholder, err := parseMetaClaim(item)
+				if err != nil {
+					return nil, h.Errf("invalid userinfo claims: %w", err)
+				}
+				u.Claims[claim] = placeholder
+			}
+		case "timeout", "cache_ttl":
+			d, err := parseDurationArg(h)
+			if err != nil {
+				return nil, h.Errf("invalid userinfo %s: %w", opt, err)
+			}
+			if opt == "timeout" {
+				u.Timeout = d
+			} else {
+				u.CacheTTL = d
+			}
+		case "cache_max_size":
+			var err error
+			if u.CacheMaxBytes, err = parseBytesArg(h); err != nil {
+				return nil, h.Errf("invalid userinfo cache_max_size: %w", err)
+			}
+		case "required":
+			if h.NextArg() {
+				return nil, h.ArgErr()
+			}
+			u.Required = true
+		default:
+			return nil, h.Errf("unrecognized userinfo option: %s", opt)
+		}
+	}
+	return u, nil
+}
+
+// parseBytesArg parses the only argument of the current option as a size in
+// bytes, e.g. "16MiB".
+func parseBytesArg(h httpcaddyfile.Helper) (int, error) {
+	var raw string
+	if !h.AllArgs(&raw) {
+		return 0, fmt.Errorf("expect exactly one size")
+	}
+	size, err := humanize.ParseBytes(raw)
+	if err != nil {
+		return 0, err
+	}
+	return int(size), nil
+}
+
+// parseDurationArg parses the only argument of the current option as a
+// duration.
+func parseDurationArg(h httpcaddyfile.Helper) (caddy.Duration, error) {
+	var raw string
+	if !h.AllArgs(&raw) {
+		return 0, fmt.Errorf("expect exactly one argument")
+	}
+	d, err := caddy.ParseDuration(raw)
+	if err != nil {
+		return 0, err
+	}
+	return caddy.Duration(d), nil
+}
+
+// deprecatedOption describes a legacy option of the Caddyfile.
+type deprecatedOption struct {
+	// Replacement is the option to translate the legacy one to. If empty,
+	// the legacy option has been removed and will be ignored.
+	Replacement string
+
+	// Migrate, if set, translates the arguments of the legacy option to
+	// the Replacement instead of parsing them as the Replacement's.
+	Migrate func(ja *JWTAuth, args []string) error
+
+	// Hint tells how to update the config.
+	Hint string
+}
+
+// deprecatedOptions are the legacy options which are migrated automatically,
+// with a warning, rather than failing the config.
+var deprecatedOptions = map[string]deprecatedOption{
+	"header_first": {
+		Replacement: "source_priority",
+		Migrate: func(ja *JWTAuth, args []string) error {
+			if len(args) != 1 {
+				return fmt.Errorf("expect exactly one argument")
+			}
+			headerFirst, err := strconv.ParseBool(args[0])
+			if err != nil {
+				return err
+			}
+			if headerFirst {
+				ja.SourcePriority = []string{"header", "query", "cookie"}
+			}
+			return nil
 		},
-	}, nil
+		Hint: "replace header_first true by source_priority header query cookie, remove header_first false",
+	},
+}
+
+// migrateDeprecatedOption adds a warning to the adapter if the current option
+// is deprecated, see warn, and returns the option to parse instead. It returns false if the option has
+// been removed or migrated already, in which case its arguments have been
+// consumed.
+func migrateDeprecatedOption(h httpcaddyfile.Helper, ja *JWTAuth, seen optionLines) (string, bool, error) {
+	opt := h.Val()
+	deprecated, ok := deprecatedOptions[opt]
+	if !ok {
+		return opt, true, nil
+	}
+	message := fmt.Sprintf("option %s is deprecated", opt)
+	if deprecated.Replacement != "" {
+		message += fmt.Sprintf(", use %s instead", deprecated.Replacement)
+	}
+	if deprecated.Hint != "" {
+		message += ": " + deprecated.Hint
+	}
+	warn(h, message)
+	if deprecated.Migrate != nil {
+		if err := seen.add(h, deprecated.Replacement); err != nil {
+			return "", false, err
+		}
+		if err := deprecated.Migrate(ja, h.RemainingArgs()); err != nil {
+			return "", false, h.Errf("invalid %s: %v", opt, err)
+		}
+		return "", false, nil
+	}
+	if deprecated.Replacement != "" {
+		return deprecated.Replacement, true, nil
+	}
+	h.RemainingArgs()
+	return "", false, nil
+}
+
+// warn adds a warning at the current token to the warnings of the adapter, so
+// that `caddy adapt` and `caddy run` report it along with the warnings of
+// caddy itself. The helper doesn't expose its warnings, so they are reached
+// through reflection; if that fails, e.g. the helper wasn't set up by the
+// adapter, the warning is logged instead.
+func warn(h httpcaddyfile.Helper, message string) {
+	field := reflect.ValueOf(&h).Elem().FieldByName("warnings")
+	if field.IsValid() && field.Type() == reflect.TypeOf((*[]caddyconfig.Warning)(nil)) {
+		if warnings := *(**[]caddyconfig.Warning)(unsafe.Pointer(field.UnsafeAddr())); warnings != nil {
+			*warnings = append(*warnings, caddyconfig.Warning{
+				File:      h.File(),
+				Line:      h.Line(),
+				Directive: "jwtauth",
+				Message:   message,
+			})
+			return
+		}
+	}
+	caddy.Log().Named("jwtauth").Warn(message,
+		zap.String("file", h.File()),
+		zap.Int("line", h.Line()),
+	)
+}
+
+// parseBoolArg parses the only argument of the current option as a bool.
+func parseBoolArg(h httpcaddyfile.Helper) (*bool, error) {
+	var raw string
+	if !h.AllArgs(&raw) {
+		return nil, fmt.Errorf("expect exactly one argument")
+	}
+	v, err := strconv.ParseBool(raw)
+	if err != nil {
+		return nil, err
+	}
+	return &v, nil
 }
 
 // parseMetaClaim parses key to get the claim and corresponding placeholder.
@@ -1,8 +1,11 @@
 package caddyjwt
 
 import (
+	"encoding/json"
 	"testing"
+	"time"
 
+	"github.com/caddyserver/caddy/v2"
 	"github.com/caddyserver/caddy/v2/caddyconfig"
 	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
 	"github.com/caddyserver/caddy/v2/caddyconfig/httpcaddyfile"
@@ -16,26 +19,306 @@ func TestParsingCaddyfileNormalCase(t *testing.T) {
 	jwtauth {
 		sign_key "TkZMNSowQmMjOVU2RUB0bm1DJkU3U1VONkd3SGZMbVk="
 		sign_alg HS256
+		allowed_algorithms HS256 HS512
+		decrypt_key_file /etc/caddy/jwe.pem
+		max_decompressed_size 131072
+		oidc_issuer https://accounts.example.com
+		jwk_url_tenants {
+			allow acme-* ^beta-[0-9]+$
+			max_tenants 500
+		}
 		from_query access_token token _tok
 		from_header X-Api-Key
 		from_cookies user_session SESSID
+		from_body id_token
 		issuer_whitelist https://api.example.com
+		issuer_aliases https://auth.old.example.com https://api.example.com
+		block_kids k1 k2
 		audience_whitelist https://api.example.io https://learn.example.com
+		audience {http.vars.jwt_aud}
+		except_paths /healthz "^/webhooks/[a-z]+$"
+		allow_options_preflight
+		subject_pattern spiffe://prod/* ^[0-9]+$
 		user_claims uid user_id login username
 		meta_claims "IsAdmin -> is_admin" "gender"
+		meta_claims_json
+		array_format ";" {
+			first_only
+		}
+		validate_iat false
+		normalize_token cookie trim unquote
+		header_scheme Bearer JWT
+		header_prefix X-Api-Key ^v[0-9]+:
+		expired_redirect /login
+		expired_flash_cookie flash
+		shared_jwks 10m
+		require_keys_at_startup lenient
+		require_scope orders:read orders:write
+		scope_match any
+		roles_claim keycloak
+		require_role admin editor
+		leeway 5s
+		max_token_age 24h
+		require_exp
+		strict_rfc9068
+		require_typ
+		allowed_typ at+jwt JOSE
+		allowed_crit exp_v2
+		expired_grace 30s
+		expiring_window 2m
+		query_token_no_store
+		matched_audience_header X-Matched-Aud
+		forward_claims_header "sub -> X-User-Id" "org.roles -> X-User-Roles"
+		forwarded_claims sub "org.id -> org"
+		strip_token
+		principal_type human
+		require_env prod deployment
+		bind_claims cip client_ip
+		bind_claims fgp sha256:{http.request.cookie.__Secure-Fgp}
+		name api
+		verification_workers 8
+		claim_policies admin {
+			roles admin
+		}
+		claim_policy admin
+		require scope admin:read admin:write
+		require org.id codelet
+		verify_claims age >=18
+		verify_claims mfa exists
+		request_id_header X-Correlation-Id
+		expose_request_id
+		claims_schema /etc/caddy/claims.schema.json
+		policy_trace_header X-Debug-Trace s3cr3t
+		audit
+		redaction strict
+		validate_expression "claims.role == 'admin' && 'payments' in claims.scopes"
+		enrich https://entitlements.example.com/users/{id} {
+			attributes "plan -> plan" seats
+			timeout 1s
+			cache_max_size 1MiB
+			volatile seats
+			stable plan
+			required
+		}
+		userinfo {
+			claims email "name -> display_name"
+		}
+		issuers {
+			https://a.example.com {
+				sign_key TkZMNSowQmMjOVU2RUB0bm1DJkU3U1VONkd3SGZMbVk=
+				sign_alg HS256
+			}
+			https://b.example.com {
+				jwk_url https://b.example.com/jwks.json
+			}
+		}
+		validation_cache {
+			max_entries 1000
+			max_ttl 1m
+			negative_ttl 5s
+		}
+		issue_session_cookie sess {
+			ttl 2m
+			secret {env.JWT_SESSION_SECRET}
+			same_site strict
+			secure false
+		}
+		failure_rate_limit 20 5m {
+			key token
+		}
+		dpop {
+			required
+			max_age 1m
+			max_entries 500
+		}
+		claims_anomaly {
+			claims iss roles
+			ttl 12h
+			max_subjects 500
+		}
+		introspection https://auth.example.com/introspect {
+			client_id caddy
+			client_secret s3cr3t
+			cache_ttl 30s
+		}
+		maintenance {
+			allow role operator
+			retry_after 10m
+		}
+		context_token X-Context-Token {
+			jwk_url https://partner.example.com/jwks
+			issuer_whitelist https://partner.example.com
+			meta_claims tenant "org.id -> org"
+			require scope checkout
+			access_meta_prefix access.
+		}
+		bearer_challenge api
+		failure_response {
+			status 403
+			body "{\"error\": {{json .Reason}}}"
+			content_type application/json
+			header WWW-Authenticate Bearer
+		}
+		sandbox_parsing {
+			workers 4
+			max_token_size 8KiB
+		}
+		deny_webhook https://soc.example.com/events {
+			batch_size 50
+			flush_interval 5s
+		}
+		upstream_basic_auth {
+			username_claim login
+			password secret
+		}
 	}
 	`),
 	}
+	falseValue := false
 	expectedJA := &JWTAuth{
-		SignKey:           TestSignKey,
-		SignAlgorithm:     "HS256",
-		FromQuery:         []string{"access_token", "token", "_tok"},
-		FromHeader:        []string{"X-Api-Key"},
-		FromCookies:       []string{"user_session", "SESSID"},
-		IssuerWhitelist:   []string{"https://api.example.com"},
-		AudienceWhitelist: []string{"https://api.example.io", "https://learn.example.com"},
-		UserClaims:        []string{"uid", "user_id", "login", "username"},
-		MetaClaims:        map[string]string{"IsAdmin": "is_admin", "gender": "gender"},
+		SignKey:               TestSignKey,
+		SignAlgorithm:         "HS256",
+		AllowedAlgorithms:     []string{"HS256", "HS512"},
+		DecryptKeyFile:        "/etc/caddy/jwe.pem",
+		MaxDecompressedSize:   131072,
+		OIDCIssuer:            "https://accounts.example.com",
+		JWKURLTenants:         &JWKURLTenants{Allow: []string{"acme-*", "^beta-[0-9]+$"}, MaxTenants: 500},
+		FromQuery:             []string{"access_token", "token", "_tok"},
+		FromHeader:            []string{"X-Api-Key"},
+		FromCookies:           []string{"user_session", "SESSID"},
+		FromBody:              []string{"id_token"},
+		IssuerWhitelist:       []string{"https://api.example.com"},
+		IssuerAliases:         map[string]string{"https://auth.old.example.com": "https://api.example.com"},
+		BlockKIDs:             []string{"k1", "k2"},
+		AudienceWhitelist:     []string{"https://api.example.io", "https://learn.example.com"},
+		Audience:              "{http.vars.jwt_aud}",
+		ExceptPaths:           []string{"/healthz", "^/webhooks/[a-z]+$"},
+		AllowOptionsPreflight: true,
+		SubjectPattern:        []string{"spiffe://prod/*", "^[0-9]+$"},
+		UserClaims:            []string{"uid", "user_id", "login", "username"},
+		MetaClaims:            map[string]string{"IsAdmin": "is_admin", "gender": "gender"},
+		MetaClaimsJSON:        true,
+		ArrayFormat:           &ArrayFormat{Separator: ";", FirstOnly: true},
+		ValidateIat:           &falseValue,
+		NormalizeToken:        map[string][]string{"cookie": {"trim", "unquote"}},
+		HeaderScheme:          []string{"Bearer", "JWT"},
+		HeaderPrefix:          map[string]string{"X-Api-Key": "^v[0-9]+:"},
+		ExpiredRedirect:       "/login",
+		ExpiredFlashCookie:    "flash",
+		SharedJWKs:            &SharedJWKs{MaxAge: caddy.Duration(10 * time.Minute)},
+		RequireKeysAtStartup:  &KeysAtStartup{Lenient: true},
+		RequireScope:          []string{"orders:read", "orders:write"},
+		ScopeMatch:            "any",
+		RolesClaim:            "keycloak",
+		RequireRole:           []string{"admin", "editor"},
+		Leeway:                caddy.Duration(5 * time.Second),
+		MaxTokenAge:           caddy.Duration(24 * time.Hour),
+		RequireExp:            true,
+		StrictRFC9068:         true,
+		RequireTyp:            true,
+		AllowedTyp:            []string{"at+jwt", "JOSE"},
+		AllowedCrit:           []string{"exp_v2"},
+		ExpiredGrace:          caddy.Duration(30 * time.Second),
+		ExpiringWindow:        caddy.Duration(2 * time.Minute),
+		QueryTokenNoStore:     true,
+		MatchedAudienceHeader: "X-Matched-Aud",
+		ForwardClaimsHeader:   map[string]string{"sub": "X-User-Id", "org.roles": "X-User-Roles"},
+		ForwardedClaims:       map[string]string{"sub": "sub", "org.id": "org"},
+		StripToken:            true,
+		PrincipalType:         "human",
+		RequireEnv:            "prod",
+		EnvClaim:              "deployment",
+		BindClaims:            map[string]string{"cip": "client_ip", "fgp": "sha256:{http.request.cookie.__Secure-Fgp}"},
+		Name:                  "api",
+		VerificationWorkers:   8,
+		ClaimPolicies:         map[string]ClaimPolicy{"admin": {"roles": {"admin"}}},
+		ClaimPolicyName:       "admin",
+		Require: []ClaimRequirement{
+			{Claim: "scope", Values: []string{"admin:read", "admin:write"}},
+			{Claim: "org.id", Values: []string{"codelet"}},
+		},
+		VerifyClaims:       []string{"age >=18", "mfa exists"},
+		ClaimsSchema:       "/etc/caddy/claims.schema.json",
+		PolicyTraceHeader:  "X-Debug-Trace",
+		Audit:              true,
+		Redaction:          "strict",
+		PolicyTraceSecret:  "s3cr3t",
+		ValidateExpression: "claims.role == 'admin' && 'payments' in claims.scopes",
+		RequestIDHeader:    "X-Correlation-Id",
+		ExposeRequestID:    true,
+		Enrich: &Enrichment{
+			URL:           "https://entitlements.example.com/users/{id}",
+			Attributes:    map[string]string{"plan": "plan", "seats": "seats"},
+			Timeout:       caddy.Duration(time.Second),
+			CacheMaxBytes: 1 << 20,
+			Volatile:      []string{"seats"},
+			Stable:        []string{"plan"},
+			Required:      true,
+		},
+		Issuers: map[string]*IssuerKeys{
+			"https://a.example.com": {SignKey: "TkZMNSowQmMjOVU2RUB0bm1DJkU3U1VONkd3SGZMbVk=", SignAlgorithm: "HS256"},
+			"https://b.example.com": {JWKURL: "https://b.example.com/jwks.json"},
+		},
+		ValidationCache: &ValidationCache{
+			MaxEntries:  1000,
+			MaxTTL:      caddy.Duration(time.Minute),
+			NegativeTTL: caddy.Duration(5 * time.Second),
+		},
+		IssueSessionCookie: &SessionCookie{
+			Name:     "sess",
+			TTL:      caddy.Duration(2 * time.Minute),
+			Secret:   "{env.JWT_SESSION_SECRET}",
+			SameSite: "strict",
+			Secure:   &falseValue,
+		},
+		FailureRateLimit: &FailureRateLimit{
+			MaxFailures: 20,
+			Window:      caddy.Duration(5 * time.Minute),
+			Key:         "token",
+		},
+		DPoP: &DPoP{Required: true, MaxAge: caddy.Duration(time.Minute), MaxEntries: 500},
+		ClaimsAnomaly: &ClaimsAnomaly{
+			Claims:      []string{"iss", "roles"},
+			TTL:         caddy.Duration(12 * time.Hour),
+			MaxSubjects: 500,
+		},
+		UserInfo: &UserInfoEnrichment{
+			Claims: map[string]string{"email": "email", "name": "display_name"},
+		},
+		Introspection: &Introspection{
+			Endpoint:     "https://auth.example.com/introspect",
+			ClientID:     "caddy",
+			ClientSecret: "s3cr3t",
+			CacheTTL:     caddy.Duration(30 * time.Second),
+		},
+		Maintenance: &Maintenance{
+			Allow:      []ClaimRequirement{{Claim: "role", Values: []string{"operator"}}},
+			RetryAfter: caddy.Duration(10 * time.Minute),
+		},
+		ContextToken: &ContextToken{
+			Header: "X-Context-Token",
+			Provider: &JWTAuth{
+				JWKURL:          "https://partner.example.com/jwks",
+				IssuerWhitelist: []string{"https://partner.example.com"},
+				MetaClaims:      map[string]string{"tenant": "tenant", "org.id": "org"},
+				Require:         []ClaimRequirement{{Claim: "scope", Values: []string{"checkout"}}},
+			},
+			AccessMetaPrefix: "access.",
+		},
+		BearerChallenge: &BearerChallenge{Realm: "api"},
+		FailureResponse: &FailureResponse{
+			StatusCode:  403,
+			Body:        `{"error": {{json .Reason}}}`,
+			ContentType: "application/json",
+			Headers:     map[string]string{"WWW-Authenticate": "Bearer"},
+		},
+		SandboxParsing: &SandboxParsing{Workers: 4, MaxTokenSize: 8 << 10},
+		DenyWebhook: &DenyWebhook{
+			URL:           "https://soc.example.com/events",
+			BatchSize:     50,
+			FlushInterval: caddy.Duration(5 * time.Second),
+		},
+		UpstreamBasicAuth: &UpstreamBasicAuth{UsernameClaim: "login", Password: "secret"},
 	}
 
 	h, err := parseCaddyfile(helper)
@@ -47,6 +330,81 @@ func TestParsingCaddyfileNormalCase(t *testing.T) {
 	assert.Equal(t, caddyconfig.JSON(expectedJA, nil), jsonConfig)
 }
 
+func TestParsingCaddyfileDeprecatedOptions(t *testing.T) {
+	// header_first is translated to source_priority, with a warning
+	helper := httpcaddyfile.Helper{
+		Dispenser: caddyfile.NewTestDispenser(`
+	jwtauth {
+		header_first true
+		from_header X-Api-Key
+	}
+	`),
+	}
+	h, err := parseCaddyfile(helper)
+	assert.Nil(t, err)
+	auth, ok := h.(caddyauth.Authentication)
+	assert.True(t, ok)
+	assert.Equal(t, caddyconfig.JSON(&JWTAuth{
+		FromHeader:     []string{"X-Api-Key"},
+		SourcePriority: []string{"header", "query", "cookie"},
+	}, nil), auth.ProvidersRaw["jwt"])
+
+	for config, expectedErr := range map[string]string{
+		"header_first false": "",
+		"header_first":       "invalid header_first",
+		"header_first yes":   "invalid header_first",
+		"header_first true\nsource_priority header cookie": "duplicate option: source_priority",
+	} {
+		helper = httpcaddyfile.Helper{Dispenser: caddyfile.NewTestDispenser("jwtauth {\n" + config + "\n}")}
+		_, err = parseCaddyfile(helper)
+		if expectedErr == "" {
+			assert.Nil(t, err, config)
+		} else {
+			assert.ErrorContains(t, err, expectedErr, config)
+		}
+	}
+}
+
+func TestParsingCaddyfileDeprecationWarnings(t *testing.T) {
+	adapter := caddyconfig.GetAdapter("caddyfile")
+	_, warnings, err := adapter.Adapt([]byte(`
+localhost {
+	route {
+		jwtauth {
+			sign_key "TkZMNSowQmMjOVU2RUB0bm1DJkU3U1VONkd3SGZMbVk="
+			header_first true
+		}
+	}
+}
+`), map[string]interface{}{"filename": "Caddyfile"})
+	assert.Nil(t, err)
+	assert.Contains(t, warnings, caddyconfig.Warning{
+		File:      "Caddyfile",
+		Line:      6,
+		Directive: "jwtauth",
+		Message:   "option header_first is deprecated, use source_priority instead: replace header_first true by source_priority header query cookie, remove header_first false",
+	})
+}
+
+func TestParsingCaddyfileStaticJWKs(t *testing.T) {
+	helper := httpcaddyfile.Helper{
+		Dispenser: caddyfile.NewTestDispenser(`
+	jwtauth {
+		jwk_sets "{\"keys\": []}"
+		jwk_files /etc/caddy/jwks-a.json /etc/caddy/jwks-b.json
+	}
+	`),
+	}
+	h, err := parseCaddyfile(helper)
+	assert.Nil(t, err)
+	auth, ok := h.(caddyauth.Authentication)
+	assert.True(t, ok)
+	assert.Equal(t, caddyconfig.JSON(&JWTAuth{
+		JWKSets:  []json.RawMessage{json.RawMessage(`{"keys": []}`)},
+		JWKFiles: []string{"/etc/caddy/jwks-a.json", "/etc/caddy/jwks-b.json"},
+	}, nil), auth.ProvidersRaw["jwt"])
+}
+
 func TestParsingCaddyfileError(t *testing.T) {
 	// invalid sign_key: missing
 	helper := httpcaddyfile.Helper{
@@ -85,6 +443,18 @@ func TestParsingCaddyfileError(t *testing.T) {
 	assert.NotNil(t, err)
 	assert.Contains(t, err.Error(), "jwk_url")
 
+	// invalid jwk_sets: malformed
+	helper = httpcaddyfile.Helper{
+		Dispenser: caddyfile.NewTestDispenser(`
+	jwtauth {
+		jwk_sets {"keys":
+	}`),
+	}
+
+	_, err = parseCaddyfile(helper)
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "jwk_sets")
+
 	// invalid sign_key: base64
 	helper = httpcaddyfile.Helper{
 		Dispenser: caddyfile.NewTestDispenser(`
@@ -97,23 +467,23 @@ func TestParsingCaddyfileError(t *testing.T) {
 	_, err = parseCaddyfile(helper)
 	assert.Nil(t, err)
 
-	// header_first is deprecated
+	// invalid meta_claims: parse error
 	helper = httpcaddyfile.Helper{
 		Dispenser: caddyfile.NewTestDispenser(`
 	jwtauth {
-		header_first true
+		meta_claims IsAdmin->is_admin->
 	}
 	`),
 	}
 	_, err = parseCaddyfile(helper)
 	assert.NotNil(t, err)
-	assert.Contains(t, err.Error(), "deprecated")
+	assert.Contains(t, err.Error(), "meta_claims")
 
-	// invalid meta_claims: parse error
+	// invalid meta_claims: duplicate
 	helper = httpcaddyfile.Helper{
 		Dispenser: caddyfile.NewTestDispenser(`
 	jwtauth {
-		meta_claims IsAdmin->is_admin->
+		meta_claims IsAdmin->is_admin Gender->gender IsAdmin->admin
 	}
 	`),
 	}
@@ -121,17 +491,36 @@ func TestParsingCaddyfileError(t *testing.T) {
 	assert.NotNil(t, err)
 	assert.Contains(t, err.Error(), "meta_claims")
 
-	// invalid meta_claims: duplicate
+	// invalid validate_exp: not a bool
 	helper = httpcaddyfile.Helper{
 		Dispenser: caddyfile.NewTestDispenser(`
 	jwtauth {
-		meta_claims IsAdmin->is_admin Gender->gender IsAdmin->admin
+		validate_exp maybe
 	}
 	`),
 	}
 	_, err = parseCaddyfile(helper)
 	assert.NotNil(t, err)
-	assert.Contains(t, err.Error(), "meta_claims")
+	assert.Contains(t, err.Error(), "validate_exp")
+
+	// invalid upstream_basic_auth: unknown sub-option
+	helper = httpcaddyfile.Helper{
+		Dispenser: caddyfile.NewTestDispenser(`
+	jwtauth {
+		enrich https://entitlements.example.com/users/{id} {
+			attributes "plan -> plan" seats
+			timeout 1s
+			required
+		}
+		upstream_basic_auth {
+			user admin
+		}
+	}
+	`),
+	}
+	_, err = parseCaddyfile(helper)
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "upstream_basic_auth")
 
 	// unrecognized option
 	helper = httpcaddyfile.Helper{
@@ -174,3 +563,112 @@ func TestParseMetaClaim(t *testing.T) {
 		}
 	}
 }
+
+func TestParsingCaddyfileOptionChecks(t *testing.T) {
+	parse := func(body string) (*JWTAuth, error) {
+		h, err := parseCaddyfile(httpcaddyfile.Helper{Dispenser: caddyfile.NewTestDispenser(body)})
+		if err != nil {
+			return nil, err
+		}
+		var ja JWTAuth
+		assert.Nil(t, json.Unmarshal(h.(caddyauth.Authentication).ProvidersRaw["jwt"], &ja))
+		return &ja, nil
+	}
+
+	// every option is recognized
+	for opt := range caddyfileOptions {
+		_, err := parse("jwtauth {\n" + opt + "\n}")
+		if err != nil {
+			assert.NotContains(t, err.Error(), "unrecognized option", opt)
+		}
+	}
+
+	// duplicate, with the line first given
+	_, err := parse(`jwtauth {
+		sign_key TkZMNSowQmMjOVU2RUB0bm1DJkU3U1VONkd3SGZMbVk=
+		from_header X-Api-Key
+		sign_key c2VjcmV0
+	}`)
+	assert.ErrorContains(t, err, "duplicate option: sign_key, already given at line 2")
+	assert.ErrorContains(t, err, ":4")
+
+	// repeatable
+	ja, err := parse(`jwtauth {
+		require_role admin
+		require_role editor
+	}`)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"admin", "editor"}, ja.RequireRole)
+
+	// block after an option of none
+	_, err = parse(`jwtauth {
+		require_exp {
+			leeway 5s
+		}
+	}`)
+	assert.ErrorContains(t, err, "unexpected block: require_exp takes none")
+
+	// missing arguments
+	_, err = parse(`jwtauth {
+		from_query
+	}`)
+	assert.ErrorContains(t, err, "invalid from_query: expect <param...>")
+
+	// typo
+	_, err = parse(`jwtauth {
+		sign_alog HS256
+	}`)
+	assert.ErrorContains(t, err, "unrecognized option: sign_alog, did you mean sign_alg?")
+	_, err = parse(`jwtauth {
+		upstream http://192.168.1.4
+	}`)
+	assert.ErrorContains(t, err, "unrecognized option: upstream")
+	assert.NotContains(t, err.Error(), "did you mean")
+}
+
+func TestParsingCaddyfileJWKBlock(t *testing.T) {
+	helper := httpcaddyfile.Helper{
+		Dispenser: caddyfile.NewTestDispenser(`
+	jwtauth {
+		jwk {
+			url https://{http.request.header.X-Tenant}.idp.example.com/jwks.json
+			tenants {
+				allow acme-*
+			}
+			refresh 10m
+			key_not_found_backoff 30s
+			shared 5m
+			require_at_startup lenient
+		}
+	}
+	`),
+	}
+	h, err := parseCaddyfile(helper)
+	assert.Nil(t, err)
+	assert.Equal(t, caddyconfig.JSON(&JWTAuth{
+		JWKURL:               "https://{http.request.header.X-Tenant}.idp.example.com/jwks.json",
+		JWKURLTenants:        &JWKURLTenants{Allow: []string{"acme-*"}},
+		JWKRefreshInterval:   caddy.Duration(10 * time.Minute),
+		KeyNotFoundBackoff:   caddy.Duration(30 * time.Second),
+		SharedJWKs:           &SharedJWKs{MaxAge: caddy.Duration(5 * time.Minute)},
+		RequireKeysAtStartup: &KeysAtStartup{Lenient: true},
+	}, nil), h.(caddyauth.Authentication).ProvidersRaw["jwt"])
+
+	// the block and the flat options are the same options
+	_, err = parseCaddyfile(httpcaddyfile.Helper{Dispenser: caddyfile.NewTestDispenser(`
+	jwtauth {
+		jwk_url https://idp.example.com/jwks.json
+		jwk {
+			url https://other.example.com/jwks.json
+		}
+	}`)})
+	assert.ErrorContains(t, err, "duplicate option: jwk_url")
+
+	_, err = parseCaddyfile(httpcaddyfile.Helper{Dispenser: caddyfile.NewTestDispenser(`
+	jwtauth {
+		jwk {
+			uri https://idp.example.com/jwks.json
+		}
+	}`)})
+	assert.ErrorContains(t, err, "unrecognized jwk option: uri")
+}
@@ -0,0 +1,69 @@
+package caddyjwt
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+)
+
+// BearerChallenge sets the WWW-Authenticate header of RFC 6750 on the
+// responses to the requests failing the authentication, e.g.
+//
+//	WWW-Authenticate: Bearer realm="api", error="invalid_token", error_description="token expired"
+//
+// which many clients rely on to refresh their tokens. Like basic auth, the
+// requests without any token are challenged without an error code.
+type BearerChallenge struct {
+	// Realm is the protection space. Optional.
+	Realm string `json:"realm"`
+}
+
+// challenge sets the WWW-Authenticate header for err.
+func (bc *BearerChallenge) challenge(rw http.ResponseWriter, err error) {
+	var params []string
+	if bc.Realm != "" {
+		params = append(params, "realm="+quoteChallengeParam(bc.Realm))
+	}
+	if !errors.Is(err, ErrMissingToken) {
+		params = append(params,
+			"error="+quoteChallengeParam(bearerErrorCode(err)),
+			"error_description="+quoteChallengeParam(genericFailureMessage(err)),
+		)
+	}
+	if len(params) == 0 {
+		rw.Header().Set("WWW-Authenticate", "Bearer")
+		return
+	}
+	rw.Header().Set("WWW-Authenticate", "Bearer "+strings.Join(params, ", "))
+}
+
+// bearerErrorCode returns the error code of RFC 6750 section 3.1 for err:
+// "insufficient_scope" if the token is valid but lacks the privileges, or
+// "invalid_token" otherwise.
+func bearerErrorCode(err error) string {
+	switch {
+	case errors.Is(err, ErrInsufficientScope),
+		errors.Is(err, ErrClaimPolicy),
+		errors.Is(err, ErrPrincipalType),
+		errors.Is(err, ErrSubjectMismatch):
+		return "insufficient_scope"
+	}
+	return "invalid_token"
+}
+
+// quoteChallengeParam quotes the value as a quoted-string, dropping the
+// characters not allowed by RFC 6750, i.e. out of %x20-21 / %x23-5B /
+// %x5D-7E.
+func quoteChallengeParam(value string) string {
+	var b strings.Builder
+	b.WriteByte('"')
+	for i := 0; i < len(value); i++ {
+		c := value[i]
+		if c < 0x20 || c > 0x7e || c == '"' || c == '\\' {
+			continue
+		}
+		b.WriteByte(c)
+	}
+	b.WriteByte('"')
+	return b.String()
+}
@@ -0,0 +1,44 @@
+package caddyjwt
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAuthenticate_BearerChallenge(t *testing.T) {
+	ja := &JWTAuth{
+		SignKey:         TestSignKey,
+		Require:         []ClaimRequirement{{Claim: "scope", Values: []string{"write"}}},
+		BearerChallenge: &BearerChallenge{Realm: "api"},
+		logger:          testLogger,
+	}
+	assert.Nil(t, ja.Validate())
+
+	challenge := func(token string) string {
+		rw := httptest.NewRecorder()
+		r, _ := http.NewRequest("GET", "/", nil)
+		if token != "" {
+			r.Header.Add("Authorization", token)
+		}
+		ja.Authenticate(rw, r)
+		return rw.Header().Get("WWW-Authenticate")
+	}
+
+	assert.Equal(t, `Bearer realm="api"`, challenge(""))
+	assert.Equal(t, `Bearer realm="api", error="invalid_token", error_description="token expired"`,
+		challenge(issueTokenString(MapClaims{"sub": "ggicci", "scope": "write", "exp": 1})))
+	assert.Equal(t, `Bearer realm="api", error="invalid_token", error_description="invalid token"`,
+		challenge("not-a-jwt"))
+	assert.Equal(t, `Bearer realm="api", error="insufficient_scope", error_description="claim policy"`,
+		challenge(issueTokenString(MapClaims{"sub": "ggicci", "scope": "read"})))
+	assert.Empty(t, challenge(issueTokenString(MapClaims{"sub": "ggicci", "scope": "write"})))
+}
+
+func TestQuoteChallengeParam(t *testing.T) {
+	assert.Equal(t, `"api"`, quoteChallengeParam("api"))
+	assert.Equal(t, `"my api"`, quoteChallengeParam("my \"api\"\n"))
+	assert.Equal(t, `""`, quoteChallengeParam("中文"))
+}
@@ -0,0 +1,182 @@
+package caddyjwt
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// claimCheck is a compiled check of VerifyClaims.
+type claimCheck struct {
+	expr    string
+	claim   string
+	op      string      // one of claimCheckOps, or "exists"
+	operand interface{} // float64, bool or string
+}
+
+// claimCheckOps are the comparison operators of VerifyClaims, the longer
+// ones first, as they are matched by prefix.
+var claimCheckOps = []string{">=", "<=", "==", "!=", ">", "<"}
+
+// compileClaimCheck parses a check of VerifyClaims, e.g. "age >=18",
+// "email_verified ==true", `name =="18"` or "mfa exists". The operand is a
+// number or a boolean if it parses as one, a string otherwise, or if
+// quoted. The ordering operators only apply to the numbers.
+func compileClaimCheck(expr string) (claimCheck, error) {
+	claim, rest, _ := strings.Cut(strings.TrimSpace(expr), " ")
+	rest = strings.TrimSpace(rest)
+	c := claimCheck{expr: expr, claim: claim}
+	if claim == "" {
+		return c, fmt.Errorf("missing claim")
+	}
+	if rest == "exists" {
+		c.op = rest
+		return c, nil
+	}
+	for _, op := range claimCheckOps {
+		if strings.HasPrefix(rest, op) {
+			c.op, rest = op, strings.TrimSpace(rest[len(op):])
+			break
+		}
+	}
+	if c.op == "" {
+		return c, fmt.Errorf("%q: expect <claim> <op><value> or <claim> exists, op is one of %s", expr, strings.Join(claimCheckOps, " "))
+	}
+	if rest == "" {
+		return c, fmt.Errorf("%q: missing value", expr)
+	}
+	if strings.HasPrefix(rest, `"`) {
+		s, err := strconv.Unquote(rest)
+		if err != nil {
+			return c, fmt.Errorf("%q: malformed quoted value", expr)
+		}
+		c.operand = s
+	} else if rest == "true" || rest == "false" {
+		c.operand = rest == "true"
+	} else if f, err := strconv.ParseFloat(rest, 64); err == nil {
+		c.operand = f
+	} else {
+		c.operand = rest
+	}
+	if _, isNumber := c.operand.(float64); !isNumber && c.op != "==" && c.op != "!=" {
+		return c, fmt.Errorf("%q: %s applies to numbers only", expr, c.op)
+	}
+	return c, nil
+}
+
+// check verifies the claim of the token. The value of an array claim
+// satisfies the check if any of its elements does, except for "!=", which
+// requires none of them to be equal.
+func (c claimCheck) check(token Token) error {
+	val, ok := getClaim(token, c.claim)
+	if !ok {
+		return fmt.Errorf("%w: missing claim %q", ErrClaimPolicy, c.claim)
+	}
+	if c.op == "exists" {
+		return nil
+	}
+	values, isArray := val.([]interface{})
+	if !isArray {
+		values = []interface{}{val}
+	}
+	satisfied := c.op == "!="
+	for _, v := range values {
+		cmp, comparable := c.compare(v)
+		if c.op == "!=" {
+			if comparable && cmp == 0 {
+				satisfied = false
+				break
+			}
+			continue
+		}
+		if comparable && c.satisfies(cmp) {
+			satisfied = true
+			break
+		}
+	}
+	if !satisfied {
+		return fmt.Errorf("%w: claim %q fails %q", ErrClaimPolicy, c.claim, c.expr)
+	}
+	return nil
+}
+
+func (c claimCheck) satisfies(cmp int) bool {
+	switch c.op {
+	case "==":
+		return cmp == 0
+	case ">=":
+		return cmp >= 0
+	case "<=":
+		return cmp <= 0
+	case ">":
+		return cmp > 0
+	case "<":
+		return cmp < 0
+	}
+	return false
+}
+
+// compare compares the value to the operand, false if of different types.
+func (c claimCheck) compare(val interface{}) (int, bool) {
+	switch operand := c.operand.(type) {
+	case float64:
+		f, ok := claimNumber(val)
+		if !ok {
+			return 0, false
+		}
+		switch {
+		case f < operand:
+			return -1, true
+		case f > operand:
+			return 1, true
+		}
+		return 0, true
+	case bool:
+		b, ok := val.(bool)
+		if !ok {
+			return 0, false
+		}
+		if b == operand {
+			return 0, true
+		}
+		return 1, true
+	case string:
+		s, ok := val.(string)
+		if !ok {
+			return 0, false
+		}
+		return strings.Compare(s, operand), true
+	}
+	return 0, false
+}
+
+// claimNumber returns the numeric value of a claim, the Unix time of the
+// time claims, e.g. "exp".
+func claimNumber(val interface{}) (float64, bool) {
+	switch v := val.(type) {
+	case float64:
+		return v, true
+	case int:
+		return float64(v), true
+	case int64:
+		return float64(v), true
+	case json.Number:
+		f, err := v.Float64()
+		return f, err == nil
+	case time.Time:
+		return float64(v.Unix()), true
+	}
+	return 0, false
+}
+
+// checkClaims verifies the claims of the token against VerifyClaims.
+func (ja *JWTAuth) checkClaims(token Token) error {
+	for _, c := range ja.claimChecks {
+		if err := c.check(token); err != nil {
+			return err
+		}
+	}
+	return nil
+}
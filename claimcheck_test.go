@@ -0,0 +1,56 @@
+package caddyjwt
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAuthenticate_VerifyClaims(t *testing.T) {
+	ja := &JWTAuth{
+		SignKey:      TestSignKey,
+		VerifyClaims: []string{"age >=18", "email_verified ==true", "mfa exists", "org.tier != free"},
+		logger:       testLogger,
+	}
+	assert.Nil(t, ja.Validate())
+
+	var testCases = []struct {
+		Claims        MapClaims
+		Authenticated bool
+	}{
+		{MapClaims{"sub": "ggicci", "age": 18, "email_verified": true, "mfa": "otp", "org": map[string]interface{}{"tier": "pro"}}, true},
+		{MapClaims{"sub": "ggicci", "age": 30, "email_verified": true, "mfa": false, "org": map[string]interface{}{"tier": []string{"pro"}}}, true},
+		{MapClaims{"sub": "ggicci", "age": 17, "email_verified": true, "mfa": "otp"}, false},
+		{MapClaims{"sub": "ggicci", "age": "30", "email_verified": true, "mfa": "otp"}, false}, // not a number
+		{MapClaims{"sub": "ggicci", "age": 30, "email_verified": "true", "mfa": "otp"}, false}, // not a boolean
+		{MapClaims{"sub": "ggicci", "age": 30, "email_verified": true}, false},                 // no mfa
+		{MapClaims{"sub": "ggicci", "age": 30, "email_verified": true, "mfa": "otp", "org": map[string]interface{}{"tier": "free"}}, false},
+		{MapClaims{"sub": "ggicci", "age": 30, "email_verified": true, "mfa": "otp"}, false}, // no org.tier
+	}
+
+	for _, c := range testCases {
+		r, _ := http.NewRequest("GET", "/", nil)
+		r.Header.Add("Authorization", issueTokenString(c.Claims))
+		_, authenticated, err := ja.Authenticate(httptest.NewRecorder(), r)
+		assert.Equal(t, c.Authenticated, authenticated, c.Claims)
+		if !c.Authenticated {
+			assert.ErrorIs(t, err, ErrClaimPolicy)
+		}
+	}
+}
+
+func TestCompileClaimCheck(t *testing.T) {
+	c, err := compileClaimCheck(`level =="18"`)
+	assert.Nil(t, err)
+	assert.Equal(t, "18", c.operand)
+	c, err = compileClaimCheck("level < 2.5")
+	assert.Nil(t, err)
+	assert.Equal(t, 2.5, c.operand)
+
+	for _, expr := range []string{"", "age", "age 18", "age >=", "name >=ggicci", "ok <true", `name =="ggicci`} {
+		_, err := compileClaimCheck(expr)
+		assert.Error(t, err, expr)
+	}
+}
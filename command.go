@@ -0,0 +1,138 @@
+package caddyjwt
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+	caddycmd "github.com/caddyserver/caddy/v2/cmd"
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	caddycmd.RegisterCommand(caddycmd.Command{
+		Name:  "jwt",
+		Usage: "<command>",
+		Short: "Utilities for the JWT authentication provider",
+		CobraFunc: func(cmd *cobra.Command) {
+			lintCmd := &cobra.Command{
+				Use:   "lint [--config <path>] [--adapter <name>] [--fail-on <severity>]",
+				Short: "Reports security smells in the JWT providers of a config",
+				Long: `
+Loads a Caddyfile or JSON config, resolves the configuration of every jwt
+authentication provider in it and reports security smells, e.g. short
+symmetric keys, missing audience checks or tokens accepted from query strings.
+
+Each issue has a severity level: low, medium or high. The command exits with
+a non-zero code if any issue is at least as severe as --fail-on (default:
+high), so it can be used as a pre-deploy gate.
+`,
+				RunE: caddycmd.WrapCommandFuncForCobra(cmdLint),
+			}
+			lintCmd.Flags().StringP("config", "c", "", "Configuration file")
+			lintCmd.Flags().StringP("adapter", "a", "", "Name of config adapter to apply")
+			lintCmd.Flags().StringP("fail-on", "f", "high", "Minimum severity to fail on")
+			cmd.AddCommand(lintCmd)
+
+			conformanceCmd := &cobra.Command{
+				Use:   "conformance [--config <path>] [--adapter <name>]",
+				Short: "Runs the conformance vectors against the JWT providers of a config",
+				Long: `
+Loads a Caddyfile or JSON config, sets up every jwt authentication provider
+in it, as it would be served, and authenticates the conformance vectors
+against it: tokens exercising the edge cases of RFC 7519, RFC 7515 and
+RFC 8725, e.g. "alg": "none", an empty "kid", an "iat" in the future, a
+string "exp" or duplicated claims, each with its expected outcome.
+
+The signed vectors can only be built for the providers verifying the tokens
+by a symmetric sign_key, they are skipped for the others. The command exits
+with a non-zero code if any vector has an unexpected outcome.
+`,
+				RunE: caddycmd.WrapCommandFuncForCobra(cmdConformance),
+			}
+			conformanceCmd.Flags().StringP("config", "c", "", "Configuration file")
+			conformanceCmd.Flags().StringP("adapter", "a", "", "Name of config adapter to apply")
+			cmd.AddCommand(conformanceCmd)
+		},
+	})
+}
+
+func cmdLint(fl caddycmd.Flags) (int, error) {
+	failOn, err := parseLintSeverity(fl.String("fail-on"))
+	if err != nil {
+		return caddy.ExitCodeFailedStartup, err
+	}
+
+	config, _, err := caddycmd.LoadConfig(fl.String("config"), fl.String("adapter"))
+	if err != nil {
+		return caddy.ExitCodeFailedStartup, err
+	}
+
+	results, err := lintConfig(config)
+	if err != nil {
+		return caddy.ExitCodeFailedStartup, err
+	}
+	if len(results) == 0 {
+		fmt.Println("no jwt providers found")
+		return caddy.ExitCodeSuccess, nil
+	}
+
+	paths := make([]string, 0, len(results))
+	for path := range results {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+
+	failed := false
+	for _, path := range paths {
+		fmt.Println(path)
+		for _, issue := range results[path] {
+			fmt.Println("  " + issue.String())
+			if issue.Severity >= failOn {
+				failed = true
+			}
+		}
+	}
+	if failed {
+		return caddy.ExitCodeFailedStartup, fmt.Errorf("found issues of severity %s or above", failOn)
+	}
+	return caddy.ExitCodeSuccess, nil
+}
+
+func cmdConformance(fl caddycmd.Flags) (int, error) {
+	config, _, err := caddycmd.LoadConfig(fl.String("config"), fl.String("adapter"))
+	if err != nil {
+		return caddy.ExitCodeFailedStartup, err
+	}
+
+	results, err := conformanceConfig(config, time.Now())
+	if err != nil {
+		return caddy.ExitCodeFailedStartup, err
+	}
+	if len(results) == 0 {
+		fmt.Println("no jwt providers found")
+		return caddy.ExitCodeSuccess, nil
+	}
+
+	paths := make([]string, 0, len(results))
+	for path := range results {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+
+	failed := false
+	for _, path := range paths {
+		fmt.Println(path)
+		for _, result := range results[path] {
+			fmt.Println("  " + result.String())
+			if !result.Skipped && !result.Passed() {
+				failed = true
+			}
+		}
+	}
+	if failed {
+		return caddy.ExitCodeFailedStartup, fmt.Errorf("found vectors of unexpected outcomes")
+	}
+	return caddy.ExitCodeSuccess, nil
+}
@@ -0,0 +1,50 @@
+package caddyjwt
+
+import (
+	"bytes"
+	"compress/flate"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// defaultMaxDecompressedSize is the default of MaxDecompressedSize.
+const defaultMaxDecompressedSize = 64 << 10
+
+// compressed reports whether the payload of the token is DEFLATE
+// compressed, i.e. of the "zip": "DEF" header.
+func compressed(token string) bool {
+	raw := rawHeader(token)
+	if !mayHaveHeaderParam(raw, "zip") {
+		return false
+	}
+	var header struct {
+		Zip string `json:"zip"`
+	}
+	_ = json.Unmarshal(raw, &header)
+	return strings.EqualFold(header.Zip, "DEF")
+}
+
+// maxDecompressedSize returns MaxDecompressedSize or its default.
+func (ja *JWTAuth) maxDecompressedSize() int {
+	if ja.MaxDecompressedSize > 0 {
+		return ja.MaxDecompressedSize
+	}
+	return defaultMaxDecompressedSize
+}
+
+// inflate decompresses the DEFLATE compressed data, which must not exceed
+// max bytes decompressed.
+func inflate(data []byte, max int) ([]byte, error) {
+	reader := flate.NewReader(bytes.NewReader(data))
+	defer reader.Close()
+	inflated, err := io.ReadAll(io.LimitReader(reader, int64(max)+1))
+	if err != nil {
+		return nil, fmt.Errorf("inflating payload: %v", err)
+	}
+	if len(inflated) > max {
+		return nil, fmt.Errorf("payload exceeds %d bytes decompressed", max)
+	}
+	return inflated, nil
+}
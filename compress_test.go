@@ -0,0 +1,89 @@
+package caddyjwt
+
+import (
+	"bytes"
+	"compress/flate"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/lestrrat-go/jwx/v2/jwa"
+	"github.com/lestrrat-go/jwx/v2/jws"
+	"github.com/stretchr/testify/assert"
+)
+
+func deflate(t *testing.T, data []byte) []byte {
+	var buf bytes.Buffer
+	w, err := flate.NewWriter(&buf, flate.BestCompression)
+	assert.Nil(t, err)
+	_, err = w.Write(data)
+	assert.Nil(t, err)
+	assert.Nil(t, w.Close())
+	return buf.Bytes()
+}
+
+// issueCompressedToken signs the claims deflated, of "zip": "DEF".
+func issueCompressedToken(t *testing.T, claims MapClaims) string {
+	payload, err := json.Marshal(claims)
+	assert.Nil(t, err)
+	headers := jws.NewHeaders()
+	assert.Nil(t, headers.Set("zip", "DEF"))
+	signed, err := jws.Sign(deflate(t, payload), jws.WithKey(jwa.HS256, RawTestSignKey, jws.WithProtectedHeaders(headers)))
+	assert.Nil(t, err)
+	return string(signed)
+}
+
+func TestAuthenticate_CompressedToken(t *testing.T) {
+	ja := &JWTAuth{SignKey: TestSignKey, MaxDecompressedSize: 1024, logger: testLogger}
+	assert.Nil(t, ja.Validate())
+	exp := time.Now().Add(time.Hour).Unix()
+
+	authenticate := func(token string) (User, bool, error) {
+		r, _ := http.NewRequest("GET", "/", nil)
+		r.Header.Add("Authorization", token)
+		return ja.Authenticate(httptest.NewRecorder(), r)
+	}
+
+	token := issueCompressedToken(t, MapClaims{"sub": "ggicci", "exp": exp})
+	assert.True(t, compressed(token))
+	user, authenticated, err := authenticate(token)
+	assert.Nil(t, err)
+	assert.True(t, authenticated)
+	assert.Equal(t, "ggicci", user.ID)
+
+	// the claims are validated after inflated
+	_, authenticated, err = authenticate(issueCompressedToken(t, MapClaims{"sub": "ggicci", "exp": time.Now().Add(-time.Hour).Unix()}))
+	assert.ErrorIs(t, err, ErrTokenExpired)
+	assert.False(t, authenticated)
+
+	// decompression bomb
+	_, authenticated, err = authenticate(issueCompressedToken(t, MapClaims{"sub": "ggicci", "exp": exp, "pad": strings.Repeat("a", 4096)}))
+	assert.ErrorIs(t, err, ErrInvalidToken)
+	assert.False(t, authenticated)
+
+	// tampered signature
+	_, authenticated, err = authenticate(token[:len(token)-4] + "AAAA")
+	assert.ErrorIs(t, err, ErrInvalidToken)
+	assert.False(t, authenticated)
+}
+
+func TestValidate_MaxDecompressedSize(t *testing.T) {
+	ja := &JWTAuth{SignKey: TestSignKey, MaxDecompressedSize: -1}
+	assert.ErrorContains(t, ja.Validate(), "invalid max_decompressed_size")
+}
+
+func Test_inflate(t *testing.T) {
+	data := bytes.Repeat([]byte("a"), 100)
+	inflated, err := inflate(deflate(t, data), 100)
+	assert.Nil(t, err)
+	assert.Equal(t, data, inflated)
+
+	_, err = inflate(deflate(t, data), 99)
+	assert.ErrorContains(t, err, "exceeds 99 bytes")
+
+	_, err = inflate([]byte("not deflated"), 100)
+	assert.ErrorContains(t, err, "inflating payload")
+}
@@ -0,0 +1,304 @@
+package caddyjwt
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/certmagic"
+	"go.uber.org/zap"
+)
+
+// ConformanceVector is a token exercising an edge case of RFC 7519 (JWT),
+// RFC 7515 (JWS) or RFC 8725 (JWT BCP), with the outcome a conforming
+// provider is expected to have. See ConformanceVectors.
+type ConformanceVector struct {
+	Name        string
+	Spec        string // the section of the RFC
+	Description string
+	Accept      bool  // the expected outcome
+	Signed      bool  // whether it's signed by the key of the provider
+	Rejection   error // the error a rejection must wrap, if any specific
+
+	header  map[string]interface{}
+	payload func(base string, now time.Time) string // raw JSON
+	tamper  bool                                    // flips the signature
+}
+
+// ConformanceVectors returns the conformance vectors, the first of which is
+// the valid token the others derive from.
+func ConformanceVectors() []ConformanceVector {
+	fresh := func(base string, now time.Time) string {
+		return fmt.Sprintf(`{%s,"iat":%d,"exp":%d}`, base, now.Unix(), now.Add(5*time.Minute).Unix())
+	}
+	return []ConformanceVector{
+		{
+			Name: "valid", Spec: "RFC 7519 7.2", Accept: true, Signed: true,
+			Description: "a well formed token, the baseline of the others",
+			payload:     fresh,
+		},
+		{
+			Name: "alg_none", Spec: "RFC 8725 3.1",
+			Description: `an unsecured token of "alg": "none" must be rejected`,
+			header:      map[string]interface{}{"alg": "none"},
+			payload:     fresh,
+		},
+		{
+			Name: "bad_signature", Spec: "RFC 7515 5.2", Signed: true,
+			Description: "a token whose signature doesn't verify must be rejected",
+			payload:     fresh,
+			tamper:      true,
+		},
+		{
+			Name: "empty_kid", Spec: "RFC 7515 4.1.4", Accept: true, Signed: true,
+			Description: `"kid" is a hint, an empty one must not stop the configured key from verifying the token`,
+			header:      map[string]interface{}{"kid": ""},
+			payload:     fresh,
+		},
+		{
+			Name: "expired", Spec: "RFC 7519 4.1.4", Signed: true,
+			Description: "a token past its \"exp\" must be rejected",
+			payload: func(base string, now time.Time) string {
+				return fmt.Sprintf(`{%s,"iat":%d,"exp":%d}`, base, now.Add(-time.Hour).Unix(), now.Add(-30*time.Minute).Unix())
+			},
+		},
+		{
+			Name: "future_iat", Spec: "RFC 7519 4.1.6", Signed: true,
+			Description: "a token issued in the future, beyond the clock skew, must be rejected",
+			payload: func(base string, now time.Time) string {
+				return fmt.Sprintf(`{%s,"iat":%d,"exp":%d}`, base, now.Add(time.Hour).Unix(), now.Add(2*time.Hour).Unix())
+			},
+		},
+		{
+			Name: "string_exp", Spec: "RFC 7519 2", Signed: true, Rejection: ErrMalformedToken,
+			Description: "\"exp\" must be a NumericDate, a string must be rejected as malformed rather than parsed, here of a date not passed yet",
+			payload: func(base string, now time.Time) string {
+				return fmt.Sprintf(`{%s,"iat":%d,"exp":%q}`, base, now.Unix(), now.Add(time.Hour).UTC().Format(time.RFC3339))
+			},
+		},
+		{
+			Name: "duplicated_claims", Spec: "RFC 7519 4", Signed: true,
+			Description: "of the duplicated claims, either the token is rejected or the last one applies, here an expired \"exp\"",
+			payload: func(base string, now time.Time) string {
+				return fmt.Sprintf(`{%s,"iat":%d,"exp":%d,"exp":%d}`, base, now.Unix(), now.Add(5*time.Minute).Unix(), now.Add(-time.Hour).Unix())
+			},
+		},
+	}
+}
+
+// Build builds the token of the vector, with the base claims, i.e. the
+// members of a JSON object, e.g. `"sub":"ggicci"`, and signed by the
+// symmetric key by the HMAC algorithm alg, if signed.
+func (v ConformanceVector) Build(base, alg string, key []byte, now time.Time) (string, error) {
+	header := map[string]interface{}{"alg": alg, "typ": "JWT"}
+	for k, val := range v.header {
+		header[k] = val
+	}
+	headerJSON, err := json.Marshal(header)
+	if err != nil {
+		return "", err
+	}
+	signingInput := base64.RawURLEncoding.EncodeToString(headerJSON) + "." +
+		base64.RawURLEncoding.EncodeToString([]byte(v.payload(base, now)))
+	if !v.Signed {
+		return signingInput + ".", nil
+	}
+	newHash, err := hmacHash(alg)
+	if err != nil {
+		return "", err
+	}
+	mac := hmac.New(newHash, key)
+	mac.Write([]byte(signingInput))
+	sig := mac.Sum(nil)
+	if v.tamper {
+		sig[0] ^= 0xff
+	}
+	return signingInput + "." + base64.RawURLEncoding.EncodeToString(sig), nil
+}
+
+func hmacHash(alg string) (func() hash.Hash, error) {
+	switch alg {
+	case "HS256":
+		return sha256.New, nil
+	case "HS384":
+		return sha512.New384, nil
+	case "HS512":
+		return sha512.New, nil
+	}
+	return nil, fmt.Errorf("unsupported alg %q", alg)
+}
+
+// conformanceResult is the outcome of a vector run against a provider.
+type conformanceResult struct {
+	Vector  ConformanceVector
+	Skipped bool  // for no symmetric key to sign the vector
+	Err     error // of the authentication, nil if accepted
+}
+
+func (cr conformanceResult) Passed() bool {
+	if cr.Skipped || (cr.Err == nil) != cr.Vector.Accept {
+		return false
+	}
+	return cr.Err == nil || cr.Vector.Rejection == nil || errors.Is(cr.Err, cr.Vector.Rejection)
+}
+
+func (cr conformanceResult) String() string {
+	status := "FAIL"
+	switch {
+	case cr.Skipped:
+		status = "SKIP"
+	case cr.Passed():
+		status = "PASS"
+	}
+	s := fmt.Sprintf("[%s] %s (%s): %s", status, cr.Vector.Name, cr.Vector.Spec, cr.Vector.Description)
+	if cr.Err != nil && !cr.Skipped {
+		s += fmt.Sprintf(" -- rejected: %v", cr.Err)
+	}
+	return s
+}
+
+// conformance runs the vectors against the provider, which must be
+// validated. The signed vectors are skipped unless the provider verifies
+// the tokens by a symmetric sign_key. The base claims satisfy the user
+// claims and the issuer and audience whitelists of the provider, with a
+// fresh "jti" per vector for OneTimeTokens.
+func (ja *JWTAuth) conformance(now time.Time) []conformanceResult {
+	keyBytes, asymmetric, err := parseSignKey(ja.SignKey)
+	canSign := ja.SignKey != "" && err == nil && !asymmetric && !ja.usingJWK()
+	alg := ja.SignAlgorithm
+	if !strings.HasPrefix(alg, "HS") {
+		alg = "HS256"
+	}
+
+	var results []conformanceResult
+	for _, v := range ConformanceVectors() {
+		if v.Signed && !canSign {
+			results = append(results, conformanceResult{Vector: v, Skipped: true})
+			continue
+		}
+		base := fmt.Sprintf(`%s,"jti":%q`, ja.conformanceBase(), conformanceJTI())
+		token, err := v.Build(base, alg, keyBytes, now)
+		if err == nil {
+			r, _ := http.NewRequest("GET", "/", nil)
+			r.Header.Set("Authorization", "Bearer "+token)
+			_, err = ja.authenticate(r)
+		}
+		results = append(results, conformanceResult{Vector: v, Err: err})
+	}
+	return results
+}
+
+// conformanceJTI returns a random "jti", never seen by the provider.
+func conformanceJTI() string {
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+	return "conformance-" + hex.EncodeToString(b)
+}
+
+// conformanceBase returns the base claims of the vectors for the provider.
+func (ja *JWTAuth) conformanceBase() string {
+	userClaim := "sub"
+	for _, claim := range ja.UserClaims {
+		if !strings.Contains(claim, ".") {
+			userClaim = claim
+			break
+		}
+	}
+	userClaims := []string{userClaim}
+	if ja.userIDFormat != nil {
+		userClaims = ja.userIDFormat.claims
+	}
+	var claims []string
+	for _, claim := range userClaims {
+		if strings.Contains(claim, ".") ||
+			claim == "iss" && len(ja.IssuerWhitelist) > 0 || claim == "aud" && len(ja.AudienceWhitelist) > 0 {
+			continue
+		}
+		claims = append(claims, fmt.Sprintf(`%q:"conformance"`, claim))
+	}
+	if len(claims) == 0 {
+		claims = append(claims, `"sub":"conformance"`)
+	}
+	if len(ja.IssuerWhitelist) > 0 {
+		claims = append(claims, fmt.Sprintf(`"iss":%q`, ja.IssuerWhitelist[0]))
+	}
+	if len(ja.AudienceWhitelist) > 0 {
+		claims = append(claims, fmt.Sprintf(`"aud":%q`, ja.AudienceWhitelist[0]))
+	}
+	return strings.Join(claims, ",")
+}
+
+// conformanceConfig finds all the JWT providers in the given Caddy JSON
+// config, provisions and validates them, with the storage of the config,
+// and runs the vectors against them. The returned map is keyed by the JSON
+// path of each provider.
+func conformanceConfig(config []byte, now time.Time) (map[string][]conformanceResult, error) {
+	var root interface{}
+	if err := json.Unmarshal(config, &root); err != nil {
+		return nil, fmt.Errorf("decode config: %w", err)
+	}
+	ctx, cancel := caddy.NewContext(caddy.Context{Context: context.Background()})
+	defer cancel()
+	storage, err := configStorage(ctx, root)
+	if err != nil {
+		return nil, err
+	}
+
+	results := make(map[string][]conformanceResult)
+	var runErr error
+	walkProviders(root, "", func(path string, raw interface{}) {
+		if runErr != nil {
+			return
+		}
+		data, _ := json.Marshal(raw)
+		ja := &JWTAuth{storage: storage}
+		if err := json.Unmarshal(data, ja); err != nil {
+			runErr = fmt.Errorf("decode provider at %s: %w", path, err)
+			return
+		}
+		if err := ja.Provision(ctx); err != nil {
+			runErr = fmt.Errorf("provision provider at %s: %w", path, err)
+			return
+		}
+		ja.logger = zap.NewNop() // the rejections are the results
+		if err := ja.Validate(); err != nil {
+			runErr = fmt.Errorf("validate provider at %s: %w", path, err)
+			return
+		}
+		defer ja.Cleanup()
+		results[path] = ja.conformance(now)
+	})
+	return results, runErr
+}
+
+// configStorage returns the storage of the Caddy JSON config, as Caddy would
+// set up, see caddy.Config.StorageRaw.
+func configStorage(ctx caddy.Context, root interface{}) (certmagic.Storage, error) {
+	fields, _ := root.(map[string]interface{})
+	raw, ok := fields["storage"]
+	if !ok {
+		return caddy.DefaultStorage, nil
+	}
+	data, _ := json.Marshal(raw)
+	mod, err := loadInlineModule(ctx, "caddy.storage", "module", data)
+	if err != nil {
+		return nil, fmt.Errorf("loading storage module: %w", err)
+	}
+	converter, ok := mod.(caddy.StorageConverter)
+	if !ok {
+		return nil, fmt.Errorf("storage module %T is not a caddy.StorageConverter", mod)
+	}
+	return converter.CertMagicStorage()
+}
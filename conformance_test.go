@@ -0,0 +1,95 @@
+package caddyjwt
+
+import (
+	"fmt"
+	"strconv"
+	"testing"
+	"time"
+
+	_ "github.com/caddyserver/caddy/v2/modules/filestorage"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConformance(t *testing.T) {
+	ja := &JWTAuth{
+		SignKey:           TestSignKey,
+		IssuerWhitelist:   []string{"https://api.example.com"},
+		AudienceWhitelist: []string{"https://api.example.io"},
+		logger:            testLogger,
+	}
+	assert.Nil(t, ja.Validate())
+	for _, result := range ja.conformance(time.Now()) {
+		assert.True(t, result.Passed(), result.String())
+	}
+
+	// a lax provider
+	validateIat := false
+	ja = &JWTAuth{SignKey: TestSignKey, ValidateIat: &validateIat, logger: testLogger}
+	assert.Nil(t, ja.Validate())
+	failed := map[string]bool{}
+	for _, result := range ja.conformance(time.Now()) {
+		failed[result.Vector.Name] = !result.Passed()
+	}
+	assert.True(t, failed["future_iat"])
+	assert.False(t, failed["expired"])
+	assert.False(t, failed["valid"])
+
+	// not signable
+	ja = &JWTAuth{JWKURL: TestJWKSetURL, logger: testLogger}
+	assert.Nil(t, ja.Validate())
+	defer ja.Cleanup()
+	for _, result := range ja.conformance(time.Now()) {
+		assert.Equal(t, result.Vector.Signed, result.Skipped, result.Vector.Name)
+		if !result.Skipped {
+			assert.True(t, result.Passed(), result.String())
+		}
+	}
+}
+
+func TestConformanceConfig(t *testing.T) {
+	config := []byte(`{"apps": {"http": {"servers": {"srv0": {"routes": [{"handle": [{
+		"handler": "authentication",
+		"providers": {"jwt": {"sign_key": "` + TestSignKey + `", "user_claims": ["uid"]}}
+	}]}]}}}}}`)
+	results, err := conformanceConfig(config, time.Now())
+	assert.Nil(t, err)
+	assert.Len(t, results, 1)
+	for _, result := range results["/apps/http/servers/srv0/routes/0/handle/0/providers/jwt"] {
+		assert.True(t, result.Passed(), result.String())
+	}
+
+	// provisioned, with the storage of the config
+	config = []byte(`{
+		"storage": {"module": "file_system", "root": ` + strconv.Quote(t.TempDir()) + `},
+		"apps": {"http": {"servers": {"srv0": {"routes": [{"handle": [{
+			"handler": "authentication",
+			"providers": {"jwt": {"sign_key": "` + TestSignKey + `", "one_time_tokens": {}}}
+		}, {
+			"handler": "authentication",
+			"providers": {"jwt": {"jwk_url": "` + TestJWKSetURL + `", "shared_jwks": {}}}
+		}]}]}}}}}`)
+	results, err = conformanceConfig(config, time.Now())
+	assert.Nil(t, err)
+	assert.Len(t, results, 2)
+	for _, result := range results["/apps/http/servers/srv0/routes/0/handle/0/providers/jwt"] {
+		assert.True(t, result.Passed(), result.String())
+	}
+	for _, result := range results["/apps/http/servers/srv0/routes/0/handle/1/providers/jwt"] {
+		assert.True(t, result.Passed() || result.Skipped, result.String())
+	}
+}
+
+func TestConformance_Rejection(t *testing.T) {
+	var stringExp ConformanceVector
+	for _, v := range ConformanceVectors() {
+		if v.Name == "string_exp" {
+			stringExp = v
+		}
+	}
+	assert.Equal(t, ErrMalformedToken, stringExp.Rejection)
+
+	// rejected, but for the date not passed
+	assert.False(t, conformanceResult{Vector: stringExp, Err: ErrTokenExpired}.Passed())
+	assert.True(t, conformanceResult{Vector: stringExp, Err: fmt.Errorf("%w: %w", ErrInvalidToken, ErrMalformedToken)}.Passed())
+	assert.False(t, conformanceResult{Vector: stringExp}.Passed())
+}
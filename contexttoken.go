@@ -0,0 +1,79 @@
+package caddyjwt
+
+import (
+	"fmt"
+	"net/http"
+
+	"go.uber.org/zap"
+)
+
+// ContextToken requires a second token along with the access token, e.g. a
+// context JWT signed by a partner, carried in a custom header and verified
+// with its own keys and policies.
+type ContextToken struct {
+	// Header carrying the context token. Required.
+	Header string `json:"header"`
+
+	// Provider verifies the context token, e.g. its sign_key, jwk_url,
+	// issuer_whitelist, require, user_claims and meta_claims. The token is
+	// read from Header only, the from_* options of the provider are ignored.
+	Provider *JWTAuth `json:"provider"`
+
+	// MetaPrefix prefixes the metadata of the context token, i.e. the
+	// meta_claims of the Provider, in the metadata of the user. Defaults to
+	// "context.", e.g. {http.auth.user.context.tenant}.
+	MetaPrefix string `json:"meta_prefix"`
+
+	// AccessMetaPrefix prefixes the metadata of the access token. Defaults
+	// to "", i.e. as is.
+	AccessMetaPrefix string `json:"access_meta_prefix"`
+}
+
+func (ct *ContextToken) provision(logger *zap.Logger) error {
+	if ct.Header == "" {
+		return fmt.Errorf("missing header")
+	}
+	if ct.Provider == nil {
+		return fmt.Errorf("missing provider")
+	}
+	if ct.Provider.ContextToken != nil {
+		return fmt.Errorf("nested context_token")
+	}
+	if ct.MetaPrefix == "" {
+		ct.MetaPrefix = "context."
+	}
+	if ct.MetaPrefix == ct.AccessMetaPrefix {
+		return fmt.Errorf("meta_prefix and access_meta_prefix must differ")
+	}
+	p := ct.Provider
+	p.FromHeader, p.FromQuery, p.FromCookies, p.FromBody = []string{ct.Header}, nil, nil, nil
+	p.IssueSessionCookie = nil
+	p.contextOnly = true
+	p.logger = logger.Named("context_token")
+	return p.Validate()
+}
+
+// verifyContextToken verifies the context token of the request, and merges
+// the metadata of both tokens into the user of the result under the
+// prefixes.
+func (ja *JWTAuth) verifyContextToken(r *http.Request, logger *zap.Logger, result *authResult, dryRun bool) error {
+	ct := ja.ContextToken
+	if ct == nil {
+		return nil
+	}
+	ctxResult, _, err := ct.Provider.verifyCandidates(r, ct.Provider.candidateTokens(r), logger.Named("context_token"), dryRun)
+	if err != nil {
+		// not wrapped with %w, a missing context token isn't a missing token
+		return fmt.Errorf("%w: %v", ErrContextToken, err)
+	}
+
+	metadata := make(map[string]string, len(result.user.Metadata)+len(ctxResult.user.Metadata))
+	for key, value := range result.user.Metadata {
+		metadata[ct.AccessMetaPrefix+key] = value
+	}
+	for key, value := range ctxResult.user.Metadata {
+		metadata[ct.MetaPrefix+key] = value
+	}
+	result.user.Metadata = metadata
+	return nil
+}
@@ -0,0 +1,89 @@
+package caddyjwt
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAuthenticate_ContextToken(t *testing.T) {
+	ja := &JWTAuth{
+		SignKey:    TestSignKey,
+		MetaClaims: map[string]string{"role": "role"},
+		ContextToken: &ContextToken{
+			Header: "X-Context-Token",
+			Provider: &JWTAuth{
+				JWKURL:     TestJWKURL,
+				UserClaims: []string{"partner"},
+				MetaClaims: map[string]string{"tenant": "tenant"},
+				Require:    []ClaimRequirement{{Claim: "scope", Values: []string{"checkout"}}},
+			},
+			AccessMetaPrefix: "access.",
+		},
+		logger: testLogger,
+	}
+	assert.Nil(t, ja.Validate())
+
+	accessToken := issueTokenString(MapClaims{"sub": "ggicci", "role": "admin"})
+	authenticate := func(contextToken string) (User, error) {
+		r, _ := http.NewRequest("GET", "/", nil)
+		r.Header.Add("Authorization", accessToken)
+		if contextToken != "" {
+			r.Header.Add("X-Context-Token", contextToken)
+		}
+		user, _, err := ja.Authenticate(httptest.NewRecorder(), r)
+		return user, err
+	}
+
+	user, err := authenticate(issueTokenStringJWK(MapClaims{"partner": "acme", "tenant": "t-1", "scope": "checkout"}))
+	assert.Nil(t, err)
+	assert.Equal(t, User{
+		ID:       "ggicci",
+		Metadata: map[string]string{"access.role": "admin", "context.tenant": "t-1"},
+	}, user)
+
+	// missing
+	_, err = authenticate("")
+	assert.ErrorIs(t, err, ErrContextToken)
+	assert.NotErrorIs(t, err, ErrMissingToken)
+
+	// policy not satisfied
+	_, err = authenticate(issueTokenStringJWK(MapClaims{"partner": "acme", "scope": "refund"}))
+	assert.ErrorIs(t, err, ErrContextToken)
+
+	// signed by the keys of the access token
+	_, err = authenticate(issueTokenString(MapClaims{"partner": "acme", "scope": "checkout"}))
+	assert.ErrorIs(t, err, ErrContextToken)
+}
+
+func TestAuthenticate_ContextTokenNotFromAuthorization(t *testing.T) {
+	ja := &JWTAuth{
+		SignKey: TestSignKey,
+		ContextToken: &ContextToken{
+			Header:   "X-Context-Token",
+			Provider: &JWTAuth{SignKey: TestSignKey},
+		},
+		logger: testLogger,
+	}
+	assert.Nil(t, ja.Validate())
+
+	// the access token can't serve as the context token
+	r, _ := http.NewRequest("GET", "/", nil)
+	r.Header.Add("Authorization", issueTokenString(MapClaims{"sub": "ggicci"}))
+	_, _, err := ja.Authenticate(httptest.NewRecorder(), r)
+	assert.ErrorIs(t, err, ErrContextToken)
+}
+
+func TestValidate_ContextToken(t *testing.T) {
+	for _, ct := range []*ContextToken{
+		{Provider: &JWTAuth{SignKey: TestSignKey}},
+		{Header: "X-Context-Token"},
+		{Header: "X-Context-Token", Provider: &JWTAuth{SignKey: TestSignKey}, AccessMetaPrefix: "context."},
+		{Header: "X-Context-Token", Provider: &JWTAuth{}},
+	} {
+		ja := &JWTAuth{SignKey: TestSignKey, ContextToken: ct, logger: testLogger}
+		assert.ErrorContains(t, ja.Validate(), "invalid context_token")
+	}
+}
@@ -0,0 +1,86 @@
+package caddyjwt
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/lestrrat-go/jwx/v2/jwe"
+)
+
+// loadDecryptKey parses DecryptKey, or the content of DecryptKeyFile, into
+// the key to decrypt the JWE tokens, nil if neither is set.
+func (ja *JWTAuth) loadDecryptKey() (interface{}, error) {
+	if ja.DecryptKey != "" && ja.DecryptKeyFile != "" {
+		return nil, fmt.Errorf("decrypt_key and decrypt_key_file are mutually exclusive")
+	}
+	key := ja.DecryptKey
+	if ja.DecryptKeyFile != "" {
+		content, err := os.ReadFile(ja.DecryptKeyFile)
+		if err != nil {
+			return nil, err
+		}
+		key = string(content)
+	}
+	if key == "" {
+		return nil, nil
+	}
+	return parseDecryptKey(key)
+}
+
+// parseDecryptKey parses a private key in PEM format (PKCS#8, PKCS#1 or
+// SEC 1), or a symmetric key in base64 format.
+func parseDecryptKey(key string) (interface{}, error) {
+	if !strings.Contains(key, "-----BEGIN") {
+		return base64.StdEncoding.DecodeString(strings.TrimSpace(key))
+	}
+	block, _ := pem.Decode([]byte(key))
+	if block == nil {
+		return nil, fmt.Errorf("invalid PEM-formatted private key")
+	}
+	switch block.Type {
+	case "PRIVATE KEY":
+		return x509.ParsePKCS8PrivateKey(block.Bytes)
+	case "RSA PRIVATE KEY":
+		return x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "EC PRIVATE KEY":
+		return x509.ParseECPrivateKey(block.Bytes)
+	}
+	return nil, fmt.Errorf("unsupported PEM block type %q", block.Type)
+}
+
+// isEncryptedToken tells whether the token is a JWE in compact
+// serialization, which has five parts.
+func isEncryptedToken(token string) bool {
+	return strings.Count(token, ".") == 4
+}
+
+// decryptToken decrypts the JWE token with the decrypt key, using the key
+// management algorithm in its header, and returns the nested token. The
+// payload of "zip": "DEF" is inflated by jwx with no bound of its own, so
+// such a token must not exceed MaxDecompressedSize either before or after
+// the inflation.
+func (ja *JWTAuth) decryptToken(token string) (string, error) {
+	max := ja.maxDecompressedSize()
+	zipped := compressed(token)
+	if zipped && len(token) > max {
+		return "", fmt.Errorf("compressed token of %d bytes exceeds %d bytes", len(token), max)
+	}
+	plaintext, err := jwe.Decrypt([]byte(token), jwe.WithKeyProvider(jwe.KeyProviderFunc(
+		func(_ context.Context, sink jwe.KeySink, r jwe.Recipient, _ *jwe.Message) error {
+			sink.Key(r.Headers().Algorithm(), ja.parsedDecryptKey)
+			return nil
+		},
+	)))
+	if err != nil {
+		return "", err
+	}
+	if zipped && len(plaintext) > max {
+		return "", fmt.Errorf("payload exceeds %d bytes decompressed", max)
+	}
+	return string(plaintext), nil
+}
@@ -0,0 +1,178 @@
+package caddyjwt
+
+import (
+	"crypto"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/lestrrat-go/jwx/v2/jwa"
+	"github.com/lestrrat-go/jwx/v2/jws"
+	"github.com/lestrrat-go/jwx/v2/jwt"
+)
+
+// dpopProofType is the "typ" header of the DPoP proofs.
+const dpopProofType = "dpop+jwt"
+
+// DPoP verifies the DPoP proofs (RFC 9449) of the sender-constrained access
+// tokens, i.e. of a "cnf" claim with a "jkt" member, the SHA-256 thumbprint
+// of the key of the client. Such a token is only accepted along with a
+// proof in the DPoP header, signed by that key, of the method and the URL of
+// the request and of the token itself, e.g. "Authorization: DPoP <token>".
+// A proof is accepted once within MaxAge, the replays are rejected.
+//
+// The URL of the request is the one Caddy sees, i.e. a proxy in front of
+// Caddy must preserve the scheme, the host and the path.
+type DPoP struct {
+	// Required rejects the tokens not bound to a key, i.e. without
+	// "cnf.jkt". By default, they are accepted as bearer tokens.
+	Required bool `json:"required,omitempty"`
+
+	// MaxAge bounds how far the "iat" of the proofs may be from now, which
+	// is also how long the "jti" of the proofs are remembered to reject the
+	// replays. Defaults to 5m.
+	MaxAge caddy.Duration `json:"max_age,omitempty"`
+
+	// MaxEntries bounds the number of the proofs remembered. Defaults to
+	// 10000. A proof is never forgotten before its "iat" is out of MaxAge,
+	// the new proofs are rejected with ErrReplayUnavailable instead while
+	// there are MaxEntries.
+	MaxEntries int `json:"max_entries,omitempty"`
+
+	mu   sync.Mutex // makes checking and remembering a proof atomic
+	seen *ttlCache  // "<jkt>|<jti>" of the proofs accepted
+}
+
+func (d *DPoP) provision() error {
+	if d.MaxAge < 0 {
+		return fmt.Errorf("invalid max_age: %s", time.Duration(d.MaxAge))
+	}
+	if d.MaxEntries < 0 {
+		return fmt.Errorf("invalid max_entries: %d", d.MaxEntries)
+	}
+	if d.MaxAge == 0 {
+		d.MaxAge = caddy.Duration(5 * time.Minute)
+	}
+	d.seen = newTTLCache(d.MaxEntries, 0)
+	return nil
+}
+
+// check verifies the DPoP proof of the request for the token, if bound to a
+// key. raw is the access token as presented. If dryRun, the proof is not
+// remembered, so it's accepted once more.
+func (d *DPoP) check(r *http.Request, token Token, raw string, dryRun bool) error {
+	val, bound := getClaim(token, "cnf.jkt")
+	if !bound {
+		if d.Required {
+			return fmt.Errorf("%w: token not bound to a key", ErrDPoPInvalid)
+		}
+		return nil
+	}
+	jkt, _ := val.(string)
+	proofs := r.Header.Values("DPoP")
+	if len(proofs) != 1 {
+		return fmt.Errorf("%w: expect one DPoP proof, got %d", ErrDPoPInvalid, len(proofs))
+	}
+	proof, thumbprint, err := parseDPoPProof(proofs[0])
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrDPoPInvalid, err)
+	}
+	if thumbprint != jkt {
+		return fmt.Errorf("%w: proof signed by a key other than cnf.jkt", ErrDPoPInvalid)
+	}
+
+	htm, _ := getClaim(proof, "htm")
+	if htm != r.Method {
+		return fmt.Errorf("%w: htm %v mismatches the method %s", ErrDPoPInvalid, htm, r.Method)
+	}
+	htu, _ := getClaim(proof, "htu")
+	if s, _ := htu.(string); !matchHTU(s, r) {
+		return fmt.Errorf("%w: htu %v mismatches the request URL", ErrDPoPInvalid, htu)
+	}
+	sum := sha256.Sum256([]byte(raw))
+	if ath, _ := getClaim(proof, "ath"); ath != base64.RawURLEncoding.EncodeToString(sum[:]) {
+		return fmt.Errorf("%w: ath mismatches the token", ErrDPoPInvalid)
+	}
+	maxAge := time.Duration(d.MaxAge)
+	if iat := proof.IssuedAt(); iat.IsZero() || time.Since(iat) > maxAge || time.Until(iat) > maxAge {
+		return fmt.Errorf("%w: iat missing or out of %s", ErrDPoPInvalid, maxAge)
+	}
+	jti := proof.JwtID()
+	if jti == "" {
+		return fmt.Errorf("%w: missing jti", ErrDPoPInvalid)
+	}
+
+	key := jkt + "|" + jti
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if _, replayed := d.seen.Get(key); replayed {
+		return fmt.Errorf("%w: proof %q replayed", ErrDPoPInvalid, jti)
+	}
+	if dryRun {
+		return nil
+	}
+	// remembered until its "iat" is out of MaxAge either way
+	if !d.seen.TrySet(key, struct{}{}, 2*maxAge) {
+		return fmt.Errorf("%w: full of %d unexpired DPoP proofs", ErrReplayUnavailable, d.seen.Len())
+	}
+	return nil
+}
+
+// parseDPoPProof verifies the signature of the proof by the public key in
+// its header and returns its claims and the base64url encoded SHA-256
+// thumbprint of the key.
+func parseDPoPProof(proof string) (Token, string, error) {
+	msg, err := jws.ParseString(proof)
+	if err != nil {
+		return nil, "", err
+	}
+	if len(msg.Signatures()) != 1 {
+		return nil, "", fmt.Errorf("expect one signature")
+	}
+	headers := msg.Signatures()[0].ProtectedHeaders()
+	if headers.Type() != dpopProofType {
+		return nil, "", fmt.Errorf("typ %q, expect %q", headers.Type(), dpopProofType)
+	}
+	alg := headers.Algorithm()
+	if alg == jwa.NoSignature || strings.HasPrefix(alg.String(), "HS") {
+		return nil, "", fmt.Errorf("alg %q not asymmetric", alg)
+	}
+	key := headers.JWK()
+	if key == nil || key.KeyType() == jwa.OctetSeq {
+		return nil, "", fmt.Errorf("missing public key in jwk")
+	}
+	if _, private := key.Get("d"); private {
+		return nil, "", fmt.Errorf("private key in jwk")
+	}
+	token, err := jwt.ParseString(proof, jwt.WithKey(alg, key), jwt.WithValidate(false))
+	if err != nil {
+		return nil, "", err
+	}
+	thumbprint, err := key.Thumbprint(crypto.SHA256)
+	if err != nil {
+		return nil, "", err
+	}
+	return token, base64.RawURLEncoding.EncodeToString(thumbprint), nil
+}
+
+// matchHTU reports whether the "htu" of a proof is the URL of the request,
+// ignoring the query and the fragment, see RFC 9449 4.3.
+func matchHTU(htu string, r *http.Request) bool {
+	u, err := url.Parse(htu)
+	if err != nil {
+		return false
+	}
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	return strings.EqualFold(u.Scheme, scheme) &&
+		strings.EqualFold(u.Host, r.Host) &&
+		u.EscapedPath() == r.URL.EscapedPath()
+}
@@ -0,0 +1,128 @@
+package caddyjwt
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/lestrrat-go/jwx/v2/jwa"
+	"github.com/lestrrat-go/jwx/v2/jwk"
+	"github.com/lestrrat-go/jwx/v2/jws"
+	"github.com/lestrrat-go/jwx/v2/jwt"
+	"github.com/stretchr/testify/assert"
+)
+
+// issueDPoPProof issues a DPoP proof of the request and the access token,
+// signed by the private key.
+func issueDPoPProof(privateKey *ecdsa.PrivateKey, method, htu, accessToken, jti string, iat time.Time) string {
+	publicKey, err := jwk.FromRaw(privateKey.Public())
+	panicOnError(err)
+	headers := jws.NewHeaders()
+	headers.Set(jws.TypeKey, "dpop+jwt")
+	headers.Set(jws.JWKKey, publicKey)
+	sum := sha256.Sum256([]byte(accessToken))
+	proof, err := jwt.NewBuilder().
+		JwtID(jti).
+		IssuedAt(iat).
+		Claim("htm", method).
+		Claim("htu", htu).
+		Claim("ath", base64.RawURLEncoding.EncodeToString(sum[:])).
+		Build()
+	panicOnError(err)
+	signed, err := jwt.Sign(proof, jwt.WithKey(jwa.ES256, privateKey, jws.WithProtectedHeaders(headers)))
+	panicOnError(err)
+	return string(signed)
+}
+
+func TestAuthenticate_DPoP(t *testing.T) {
+	ja := &JWTAuth{SignKey: TestSignKey, DPoP: &DPoP{}, logger: testLogger}
+	assert.Nil(t, ja.Validate())
+
+	clientKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.Nil(t, err)
+	publicKey, _ := jwk.FromRaw(clientKey.Public())
+	thumbprint, _ := publicKey.Thumbprint(crypto.SHA256)
+	jkt := base64.RawURLEncoding.EncodeToString(thumbprint)
+	otherKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+
+	bound := issueTokenString(MapClaims{"sub": "ggicci", "cnf": map[string]interface{}{"jkt": jkt}})
+	authenticate := func(method, token string, proofs ...string) error {
+		r, _ := http.NewRequest(method, "http://api.example.com/orders?page=2", nil)
+		r.Header.Set("Authorization", "DPoP "+token)
+		for _, proof := range proofs {
+			r.Header.Add("DPoP", proof)
+		}
+		_, _, err := ja.Authenticate(httptest.NewRecorder(), r)
+		return err
+	}
+	now := time.Now()
+	const htu = "http://api.example.com/orders"
+
+	assert.Nil(t, authenticate("POST", bound, issueDPoPProof(clientKey, "POST", htu, bound, "p1", now)))
+	// replayed
+	assert.ErrorIs(t, authenticate("POST", bound, issueDPoPProof(clientKey, "POST", htu, bound, "p1", now)), ErrDPoPInvalid)
+
+	for name, proofs := range map[string][]string{
+		"missing":      nil,
+		"two proofs":   {issueDPoPProof(clientKey, "POST", htu, bound, "p2", now), issueDPoPProof(clientKey, "POST", htu, bound, "p3", now)},
+		"other key":    {issueDPoPProof(otherKey, "POST", htu, bound, "p4", now)},
+		"other method": {issueDPoPProof(clientKey, "GET", htu, bound, "p5", now)},
+		"other url":    {issueDPoPProof(clientKey, "POST", "https://api.example.com/orders", bound, "p6", now)},
+		"other token":  {issueDPoPProof(clientKey, "POST", htu, issueTokenString(MapClaims{"sub": "ggicci"}), "p7", now)},
+		"stale":        {issueDPoPProof(clientKey, "POST", htu, bound, "p8", now.Add(-time.Hour))},
+		"missing jti":  {issueDPoPProof(clientKey, "POST", htu, bound, "", now)},
+		"not a proof":  {issueTokenStringJWK(MapClaims{"htm": "POST", "htu": htu})},
+		"malformed":    {"not.a.proof"},
+	} {
+		err := authenticate("POST", bound, proofs...)
+		assert.ErrorIs(t, err, ErrDPoPInvalid, name)
+		assert.Equal(t, "dpop_invalid", failureReason(err), name)
+	}
+
+	// bearer tokens
+	bearer := issueTokenString(MapClaims{"sub": "ggicci"})
+	assert.Nil(t, authenticate("GET", bearer))
+	ja.DPoP.Required = true
+	assert.ErrorIs(t, authenticate("GET", bearer), ErrDPoPInvalid)
+}
+
+func TestAuthenticate_DPoPFull(t *testing.T) {
+	ja := &JWTAuth{SignKey: TestSignKey, DPoP: &DPoP{MaxEntries: 2}, logger: testLogger}
+	assert.Nil(t, ja.Validate())
+
+	clientKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	publicKey, _ := jwk.FromRaw(clientKey.Public())
+	thumbprint, _ := publicKey.Thumbprint(crypto.SHA256)
+	bound := issueTokenString(MapClaims{"sub": "ggicci", "cnf": map[string]interface{}{"jkt": base64.RawURLEncoding.EncodeToString(thumbprint)}})
+	const htu = "http://api.example.com/orders"
+	authenticate := func(jti string) error {
+		r, _ := http.NewRequest("POST", htu, nil)
+		r.Header.Set("Authorization", "DPoP "+bound)
+		r.Header.Set("DPoP", issueDPoPProof(clientKey, "POST", htu, bound, jti, time.Now()))
+		_, _, err := ja.Authenticate(httptest.NewRecorder(), r)
+		return err
+	}
+
+	assert.Nil(t, authenticate("p1"))
+	assert.Nil(t, authenticate("p2"))
+	// the live proofs are never forgotten to make room
+	assert.ErrorIs(t, authenticate("p3"), ErrReplayUnavailable)
+	assert.ErrorIs(t, authenticate("p1"), ErrDPoPInvalid)
+	assert.ErrorIs(t, authenticate("p2"), ErrDPoPInvalid)
+}
+
+func TestValidate_DPoP(t *testing.T) {
+	ja := &JWTAuth{SignKey: TestSignKey, DPoP: &DPoP{MaxEntries: -1}}
+	assert.ErrorContains(t, ja.Validate(), "invalid dpop")
+
+	ja = &JWTAuth{SignKey: TestSignKey, DPoP: &DPoP{}}
+	assert.Nil(t, ja.Validate())
+	assert.Equal(t, 5*time.Minute, time.Duration(ja.DPoP.MaxAge))
+}
@@ -0,0 +1,329 @@
+package caddyjwt
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+	"go.uber.org/zap"
+	"golang.org/x/sync/singleflight"
+)
+
+// maxEnrichResponseSize limits the size of the response of the enrichment
+// endpoint.
+const maxEnrichResponseSize = 1 << 20
+
+// Enrichment calls an external HTTP endpoint with the ID of the authenticated
+// user, and merges the returned attributes into the user metadata, i.e. the
+// {http.auth.user.*} placeholders. It's useful when the attributes, e.g.
+// entitlements, are too large or too dynamic to embed in the tokens.
+//
+// The endpoint will be called with a GET request and must respond with a
+// JSON object.
+type Enrichment struct {
+	// URL is the URL of the enrichment endpoint. The "{id}" in the URL will
+	// be replaced with the (URL-escaped) ID of the user. If "{id}" is
+	// absent, the ID will be sent as the query parameter "sub".
+	URL string `json:"url"`
+
+	// Attributes defines a map to populate the metadata from the fields of
+	// the response, the same as MetaClaims. Nested fields can be accessed by
+	// dot notation. If empty, all the top-level fields will be populated
+	// under their own names.
+	Attributes map[string]string `json:"attributes"`
+
+	// Timeout is the timeout of each call. Defaults to 2s.
+	Timeout caddy.Duration `json:"timeout"`
+
+	// CacheTTL is how long the attributes of a user will be cached.
+	// Defaults to 1m.
+	CacheTTL caddy.Duration `json:"cache_ttl"`
+
+	// CacheMaxBytes bounds the approximate bytes taken by the cache of the
+	// attributes. Defaults to 0, bounded by the number of entries only.
+	CacheMaxBytes int `json:"cache_max_bytes"`
+
+	// Volatile lists the metadata keys, i.e. the placeholders, which are
+	// never cached, e.g. the fast-changing entitlements. If any, the
+	// endpoint is called on every request.
+	Volatile []string `json:"volatile"`
+
+	// Stable lists the metadata keys cached until the token expires rather
+	// than for CacheTTL, e.g. the static profile data.
+	Stable []string `json:"stable"`
+
+	// FailureThreshold is the number of consecutive failures which opens the
+	// circuit breaker, i.e. stops calling the endpoint for Cooldown.
+	// Defaults to 5.
+	FailureThreshold int `json:"failure_threshold"`
+
+	// Cooldown is how long the circuit breaker stays open. Defaults to 30s.
+	Cooldown caddy.Duration `json:"cooldown"`
+
+	// Required makes the authentication fail if the enrichment fails.
+	// By default, the user will be authenticated without the attributes.
+	Required bool `json:"required"`
+
+	client  *http.Client
+	cache   *ttlCache
+	group   singleflight.Group // deduplicates concurrent calls for a user
+	breaker *circuitBreaker
+}
+
+func (e *Enrichment) provision() error {
+	if e.URL == "" {
+		return fmt.Errorf("missing url")
+	}
+	if _, err := url.Parse(strings.ReplaceAll(e.URL, "{id}", "id")); err != nil {
+		return fmt.Errorf("invalid url: %w", err)
+	}
+	for field, placeholder := range e.Attributes {
+		if field == "" || placeholder == "" {
+			return fmt.Errorf("invalid attribute: %s -> %s", field, placeholder)
+		}
+	}
+	if e.Timeout == 0 {
+		e.Timeout = caddy.Duration(2 * time.Second)
+	}
+	if e.CacheTTL == 0 {
+		e.CacheTTL = caddy.Duration(time.Minute)
+	}
+	if e.CacheMaxBytes < 0 {
+		return fmt.Errorf("invalid cache_max_bytes: %d", e.CacheMaxBytes)
+	}
+	for _, key := range e.Stable {
+		for _, volatile := range e.Volatile {
+			if key == volatile {
+				return fmt.Errorf("%q is both volatile and stable", key)
+			}
+		}
+	}
+	if e.FailureThreshold <= 0 {
+		e.FailureThreshold = 5
+	}
+	if e.Cooldown == 0 {
+		e.Cooldown = caddy.Duration(30 * time.Second)
+	}
+	e.client = &http.Client{Timeout: time.Duration(e.Timeout)}
+	e.cache = newTTLCache(0, e.CacheMaxBytes)
+	registerSubjectCache(e.cache, func(key, sub string) bool { return key == sub || key == sub+stableCacheSuffix })
+	e.breaker = &circuitBreaker{threshold: e.FailureThreshold, cooldown: time.Duration(e.Cooldown)}
+	return nil
+}
+
+// enrichUser merges the attributes of the user into the user metadata.
+func (ja *JWTAuth) enrichUser(ctx context.Context, logger *zap.Logger, result *authResult) error {
+	e := ja.Enrich
+	if e == nil {
+		return nil
+	}
+	user := &result.user
+	attrs, err := e.attributes(ctx, user.ID, result.token.Expiration())
+	if err != nil {
+		logger.Error("enrichment failed", zap.String("id", user.ID), zap.Error(err))
+		if e.Required {
+			return fmt.Errorf("%w: %v", ErrEnrichmentFailed, err)
+		}
+		return nil
+	}
+	if user.Metadata == nil {
+		user.Metadata = make(map[string]string)
+	}
+	for placeholder, value := range attrs {
+		user.Metadata[placeholder] = value
+	}
+	return nil
+}
+
+func (e *Enrichment) cleanup() {
+	unregisterSubjectCache(e.cache)
+}
+
+// stableCacheSuffix suffixes the cache keys of the Stable attributes.
+const stableCacheSuffix = "|stable"
+
+// attributes returns the metadata of the user, from the cache if possible.
+// Concurrent cache misses of the same user share one call. The Stable
+// attributes are cached until exp, the expiry of the token, if any.
+func (e *Enrichment) attributes(ctx context.Context, id string, exp time.Time) (map[string]string, error) {
+	if cached, ok := e.cached(id); ok {
+		return cached, nil
+	}
+	v, err, _ := e.group.Do(id, func() (interface{}, error) {
+		if cached, ok := e.cached(id); ok {
+			return cached, nil
+		}
+		if !e.breaker.allow() {
+			return nil, ErrCircuitOpen
+		}
+		attrs, err := e.fetch(ctx, id)
+		e.breaker.record(err == nil)
+		if err != nil {
+			return nil, err
+		}
+		e.store(id, attrs, exp)
+		return attrs, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return v.(map[string]string), nil
+}
+
+// cached returns the attributes of the user if all of them are cached, i.e.
+// none is Volatile.
+func (e *Enrichment) cached(id string) (map[string]string, bool) {
+	if len(e.Volatile) > 0 {
+		return nil, false
+	}
+	cached, ok := e.cache.Get(id)
+	if !ok {
+		return nil, false
+	}
+	if len(e.Stable) == 0 {
+		return cached.(map[string]string), true
+	}
+	stable, ok := e.cache.Get(id + stableCacheSuffix)
+	if !ok {
+		return nil, false
+	}
+	attrs := make(map[string]string)
+	for _, m := range []interface{}{cached, stable} {
+		for key, value := range m.(map[string]string) {
+			attrs[key] = value
+		}
+	}
+	return attrs, true
+}
+
+// store caches the attributes of the user but the Volatile ones, and the
+// Stable ones until exp, or for CacheTTL if the token never expires.
+func (e *Enrichment) store(id string, attrs map[string]string, exp time.Time) {
+	if len(e.Volatile) == 0 && len(e.Stable) == 0 {
+		e.cache.Set(id, attrs, time.Duration(e.CacheTTL))
+		return
+	}
+	class := make(map[string]int, len(e.Volatile)+len(e.Stable))
+	for _, key := range e.Volatile {
+		class[key] = -1
+	}
+	for _, key := range e.Stable {
+		class[key] = 1
+	}
+	normal, stable := make(map[string]string), make(map[string]string)
+	for key, value := range attrs {
+		switch class[key] {
+		case 0:
+			normal[key] = value
+		case 1:
+			stable[key] = value
+		}
+	}
+	e.cache.Set(id, normal, time.Duration(e.CacheTTL))
+	if len(e.Stable) > 0 {
+		ttl := time.Duration(e.CacheTTL)
+		if !exp.IsZero() {
+			ttl = time.Until(exp)
+		}
+		if ttl > 0 {
+			e.cache.Set(id+stableCacheSuffix, stable, ttl)
+		}
+	}
+}
+
+func (e *Enrichment) fetch(ctx context.Context, id string) (map[string]string, error) {
+	target := e.URL
+	if strings.Contains(target, "{id}") {
+		target = strings.ReplaceAll(target, "{id}", url.PathEscape(id))
+	} else {
+		u, _ := url.Parse(target) // validated at provision
+		query := u.Query()
+		query.Set("sub", id)
+		u.RawQuery = query.Encode()
+		target = u.String()
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, target, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status: %s", resp.Status)
+	}
+
+	var fields map[string]interface{}
+	decoder := json.NewDecoder(io.LimitReader(resp.Body, maxEnrichResponseSize))
+	decoder.UseNumber()
+	if err := decoder.Decode(&fields); err != nil {
+		return nil, fmt.Errorf("decode response: %w", err)
+	}
+
+	attrs := make(map[string]string)
+	if len(e.Attributes) == 0 {
+		for field, val := range fields {
+			attrs[field] = stringify(val)
+		}
+		return attrs, nil
+	}
+	for field, placeholder := range e.Attributes {
+		val, ok := fields[field]
+		if !ok && strings.Contains(field, ".") {
+			val, _ = queryNested(fields, strings.Split(field, "."))
+		}
+		attrs[placeholder] = stringify(val)
+	}
+	return attrs, nil
+}
+
+// circuitBreaker stops calling a failing endpoint for a cooldown period
+// after a number of consecutive failures. After the cooldown, one call is
+// allowed to probe the endpoint (half-open).
+type circuitBreaker struct {
+	threshold int
+	cooldown  time.Duration
+
+	mu        sync.Mutex
+	failures  int
+	openUntil time.Time
+	probing   bool
+}
+
+func (cb *circuitBreaker) allow() bool {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	if cb.failures < cb.threshold {
+		return true
+	}
+	if time.Now().Before(cb.openUntil) || cb.probing {
+		return false
+	}
+	cb.probing = true
+	return true
+}
+
+func (cb *circuitBreaker) record(success bool) {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	cb.probing = false
+	if success {
+		cb.failures = 0
+		return
+	}
+	cb.failures++
+	if cb.failures >= cb.threshold {
+		cb.openUntil = time.Now().Add(cb.cooldown)
+	}
+}
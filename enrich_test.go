@@ -0,0 +1,270 @@
+package caddyjwt
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAuthenticate_Enrich(t *testing.T) {
+	var calls int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		if r.URL.Path != "/users/ggicci" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"plan":    "pro",
+			"billing": map[string]interface{}{"seats": 5},
+		})
+	}))
+	defer server.Close()
+
+	ja := &JWTAuth{
+		SignKey:    TestSignKey,
+		MetaClaims: map[string]string{"IsAdmin": "is_admin"},
+		Enrich: &Enrichment{
+			URL:        server.URL + "/users/{id}",
+			Attributes: map[string]string{"plan": "plan", "billing.seats": "seats"},
+		},
+		logger: testLogger,
+	}
+	assert.Nil(t, ja.Validate())
+
+	for i := 0; i < 2; i++ {
+		r, _ := http.NewRequest("GET", "/", nil)
+		r.Header.Add("Authorization", issueTokenString(MapClaims{"sub": "ggicci", "IsAdmin": true}))
+		gotUser, authenticated, err := ja.Authenticate(httptest.NewRecorder(), r)
+		assert.Nil(t, err)
+		assert.True(t, authenticated)
+		assert.Equal(t, map[string]string{"is_admin": "true", "plan": "pro", "seats": "5"}, gotUser.Metadata)
+	}
+	assert.Equal(t, int32(1), atomic.LoadInt32(&calls)) // cached
+
+	// enrichment failure doesn't fail the authentication by default
+	r, _ := http.NewRequest("GET", "/", nil)
+	r.Header.Add("Authorization", issueTokenString(MapClaims{"sub": "eva"}))
+	gotUser, authenticated, err := ja.Authenticate(httptest.NewRecorder(), r)
+	assert.Nil(t, err)
+	assert.True(t, authenticated)
+	assert.Equal(t, "eva", gotUser.ID)
+
+	// unless required
+	ja.Enrich.Required = true
+	r, _ = http.NewRequest("GET", "/", nil)
+	r.Header.Add("Authorization", issueTokenString(MapClaims{"sub": "eva"}))
+	_, authenticated, err = ja.Authenticate(httptest.NewRecorder(), r)
+	assert.ErrorIs(t, err, ErrEnrichmentFailed)
+	assert.False(t, authenticated)
+}
+
+func TestEnrichment_Provision(t *testing.T) {
+	e := &Enrichment{}
+	assert.ErrorContains(t, e.provision(), "missing url")
+
+	e = &Enrichment{URL: "https://example.com"}
+	assert.Nil(t, e.provision())
+	assert.Equal(t, 2*time.Second, time.Duration(e.Timeout))
+	assert.Equal(t, 5, e.FailureThreshold)
+}
+
+func TestCircuitBreaker(t *testing.T) {
+	cb := &circuitBreaker{threshold: 2, cooldown: 50 * time.Millisecond}
+	assert.True(t, cb.allow())
+	cb.record(false)
+	assert.True(t, cb.allow())
+	cb.record(false)
+	assert.False(t, cb.allow()) // open
+
+	time.Sleep(60 * time.Millisecond)
+	assert.True(t, cb.allow()) // half-open, probing
+	assert.False(t, cb.allow())
+	cb.record(true)
+	assert.True(t, cb.allow()) // closed
+}
+
+func TestTTLCache(t *testing.T) {
+	now := time.Now()
+	c := newTTLCache(2, 0)
+	c.now = func() time.Time { return now }
+
+	c.Set("a", 1, time.Minute)
+	c.Set("b", 2, time.Second)
+	v, ok := c.Get("a")
+	assert.True(t, ok)
+	assert.Equal(t, 1, v)
+
+	now = now.Add(2 * time.Second)
+	_, ok = c.Get("b")
+	assert.False(t, ok) // expired
+
+	c.Set("b", 2, time.Minute)
+	c.Set("c", 3, time.Minute) // evicts one
+	assert.Equal(t, 2, c.Len())
+
+	c.Delete("c")
+	_, ok = c.Get("c")
+	assert.False(t, ok)
+}
+
+func TestTTLCache_LRU(t *testing.T) {
+	now := time.Now()
+	c := newTTLCache(3, 0)
+	c.now = func() time.Time { return now }
+	c.pressure = func() bool { return false }
+
+	c.Set("a", 1, time.Minute)
+	c.Set("b", 2, time.Minute)
+	c.Set("c", 3, time.Second)
+	c.Get("a") // b is the least recently used
+
+	c.Set("d", 4, time.Minute) // evicts b
+	_, ok := c.Get("b")
+	assert.False(t, ok)
+	_, ok = c.Get("a")
+	assert.True(t, ok)
+
+	now = now.Add(2 * time.Second)
+	c.Set("e", 5, time.Minute) // evicts the expired c rather than the LRU d
+	_, ok = c.Get("d")
+	assert.True(t, ok)
+	_, ok = c.Get("c")
+	assert.False(t, ok)
+	assert.Equal(t, 3, c.Len())
+}
+
+func TestEnrichment_Singleflight(t *testing.T) {
+	var calls int32
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		<-release
+		json.NewEncoder(w).Encode(map[string]string{"plan": "pro"})
+	}))
+	defer server.Close()
+
+	e := &Enrichment{URL: server.URL}
+	assert.Nil(t, e.provision())
+	defer e.cleanup()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			attrs, err := e.attributes(context.Background(), "ggicci", time.Time{})
+			assert.Nil(t, err)
+			assert.Equal(t, "pro", attrs["plan"])
+		}()
+	}
+	time.Sleep(100 * time.Millisecond)
+	close(release)
+	wg.Wait()
+	assert.Equal(t, int32(1), atomic.LoadInt32(&calls))
+}
+
+func TestEnrichment_VolatileAndStable(t *testing.T) {
+	var calls int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := atomic.AddInt32(&calls, 1)
+		json.NewEncoder(w).Encode(map[string]interface{}{"plan": "pro", "seats": n, "name": "Ggicci"})
+	}))
+	defer server.Close()
+
+	e := &Enrichment{URL: server.URL, Stable: []string{"name"}}
+	assert.Nil(t, e.provision())
+	defer e.cleanup()
+
+	exp := time.Now().Add(time.Hour)
+	attrs, err := e.attributes(context.Background(), "ggicci", exp)
+	assert.Nil(t, err)
+	assert.Equal(t, "1", attrs["seats"])
+	attrs, err = e.attributes(context.Background(), "ggicci", exp)
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]string{"plan": "pro", "seats": "1", "name": "Ggicci"}, attrs)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&calls))
+
+	// the stable attributes outlive the others
+	e.cache.Delete("ggicci")
+	_, ok := e.cache.Get("ggicci" + stableCacheSuffix)
+	assert.True(t, ok)
+
+	// the volatile attributes are never cached
+	e = &Enrichment{URL: server.URL, Volatile: []string{"seats"}}
+	assert.Nil(t, e.provision())
+	defer e.cleanup()
+	attrs, err = e.attributes(context.Background(), "ggicci", exp)
+	assert.Nil(t, err)
+	assert.Equal(t, "2", attrs["seats"])
+	attrs, err = e.attributes(context.Background(), "ggicci", exp)
+	assert.Nil(t, err)
+	assert.Equal(t, "3", attrs["seats"])
+	cached, _ := e.cache.Get("ggicci")
+	assert.NotContains(t, cached, "seats")
+
+	e = &Enrichment{URL: server.URL, Volatile: []string{"seats"}, Stable: []string{"seats"}}
+	assert.Error(t, e.provision())
+}
+
+func TestAdminAPI_PurgeCache(t *testing.T) {
+	e := &Enrichment{URL: "https://entitlements.example.com"}
+	assert.Nil(t, e.provision())
+	defer e.cleanup()
+	u := &UserInfoEnrichment{Claims: map[string]string{"email": "email"}}
+	assert.Nil(t, u.provision())
+	defer u.cleanup()
+	purgeSubjectCaches("") // left by the other tests
+	e.cache.Set("ggicci", map[string]string{}, time.Minute)
+	e.cache.Set("alice", map[string]string{}, time.Minute)
+	u.cache.Set("https://api.example.com|ggicci", map[string]string{}, time.Minute)
+
+	api := adminAPI{}
+	rw := httptest.NewRecorder()
+	assert.Error(t, api.handlePurgeCache(rw, httptest.NewRequest("GET", "/jwtauth/cache/purge", nil)))
+
+	rw = httptest.NewRecorder()
+	assert.Nil(t, api.handlePurgeCache(rw, httptest.NewRequest("POST", "/jwtauth/cache/purge?sub=ggicci", nil)))
+	assert.JSONEq(t, `{"purged": 2}`, rw.Body.String())
+	assert.Equal(t, 1, e.cache.Len())
+	assert.Equal(t, 0, u.cache.Len())
+
+	rw = httptest.NewRecorder()
+	assert.Nil(t, api.handlePurgeCache(rw, httptest.NewRequest("POST", "/jwtauth/cache/purge", nil)))
+	assert.Equal(t, 0, e.cache.Len())
+}
+
+func TestTTLCache_MaxBytes(t *testing.T) {
+	c := newTTLCache(0, 3*approxSize("a", "value"))
+	c.pressure = func() bool { return false }
+
+	c.Set("a", "value", time.Minute)
+	c.Set("b", "value", time.Minute)
+	c.Set("c", "value", time.Minute)
+	assert.Equal(t, 3, c.Len())
+	c.Set("d", "value", time.Minute) // evicts one
+	assert.Equal(t, 3, c.Len())
+	assert.Equal(t, 3*approxSize("a", "value"), c.Bytes())
+
+	c.Set("e", strings.Repeat("x", 1000), time.Minute) // never fits
+	_, ok := c.Get("e")
+	assert.False(t, ok)
+
+	// shrinks to half under memory pressure
+	c.pressure = func() bool { return true }
+	c.Set("f", "value", time.Minute)
+	assert.Equal(t, 1, c.Len())
+	_, ok = c.Get("f")
+	assert.True(t, ok)
+
+	c.Delete("f")
+	assert.Equal(t, 0, c.Bytes())
+}
@@ -0,0 +1,31 @@
+package caddyjwt
+
+import (
+	"fmt"
+)
+
+// defaultEnvClaim is the claim holding the environment label, see
+// RequireEnv.
+const defaultEnvClaim = "env"
+
+// checkEnv verifies the environment label of the token, i.e. the EnvClaim,
+// against RequireEnv. The claim can be a string or a list of strings.
+func (ja *JWTAuth) checkEnv(token Token) error {
+	val, ok := getClaim(token, ja.EnvClaim)
+	if !ok {
+		return fmt.Errorf("%w: missing %s", ErrEnvMismatch, ja.EnvClaim)
+	}
+	switch env := val.(type) {
+	case string:
+		if env == ja.RequireEnv {
+			return nil
+		}
+	case []interface{}:
+		for _, v := range env {
+			if v == ja.RequireEnv {
+				return nil
+			}
+		}
+	}
+	return fmt.Errorf("%w: expect %s, got %s", ErrEnvMismatch, ja.RequireEnv, stringify(val))
+}
@@ -0,0 +1,39 @@
+package caddyjwt
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAuthenticate_RequireEnv(t *testing.T) {
+	ja := &JWTAuth{SignKey: TestSignKey, RequireEnv: "prod", logger: testLogger}
+	assert.Nil(t, ja.Validate())
+	assert.Equal(t, "env", ja.EnvClaim)
+
+	var testCases = []struct {
+		Claims MapClaims
+		Err    error
+	}{
+		{MapClaims{"sub": "ggicci", "env": "prod"}, nil},
+		{MapClaims{"sub": "ggicci", "env": []string{"staging", "prod"}}, nil},
+		{MapClaims{"sub": "ggicci", "env": "staging"}, ErrEnvMismatch},
+		{MapClaims{"sub": "ggicci", "env": []string{"staging"}}, ErrEnvMismatch},
+		{MapClaims{"sub": "ggicci"}, ErrEnvMismatch},
+	}
+	for _, c := range testCases {
+		r, _ := http.NewRequest("GET", "/", nil)
+		r.Header.Add("Authorization", issueTokenString(c.Claims))
+		_, authenticated, err := ja.Authenticate(httptest.NewRecorder(), r)
+		if c.Err == nil {
+			assert.Nil(t, err, c.Claims["env"])
+			assert.True(t, authenticated)
+		} else {
+			assert.ErrorIs(t, err, c.Err, c.Claims["env"])
+			assert.False(t, authenticated)
+		}
+	}
+	assert.Equal(t, "env_mismatch", failureReason(ErrEnvMismatch))
+}
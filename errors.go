@@ -1,12 +1,163 @@
 package caddyjwt
 
-import "errors"
+import (
+	"errors"
+	"strings"
+)
 
 var (
 	ErrMissingKeys          = errors.New("missing sign_key and jwk_url")
 	ErrInvalidPublicKey     = errors.New("invalid PEM-formatted public key")
 	ErrInvalidSignAlgorithm = errors.New("invalid sign_alg")
-	ErrInvalidIssuer        = errors.New("invalid issuer")
-	ErrInvalidAudience      = errors.New("invalid audience")
-	ErrEmptyUserClaim       = errors.New("user claim is empty")
+	ErrCircuitOpen          = errors.New("circuit breaker is open")
+)
+
+// The errors of the rejected tokens. Authenticate and PreValidate wrap them
+// with the details, use errors.Is to check. The failure reason is exposed
+// as the {http.auth.jwt.error} placeholder, see failureReason.
+var (
+	ErrMissingToken          = errors.New("missing token")
+	ErrConflictingTokens     = errors.New("conflicting tokens") // see JWTAuth.SourcesExclusive
+	ErrQueryTokenDenied      = errors.New("query token denied") // see JWTAuth.QueryTokenPolicy
+	ErrInvalidToken          = errors.New("invalid token")      // malformed or bad signature
+	ErrSignatureInvalid      = errors.New("signature invalid")  // also ErrInvalidToken
+	ErrMalformedToken        = errors.New("malformed token")    // also ErrInvalidToken, reason invalid_token
+	ErrClaimMismatch         = errors.New("claim mismatch")     // e.g. ErrInvalidIssuer, see claimMismatch
+	ErrKeyNotFound           = errors.New("key not found")
+	ErrKeyBlocked            = errors.New("key blocked")
+	ErrAlgorithmNotAllowed   = errors.New("algorithm not allowed") // see JWTAuth.AllowedAlgorithms
+	ErrTokenExpired          = errors.New("token expired")
+	ErrTokenNotYetValid      = errors.New("token not yet valid")
+	ErrInvalidIssuedAt       = errors.New("invalid issued at")
+	ErrTokenTooOld           = errors.New("token too old")
+	ErrMissingExp            = errors.New("missing exp")
+	ErrNotAccessToken        = errors.New("not an access token")    // see JWTAuth.StrictRFC9068
+	ErrTokenType             = errors.New("token type not allowed") // see JWTAuth.AllowedTyp
+	ErrInvalidIssuer         = claimMismatch("invalid issuer")
+	ErrAudienceMismatch      = claimMismatch("audience mismatch")
+	ErrSubjectMismatch       = claimMismatch("subject mismatch")
+	ErrPrincipalType         = claimMismatch("principal type not allowed")
+	ErrEnvMismatch           = claimMismatch("environment mismatch")
+	ErrBindingMismatch       = claimMismatch("token binding mismatch") // see JWTAuth.BindClaims
+	ErrEmptyUserClaim        = errors.New("user claim is empty")
+	ErrClaimPolicy           = claimMismatch("claim policy not satisfied")
+	ErrClaimsSchema          = claimMismatch("claims schema not satisfied")
+	ErrInsufficientScope     = claimMismatch("insufficient scope")
+	ErrRevoked               = errors.New("token revoked")
+	ErrRevocationUnavailable = errors.New("revocation status unavailable")
+	ErrTokenReplayed         = errors.New("token replayed") // see JWTAuth.OneTimeTokens
+	ErrReplayUnavailable     = errors.New("replay status unavailable")
+	ErrEnrichmentFailed      = errors.New("enrichment failed")
+	ErrUserInfoFailed        = errors.New("userinfo request failed")
+	ErrIntrospectionFailed   = errors.New("introspection failed")
+	ErrMaintenance           = errors.New("under maintenance")
+	ErrRateLimited           = errors.New("too many failed attempts") // see JWTAuth.FailureRateLimit
+	ErrContextToken          = errors.New("context token rejected")
+	ErrDPoPInvalid           = errors.New("DPoP proof invalid")
+
+	// Deprecated: use ErrAudienceMismatch.
+	ErrInvalidAudience = ErrAudienceMismatch
 )
+
+// claimMismatchError is an error of a claim mismatching, which is also
+// ErrClaimMismatch.
+type claimMismatchError struct {
+	msg string
+}
+
+func claimMismatch(msg string) error {
+	return &claimMismatchError{msg: msg}
+}
+
+func (e *claimMismatchError) Error() string {
+	return e.msg
+}
+
+func (e *claimMismatchError) Is(target error) bool {
+	return target == ErrClaimMismatch
+}
+
+// failureReason classifies the error of a failed authentication into a short
+// snake_cased reason, e.g. for statistics.
+func failureReason(err error) string {
+	switch {
+	case errors.Is(err, ErrMaintenance):
+		return "maintenance"
+	case errors.Is(err, ErrRateLimited):
+		return "rate_limited"
+	case errors.Is(err, ErrContextToken):
+		return "context_token"
+	case errors.Is(err, ErrMissingToken):
+		return "missing_token"
+	case errors.Is(err, ErrConflictingTokens):
+		return "conflicting_tokens"
+	case errors.Is(err, ErrQueryTokenDenied):
+		return "query_token_denied"
+	case errors.Is(err, ErrKeyNotFound):
+		return "key_not_found"
+	case errors.Is(err, ErrKeyBlocked):
+		return "key_blocked"
+	case errors.Is(err, ErrAlgorithmNotAllowed):
+		return "algorithm_not_allowed"
+	case errors.Is(err, ErrSignatureInvalid):
+		return "signature_invalid"
+	case errors.Is(err, ErrTokenExpired):
+		return "token_expired"
+	case errors.Is(err, ErrTokenNotYetValid):
+		return "token_not_yet_valid"
+	case errors.Is(err, ErrInvalidIssuedAt):
+		return "invalid_issued_at"
+	case errors.Is(err, ErrTokenTooOld):
+		return "token_too_old"
+	case errors.Is(err, ErrMissingExp):
+		return "missing_exp"
+	case errors.Is(err, ErrNotAccessToken):
+		return "not_access_token"
+	case errors.Is(err, ErrTokenType):
+		return "token_type"
+	case errors.Is(err, ErrInvalidIssuer):
+		return "invalid_issuer"
+	case errors.Is(err, ErrAudienceMismatch):
+		return "invalid_audience"
+	case errors.Is(err, ErrSubjectMismatch):
+		return "subject_mismatch"
+	case errors.Is(err, ErrPrincipalType):
+		return "principal_type"
+	case errors.Is(err, ErrEnvMismatch):
+		return "env_mismatch"
+	case errors.Is(err, ErrBindingMismatch):
+		return "binding_mismatch"
+	case errors.Is(err, ErrRevoked):
+		return "revoked"
+	case errors.Is(err, ErrRevocationUnavailable):
+		return "revocation_unavailable"
+	case errors.Is(err, ErrTokenReplayed):
+		return "token_replayed"
+	case errors.Is(err, ErrReplayUnavailable):
+		return "replay_unavailable"
+	case errors.Is(err, ErrEmptyUserClaim):
+		return "empty_user_claim"
+	case errors.Is(err, ErrClaimPolicy):
+		return "claim_policy"
+	case errors.Is(err, ErrClaimsSchema):
+		return "claims_schema"
+	case errors.Is(err, ErrInsufficientScope):
+		return "insufficient_scope"
+	case errors.Is(err, ErrEnrichmentFailed):
+		return "enrichment_failed"
+	case errors.Is(err, ErrUserInfoFailed):
+		return "userinfo_failed"
+	case errors.Is(err, ErrIntrospectionFailed):
+		return "introspection_failed"
+	case errors.Is(err, ErrDPoPInvalid):
+		return "dpop_invalid"
+	}
+	return "invalid_token"
+}
+
+// isSignatureMismatch reports whether the parse error of jwx is of the
+// signature not verified by any key, rather than of a malformed token. jwx
+// doesn't type the error.
+func isSignatureMismatch(err error) bool {
+	return strings.Contains(err.Error(), "could not verify message using any of the signatures or keys")
+}
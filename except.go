@@ -0,0 +1,37 @@
+package caddyjwt
+
+import (
+	"net/http"
+
+	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
+)
+
+// bypassed reports whether the request skips the authentication, i.e. its
+// path matches ExceptPaths, or it's a CORS preflight and
+// AllowOptionsPreflight is set. The path is unescaped and cleaned, as by
+// the path matcher of Caddy, so e.g. "/public/../admin" and
+// "/public/%2e%2e/admin" are "/admin", not under "/public/*".
+func (ja *JWTAuth) bypassed(r *http.Request) bool {
+	if ja.AllowOptionsPreflight && isPreflight(r) {
+		return true
+	}
+	if len(ja.exceptPaths) == 0 {
+		return false
+	}
+	path := "/" + r.URL.Path // r.URL.Path is unescaped already
+	path = caddyhttp.CleanPath(path, true)
+	for _, pattern := range ja.exceptPaths {
+		if pattern.MatchString(path) {
+			return true
+		}
+	}
+	return false
+}
+
+// isPreflight reports whether the request is a CORS preflight, which never
+// carries credentials.
+func isPreflight(r *http.Request) bool {
+	return r.Method == http.MethodOptions &&
+		r.Header.Get("Origin") != "" &&
+		r.Header.Get("Access-Control-Request-Method") != ""
+}
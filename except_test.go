@@ -0,0 +1,53 @@
+package caddyjwt
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAuthenticate_ExceptPaths(t *testing.T) {
+	ja := &JWTAuth{
+		SignKey:               TestSignKey,
+		ExceptPaths:           []string{"/healthz", "/webhooks/*", "^/public/[a-z]+$"},
+		AllowOptionsPreflight: true,
+		logger:                testLogger,
+	}
+	assert.Nil(t, ja.Validate())
+
+	authenticate := func(method, path string, header http.Header) bool {
+		r, _ := http.NewRequest(method, path, nil)
+		for k, v := range header {
+			r.Header[k] = v
+		}
+		_, authenticated, _ := ja.Authenticate(httptest.NewRecorder(), r)
+		return authenticated
+	}
+	assert.True(t, authenticate("GET", "/healthz", nil))
+	assert.True(t, authenticate("POST", "/webhooks/github/push", nil))
+	assert.True(t, authenticate("GET", "/public/docs", nil))
+	assert.False(t, authenticate("GET", "/healthz/deep", nil))
+	assert.False(t, authenticate("GET", "/public/Docs", nil))
+	assert.False(t, authenticate("GET", "/api", nil))
+
+	// traversals are resolved before matching
+	assert.False(t, authenticate("GET", "/webhooks/../api", nil))
+	assert.False(t, authenticate("GET", "/webhooks/%2e%2e/api", nil))
+	assert.False(t, authenticate("GET", "/webhooks/%2E%2E/%2e%2e/api", nil))
+	assert.False(t, authenticate("GET", "/public/docs/../../admin", nil))
+	assert.False(t, authenticate("GET", "/public/%2e%2e/admin", nil))
+	assert.False(t, authenticate("GET", "/healthz/..", nil))
+	assert.True(t, authenticate("GET", "/api/../healthz", nil))
+	assert.True(t, authenticate("GET", "/public//./docs", nil))
+
+	preflight := http.Header{"Origin": {"https://app.example.com"}, "Access-Control-Request-Method": {"POST"}}
+	assert.True(t, authenticate("OPTIONS", "/api", preflight))
+	assert.False(t, authenticate("OPTIONS", "/api", nil)) // not a preflight
+	ja.AllowOptionsPreflight = false
+	assert.False(t, authenticate("OPTIONS", "/api", preflight))
+
+	ja.ExceptPaths = []string{"^/[a-z+$"}
+	assert.ErrorContains(t, ja.Validate(), "invalid except_paths")
+}
@@ -0,0 +1,48 @@
+package caddyjwt
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/google/cel-go/cel"
+)
+
+// compileExpression compiles the CEL expression of ValidateExpression, which
+// must evaluate to a bool. It returns nil if the expression is empty.
+func compileExpression(expr string) (cel.Program, error) {
+	if expr == "" {
+		return nil, nil
+	}
+	env, err := cel.NewEnv(cel.Variable("claims", cel.MapType(cel.StringType, cel.DynType)))
+	if err != nil {
+		return nil, err
+	}
+	ast, issues := env.Compile(expr)
+	if issues != nil && issues.Err() != nil {
+		return nil, issues.Err()
+	}
+	if ast.OutputType() != cel.BoolType && ast.OutputType() != cel.DynType {
+		return nil, fmt.Errorf("expect a bool expression, got %s", ast.OutputType())
+	}
+	return env.Program(ast)
+}
+
+// checkExpression evaluates ValidateExpression against the claims of the
+// token. An evaluation error, e.g. of a missing claim, fails the check.
+func (ja *JWTAuth) checkExpression(ctx context.Context, token Token) error {
+	if ja.validateProgram == nil {
+		return nil
+	}
+	claims, err := token.AsMap(ctx)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidToken, err)
+	}
+	out, _, err := ja.validateProgram.ContextEval(ctx, map[string]interface{}{"claims": claims})
+	if err != nil {
+		return fmt.Errorf("%w: validate_expression: %v", ErrClaimPolicy, err)
+	}
+	if ok, isBool := out.Value().(bool); !isBool || !ok {
+		return fmt.Errorf("%w: validate_expression evaluated to %v", ErrClaimPolicy, out.Value())
+	}
+	return nil
+}
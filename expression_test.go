@@ -0,0 +1,42 @@
+package caddyjwt
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAuthenticate_ValidateExpression(t *testing.T) {
+	ja := &JWTAuth{
+		SignKey:            TestSignKey,
+		ValidateExpression: `claims.role == "admin" && "payments" in claims.scopes`,
+		logger:             testLogger,
+	}
+	assert.Nil(t, ja.Validate())
+
+	authenticate := func(claims MapClaims) error {
+		r, _ := http.NewRequest("GET", "/", nil)
+		r.Header.Add("Authorization", issueTokenString(claims))
+		_, _, err := ja.Authenticate(httptest.NewRecorder(), r)
+		return err
+	}
+	assert.Nil(t, authenticate(MapClaims{"sub": "ggicci", "role": "admin", "scopes": []string{"billing", "payments"}}))
+	assert.ErrorIs(t, authenticate(MapClaims{"sub": "ggicci", "role": "admin", "scopes": []string{"billing"}}), ErrClaimPolicy)
+	assert.ErrorIs(t, authenticate(MapClaims{"sub": "ggicci", "role": "user", "scopes": []string{"payments"}}), ErrClaimPolicy)
+	assert.ErrorIs(t, authenticate(MapClaims{"sub": "ggicci"}), ErrClaimPolicy) // no such key
+}
+
+func TestCompileExpression(t *testing.T) {
+	program, err := compileExpression("")
+	assert.Nil(t, err)
+	assert.Nil(t, program)
+
+	_, err = compileExpression(`has(claims.exp) && claims.exp > timestamp("2020-01-01T00:00:00Z")`)
+	assert.Nil(t, err)
+	_, err = compileExpression(`claims.role ==`)
+	assert.NotNil(t, err)
+	_, err = compileExpression(`"admin"`)
+	assert.ErrorContains(t, err, "expect a bool expression")
+}
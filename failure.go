@@ -0,0 +1,116 @@
+package caddyjwt
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"text/template"
+
+	"golang.org/x/net/http/httpguts"
+)
+
+// FailureResponse customizes the response to the requests failing the
+// authentication, instead of the bare one emitted by Caddy. It doesn't apply
+// to the requests without any token, which are left to the other providers.
+//
+// On a server with handle_errors, the error routes respond instead, as they
+// do to the 429 of FailureRateLimit, the 503 of Maintenance, the 403 of
+// RequireScope and the redirect of ExpiredRedirect. The headers are set
+// still, and the status is in {http.auth.jwt.status}.
+type FailureResponse struct {
+	// StatusCode of the response. Defaults to 401. It's always 403 for the
+	// tokens lacking the scopes of JWTAuth.RequireScope.
+	StatusCode int `json:"status_code"`
+
+	// Body is a Go text/template of the response body, executed with:
+	//
+	//   - .Status: the status code
+	//   - .Reason: the failure reason, e.g. "token_expired"
+	//   - .Error: the generic message of the failure reason, e.g. "token
+	//     expired", never quoting the claims, which only the logs carry
+	//   - .RequestID: the correlation ID, see JWTAuth.RequestIDHeader
+	//
+	// Besides the builtin functions, e.g. html, a "json" function encodes a
+	// value as JSON, e.g.
+	//
+	//     {"error": {{json .Reason}}, "request_id": {{json .RequestID}}}
+	//
+	// Defaults to the status text.
+	Body string `json:"body"`
+
+	// ContentType of the response. Defaults to "text/plain; charset=utf-8".
+	ContentType string `json:"content_type"`
+
+	// Headers are the extra headers of the response.
+	Headers map[string]string `json:"headers"`
+
+	body *template.Template
+}
+
+// failureData is the data of the FailureResponse.Body template.
+type failureData struct {
+	Status    int
+	Reason    string
+	Error     string
+	RequestID string
+}
+
+var failureFuncs = template.FuncMap{
+	"json": func(v interface{}) (string, error) {
+		b, err := json.Marshal(v)
+		return string(b), err
+	},
+}
+
+func (f *FailureResponse) provision() error {
+	if f.StatusCode == 0 {
+		f.StatusCode = http.StatusUnauthorized
+	}
+	if f.StatusCode < 400 || f.StatusCode > 599 {
+		return fmt.Errorf("invalid status_code: %d", f.StatusCode)
+	}
+	if f.ContentType == "" {
+		f.ContentType = "text/plain; charset=utf-8"
+	}
+	if f.Body == "" {
+		f.Body = http.StatusText(f.StatusCode) + "\n"
+	}
+	body, err := template.New("failure_response").Funcs(failureFuncs).Parse(f.Body)
+	if err != nil {
+		return fmt.Errorf("invalid body: %w", err)
+	}
+	f.body = body
+	for name := range f.Headers {
+		if !httpguts.ValidHeaderFieldName(name) {
+			return fmt.Errorf("invalid header name %q", name)
+		}
+	}
+	return nil
+}
+
+// respond writes the failure response for err. The status code is 403 if
+// the token lacks the scopes, see JWTAuth.RequireScope.
+func (f *FailureResponse) respond(rw http.ResponseWriter, r *http.Request, err error, requestID string) {
+	status := f.StatusCode
+	if errors.Is(err, ErrInsufficientScope) {
+		status = http.StatusForbidden
+	}
+	var buf bytes.Buffer
+	if execErr := f.body.Execute(&buf, failureData{
+		Status:    status,
+		Reason:    failureReason(err),
+		Error:     genericFailureMessage(err),
+		RequestID: requestID,
+	}); execErr != nil {
+		// the template is broken for this data, fall back to the status text
+		buf.Reset()
+		buf.WriteString(http.StatusText(status) + "\n")
+	}
+	for name, value := range f.Headers {
+		rw.Header().Set(name, value)
+	}
+	rw.Header().Set("Content-Type", f.ContentType)
+	writeFailure(rw, r, status, buf.Bytes())
+}
@@ -0,0 +1,97 @@
+package caddyjwt
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAuthenticate_FailureResponse(t *testing.T) {
+	ja := &JWTAuth{
+		SignKey:         TestSignKey,
+		RequestIDHeader: "X-Request-Id",
+		FailureResponse: &FailureResponse{
+			Body:        `{"error": {{json .Reason}}, "request_id": {{json .RequestID}}}`,
+			ContentType: "application/json",
+			Headers:     map[string]string{"X-Auth-Failure": "1"},
+		},
+		logger: testLogger,
+	}
+	assert.Nil(t, ja.Validate())
+
+	rw := httptest.NewRecorder()
+	r, _ := http.NewRequest("GET", "/", nil)
+	r.Header.Set("X-Request-Id", "req-1")
+	r.Header.Add("Authorization", issueTokenString(MapClaims{"sub": "ggicci", "exp": 1}))
+	_, authenticated, err := ja.Authenticate(rw, r)
+	assert.ErrorIs(t, err, ErrTokenExpired)
+	assert.False(t, authenticated)
+	assert.Equal(t, http.StatusUnauthorized, rw.Code)
+	assert.Equal(t, "application/json", rw.Header().Get("Content-Type"))
+	assert.Equal(t, "1", rw.Header().Get("X-Auth-Failure"))
+	assert.JSONEq(t, `{"error": "token_expired", "request_id": "req-1"}`, rw.Body.String())
+
+	// no response for the requests without any token
+	rw = httptest.NewRecorder()
+	r, _ = http.NewRequest("GET", "/", nil)
+	_, authenticated, err = ja.Authenticate(rw, r)
+	assert.Nil(t, err)
+	assert.False(t, authenticated)
+	assert.Empty(t, rw.Header().Get("X-Auth-Failure"))
+}
+
+func TestAuthenticate_FailureResponseOfErrorRoutes(t *testing.T) {
+	ja := &JWTAuth{
+		SignKey: TestSignKey,
+		FailureResponse: &FailureResponse{
+			StatusCode:  http.StatusForbidden,
+			ContentType: "application/json",
+			Headers:     map[string]string{"X-Auth-Failure": "1"},
+		},
+		FailureRateLimit: &FailureRateLimit{MaxFailures: 1},
+		logger:           testLogger,
+	}
+	assert.Nil(t, ja.Validate())
+	srv := &caddyhttp.Server{Errors: &caddyhttp.HTTPErrorConfig{Routes: caddyhttp.RouteList{{}}}}
+
+	authenticate := func() (*httptest.ResponseRecorder, interface{}) {
+		rw := httptest.NewRecorder()
+		r, repl := newRequestWithReplacer("GET", "/")
+		r = r.WithContext(context.WithValue(r.Context(), caddyhttp.ServerCtxKey, srv))
+		r.RemoteAddr = "192.0.2.1:1234"
+		r.Header.Add("Authorization", issueTokenString(MapClaims{"sub": "ggicci", "exp": 1}))
+		_, authenticated, err := ja.Authenticate(rw, r)
+		assert.Error(t, err)
+		assert.False(t, authenticated)
+		status, _ := repl.Get("http.auth.jwt.status")
+		return rw, status
+	}
+
+	// left to the error routes, of the headers
+	rw, status := authenticate()
+	assert.Equal(t, http.StatusForbidden, status)
+	assert.Empty(t, rw.Body.String())
+	assert.Equal(t, "1", rw.Header().Get("X-Auth-Failure"))
+	assert.Empty(t, rw.Header().Get("Content-Type"))
+
+	rw, status = authenticate()
+	assert.Equal(t, http.StatusTooManyRequests, status)
+	assert.Empty(t, rw.Body.String())
+	assert.NotEmpty(t, rw.Header().Get("Retry-After"))
+}
+
+func TestFailureResponse_provision(t *testing.T) {
+	f := &FailureResponse{}
+	assert.Nil(t, f.provision())
+	assert.Equal(t, http.StatusUnauthorized, f.StatusCode)
+	assert.Equal(t, "text/plain; charset=utf-8", f.ContentType)
+	assert.Equal(t, "Unauthorized\n", f.Body)
+
+	assert.ErrorContains(t, (&FailureResponse{StatusCode: 302}).provision(), "invalid status_code")
+	assert.ErrorContains(t, (&FailureResponse{Body: "{{.Reason"}).provision(), "invalid body")
+	assert.ErrorContains(t, (&FailureResponse{Headers: map[string]string{"Bad Name": "x"}}).provision(), "invalid header name")
+}
@@ -0,0 +1,139 @@
+package caddyjwt
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+
+	"golang.org/x/net/http/httpguts"
+)
+
+// forwardedBy is the "by" parameter of the Forwarded elements appended by
+// ForwardedClaims.
+const forwardedBy = "caddy-jwt"
+
+// validateForwardedClaims checks that each parameter of ForwardedClaims is a
+// valid name, not one of the RFC 7239 ones, and mapped from one claim only.
+func validateForwardedClaims(params map[string]string) error {
+	claimOf := make(map[string]string)
+	for claim, param := range params {
+		if claim == "" || param == "" {
+			return fmt.Errorf("%s -> %s", claim, param)
+		}
+		if !httpguts.ValidHeaderFieldName(param) {
+			return fmt.Errorf("invalid parameter name %q", param)
+		}
+		lower := strings.ToLower(param)
+		switch lower {
+		case "for", "by", "host", "proto":
+			return fmt.Errorf("reserved parameter name %q", param)
+		}
+		if other, ok := claimOf[lower]; ok {
+			return fmt.Errorf("parameter %q mapped from both %q and %q", param, other, claim)
+		}
+		claimOf[lower] = claim
+	}
+	return nil
+}
+
+// setForwardedClaims appends a Forwarded element to the request carrying the
+// claims of ForwardedClaims as extension parameters, e.g.
+//
+//	Forwarded: for=192.0.2.1;by=caddy-jwt;sub=ggicci
+//
+// The parameters of the same names in the Forwarded header supplied by the
+// client are removed, so they can't be spoofed.
+func (ja *JWTAuth) setForwardedClaims(r *http.Request, result *authResult) {
+	if len(ja.ForwardedClaims) == 0 {
+		return
+	}
+	names := make(map[string]struct{}, len(ja.ForwardedClaims))
+	for _, param := range ja.ForwardedClaims {
+		names[strings.ToLower(param)] = struct{}{}
+	}
+	elements := stripForwardedParams(r.Header.Values("Forwarded"), names)
+
+	pairs := []string{"for=" + forwardedNode(r.RemoteAddr), "by=" + forwardedBy}
+	var ext []string
+	for claim, param := range ja.ForwardedClaims {
+		val, ok := getClaim(result.token, claim)
+		if !ok {
+			continue
+		}
+		ext = append(ext, param+"="+quoteForwardedValue(ja.stringifyClaim(val)))
+	}
+	sort.Strings(ext)
+	elements = append(elements, strings.Join(append(pairs, ext...), ";"))
+	r.Header.Set("Forwarded", strings.Join(elements, ", "))
+}
+
+// stripForwardedParams removes the parameters of the names from the
+// Forwarded header values, and returns the remaining non-empty elements.
+func stripForwardedParams(values []string, names map[string]struct{}) []string {
+	var elements []string
+	for _, value := range values {
+		for _, element := range splitOutsideQuotes(value, ',') {
+			var kept []string
+			for _, pair := range splitOutsideQuotes(element, ';') {
+				pair = strings.TrimSpace(pair)
+				name, _, _ := strings.Cut(pair, "=")
+				if _, ok := names[strings.ToLower(strings.TrimSpace(name))]; ok || pair == "" {
+					continue
+				}
+				kept = append(kept, pair)
+			}
+			if len(kept) > 0 {
+				elements = append(elements, strings.Join(kept, ";"))
+			}
+		}
+	}
+	return elements
+}
+
+// splitOutsideQuotes splits s by sep, except inside the quoted strings.
+func splitOutsideQuotes(s string, sep byte) []string {
+	var (
+		parts    []string
+		start    int
+		inQuotes bool
+	)
+	for i := 0; i < len(s); i++ {
+		switch {
+		case inQuotes && s[i] == '\\':
+			i++ // skip the escaped char
+		case s[i] == '"':
+			inQuotes = !inQuotes
+		case !inQuotes && s[i] == sep:
+			parts = append(parts, s[start:i])
+			start = i + 1
+		}
+	}
+	return append(parts, s[start:])
+}
+
+// forwardedNode formats the remote address as the node of the "for"
+// parameter, IPv6 addresses are bracketed and quoted.
+func forwardedNode(remoteAddr string) string {
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		host = remoteAddr
+	}
+	if host == "" {
+		return "unknown"
+	}
+	if strings.Contains(host, ":") {
+		return `"[` + host + `]"`
+	}
+	return quoteForwardedValue(host)
+}
+
+// quoteForwardedValue returns the value as is if it's a token, otherwise as
+// a quoted string.
+func quoteForwardedValue(value string) string {
+	if value != "" && httpguts.ValidHeaderFieldName(value) {
+		return value
+	}
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(value) + `"`
+}
@@ -0,0 +1,50 @@
+package caddyjwt
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAuthenticate_ForwardedClaims(t *testing.T) {
+	ja := &JWTAuth{
+		SignKey:         TestSignKey,
+		ForwardedClaims: map[string]string{"sub": "sub", "org.name": "org", "email": "email"},
+		logger:          testLogger,
+	}
+	assert.Nil(t, ja.Validate())
+
+	authenticate := func(remoteAddr string, forwarded ...string) string {
+		r, _ := http.NewRequest("GET", "/", nil)
+		r.RemoteAddr = remoteAddr
+		for _, v := range forwarded {
+			r.Header.Add("Forwarded", v)
+		}
+		r.Header.Add("Authorization", issueTokenString(MapClaims{
+			"sub": "ggicci",
+			"org": map[string]interface{}{"name": "Codelet Inc."},
+		}))
+		_, authenticated, err := ja.Authenticate(httptest.NewRecorder(), r)
+		assert.Nil(t, err)
+		assert.True(t, authenticated)
+		return r.Header.Get("Forwarded")
+	}
+
+	assert.Equal(t, `for=192.0.2.1;by=caddy-jwt;org="Codelet Inc.";sub=ggicci`, authenticate("192.0.2.1:4321"))
+	assert.Equal(t, `for="[2001:db8::1]";by=caddy-jwt;org="Codelet Inc.";sub=ggicci`, authenticate("[2001:db8::1]:4321"))
+
+	// spoofed parameters are removed, the others are kept
+	assert.Equal(t,
+		`for=198.51.100.7;proto=https, for=192.0.2.1;by=caddy-jwt;org="Codelet Inc.";sub=ggicci`,
+		authenticate("192.0.2.1:4321", `for=198.51.100.7;proto=https;SUB="admin;x", email=root@example.com`),
+	)
+}
+
+func TestValidateForwardedClaims(t *testing.T) {
+	assert.Nil(t, validateForwardedClaims(map[string]string{"sub": "sub"}))
+	assert.ErrorContains(t, validateForwardedClaims(map[string]string{"sub": "for"}), "reserved")
+	assert.ErrorContains(t, validateForwardedClaims(map[string]string{"sub": "a b"}), "invalid parameter name")
+	assert.ErrorContains(t, validateForwardedClaims(map[string]string{"sub": "id", "uid": "ID"}), "mapped from both")
+}
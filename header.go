@@ -0,0 +1,113 @@
+package caddyjwt
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// headerType returns the "typ" header of the token, empty if absent.
+func headerType(token string) string {
+	return headerParam(token, "typ")
+}
+
+// headerParam returns the string parameter of the header of the token,
+// without verifying it, empty if absent.
+func headerParam(token, name string) string {
+	value, _ := decodeHeader(token)[name].(string)
+	return value
+}
+
+// decodeHeader decodes the header of the token, without verifying it, nil if
+// malformed.
+func decodeHeader(token string) map[string]interface{} {
+	var header map[string]interface{}
+	_ = json.Unmarshal(rawHeader(token), &header)
+	return header
+}
+
+// rawHeader returns the JSON of the header of the token, without verifying
+// it, nil if malformed.
+func rawHeader(token string) []byte {
+	segment, _, _ := strings.Cut(token, ".")
+	decoded, err := decodeSegment(segment)
+	if err != nil {
+		return nil
+	}
+	return decoded
+}
+
+// mayHaveHeaderParam reports whether the raw header may have the parameter,
+// spelled out or escaped, so the headers without it needn't be decoded.
+func mayHaveHeaderParam(raw []byte, name string) bool {
+	return bytes.Contains(raw, []byte(`"`+name+`"`)) || bytes.Contains(raw, []byte(`\u`))
+}
+
+// checksHeader reports whether the header of the token is to be checked,
+// i.e. the "typ" is restricted or the "crit" present.
+func (ja *JWTAuth) checksHeader(signedToken string) bool {
+	return ja.RequireTyp || len(ja.AllowedTyp) > 0 || mayHaveHeaderParam(rawHeader(signedToken), "crit")
+}
+
+// checkHeader checks the "typ" header of the verified token against
+// RequireTyp and AllowedTyp, and the "crit" header against AllowedCrit.
+func (ja *JWTAuth) checkHeader(header map[string]interface{}) error {
+	if err := checkCritical(header, ja.AllowedCrit); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidToken, err)
+	}
+	typ, _ := header["typ"].(string)
+	if typ == "" {
+		if ja.RequireTyp {
+			return fmt.Errorf("%w: missing typ", ErrTokenType)
+		}
+		return nil
+	}
+	if len(ja.AllowedTyp) > 0 && !typAllowed(typ, ja.AllowedTyp) {
+		return fmt.Errorf("%w: typ %q, expect one of %q", ErrTokenType, typ, ja.AllowedTyp)
+	}
+	return nil
+}
+
+// checkCritical rejects the "crit" header naming the extensions not in
+// understood, which must be honored as the token is otherwise misread, see
+// RFC 7515 4.1.11.
+func checkCritical(header map[string]interface{}, understood []string) error {
+	raw, ok := header["crit"]
+	if !ok {
+		return nil
+	}
+	names, ok := raw.([]interface{})
+	if !ok || len(names) == 0 {
+		return fmt.Errorf("malformed crit header")
+	}
+	for _, v := range names {
+		name, _ := v.(string)
+		if name == "" {
+			return fmt.Errorf("malformed crit header")
+		}
+		if _, present := header[name]; !present {
+			return fmt.Errorf("critical header %q missing", name)
+		}
+		recognized := false
+		for _, u := range understood {
+			recognized = recognized || u == name
+		}
+		if !recognized {
+			return fmt.Errorf("unrecognized critical header %q", name)
+		}
+	}
+	return nil
+}
+
+// typAllowed reports whether the "typ" is one of allowed, case-insensitively
+// and regardless of the "application/" prefix, see RFC 7515 4.1.9.
+func typAllowed(typ string, allowed []string) bool {
+	typ = strings.TrimPrefix(strings.ToLower(typ), "application/")
+	for _, a := range allowed {
+		if typ == strings.TrimPrefix(strings.ToLower(a), "application/") {
+			return true
+		}
+	}
+	return false
+}
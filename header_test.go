@@ -0,0 +1,80 @@
+package caddyjwt
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/lestrrat-go/jwx/v2/jwa"
+	"github.com/lestrrat-go/jwx/v2/jws"
+	"github.com/stretchr/testify/assert"
+)
+
+// issueTokenStringHeaders issues a HS256 token of the headers, without
+// "typ" unless given.
+func issueTokenStringHeaders(extra map[string]interface{}, claims MapClaims) string {
+	headers := jws.NewHeaders()
+	for name, value := range extra {
+		panicOnError(headers.Set(name, value))
+	}
+	payload, err := json.Marshal(claims)
+	panicOnError(err)
+	tokenBytes, err := jws.Sign(payload, jws.WithKey(jwa.HS256, RawTestSignKey, jws.WithProtectedHeaders(headers)))
+	panicOnError(err)
+	return string(tokenBytes)
+}
+
+func TestAuthenticate_TokenType(t *testing.T) {
+	ja := &JWTAuth{SignKey: TestSignKey, AllowedTyp: []string{"at+jwt", "JOSE"}, logger: testLogger}
+	assert.Nil(t, ja.Validate())
+	authenticate := func(token string) error {
+		r, _ := http.NewRequest("GET", "/", nil)
+		r.Header.Add("Authorization", token)
+		_, _, err := ja.Authenticate(httptest.NewRecorder(), r)
+		return err
+	}
+	claims := MapClaims{"sub": "ggicci"}
+
+	assert.Nil(t, authenticate(issueTokenStringTyped("at+jwt", claims)))
+	assert.Nil(t, authenticate(issueTokenStringTyped("application/AT+JWT", claims)))
+	assert.Nil(t, authenticate(issueTokenStringTyped("jose", claims)))
+	assert.ErrorIs(t, authenticate(issueTokenStringTyped("JWT", claims)), ErrTokenType)
+	assert.ErrorIs(t, authenticate(issueTokenStringTyped("id+jwt", claims)), ErrTokenType)
+
+	// missing typ
+	token := issueTokenStringHeaders(nil, claims)
+	assert.Equal(t, "", headerType(token))
+	assert.Nil(t, authenticate(token))
+	ja.RequireTyp = true
+	assert.ErrorIs(t, authenticate(token), ErrTokenType)
+	assert.Equal(t, "token_type", failureReason(ErrTokenType))
+}
+
+func TestAuthenticate_CriticalHeader(t *testing.T) {
+	ja := &JWTAuth{SignKey: TestSignKey, logger: testLogger}
+	assert.Nil(t, ja.Validate())
+	authenticate := func(token string) error {
+		r, _ := http.NewRequest("GET", "/", nil)
+		r.Header.Add("Authorization", token)
+		_, _, err := ja.Authenticate(httptest.NewRecorder(), r)
+		return err
+	}
+	claims := MapClaims{"sub": "ggicci"}
+	token := issueTokenStringHeaders(map[string]interface{}{"crit": []string{"exp_v2"}, "exp_v2": true}, claims)
+
+	err := authenticate(token)
+	assert.ErrorIs(t, err, ErrInvalidToken)
+	assert.ErrorContains(t, err, `unrecognized critical header "exp_v2"`)
+
+	ja.AllowedCrit = []string{"exp_v2"}
+	assert.Nil(t, authenticate(token))
+
+	// named but absent
+	err = authenticate(issueTokenStringHeaders(map[string]interface{}{"crit": []string{"exp_v2"}}, claims))
+	assert.ErrorContains(t, err, `critical header "exp_v2" missing`)
+
+	// empty
+	err = authenticate(issueTokenStringHeaders(map[string]interface{}{"crit": []string{}}, claims))
+	assert.ErrorContains(t, err, "malformed crit header")
+}
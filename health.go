@@ -0,0 +1,95 @@
+package caddyjwt
+
+import (
+	"fmt"
+	"sort"
+
+	"go.uber.org/zap"
+)
+
+// KeysAtStartup is the requirement of the JWKs at startup, see
+// JWTAuth.RequireKeysAtStartup.
+type KeysAtStartup struct {
+	// Lenient, if set, starts the provider degraded rather than failing the
+	// provisioning when the JWKs can't be fetched. The failure is logged,
+	// and the tokens are rejected until the JWKs are fetched in the
+	// background.
+	Lenient bool `json:"lenient,omitempty"`
+}
+
+// keysReady reports whether the JWKs in use have been fetched at least once,
+// or the error of discovering or fetching them if not. The JWKs of jwk_file,
+// jwk_sets and jwk_files, and of a jwk_url resolved per request, are always
+// ready once validated.
+func (ja *JWTAuth) keysReady() (bool, error) {
+	if ja.jwkTenants != nil {
+		return true, nil // fetched on the first requests
+	}
+	ja.jwkMu.RLock()
+	defer ja.jwkMu.RUnlock()
+	if ja.jwkIndex != nil && ja.jwkIndex.source == ja.jwkURL {
+		return true, nil
+	}
+	err := ja.jwkLoadErr
+	if err == nil && ja.jwkURL == "" {
+		err = fmt.Errorf("JWKs URL not discovered yet from %q", ja.OIDCIssuer)
+	}
+	return false, err
+}
+
+// checkKeysAtStartup checks the JWKs of the provider and of its Issuers are
+// fetched, see RequireKeysAtStartup.
+func (ja *JWTAuth) checkKeysAtStartup() error {
+	providers := []*JWTAuth{ja}
+	for _, keys := range ja.Issuers {
+		providers = append(providers, keys.provider)
+	}
+	for _, p := range providers {
+		if !p.usingJWK() {
+			continue
+		}
+		ready, err := p.keysReady()
+		if ready {
+			continue
+		}
+		url, _ := p.jwks()
+		if !ja.RequireKeysAtStartup.Lenient {
+			return fmt.Errorf("JWKs unavailable from %q: %v", url, err)
+		}
+		ja.logger.Error("JWKs unavailable at startup, rejecting the tokens until fetched", zap.String("url", url), zap.Error(err))
+	}
+	return nil
+}
+
+// healthReport reports whether the JWKs of the providers have been fetched.
+type healthReport struct {
+	Ready     bool             `json:"ready"` // all of the providers
+	Providers []providerHealth `json:"providers"`
+}
+
+type providerHealth struct {
+	Provider string `json:"provider"`
+	Location string `json:"location"` // e.g. the JWKS URL
+	Ready    bool   `json:"ready"`
+	Error    string `json:"error,omitempty"` // of the last attempt, if not ready
+}
+
+// checkHealth reports the readiness of the JWKs of the providers.
+func checkHealth(providers []*JWTAuth) healthReport {
+	report := healthReport{Ready: true, Providers: make([]providerHealth, 0, len(providers))}
+	for _, ja := range providers {
+		ready, err := ja.keysReady()
+		url, _ := ja.jwks()
+		health := providerHealth{Provider: ja.Name, Location: url, Ready: ready}
+		if err != nil {
+			health.Error = err.Error()
+		}
+		report.Ready = report.Ready && ready
+		report.Providers = append(report.Providers, health)
+	}
+	sort.Slice(report.Providers, func(i, j int) bool {
+		a, b := report.Providers[i], report.Providers[j]
+		return a.Provider < b.Provider || a.Provider == b.Provider && a.Location < b.Location
+	})
+	return report
+}
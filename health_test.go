@@ -0,0 +1,74 @@
+package caddyjwt
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRequireKeysAtStartup(t *testing.T) {
+	var up atomic.Bool
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !up.Load() {
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		http.Redirect(w, r, TestJWKSetURL, http.StatusFound)
+	}))
+	defer server.Close()
+
+	health := func(provider string) (int, healthReport) {
+		rw := httptest.NewRecorder()
+		r, _ := http.NewRequest("GET", "/jwtauth/health?provider="+provider, nil)
+		assert.Nil(t, adminAPI{}.handleHealth(rw, r))
+		var report healthReport
+		assert.Nil(t, json.Unmarshal(rw.Body.Bytes(), &report))
+		return rw.Code, report
+	}
+
+	// fails fast
+	ja := &JWTAuth{JWKURL: server.URL, RequireKeysAtStartup: &KeysAtStartup{}, logger: testLogger}
+	assert.ErrorContains(t, ja.Validate(), "invalid require_keys_at_startup")
+	ja.Cleanup()
+
+	// fails fast on the keys of the issuers too
+	ja = &JWTAuth{
+		SignKey:              TestSignKey,
+		Issuers:              map[string]*IssuerKeys{"https://idp.example.com": {JWKURL: server.URL}},
+		RequireKeysAtStartup: &KeysAtStartup{},
+		logger:               testLogger,
+	}
+	assert.ErrorContains(t, ja.Validate(), server.URL)
+	ja.Cleanup()
+
+	// starts degraded
+	ja = &JWTAuth{Name: "lenient", JWKURL: server.URL, RequireKeysAtStartup: &KeysAtStartup{Lenient: true}, logger: testLogger}
+	assert.Nil(t, ja.Validate())
+	defer ja.Cleanup()
+	code, report := health("lenient")
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+	assert.False(t, report.Ready)
+	assert.Len(t, report.Providers, 1)
+	assert.Equal(t, server.URL, report.Providers[0].Location)
+	assert.NotEmpty(t, report.Providers[0].Error)
+
+	up.Store(true)
+	assert.Nil(t, ja.refreshJWKCache())
+	code, report = health("lenient")
+	assert.Equal(t, http.StatusOK, code)
+	assert.True(t, report.Ready)
+	assert.Equal(t, providerHealth{Provider: "lenient", Location: server.URL, Ready: true}, report.Providers[0])
+
+	// ready once fetched, even if unavailable afterwards
+	up.Store(false)
+	assert.NotNil(t, ja.refreshJWKCache())
+	code, _ = health("lenient")
+	assert.Equal(t, http.StatusOK, code)
+
+	r, _ := http.NewRequest("GET", "/jwtauth/health?provider=unknown", nil)
+	assert.NotNil(t, adminAPI{}.handleHealth(httptest.NewRecorder(), r))
+}
@@ -0,0 +1,231 @@
+// Package idptest provides a fake identity provider for testing the JWT
+// authentication, e.g. of the Caddy configs using caddy-jwt, without a real
+// one. It publishes the OIDC discovery document, the JWKs, a token endpoint
+// and an introspection endpoint on an ephemeral port.
+package idptest
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"time"
+
+	"github.com/lestrrat-go/jwx/v2/jwa"
+	"github.com/lestrrat-go/jwx/v2/jwk"
+	"github.com/lestrrat-go/jwx/v2/jwt"
+)
+
+// Claims are the claims of a token.
+type Claims map[string]interface{}
+
+// Server is a fake identity provider. Its URL is the issuer. The tokens are
+// signed with RS256 by the latest key, see Rotate.
+type Server struct {
+	*httptest.Server
+
+	// TokenTTL is the lifetime of the issued tokens. Defaults to 1h.
+	TokenTTL time.Duration
+
+	mu      sync.Mutex
+	keys    []jwk.Key         // private keys, the last one signs
+	clients map[string]string // client ID -> client secret
+	opaque  map[string]Claims // opaque tokens -> claims
+}
+
+// NewServer starts a fake identity provider with one signing key. The caller
+// should call Close when finished.
+func NewServer() *Server {
+	s := &Server{
+		TokenTTL: time.Hour,
+		clients:  make(map[string]string),
+		opaque:   make(map[string]Claims),
+	}
+	s.Rotate(false)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", s.handleDiscovery)
+	mux.HandleFunc("/jwks", s.handleJWKS)
+	mux.HandleFunc("/token", s.handleToken)
+	mux.HandleFunc("/introspect", s.handleIntrospect)
+	s.Server = httptest.NewServer(mux)
+	return s
+}
+
+// JWKSURL returns the URL of the JWKs.
+func (s *Server) JWKSURL() string { return s.URL + "/jwks" }
+
+// TokenURL returns the URL of the token endpoint, which issues tokens by
+// the client credentials grant.
+func (s *Server) TokenURL() string { return s.URL + "/token" }
+
+// IntrospectionURL returns the URL of the introspection endpoint.
+func (s *Server) IntrospectionURL() string { return s.URL + "/introspect" }
+
+// AddClient registers a client for the token and introspection endpoints.
+func (s *Server) AddClient(id, secret string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.clients[id] = secret
+}
+
+// Rotate generates a new signing key. The previous keys are still published
+// unless retire is true, as a real provider does during a rotation.
+func (s *Server) Rotate(retire bool) jwk.Key {
+	privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		panic(err)
+	}
+	key, err := jwk.FromRaw(privateKey)
+	if err != nil {
+		panic(err)
+	}
+	_ = jwk.AssignKeyID(key)
+	_ = key.Set(jwk.AlgorithmKey, jwa.RS256)
+	_ = key.Set(jwk.KeyUsageKey, jwk.ForSignature)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if retire {
+		s.keys = nil
+	}
+	s.keys = append(s.keys, key)
+	return key
+}
+
+// Issue returns a token of the claims signed by the latest key. The "iss",
+// "iat" and "exp" claims are filled in if absent.
+func (s *Server) Issue(claims Claims) string {
+	s.mu.Lock()
+	key := s.keys[len(s.keys)-1]
+	s.mu.Unlock()
+
+	token := jwt.New()
+	now := time.Now()
+	_ = token.Set(jwt.IssuerKey, s.URL)
+	_ = token.Set(jwt.IssuedAtKey, now)
+	_ = token.Set(jwt.ExpirationKey, now.Add(s.TokenTTL))
+	for name, value := range claims {
+		if err := token.Set(name, value); err != nil {
+			panic(err)
+		}
+	}
+	signed, err := jwt.Sign(token, jwt.WithKey(jwa.RS256, key))
+	if err != nil {
+		panic(err)
+	}
+	return string(signed)
+}
+
+// IssueOpaque returns an opaque token which can only be resolved to the
+// claims by the introspection endpoint.
+func (s *Server) IssueOpaque(claims Claims) string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	token := hex.EncodeToString(b)
+	full := Claims{"iss": s.URL, "exp": time.Now().Add(s.TokenTTL).Unix()}
+	for name, value := range claims {
+		full[name] = value
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.opaque[token] = full
+	return token
+}
+
+// Revoke makes the opaque token inactive.
+func (s *Server) Revoke(token string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.opaque, token)
+}
+
+func (s *Server) handleDiscovery(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, map[string]string{
+		"issuer":                 s.URL,
+		"jwks_uri":               s.JWKSURL(),
+		"token_endpoint":         s.TokenURL(),
+		"introspection_endpoint": s.IntrospectionURL(),
+	})
+}
+
+func (s *Server) handleJWKS(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	set := jwk.NewSet()
+	for _, key := range s.keys {
+		public, err := key.PublicKey()
+		if err != nil {
+			panic(err)
+		}
+		_ = set.AddKey(public)
+	}
+	s.mu.Unlock()
+	writeJSON(w, set)
+}
+
+// handleToken issues a token for the client by the client credentials
+// grant, the "sub" is the client ID.
+func (s *Server) handleToken(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost || r.PostFormValue("grant_type") != "client_credentials" {
+		w.WriteHeader(http.StatusBadRequest)
+		writeJSON(w, map[string]string{"error": "unsupported_grant_type"})
+		return
+	}
+	id, ok := s.authenticateClient(r)
+	if !ok {
+		w.WriteHeader(http.StatusUnauthorized)
+		writeJSON(w, map[string]string{"error": "invalid_client"})
+		return
+	}
+	claims := Claims{"sub": id, "client_id": id}
+	if scope := r.PostFormValue("scope"); scope != "" {
+		claims["scope"] = scope
+	}
+	writeJSON(w, map[string]interface{}{
+		"access_token": s.Issue(claims),
+		"token_type":   "Bearer",
+		"expires_in":   int(s.TokenTTL.Seconds()),
+	})
+}
+
+// handleIntrospect reports the opaque tokens issued by IssueOpaque, and not
+// revoked, active.
+func (s *Server) handleIntrospect(w http.ResponseWriter, r *http.Request) {
+	if _, ok := s.authenticateClient(r); !ok {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	s.mu.Lock()
+	claims, ok := s.opaque[r.PostFormValue("token")]
+	s.mu.Unlock()
+	if !ok {
+		writeJSON(w, map[string]bool{"active": false})
+		return
+	}
+	resp := Claims{"active": true}
+	for name, value := range claims {
+		resp[name] = value
+	}
+	writeJSON(w, resp)
+}
+
+func (s *Server) authenticateClient(r *http.Request) (string, bool) {
+	id, secret, ok := r.BasicAuth()
+	if !ok {
+		return "", false
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	want, ok := s.clients[id]
+	return id, ok && want == secret
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(v)
+}
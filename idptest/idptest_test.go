@@ -0,0 +1,72 @@
+package idptest
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/lestrrat-go/jwx/v2/jwk"
+	"github.com/lestrrat-go/jwx/v2/jwt"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestServer_IssueAndRotate(t *testing.T) {
+	s := NewServer()
+	defer s.Close()
+
+	token := s.Issue(Claims{"sub": "ggicci"})
+	set, err := jwk.Fetch(context.Background(), s.JWKSURL())
+	assert.Nil(t, err)
+	parsed, err := jwt.ParseString(token, jwt.WithKeySet(set))
+	assert.Nil(t, err)
+	assert.Equal(t, "ggicci", parsed.Subject())
+	assert.Equal(t, s.URL, parsed.Issuer())
+
+	s.Rotate(false)
+	set, _ = jwk.Fetch(context.Background(), s.JWKSURL())
+	assert.Equal(t, 2, set.Len())
+	_, err = jwt.ParseString(token, jwt.WithKeySet(set))
+	assert.Nil(t, err, "the previous key is still published")
+
+	s.Rotate(true)
+	set, _ = jwk.Fetch(context.Background(), s.JWKSURL())
+	assert.Equal(t, 1, set.Len())
+	_, err = jwt.ParseString(token, jwt.WithKeySet(set))
+	assert.NotNil(t, err, "the previous key is retired")
+}
+
+func TestServer_TokenAndIntrospection(t *testing.T) {
+	s := NewServer()
+	defer s.Close()
+	s.AddClient("caddy", "s3cr3t")
+
+	post := func(endpoint string, form url.Values, secret string) (*http.Response, map[string]interface{}) {
+		req, _ := http.NewRequest("POST", endpoint, strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.SetBasicAuth("caddy", secret)
+		resp, err := http.DefaultClient.Do(req)
+		assert.Nil(t, err)
+		defer resp.Body.Close()
+		var body map[string]interface{}
+		json.NewDecoder(resp.Body).Decode(&body)
+		return resp, body
+	}
+
+	resp, body := post(s.TokenURL(), url.Values{"grant_type": {"client_credentials"}, "scope": {"read"}}, "s3cr3t")
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.NotEmpty(t, body["access_token"])
+	resp, _ = post(s.TokenURL(), url.Values{"grant_type": {"client_credentials"}}, "wrong")
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+
+	opaque := s.IssueOpaque(Claims{"sub": "ggicci"})
+	_, body = post(s.IntrospectionURL(), url.Values{"token": {opaque}}, "s3cr3t")
+	assert.Equal(t, true, body["active"])
+	assert.Equal(t, "ggicci", body["sub"])
+
+	s.Revoke(opaque)
+	_, body = post(s.IntrospectionURL(), url.Values{"token": {opaque}}, "s3cr3t")
+	assert.Equal(t, false, body["active"])
+}
@@ -0,0 +1,158 @@
+package caddyjwt
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/lestrrat-go/jwx/v2/jwt"
+	"golang.org/x/sync/singleflight"
+)
+
+// Introspection authenticates the opaque (reference) tokens, i.e. the ones
+// not parseable as JWTs, by calling the OAuth 2.0 token introspection
+// endpoint of the authorization server, see https://www.rfc-editor.org/rfc/rfc7662.
+//
+// A token is accepted if the endpoint reports it "active". The returned
+// claims are then verified as if they were the claims of a JWT, e.g. "exp",
+// IssuerWhitelist and UserClaims.
+type Introspection struct {
+	// Endpoint is the URL of the introspection endpoint. Required.
+	Endpoint string `json:"endpoint"`
+
+	// ClientID and ClientSecret are the credentials to authenticate to the
+	// endpoint with HTTP basic auth.
+	ClientID     string `json:"client_id"`
+	ClientSecret string `json:"client_secret"`
+
+	// Timeout is the timeout of each call. Defaults to 2s.
+	Timeout caddy.Duration `json:"timeout"`
+
+	// CacheTTL is how long the result of a token will be cached, but never
+	// beyond the expiry of the token. Defaults to 1m.
+	CacheTTL caddy.Duration `json:"cache_ttl"`
+
+	// CacheMaxBytes bounds the approximate bytes taken by the cache of the
+	// results. Defaults to 0, bounded by the number of entries only.
+	CacheMaxBytes int `json:"cache_max_bytes"`
+
+	client *http.Client
+	cache  *ttlCache // keyed by the SHA-256 of the token
+	group  singleflight.Group
+}
+
+// introspectionResult is the cached result of introspecting a token, the
+// token is nil if inactive.
+type introspectionResult struct {
+	token Token
+}
+
+func (in *Introspection) provision() error {
+	if in.Endpoint == "" {
+		return fmt.Errorf("missing endpoint")
+	}
+	if _, err := url.Parse(in.Endpoint); err != nil {
+		return fmt.Errorf("invalid endpoint: %w", err)
+	}
+	if in.Timeout == 0 {
+		in.Timeout = caddy.Duration(2 * time.Second)
+	}
+	if in.CacheTTL == 0 {
+		in.CacheTTL = caddy.Duration(time.Minute)
+	}
+	if in.CacheMaxBytes < 0 {
+		return fmt.Errorf("invalid cache_max_bytes: %d", in.CacheMaxBytes)
+	}
+	in.client = &http.Client{Timeout: time.Duration(in.Timeout)}
+	in.cache = newTTLCache(0, in.CacheMaxBytes)
+	return nil
+}
+
+// isOpaqueToken tells whether the token is neither a JWS nor a JWE in
+// compact serialization.
+func isOpaqueToken(token string) bool {
+	return strings.Count(token, ".") != 2 && !isEncryptedToken(token)
+}
+
+// introspect returns the claims of the token as a Token, from the cache if
+// possible. Concurrent cache misses of the same token share one call.
+func (in *Introspection) introspect(ctx context.Context, token string) (Token, error) {
+	sum := sha256.Sum256([]byte(token))
+	cacheKey := hex.EncodeToString(sum[:])
+	if cached, ok := in.cache.Get(cacheKey); ok {
+		return in.activeToken(cached.(introspectionResult))
+	}
+	v, err, _ := in.group.Do(cacheKey, func() (interface{}, error) {
+		result, err := in.fetch(ctx, token)
+		if err != nil {
+			return nil, err
+		}
+		ttl := time.Duration(in.CacheTTL)
+		if result.token != nil {
+			if exp := result.token.Expiration(); !exp.IsZero() && time.Until(exp) < ttl {
+				ttl = time.Until(exp)
+			}
+		}
+		in.cache.Set(cacheKey, result, ttl)
+		return result, nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrIntrospectionFailed, err)
+	}
+	return in.activeToken(v.(introspectionResult))
+}
+
+func (in *Introspection) activeToken(result introspectionResult) (Token, error) {
+	if result.token == nil {
+		return nil, fmt.Errorf("%w: inactive", ErrInvalidToken)
+	}
+	return result.token, nil
+}
+
+func (in *Introspection) fetch(ctx context.Context, token string) (introspectionResult, error) {
+	form := url.Values{"token": {token}, "token_type_hint": {"access_token"}}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, in.Endpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return introspectionResult{}, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if in.ClientID != "" {
+		req.SetBasicAuth(url.QueryEscape(in.ClientID), url.QueryEscape(in.ClientSecret))
+	}
+	resp, err := in.client.Do(req)
+	if err != nil {
+		return introspectionResult{}, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return introspectionResult{}, fmt.Errorf("unexpected status: %s", resp.Status)
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxEnrichResponseSize))
+	if err != nil {
+		return introspectionResult{}, err
+	}
+
+	var status struct {
+		Active bool `json:"active"`
+	}
+	if err := json.Unmarshal(body, &status); err != nil {
+		return introspectionResult{}, err
+	}
+	if !status.Active {
+		return introspectionResult{}, nil
+	}
+	claims := jwt.New()
+	if err := json.Unmarshal(body, claims); err != nil {
+		return introspectionResult{}, fmt.Errorf("invalid claims: %w", err)
+	}
+	return introspectionResult{token: claims}, nil
+}
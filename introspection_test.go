@@ -0,0 +1,86 @@
+package caddyjwt
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAuthenticate_Introspection(t *testing.T) {
+	var calls int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		if id, secret, _ := r.BasicAuth(); id != "caddy" || secret != "s3cr3t" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		switch r.PostFormValue("token") {
+		case "opaque-active":
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"active": true,
+				"sub":    "ggicci",
+				"iss":    "https://auth.example.com",
+				"exp":    time.Now().Add(time.Hour).Unix(),
+				"scope":  "read write",
+			})
+		case "opaque-expired":
+			json.NewEncoder(w).Encode(map[string]interface{}{"active": true, "sub": "ggicci", "exp": 689702400})
+		case "opaque-broken":
+			w.WriteHeader(http.StatusInternalServerError)
+		default:
+			json.NewEncoder(w).Encode(map[string]interface{}{"active": false})
+		}
+	}))
+	defer server.Close()
+
+	ja := &JWTAuth{
+		SignKey:         TestSignKey,
+		IssuerWhitelist: []string{"https://auth.example.com"},
+		MetaClaims:      map[string]string{"scope": "scope"},
+		Introspection: &Introspection{
+			Endpoint:     server.URL,
+			ClientID:     "caddy",
+			ClientSecret: "s3cr3t",
+		},
+		logger: testLogger,
+	}
+	assert.Nil(t, ja.Validate())
+
+	authenticate := func(token string) (User, error) {
+		r, _ := http.NewRequest("GET", "/", nil)
+		r.Header.Add("Authorization", "Bearer "+token)
+		user, _, err := ja.Authenticate(httptest.NewRecorder(), r)
+		return user, err
+	}
+
+	for i := 0; i < 2; i++ {
+		user, err := authenticate("opaque-active")
+		assert.Nil(t, err)
+		assert.Equal(t, "ggicci", user.ID)
+		assert.Equal(t, "read write", user.Metadata["scope"])
+	}
+	assert.Equal(t, int32(1), atomic.LoadInt32(&calls)) // cached
+
+	_, err := authenticate("opaque-inactive")
+	assert.ErrorIs(t, err, ErrInvalidToken)
+	_, err = authenticate("opaque-expired")
+	assert.ErrorIs(t, err, ErrTokenExpired)
+	_, err = authenticate("opaque-broken")
+	assert.ErrorIs(t, err, ErrIntrospectionFailed)
+
+	// JWTs are never introspected
+	atomic.StoreInt32(&calls, 0)
+	_, err = authenticate(issueTokenString(MapClaims{"sub": "ggicci", "iss": "https://auth.example.com"}))
+	assert.Nil(t, err)
+	assert.Equal(t, int32(0), atomic.LoadInt32(&calls))
+}
+
+func TestIntrospection_Provision(t *testing.T) {
+	in := &Introspection{}
+	assert.ErrorContains(t, in.provision(), "missing endpoint")
+}
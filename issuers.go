@@ -0,0 +1,103 @@
+package caddyjwt
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/lestrrat-go/jwx/v2/jws"
+	"github.com/lestrrat-go/jwx/v2/jwt"
+	"go.uber.org/zap"
+)
+
+// IssuerKeys is the key material of an issuer, see JWTAuth.Issuers. Either
+// SignKey or JWKURL is required.
+type IssuerKeys struct {
+	// SignKey, SignAlgorithm and JWKURL work the same as the ones of
+	// JWTAuth.
+	SignKey       string `json:"sign_key,omitempty"`
+	SignAlgorithm string `json:"sign_alg,omitempty"`
+	JWKURL        string `json:"jwk_url,omitempty"`
+
+	provider *JWTAuth // verifies the tokens of the issuer
+}
+
+func (ik *IssuerKeys) provision(logger *zap.Logger) error {
+	if ik.SignKey == "" && ik.JWKURL == "" {
+		return ErrMissingKeys
+	}
+	ik.provider = &JWTAuth{
+		SignKey:       ik.SignKey,
+		SignAlgorithm: ik.SignAlgorithm,
+		JWKURL:        ik.JWKURL,
+		logger:        logger,
+	}
+	return ik.provider.Validate()
+}
+
+// hasDefaultKeys reports whether the keys of the tokens of the issuers not
+// on Issuers are configured.
+func (ja *JWTAuth) hasDefaultKeys() bool {
+	return ja.SignKey != "" || ja.SignKeyFile != "" || ja.usingJWK()
+}
+
+// issuerKeysOf returns the keys of the issuer of the token in the message,
+// if on Issuers, either by the issuer itself or by its canonical issuer, see
+// IssuerAliases. The "iss" claim is not verified yet, but it's the one the
+// returned keys will verify, as the payload is signed.
+func (ja *JWTAuth) issuerKeysOf(msg *jws.Message) (string, *IssuerKeys) {
+	var claims struct {
+		Issuer string `json:"iss"`
+	}
+	if err := json.Unmarshal(msg.Payload(), &claims); err != nil {
+		return "", nil
+	}
+	if keys, ok := ja.Issuers[claims.Issuer]; ok {
+		return claims.Issuer, keys
+	}
+	return claims.Issuer, ja.Issuers[ja.canonicalIssuer(claims.Issuer)]
+}
+
+// issuerKeyProvider provides the key of the issuer of the token in the
+// message, if on Issuers. It returns false if the default keys apply.
+func (ja *JWTAuth) issuerKeyProvider(reqCtx context.Context, kp *keyProvenance, msg *jws.Message) (jws.KeyProvider, bool, error) {
+	if len(ja.Issuers) == 0 {
+		return nil, false, nil
+	}
+	issuer, keys := ja.issuerKeysOf(msg)
+	if keys != nil {
+		return keys.provider.keyProvider(reqCtx, kp), true, nil
+	}
+	if !ja.hasDefaultKeys() {
+		return nil, false, fmt.Errorf("%w: no keys for issuer %q", ErrKeyNotFound, issuer)
+	}
+	return nil, false, nil
+}
+
+// canonicalIssuer returns the canonical issuer of the issuer, if an alias on
+// IssuerAliases, or the issuer itself.
+func (ja *JWTAuth) canonicalIssuer(issuer string) string {
+	if canonical, ok := ja.IssuerAliases[issuer]; ok {
+		return canonical
+	}
+	return issuer
+}
+
+// canonicalizeIssuer returns a copy of the token with the "iss" claim
+// replaced by the canonical issuer, if an alias on IssuerAliases, or the
+// token itself. The token is copied as it may be shared, see
+// ValidationCache.
+func (ja *JWTAuth) canonicalizeIssuer(token Token) (Token, error) {
+	canonical, ok := ja.IssuerAliases[token.Issuer()]
+	if !ok {
+		return token, nil
+	}
+	clone, err := token.Clone()
+	if err != nil {
+		return nil, err
+	}
+	if err := clone.Set(jwt.IssuerKey, canonical); err != nil {
+		return nil, err
+	}
+	return clone, nil
+}
@@ -0,0 +1,91 @@
+package caddyjwt
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAuthenticate_Issuers(t *testing.T) {
+	ja := &JWTAuth{
+		Issuers: map[string]*IssuerKeys{
+			"https://a.example.com": {SignKey: TestSignKey},
+			"https://b.example.com": {JWKURL: TestJWKSetURL},
+		},
+		logger: testLogger,
+	}
+	assert.Nil(t, ja.Validate())
+	defer ja.Cleanup()
+
+	authenticate := func(ja *JWTAuth, token string) error {
+		r, _ := http.NewRequest("GET", "/", nil)
+		r.Header.Add("Authorization", token)
+		_, _, err := ja.Authenticate(httptest.NewRecorder(), r)
+		return err
+	}
+	assert.Nil(t, authenticate(ja, issueTokenString(MapClaims{"sub": "ggicci", "iss": "https://a.example.com"})))
+	assert.Nil(t, authenticate(ja, issueTokenStringJWK(MapClaims{"sub": "ggicci", "iss": "https://b.example.com"})))
+
+	// signed by the key of the other issuer
+	assert.ErrorIs(t, authenticate(ja, issueTokenString(MapClaims{"sub": "ggicci", "iss": "https://b.example.com"})), ErrKeyNotFound)
+	assert.ErrorIs(t, authenticate(ja, issueTokenStringJWK(MapClaims{"sub": "ggicci", "iss": "https://a.example.com"})), ErrInvalidToken)
+
+	// no keys for the issuer
+	assert.ErrorIs(t, authenticate(ja, issueTokenString(MapClaims{"sub": "ggicci", "iss": "https://c.example.com"})), ErrKeyNotFound)
+
+	// the default keys
+	ja = &JWTAuth{
+		JWKURL:  TestJWKSetURL,
+		Issuers: map[string]*IssuerKeys{"https://a.example.com": {SignKey: TestSignKey}},
+		logger:  testLogger,
+	}
+	assert.Nil(t, ja.Validate())
+	defer ja.Cleanup()
+	assert.Nil(t, authenticate(ja, issueTokenString(MapClaims{"sub": "ggicci", "iss": "https://a.example.com"})))
+	assert.Nil(t, authenticate(ja, issueTokenStringJWK(MapClaims{"sub": "ggicci", "iss": "https://c.example.com"})))
+
+	ja = &JWTAuth{Issuers: map[string]*IssuerKeys{"https://a.example.com": {}}, logger: testLogger}
+	assert.ErrorIs(t, ja.Validate(), ErrMissingKeys)
+}
+
+func TestAuthenticate_IssuerAliases(t *testing.T) {
+	ja := &JWTAuth{
+		SignKey:         TestSignKey,
+		IssuerWhitelist: []string{"https://auth.example.com"},
+		IssuerAliases:   map[string]string{"https://auth.old.example.com": "https://auth.example.com"},
+		MetaClaims:      map[string]string{"iss": "issuer"},
+		logger:          testLogger,
+	}
+	assert.Nil(t, ja.Validate())
+	defer ja.Cleanup()
+
+	authenticate := func(token string) (User, error) {
+		r, _ := http.NewRequest("GET", "/", nil)
+		r.Header.Add("Authorization", token)
+		user, _, err := ja.Authenticate(httptest.NewRecorder(), r)
+		return user, err
+	}
+	for _, iss := range []string{"https://auth.example.com", "https://auth.old.example.com"} {
+		user, err := authenticate(issueTokenString(MapClaims{"sub": "ggicci", "iss": iss}))
+		assert.Nil(t, err)
+		assert.Equal(t, "https://auth.example.com", user.Metadata["issuer"])
+	}
+	_, err := authenticate(issueTokenString(MapClaims{"sub": "ggicci", "iss": "https://auth.other.example.com"}))
+	assert.ErrorIs(t, err, ErrInvalidIssuer)
+
+	// the keys of the canonical issuer
+	ja = &JWTAuth{
+		Issuers:       map[string]*IssuerKeys{"https://auth.example.com": {SignKey: TestSignKey}},
+		IssuerAliases: map[string]string{"https://auth.old.example.com": "https://auth.example.com"},
+		logger:        testLogger,
+	}
+	assert.Nil(t, ja.Validate())
+	defer ja.Cleanup()
+	_, err = authenticate(issueTokenString(MapClaims{"sub": "ggicci", "iss": "https://auth.old.example.com"}))
+	assert.Nil(t, err)
+
+	ja = &JWTAuth{SignKey: TestSignKey, IssuerAliases: map[string]string{"https://auth.example.com": ""}, logger: testLogger}
+	assert.Error(t, ja.Validate())
+}
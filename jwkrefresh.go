@@ -0,0 +1,221 @@
+package caddyjwt
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/lestrrat-go/jwx/v2/jwk"
+	"go.uber.org/zap"
+	"golang.org/x/sync/singleflight"
+)
+
+const (
+	// jwkMinRefreshAhead is the minimum delay between two refreshes ahead of
+	// the expiry of the JWKs, so short or past expiry hints won't flood the
+	// provider.
+	jwkMinRefreshAhead = time.Minute
+
+	// jwkMaxRefreshLead is the maximum lead time of a refresh ahead of the
+	// expiry of the JWKs.
+	jwkMaxRefreshLead = time.Minute
+)
+
+// jwkExpiry tracks the expiry hints of the JWKs: the Cache-Control max-age
+// (or Expires) of the JWKS responses, and the "exp" of the keys, if any. It
+// hooks into the JWK cache as the HTTP client and the post fetcher.
+type jwkExpiry struct {
+	client jwk.HTTPClient // http.DefaultClient, or a sharedJWKsClient
+
+	mu      sync.Mutex
+	pending time.Time // hinted by the headers of the response being fetched
+	expires time.Time // the earliest hint of the last fetch, zero if none
+	changed chan struct{}
+}
+
+func newJWKExpiry() *jwkExpiry {
+	return &jwkExpiry{
+		client:  http.DefaultClient,
+		changed: make(chan struct{}, 1),
+	}
+}
+
+// Get implements jwk.HTTPClient interface.
+func (je *jwkExpiry) Get(url string) (*http.Response, error) {
+	resp, err := je.client.Get(url)
+	if err == nil && resp.StatusCode == http.StatusOK {
+		je.mu.Lock()
+		je.pending = expiryFromHeaders(resp.Header, time.Now())
+		je.mu.Unlock()
+	}
+	return resp, err
+}
+
+// PostFetch implements jwk.PostFetcher interface.
+func (je *jwkExpiry) PostFetch(_ string, set jwk.Set) (jwk.Set, error) {
+	je.mu.Lock()
+	expires := je.pending
+	je.pending = time.Time{}
+	for i := 0; i < set.Len(); i++ {
+		key, _ := set.Key(i)
+		if exp := keyExpiry(key); !exp.IsZero() && (expires.IsZero() || exp.Before(expires)) {
+			expires = exp
+		}
+	}
+	je.expires = expires
+	je.mu.Unlock()
+
+	select {
+	case je.changed <- struct{}{}:
+	default:
+	}
+	return set, nil
+}
+
+// expiry returns the earliest expiry hinted by the last fetch, zero if none.
+func (je *jwkExpiry) expiry() time.Time {
+	je.mu.Lock()
+	defer je.mu.Unlock()
+	return je.expires
+}
+
+// expiryFromHeaders returns the expiry of a response by its Cache-Control
+// max-age, or its Expires header, zero if neither.
+func expiryFromHeaders(header http.Header, now time.Time) time.Time {
+	for _, directive := range strings.Split(header.Get("Cache-Control"), ",") {
+		name, value, _ := strings.Cut(strings.TrimSpace(directive), "=")
+		if strings.EqualFold(name, "max-age") {
+			if seconds, err := strconv.Atoi(value); err == nil && seconds >= 0 {
+				return now.Add(time.Duration(seconds) * time.Second)
+			}
+		}
+	}
+	if expires, err := http.ParseTime(header.Get("Expires")); err == nil {
+		return expires
+	}
+	return time.Time{}
+}
+
+// keyExpiry returns the "exp" of the key in unix seconds, a non-standard
+// field published by some providers, zero if absent.
+func keyExpiry(key jwk.Key) time.Time {
+	v, ok := key.Get("exp")
+	if !ok {
+		return time.Time{}
+	}
+	switch exp := v.(type) {
+	case float64:
+		return time.Unix(int64(exp), 0)
+	case json.Number:
+		if n, err := exp.Int64(); err == nil {
+			return time.Unix(n, 0)
+		}
+	}
+	return time.Time{}
+}
+
+// refreshAheadDelay returns how long to wait before refreshing the JWKs
+// expiring at the given time: a lead of a tenth of the remaining lifetime,
+// at most jwkMaxRefreshLead, and at least jwkMinRefreshAhead in total.
+func refreshAheadDelay(now, expires time.Time) time.Duration {
+	lifetime := expires.Sub(now)
+	lead := lifetime / 10
+	if lead > jwkMaxRefreshLead {
+		lead = jwkMaxRefreshLead
+	}
+	if delay := lifetime - lead; delay > jwkMinRefreshAhead {
+		return delay
+	}
+	return jwkMinRefreshAhead
+}
+
+// refreshAhead keeps refreshing the JWKs just before they expire, as hinted
+// by the JWKS responses, until the context is done. Without any hint, the
+// JWK cache refreshes them on its own schedule.
+func (ja *JWTAuth) refreshAhead(ctx context.Context) {
+	for {
+		var timer <-chan time.Time
+		if expires := ja.jwkExpiry.expiry(); !expires.IsZero() {
+			delay := refreshAheadDelay(time.Now(), expires)
+			timer = time.After(delay)
+			ja.logger.Debug("scheduled JWKs refresh", zap.Time("expires", expires), zap.Duration("delay", delay))
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ja.jwkExpiry.changed:
+		case <-timer:
+			if err := ja.refreshJWKCache(); err != nil {
+				ja.logger.Error("failed to refresh JWKs ahead of expiry", zap.Error(err))
+			}
+		}
+	}
+}
+
+// defaultKeyNotFoundBackoff is the default of JWTAuth.KeyNotFoundBackoff.
+const defaultKeyNotFoundBackoff = time.Minute
+
+// jwkMinRefetch is the minimum delay between two refetches of the JWKs on
+// the tokens of unknown kids, whichever the kids, so the tokens of made-up
+// kids can't flood the provider.
+const jwkMinRefetch = 5 * time.Second
+
+// errRefetchThrottled is of a refetch of the JWKs not allowed yet.
+var errRefetchThrottled = errors.New("refetch throttled")
+
+// kidRefetches rate limits refetching the JWKs on the tokens of unknown
+// kids, see KeyNotFoundBackoff.
+type kidRefetches struct {
+	backoff time.Duration
+	group   singleflight.Group // by kid
+
+	mu    sync.Mutex // guards tried and last
+	tried *ttlCache  // kid -> struct{}, of the kids refetched for within backoff
+	last  time.Time
+}
+
+func newKIDRefetches(backoff time.Duration) *kidRefetches {
+	return &kidRefetches{backoff: backoff, tried: newTTLCache(0, 0)}
+}
+
+// allow reports whether the JWKs may be refetched for the kid, recording
+// the refetch if so.
+func (kr *kidRefetches) allow(kid string, now time.Time) bool {
+	kr.mu.Lock()
+	defer kr.mu.Unlock()
+	if _, ok := kr.tried.Get(kid); ok {
+		return false
+	}
+	if now.Sub(kr.last) < jwkMinRefetch {
+		return false
+	}
+	kr.tried.Set(kid, struct{}{}, kr.backoff)
+	kr.last = now
+	return true
+}
+
+// refetchForKID refetches the JWKs for the unknown kid of a token, unless
+// rate limited, and reports whether they have been refetched, so the kid
+// is to be looked up again. The concurrent tokens of the kid wait for the
+// same refetch.
+func (ja *JWTAuth) refetchForKID(kid string) bool {
+	kr := ja.kidRefetches
+	if kr == nil || kid == "" {
+		return false
+	}
+	_, err, _ := kr.group.Do(kid, func() (interface{}, error) {
+		if !kr.allow(kid, time.Now()) {
+			return nil, errRefetchThrottled
+		}
+		return nil, ja.refreshJWKCache()
+	})
+	if err != nil && err != errRefetchThrottled {
+		ja.logger.Error("failed to refetch JWKs for unknown kid", zap.String("kid", kid), zap.Error(err))
+	}
+	return err == nil
+}
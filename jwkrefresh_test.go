@@ -0,0 +1,79 @@
+package caddyjwt
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/lestrrat-go/jwx/v2/jwk"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExpiryFromHeaders(t *testing.T) {
+	now := time.Date(2023, 10, 1, 12, 0, 0, 0, time.UTC)
+	var testCases = []struct {
+		CacheControl string
+		Expires      string
+		Expected     time.Time
+	}{
+		{"", "", time.Time{}},
+		{"public, max-age=600", "", now.Add(10 * time.Minute)},
+		{"no-transform, MAX-AGE=60", "Sun, 01 Oct 2023 13:00:00 GMT", now.Add(time.Minute)},
+		{"no-cache", "Sun, 01 Oct 2023 13:00:00 GMT", now.Add(time.Hour)},
+		{"max-age=abc", "", time.Time{}},
+	}
+	for _, c := range testCases {
+		header := http.Header{}
+		header.Set("Cache-Control", c.CacheControl)
+		header.Set("Expires", c.Expires)
+		assert.True(t, c.Expected.Equal(expiryFromHeaders(header, now)), c.CacheControl)
+	}
+}
+
+func TestRefreshAheadDelay(t *testing.T) {
+	now := time.Date(2023, 10, 1, 12, 0, 0, 0, time.UTC)
+	assert.Equal(t, 59*time.Minute, refreshAheadDelay(now, now.Add(time.Hour)))
+	assert.Equal(t, 9*time.Minute, refreshAheadDelay(now, now.Add(10*time.Minute)))
+	assert.Equal(t, jwkMinRefreshAhead, refreshAheadDelay(now, now.Add(30*time.Second)))
+	assert.Equal(t, jwkMinRefreshAhead, refreshAheadDelay(now, now.Add(-time.Hour)))
+}
+
+func TestJWKExpiry(t *testing.T) {
+	keyExp := time.Now().Add(5 * time.Minute).Truncate(time.Second)
+	maxAge := 3600
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		set := map[string]interface{}{}
+		raw, _ := json.Marshal(jwkPubKeySet)
+		json.Unmarshal(raw, &set)
+		set["keys"].([]interface{})[0].(map[string]interface{})["exp"] = keyExp.Unix()
+		w.Header().Set("Cache-Control", "max-age="+strconv.Itoa(maxAge))
+		json.NewEncoder(w).Encode(set)
+	}))
+	defer server.Close()
+
+	je := newJWKExpiry()
+	cache := jwk.NewCache(context.Background())
+	assert.Nil(t, cache.Register(server.URL, jwk.WithHTTPClient(je), jwk.WithPostFetcher(je)))
+	_, err := cache.Refresh(context.Background(), server.URL)
+	assert.Nil(t, err)
+	assert.True(t, keyExp.Equal(je.expiry()), "the key expires first")
+	<-je.changed
+
+	maxAge = 60
+	_, err = cache.Refresh(context.Background(), server.URL)
+	assert.Nil(t, err)
+	assert.WithinDuration(t, time.Now().Add(time.Minute), je.expiry(), 5*time.Second)
+}
+
+func TestKIDRefetches_Allow(t *testing.T) {
+	kr := newKIDRefetches(time.Minute)
+	now := time.Now()
+	assert.True(t, kr.allow("a", now))
+	assert.False(t, kr.allow("a", now.Add(jwkMinRefetch)), "within the backoff of the kid")
+	assert.False(t, kr.allow("b", now.Add(time.Second)), "within jwkMinRefetch")
+	assert.True(t, kr.allow("b", now.Add(jwkMinRefetch)))
+}
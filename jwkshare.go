@@ -0,0 +1,149 @@
+package caddyjwt
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/certmagic"
+	"github.com/lestrrat-go/jwx/v2/jwk"
+	"go.uber.org/zap"
+)
+
+// sharedJWKsLockTimeout is how long to wait for the storage lock of a JWKS
+// fetch, before fetching regardless.
+const sharedJWKsLockTimeout = 30 * time.Second
+
+// SharedJWKs coordinates the JWKS fetches of the Caddy instances sharing the
+// storage, e.g. a fleet behind a load balancer: the first instance due to
+// refresh takes a storage lock, fetches the JWKS and stores the response, and
+// the others reuse it while fresh. So the fleet fetches once per MaxAge
+// rather than once per instance, which eases the rate limits of the IdP.
+type SharedJWKs struct {
+	// MaxAge is how long a JWKS response fetched by any instance is reused.
+	// A key rotated in may be missed for up to this long. Defaults to 5m.
+	MaxAge caddy.Duration `json:"max_age"`
+}
+
+// sharedJWKsEntry is a JWKS response stored in the storage.
+type sharedJWKsEntry struct {
+	URL       string    `json:"url"`
+	FetchedAt time.Time `json:"fetched_at"`
+	Expires   time.Time `json:"expires,omitempty"` // hinted by the response
+	Body      []byte    `json:"body"`
+}
+
+// sharedJWKsClient is the HTTP client fetching the JWKS via the storage, see
+// SharedJWKs.
+type sharedJWKsClient struct {
+	storage certmagic.Storage
+	maxAge  time.Duration
+	next    jwk.HTTPClient
+	logger  *zap.Logger
+}
+
+func sharedJWKsKey(url string) string {
+	sum := sha256.Sum256([]byte(url))
+	return "jwtauth/jwks/" + hex.EncodeToString(sum[:8]) + ".json"
+}
+
+// Get implements jwk.HTTPClient interface.
+func (c *sharedJWKsClient) Get(url string) (*http.Response, error) {
+	key := sharedJWKsKey(url)
+	if entry := c.load(key, url); entry != nil {
+		return entry.response(), nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), sharedJWKsLockTimeout)
+	defer cancel()
+	if err := c.storage.Lock(ctx, key); err != nil {
+		c.logger.Warn("failed to lock the shared JWKs, fetching regardless", zap.String("url", url), zap.Error(err))
+		return c.next.Get(url)
+	}
+	defer func() {
+		if err := c.storage.Unlock(context.Background(), key); err != nil {
+			c.logger.Error("failed to unlock the shared JWKs", zap.String("url", url), zap.Error(err))
+		}
+	}()
+	// fetched by another instance while waiting for the lock
+	if entry := c.load(key, url); entry != nil {
+		return entry.response(), nil
+	}
+
+	resp, err := c.next.Get(url)
+	if err != nil || resp.StatusCode != http.StatusOK {
+		return resp, err
+	}
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+
+	now := time.Now()
+	data, _ := json.Marshal(sharedJWKsEntry{
+		URL:       url,
+		FetchedAt: now,
+		Expires:   expiryFromHeaders(resp.Header, now),
+		Body:      body,
+	})
+	if err := c.storage.Store(ctx, key, data); err != nil {
+		c.logger.Error("failed to store the shared JWKs", zap.String("url", url), zap.Error(err))
+	}
+	return resp, nil
+}
+
+// load returns the stored entry of the URL, nil if absent or stale.
+func (c *sharedJWKsClient) load(key, url string) *sharedJWKsEntry {
+	data, err := c.storage.Load(context.Background(), key)
+	if err != nil {
+		if !errors.Is(err, fs.ErrNotExist) {
+			c.logger.Error("failed to load the shared JWKs", zap.String("url", url), zap.Error(err))
+		}
+		return nil
+	}
+	var entry sharedJWKsEntry
+	if err := json.Unmarshal(data, &entry); err != nil || entry.URL != url {
+		return nil
+	}
+	now := time.Now()
+	if now.Sub(entry.FetchedAt) >= c.maxAge || (!entry.Expires.IsZero() && now.After(entry.Expires)) {
+		return nil
+	}
+	return &entry
+}
+
+// response rebuilds the response of the entry, with the expiry hint.
+func (entry *sharedJWKsEntry) response() *http.Response {
+	header := http.Header{"Content-Type": {"application/json"}}
+	if !entry.Expires.IsZero() {
+		header.Set("Expires", entry.Expires.UTC().Format(http.TimeFormat))
+	}
+	return &http.Response{
+		Status:        "200 OK",
+		StatusCode:    http.StatusOK,
+		Header:        header,
+		Body:          io.NopCloser(bytes.NewReader(entry.Body)),
+		ContentLength: int64(len(entry.Body)),
+	}
+}
+
+func (s *SharedJWKs) provision() error {
+	if s.MaxAge == 0 {
+		s.MaxAge = caddy.Duration(5 * time.Minute)
+	}
+	if s.MaxAge < 0 {
+		return fmt.Errorf("invalid max_age: %s", time.Duration(s.MaxAge))
+	}
+	return nil
+}
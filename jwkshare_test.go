@@ -0,0 +1,69 @@
+package caddyjwt
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/certmagic"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAuthenticate_SharedJWKs(t *testing.T) {
+	var fetches atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetches.Add(1)
+		w.Header().Set("Cache-Control", "max-age=3600")
+		json.NewEncoder(w).Encode(jwkPubKeySet)
+	}))
+	defer server.Close()
+
+	storage := &certmagic.FileStorage{Path: t.TempDir()}
+	newProvider := func() *JWTAuth {
+		ja := &JWTAuth{
+			JWKURL:     server.URL,
+			SharedJWKs: &SharedJWKs{},
+			storage:    storage,
+			logger:     testLogger,
+		}
+		assert.Nil(t, ja.Validate())
+		t.Cleanup(func() { ja.Cleanup() })
+		return ja
+	}
+
+	// a fleet of three fetches once
+	fleet := []*JWTAuth{newProvider(), newProvider(), newProvider()}
+	assert.Equal(t, int32(1), fetches.Load())
+	for _, ja := range fleet {
+		r, _ := http.NewRequest("GET", "/", nil)
+		r.Header.Add("Authorization", issueTokenStringJWK(MapClaims{"sub": "ggicci"}))
+		_, authenticated, err := ja.Authenticate(httptest.NewRecorder(), r)
+		assert.Nil(t, err)
+		assert.True(t, authenticated)
+	}
+	// the expiry hint is shared, too
+	assert.WithinDuration(t, time.Now().Add(time.Hour), fleet[2].jwkExpiry.expiry(), 5*time.Second)
+
+	// stale
+	fleet[0].SharedJWKs.MaxAge = caddy.Duration(time.Nanosecond)
+	fleet[0].jwkExpiry.client.(*sharedJWKsClient).maxAge = time.Nanosecond
+	assert.Nil(t, fleet[0].refreshJWKCache())
+	assert.Equal(t, int32(2), fetches.Load())
+}
+
+func TestValidate_SharedJWKs(t *testing.T) {
+	ja := &JWTAuth{JWKURL: TestJWKSetURL, SharedJWKs: &SharedJWKs{}, logger: testLogger}
+	assert.ErrorContains(t, ja.Validate(), "storage unavailable")
+
+	ja = &JWTAuth{
+		JWKURL:     TestJWKSetURL,
+		SharedJWKs: &SharedJWKs{MaxAge: caddy.Duration(-time.Second)},
+		storage:    &certmagic.FileStorage{Path: t.TempDir()},
+		logger:     testLogger,
+	}
+	assert.ErrorContains(t, ja.Validate(), "invalid shared_jwks")
+}
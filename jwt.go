@@ -3,22 +3,32 @@ package caddyjwt
 
 import (
 	"context"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
 	"crypto/x509"
 	"encoding/base64"
 	"encoding/json"
 	"encoding/pem"
+	"errors"
 	"fmt"
 	"net/http"
+	"reflect"
+	"regexp"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/caddyserver/caddy/v2"
 	"github.com/caddyserver/caddy/v2/modules/caddyhttp/caddyauth"
+	"github.com/caddyserver/certmagic"
+	"github.com/google/cel-go/cel"
 	"github.com/lestrrat-go/jwx/v2/jwa"
 	"github.com/lestrrat-go/jwx/v2/jwk"
 	"github.com/lestrrat-go/jwx/v2/jws"
 	"github.com/lestrrat-go/jwx/v2/jwt"
+	"github.com/santhosh-tekuri/jsonschema/v5"
 	"go.uber.org/zap"
 )
 
@@ -45,12 +55,108 @@ type JWTAuth struct {
 	// This is an optional field. You can instead provide JWKURL to use JWKs.
 	SignKey string `json:"sign_key"`
 
+	// SignKeyFile works like SignKey, but loads the key from a file, and
+	// reloads it whenever the file changes, e.g. a Kubernetes secret mounted
+	// as a volume being rotated, without reloading Caddy.
+	SignKeyFile string `json:"sign_key_file"`
+
 	// JWKURL is the URL where a provider publishes their JWKs. The URL must
 	// publish the JWKs in the standard format as described in
 	// https://tools.ietf.org/html/rfc7517.
 	// If you'd like to use JWK, set this field and leave SignKey unset.
+	//
+	// The placeholders of the requests are resolved per request, e.g.
+	// "https://{http.request.header.X-Tenant}.idp.example.com/jwks" for the
+	// tenants of their own IdPs, whose JWKs are fetched on the first
+	// requests of the tenants. The values must consist of letters, digits,
+	// ".", "_", "~" and "-" only, not to redirect the URL elsewhere, and
+	// be allowed by JWKURLTenants, which is required then.
 	JWKURL string `json:"jwk_url"`
 
+	// JWKURLTenants restricts and bounds the JWKs URLs resolved per request
+	// from JWKURL, e.g. "https://{http.request.host.labels.2}.idp.example.com/jwks.json"
+	// fronting the tenants of a SaaS, by an allowlist of the tenants and by
+	// the number of their JWKs kept.
+	JWKURLTenants *JWKURLTenants `json:"jwk_url_tenants"`
+
+	// JWKRefreshInterval refreshes the JWKs of JWKURL, or of OIDCIssuer, at
+	// the fixed interval, rather than as hinted by the Cache-Control and
+	// Expires headers of the responses, at least hourly.
+	JWKRefreshInterval caddy.Duration `json:"jwk_refresh_interval"`
+
+	// KeyNotFoundBackoff is how long to wait before refetching the JWKs
+	// again for the same unknown kid. A token of a kid not in the JWKs
+	// refetches them at once, e.g. just rotated by the provider, and is
+	// looked up again before rejected. Defaults to 1m. The refetches of any
+	// kids are at least 5s apart.
+	KeyNotFoundBackoff caddy.Duration `json:"key_not_found_backoff,omitempty"`
+
+	// JWKFile is the path of a file of a JWK or a JWK set, which is reloaded
+	// whenever the file changes, like SignKeyFile. It excludes JWKURL and
+	// OIDCIssuer.
+	JWKFile string `json:"jwk_file"`
+
+	// JWKSets are inline JWK sets (or single JWKs), and JWKFiles are the
+	// paths of the files of JWK sets, e.g. for the air-gapped environments
+	// where the JWKs can't be fetched over HTTP. The keys of all of them are
+	// trusted. Unlike JWKFile, they are loaded once, at provision. They
+	// exclude JWKURL, OIDCIssuer and JWKFile.
+	JWKSets  []json.RawMessage `json:"jwk_sets"`
+	JWKFiles []string          `json:"jwk_files"`
+
+	// Vault fetches the JWKs from HashiCorp Vault, of a transit key or of
+	// its identity tokens, refreshed periodically. It excludes JWKURL,
+	// OIDCIssuer, JWKFile, JWKSets and JWKFiles.
+	Vault *VaultKeys `json:"vault,omitempty"`
+
+	// Issuers maps the issuers to their own key material, i.e. a static key
+	// or a JWKs URL, which verifies their tokens instead of the keys above,
+	// selected by the "iss" claim of the tokens. It's useful to the
+	// multi-tenant gateways fronting several IdPs. The tokens of the other
+	// issuers are verified by the keys above, if any, or rejected.
+	Issuers map[string]*IssuerKeys `json:"issuers"`
+
+	// KeyResolver, if set, resolves the keys to verify the tokens before the
+	// configured keys, for the programs embedding the module, e.g. to look
+	// up the keys in a database. It can't be configured by JSON or
+	// Caddyfile. If set, the configured keys are optional.
+	KeyResolver KeyResolverFunc `json:"-"`
+
+	// BlockKIDs rejects the tokens signed by the keys of the IDs ("kid"),
+	// regardless of the JWKs, e.g. when a signing key is suspected to be
+	// compromised. More key IDs can be blocked at runtime via the admin API,
+	// see /jwtauth/blocked-kids, which are persisted in the Caddy data
+	// directory and apply to all the providers.
+	BlockKIDs []string `json:"block_kids"`
+
+	// DecryptKey is the key to decrypt the encrypted tokens (JWE), whose
+	// payloads are the signed tokens (JWS) to verify, e.g. the ones issued
+	// by Azure AD with token encryption. Use the private key in PEM format
+	// for asymmetric algorithms, e.g. RSA-OAEP, or the key in base64 format
+	// for symmetric ones, e.g. A256KW. The key management algorithm is read
+	// from the "alg" field in the JWE header.
+	//
+	// This is an optional field. The tokens not encrypted are always
+	// accepted as before.
+	DecryptKey string `json:"decrypt_key"`
+
+	// DecryptKeyFile works like DecryptKey, but loads the key from a file.
+	DecryptKeyFile string `json:"decrypt_key_file"`
+
+	// MaxDecompressedSize bounds the payloads of the compressed tokens, of
+	// the "zip": "DEF" header, which are inflated transparently before the
+	// claims are validated, against the decompression bombs. The signed
+	// tokens are inflated after their signatures are verified. Defaults to
+	// 64 KiB.
+	MaxDecompressedSize int `json:"max_decompressed_size"`
+
+	// OIDCIssuer is the issuer URL of an OpenID Connect provider. If set, and
+	// neither SignKey nor JWKURL is set, the JWKs URL will be discovered from
+	// the "jwks_uri" of "<oidc_issuer>/.well-known/openid-configuration", and
+	// rediscovered hourly to follow the rotation. IssuerWhitelist defaults to
+	// the issuer.
+	OIDCIssuer string `json:"oidc_issuer"`
+
 	// SignAlgorithm is the the signing algorithm used. Available values are defined in
 	// https://www.rfc-editor.org/rfc/rfc7518#section-3.1
 	// This is an optional field, which is used for determining the signing algorithm.
@@ -58,8 +164,17 @@ type JWTAuth struct {
 	// 1. The "alg" field in the JWT header.
 	// 2. The "alg" field in the matched JWK (if JWKURL is provided).
 	// 3. The value set here.
+	// For an EC or Ed25519 sign_key, the algorithm is inferred from the key
+	// instead, i.e. ES256, ES384, ES512 by the curve, or EdDSA.
 	SignAlgorithm string `json:"sign_alg"`
 
+	// AllowedAlgorithms, if set, restricts the "alg" of the tokens, checked
+	// before the verification, e.g. ["RS256", "ES256"]. "none" is always
+	// rejected. Regardless, a token is only verified by a key of the type
+	// of its algorithm, e.g. RS* and PS* by an RSA key, which defends
+	// against the algorithm confusion of the JWKs of mixed key types.
+	AllowedAlgorithms []string `json:"allowed_algorithms"`
+
 	// FromQuery defines a list of names to get tokens from the query parameters
 	// of an HTTP request.
 	//
@@ -67,7 +182,7 @@ type JWTAuth struct {
 	// values will be treated as candidate tokens. And we will verify each of
 	// them until we got a valid one.
 	//
-	// Priority: from_query > from_header > from_cookies.
+	// Priority: from_query > from_header > from_cookies, see SourcePriority.
 	FromQuery []string `json:"from_query"`
 
 	// FromHeader works like FromQuery. But defines a list of names to get
@@ -78,18 +193,56 @@ type JWTAuth struct {
 	// from the HTTP cookies.
 	FromCookies []string `json:"from_cookies"`
 
+	// FromBody works like FromQuery. But defines a list of names to get tokens
+	// from the fields of the form in the request body, either URL-encoded or
+	// multipart, e.g. "id_token" of a form post. The body is left intact for
+	// the handlers downstream. The bodies larger than 1MiB are not read.
+	//
+	// Priority: from_cookies > from_body.
+	FromBody []string `json:"from_body,omitempty"`
+
+	// SourcePriority is the order the sources of the tokens are looked up
+	// in, of "query", "header", "cookie", "body" and "authorization", i.e.
+	// of FromQuery, FromHeader, FromCookies, FromBody and the Authorization
+	// header. The sources not listed follow in that order, the default.
+	SourcePriority []string `json:"source_priority,omitempty"`
+
+	// SourcesExclusive rejects the requests presenting different tokens,
+	// e.g. one in the query and another in the Authorization header, with
+	// ErrConflictingTokens, rather than accepting whichever is valid, which
+	// can mask the bugs of the clients, or smuggle a token in by the query.
+	// The same token presented twice is not a conflict.
+	SourcesExclusive bool `json:"sources_exclusive,omitempty"`
+
 	// IssuerWhitelist defines a list of issuers. A non-empty list turns on "iss
 	// verification": the "iss" claim must exist in the given JWT payload. And
 	// the value of the "iss" claim must be on the whitelist in order to pass
 	// the verification.
 	IssuerWhitelist []string `json:"issuer_whitelist"`
 
+	// IssuerAliases maps the historical issuers to their canonical issuers,
+	// e.g. during the migration of the domain of an IdP, so the tokens minted
+	// before the migration keep validating. The "iss" claim of such a token
+	// is replaced by the canonical issuer once the signature is verified, so
+	// the token is checked against IssuerWhitelist, logged and reported
+	// under the canonical issuer. Issuers is looked up by the canonical
+	// issuer as well, if the historical one is not on it.
+	IssuerAliases map[string]string `json:"issuer_aliases,omitempty"`
+
 	// AudienceWhitelist defines a list of audiences. A non-empty list turns on
 	// "aud verification": the "aud" claim must exist in the given JWT payload.
 	// The verification will pass as long as one of the "aud" values is on the
 	// whitelist.
 	AudienceWhitelist []string `json:"audience_whitelist"`
 
+	// Audience overrides AudienceWhitelist per request, by the audiences it
+	// is replaced to, space separated. Placeholders are supported, e.g.
+	// "{http.vars.jwt_aud}" set by the vars directive, or the output of a
+	// map directive keyed on the path, so one provider can enforce the
+	// audiences of the paths. If replaced to empty, AudienceWhitelist
+	// applies.
+	Audience string `json:"audience,omitempty"`
+
 	// UserClaims defines a list of names to find the ID of the authenticated user.
 	//
 	// By default, this config will be set to []string{"sub"}.
@@ -104,6 +257,14 @@ type JWTAuth struct {
 	// If no non-empty values found, leaves it unauthenticated.
 	UserClaims []string `json:"user_claims"`
 
+	// UserIDFormat builds the ID of the authenticated user of several claims
+	// instead of UserClaims, of the placeholders of the claims, nested ones
+	// by dots, and the literal text around them, e.g. "{iss}|{sub}" or
+	// "tenant:{tid}:{oid}", as the "sub" of different issuers can collide
+	// in the deployments of multiple IdPs. All the claims must be non-empty
+	// strings or numbers, or it leaves the request unauthenticated.
+	UserIDFormat string `json:"user_id_format,omitempty"`
+
 	// MetaClaims defines a map to populate {http.auth.user.*} metadata placeholders.
 	// The key is the claim in the JWT payload, the value is the placeholder name.
 	// e.g. {"IsAdmin": "is_admin"} can populate {http.auth.user.is_admin} with
@@ -133,11 +294,511 @@ type JWTAuth struct {
 	// Use dot notation to access nested claims.
 	MetaClaims map[string]string `json:"meta_claims"`
 
+	// MetaClaimsJSON, if true, exports the arrays and the objects of
+	// MetaClaims as compact JSON, e.g. `["admin","dev"]`, rather than
+	// flattened, e.g. "admin,dev", and the whole metadata as a JSON object
+	// in {http.auth.user.metadata_json}, whose values of the claims keep
+	// their types, so the upstreams can reconstruct the structured data.
+	MetaClaimsJSON bool `json:"meta_claims_json"`
+
+	// ArrayFormat formats the array claims into the strings of the
+	// metadata, see MetaClaims, unless MetaClaimsJSON, and of the headers
+	// of ForwardClaimsHeader and ForwardedClaims. By default, the elements
+	// are joined by commas, e.g. "admin,dev".
+	ArrayFormat *ArrayFormat `json:"array_format"`
+
+	// ValidateExp, ValidateNbf and ValidateIat toggle the verification of the
+	// standard claims "exp", "nbf" and "iat" correspondingly. All of them
+	// default to true. Turn off one of them only for interoperating with
+	// issuers emitting broken values, e.g. a legacy system having "iat" in
+	// the future.
+	ValidateExp *bool `json:"validate_exp"`
+	ValidateNbf *bool `json:"validate_nbf"`
+	ValidateIat *bool `json:"validate_iat"`
+
+	// SharedJWKs, if set, coordinates the JWKS fetches of the Caddy instances
+	// sharing the storage, so the fleet fetches once rather than once per
+	// instance. Only applies to jwk_url and oidc_issuer.
+	SharedJWKs *SharedJWKs `json:"shared_jwks"`
+
+	// RequireKeysAtStartup, if set, fails the provisioning if the JWKs of
+	// jwk_url, oidc_issuer or Issuers can't be fetched at startup, rather
+	// than retrying in the background while rejecting the tokens. See
+	// KeysAtStartup.Lenient to start degraded instead. Either way, the
+	// admin endpoint /jwtauth/health reports whether the JWKs are fetched.
+	RequireKeysAtStartup *KeysAtStartup `json:"require_keys_at_startup"`
+
+	// Leeway is the tolerance of the clock skew between the issuers and
+	// Caddy, applied when verifying "exp", "nbf" and "iat". Defaults to 0,
+	// strict.
+	Leeway caddy.Duration `json:"leeway"`
+
+	// MaxTokenAge, if set, rejects the tokens issued, per "iat", longer ago
+	// than the duration, regardless of "exp", as well as the tokens without
+	// "iat". It caps the lifetime of the tokens issued by misconfigured
+	// services.
+	MaxTokenAge caddy.Duration `json:"max_token_age"`
+
+	// RequireExp, if true, rejects the tokens without "exp", i.e. the ones
+	// never expiring.
+	RequireExp bool `json:"require_exp"`
+
+	// StrictRFC9068, if true, only accepts the access tokens of the JWT
+	// profile of RFC 9068, i.e. of the "typ" header "at+jwt", with the
+	// "iss", "exp", "aud", "sub", "client_id", "iat" and "jti" claims. It
+	// keeps the ID tokens of the same issuer off the APIs. The opaque tokens
+	// of Introspection are exempt.
+	StrictRFC9068 bool `json:"strict_rfc9068"`
+
+	// RequireTyp, if true, rejects the tokens without the "typ" header.
+	RequireTyp bool `json:"require_typ"`
+
+	// AllowedTyp pins the "typ" header of the tokens, e.g. "at+jwt" or
+	// "JOSE", against the confusion of the types of the tokens, e.g. an ID
+	// token presented as an access token. It's matched case-insensitively,
+	// with or without the "application/" prefix. The tokens without "typ"
+	// are accepted unless RequireTyp. By default, any "typ" is accepted.
+	AllowedTyp []string `json:"allowed_typ"`
+
+	// AllowedCrit lists the critical header parameters, of the "crit"
+	// header, the upstreams understand. A token of any other critical
+	// parameter is rejected, as required by RFC 7515. By default, none are.
+	AllowedCrit []string `json:"allowed_crit"`
+
+	// ExpiredGrace lets the tokens expired within the duration still access
+	// with the safe methods, i.e. GET and HEAD, while the other methods
+	// require a fresh token. It smooths over the races between a client
+	// refreshing its token and the requests in flight. Defaults to 0, no
+	// grace.
+	ExpiredGrace caddy.Duration `json:"expired_grace"`
+
+	// NormalizeToken defines the rules to clean up the envelope of the tokens
+	// of each source before verification, since many clients mangle the
+	// token, e.g. wrapping it in quotes or URL-encoding it twice. The key is
+	// the source: "query", "header" or "cookie". The value is a list of rules:
+	//
+	//   - "trim": trims surrounding whitespaces and newlines
+	//   - "unquote": strips a pair of surrounding quotes
+	//   - "urldecode": URL-decodes the token when percent-encoding is detected
+	//
+	// Caddyfile:
+	//
+	//     normalize_token cookie unquote urldecode
+	//
+	// The "Bearer " prefix is always stripped, unless HeaderScheme is set.
+	// Also "body" is a source, see FromBody.
+	NormalizeToken map[string][]string `json:"normalize_token"`
+
+	// HeaderScheme lists the authentication schemes accepted of the tokens
+	// from the headers, e.g. "Bearer", "JWT" or "Token", case-insensitive,
+	// and "none" for a bare token. A token of any other scheme is rejected.
+	// Defaults to "Bearer" and "none".
+	//
+	// Caddyfile:
+	//
+	//     header_scheme Bearer JWT none
+	HeaderScheme []string `json:"header_scheme,omitempty"`
+
+	// HeaderPrefix maps the headers to the patterns (regular expressions)
+	// of the prefixes to strip off their values before the scheme, e.g. of
+	// the legacy or gRPC-web clients prefixing the tokens.
+	//
+	// Caddyfile:
+	//
+	//     header_prefix X-Grpc-Auth ^v[0-9]+:
+	HeaderPrefix map[string]string `json:"header_prefix,omitempty"`
+
+	// ExpiredRedirect is the URL to redirect to when the token found in the
+	// cookies was expired (as opposed to invalid). Instead of the bare 401,
+	// a 302 response will be sent along with a flash cookie describing the
+	// reason, which plays nicer with server-rendered web apps.
+	//
+	// This is an optional field. Only tokens from `from_cookies` are affected.
+	ExpiredRedirect string `json:"expired_redirect"`
+
+	// ExpiredFlashCookie is the name of the flash cookie set on redirection
+	// by ExpiredRedirect. Defaults to "jwt_flash".
+	ExpiredFlashCookie string `json:"expired_flash_cookie"`
+
+	// QueryTokenNoStore, if true, adds "Cache-Control: no-store" and
+	// "Pragma: no-cache" to the responses to the requests authenticated by
+	// the tokens in the query, as required by RFC 6750 section 2.3, so the
+	// URLs carrying the tokens won't be cached.
+	QueryTokenNoStore bool `json:"query_token_no_store"`
+
+	// QueryTokenPolicy is how the tokens in the query are treated, as the
+	// URLs leak the tokens via the logs and the Referer headers:
+	//
+	//   - "allow", the default, accepts them as the other tokens;
+	//   - "warn" logs a warning of each request presenting one;
+	//   - "redact" removes them from the request once extracted, accepted
+	//     or not, so the placeholders, e.g. {http.request.uri}, and the
+	//     handlers and the upstream down the route never see them;
+	//   - "deny" rejects the requests presenting one with
+	//     ErrQueryTokenDenied, of the parameters of FromQuery, or
+	//     "access_token" of RFC 6750 if none, rather than ignoring them.
+	//
+	// The access log of Caddy and the {http.request.orig_uri} placeholders
+	// keep the request as received, before any handler, so redact the
+	// parameters of the access log by the "query" log filter.
+	QueryTokenPolicy string `json:"query_token_policy,omitempty"`
+
+	// ExpiringWindow turns on the soft expiry warning. When a valid token
+	// will expire within this window, a response header (ExpiringHeader)
+	// carrying the remaining seconds is added, e.g. `X-Token-Expiring: 120`,
+	// so well-behaved clients can refresh their tokens proactively.
+	ExpiringWindow caddy.Duration `json:"expiring_window"`
+
+	// ExpiringHeader is the name of the header set by ExpiringWindow.
+	// Defaults to "X-Token-Expiring".
+	ExpiringHeader string `json:"expiring_header"`
+
+	// MatchedAudienceHeader is the name of the request header to inject the
+	// audience (the first one on AudienceWhitelist, or Audience, found in the
+	// "aud" claim) which admitted the token, for the upstream. The matched
+	// audience is always available as the placeholder {http.auth.jwt.matched_aud}.
+	MatchedAudienceHeader string `json:"matched_audience_header"`
+
+	// ForwardClaimsHeader maps the claims (nested ones by dots) to the request
+	// headers to inject their values into, for the upstream, e.g.
+	// {"sub": "X-User-Id", "roles": "X-User-Roles"}. The array values are
+	// joined by commas. The headers supplied by the client are always
+	// removed, so they can't be spoofed, even if the claims are absent.
+	//
+	// Caddyfile:
+	//
+	//     forward_claims_header "sub -> X-User-Id" "roles -> X-User-Roles"
+	ForwardClaimsHeader map[string]string `json:"forward_claims_header"`
+
+	// ForwardedClaims maps the claims to the extension parameters of a RFC
+	// 7239 Forwarded element appended to the request, for the upstream stacks
+	// parsing Forwarded instead of custom headers, e.g. {"sub": "sub"} gives
+	// `Forwarded: for=192.0.2.1;by=caddy-jwt;sub=ggicci`. The parameters of
+	// the same names supplied by the client are removed.
+	//
+	// Caddyfile:
+	//
+	//     forwarded_claims sub "org.id -> org"
+	ForwardedClaims map[string]string `json:"forwarded_claims"`
+
+	// StripToken, if true, removes the tokens from the accepted requests,
+	// so the upstream never sees the raw credentials, i.e. the Authorization
+	// header and the headers, the query parameters, the cookies and the form
+	// fields of the sources, including the context token and the session
+	// cookie. The tokens in the multipart/form-data bodies are left intact.
+	// The Authorization header of UpstreamBasicAuth is set afterwards.
+	StripToken bool `json:"strip_token"`
+
+	// ExceptPaths lets the requests of the paths matching one of the
+	// patterns bypass the authentication, e.g. the health checks and the
+	// webhooks, without splitting the routes. A pattern is a glob, e.g.
+	// "/webhooks/*", or a regular expression starting with "^" or ending
+	// with "$", e.g. "^/healthz?$". The paths are matched unescaped and
+	// cleaned, as by the path matcher, so "/webhooks/../admin" is not
+	// bypassed by "/webhooks/*". The bypassing requests have no user.
+	ExceptPaths []string `json:"except_paths"`
+
+	// AllowOptionsPreflight, if true, lets the CORS preflight requests, i.e.
+	// OPTIONS with the Origin and Access-Control-Request-Method headers,
+	// bypass the authentication, as the browsers never send credentials
+	// with them.
+	AllowOptionsPreflight bool `json:"allow_options_preflight"`
+
+	// SubjectPattern defines the patterns which the "sub" claim must match
+	// one of, e.g. "spiffe://prod/*" (glob) or "^[0-9]+$" (a regular
+	// expression, starting with "^" or ending with "$"). It's a cheap guard
+	// against the tokens of other environments.
+	SubjectPattern []string `json:"subject_pattern"`
+
+	// PrincipalType restricts the tokens by the type of the principal, which
+	// is inferred from the shape of the claims. Can be "human", "service" or
+	// "any". Defaults to "any". E.g. "human" refuses the machine tokens on
+	// interactive endpoints.
+	PrincipalType string `json:"principal_type"`
+
+	// RequireEnv is the environment label, e.g. "prod", which the EnvClaim
+	// of the tokens must match (or contain, if a list). It prevents the
+	// tokens minted for the other environments by the same issuer, e.g.
+	// staging, from being replayed against this one.
+	RequireEnv string `json:"require_env"`
+
+	// BindClaims binds the tokens to the clients they were issued to, so a
+	// stolen token replayed from another client is rejected. It maps the
+	// claims to the attributes of the request, the claim must equal (or
+	// contain, if a list) the attribute:
+	//
+	//   - "client_ip": the IP of the client, as determined by Caddy. The
+	//     claim can be an IP or a CIDR, e.g. "cip": "10.1.0.0/16"
+	//   - "sha256:<value>": the claim is the SHA-256, hex or base64url
+	//     encoded, of the value, e.g. "fgp": "sha256:{http.request.cookie.__Secure-Fgp}"
+	//   - any other value, with placeholders, e.g. {http.request.header.User-Agent}
+	//
+	// The tokens without the claims are rejected.
+	BindClaims map[string]string `json:"bind_claims,omitempty"`
+
+	// EnvClaim is the claim holding the environment label of the tokens.
+	// Defaults to "env".
+	EnvClaim string `json:"env_claim"`
+
+	// SandboxParsing, if set, parses the untrusted tokens in a pool of
+	// isolated worker goroutines, so a parser bug or a pathological token
+	// can't take down the server.
+	SandboxParsing *SandboxParsing `json:"sandbox_parsing"`
+
+	// VerificationWorkers limits the number of the requests whose tokens are
+	// verified concurrently, the others wait in a queue. It bounds the CPU
+	// spent on verification, e.g. of RSA signatures. Defaults to 0, unlimited.
+	VerificationWorkers int `json:"verification_workers"`
+
+	// ClaimPolicies defines named sets of claim policies, which can be
+	// selected per route by ClaimPolicyName, so the routes can share one
+	// provider config.
+	ClaimPolicies map[string]ClaimPolicy `json:"claim_policies"`
+
+	// ClaimPolicyName selects the claim policy to apply from ClaimPolicies.
+	// Placeholders are supported, e.g. "{http.vars.jwt_policy}". If empty,
+	// or replaced to empty, no claim policy applies.
+	ClaimPolicyName string `json:"claim_policy"`
+
+	// Require defines the claims the tokens must have, so different routes
+	// can demand different claims. A token satisfies a requirement if the
+	// claim, or any element of it, is one of the values (OR), and it must
+	// satisfy all the requirements (AND).
+	//
+	// Caddyfile:
+	//
+	//     require scope admin:read admin:write
+	//     require org.id codelet
+	Require []ClaimRequirement `json:"require"`
+
+	// VerifyClaims defines the checks of the claims (nested ones by dots) by
+	// the operators ==, !=, >=, <=, > and <, or existence, so the numeric
+	// and boolean claims can be verified. The operand is typed: a number, a
+	// boolean ("true" or "false"), or a string, forced by quoting. A missing
+	// claim, or one of a different type, fails the check. All the checks
+	// must pass.
+	//
+	// Caddyfile:
+	//
+	//     verify_claims age >=18
+	//     verify_claims email_verified ==true
+	//     verify_claims mfa exists
+	VerifyClaims []string `json:"verify_claims,omitempty"`
+
+	// RequireScope lists the scopes the tokens must be granted, per the
+	// "scope" or "scp" claim, either a space-delimited string or an array.
+	// A token lacking them is rejected with 403 and the error code
+	// "insufficient_scope" of RFC 6750, rather than 401.
+	RequireScope []string `json:"require_scope"`
+
+	// ScopeMatch is how RequireScope is matched: "all" of the scopes are
+	// required (AND), or "any" of them suffices (OR). Defaults to "all".
+	ScopeMatch string `json:"scope_match"`
+
+	// RolesClaim is the claim (nested ones by dots) of the roles granted by
+	// the tokens, either an array or a space-delimited string, or a preset
+	// of the layout of an IdP: "azure" ("roles"), "keycloak"
+	// ("realm_access.roles") or "cognito" ("cognito:groups"). If set, the
+	// roles are flattened into the {http.auth.user.roles} placeholder,
+	// joined by commas. Defaults to "roles" if RequireRole is set, unless
+	// MapGroups.
+	RolesClaim string `json:"roles_claim,omitempty"`
+
+	// RequireRole lists the roles, any of which the tokens must be granted,
+	// per RolesClaim.
+	RequireRole []string `json:"require_role,omitempty"`
+
+	// MapGroups maps the groups of the tokens to the roles, joining the
+	// roles of RolesClaim. Caddyfile:
+	//
+	//     map_groups [<claim>] {
+	//         "S-1-5-21-..." => admins
+	//         <group> => <role...>
+	//         keep_unmapped
+	//     }
+	MapGroups *GroupMapping `json:"map_groups,omitempty"`
+
+	// PolicyTrace, if true, attaches a trace of the checks run on each token,
+	// i.e. the signature, the standard claims, the whitelists, the policies,
+	// the expression, the scopes, etc., with their inputs and outcomes in
+	// order, to the log entries of the verification. It makes the combined
+	// policies debuggable. Don't turn it on in production, as the claims are
+	// logged.
+	PolicyTrace bool `json:"policy_trace"`
+
+	// PolicyTraceHeader and PolicyTraceSecret turn on PolicyTrace for the
+	// requests carrying the header of the secret value only, e.g. for an
+	// operator debugging in production. The header is removed from the
+	// request.
+	PolicyTraceHeader string `json:"policy_trace_header"`
+	PolicyTraceSecret string `json:"policy_trace_secret"`
+
+	// Audit, if true, logs every authentication decision to the Caddy log
+	// named "jwtauth.audit", with the issuer, the subject, the kid and the
+	// jti of the token, where the token was found (header, query or cookie),
+	// the client IP and the failure reason. The token is always redacted.
+	Audit bool `json:"audit"`
+
+	// ClaimsSchema is the path or the URL of a JSON Schema document, which
+	// the full payload of the tokens must satisfy before the policies are
+	// evaluated, so the token structure contracts of the issuers can be
+	// enforced at the edge.
+	ClaimsSchema string `json:"claims_schema"`
+
+	// ValidateExpression is a CEL expression over the claims of the token,
+	// which must evaluate to true, for the rules Require can't express, e.g.
+	// `claims.role == "admin" && "payments" in claims.scopes`. The claims are
+	// available as the map "claims". A claim missing from the token fails
+	// the evaluation, use `has(claims.x)` to check it first.
+	ValidateExpression string `json:"validate_expression"`
+
+	// RequestIDHeader is the request header carrying the correlation ID of
+	// the request, which is logged as "request_id" in every auth log line.
+	// Without it, Caddy's request UUID is used. Defaults to "X-Request-Id".
+	// The ID is also available as the {http.auth.jwt.request_id} placeholder,
+	// e.g. to put it in the body of the error responses by handle_errors.
+	RequestIDHeader string `json:"request_id_header"`
+
+	// ExposeRequestID, if true, echoes the correlation ID in the
+	// RequestIDHeader of the responses to the denied requests, so users can
+	// report it to the support teams.
+	ExposeRequestID bool `json:"expose_request_id"`
+
+	// Enrich, if set, calls an external HTTP endpoint to get extra attributes
+	// of the authenticated user, and merges them into the user metadata.
+	Enrich *Enrichment `json:"enrich"`
+
+	// UserInfo, if set, calls the OIDC userinfo endpoint with the token and
+	// merges the returned claims into the user metadata, so thin access
+	// tokens can still yield names/emails for the upstream.
+	UserInfo *UserInfoEnrichment `json:"userinfo"`
+
+	// ClaimsAnomaly, if set, reports the users showing up with a combination
+	// of claims, e.g. roles, never seen before for them.
+	ClaimsAnomaly *ClaimsAnomaly `json:"claims_anomaly"`
+
+	// Introspection, if set, authenticates the opaque tokens, which are not
+	// JWTs, by the OAuth 2.0 token introspection endpoint.
+	Introspection *Introspection `json:"introspection"`
+
+	// ALBOIDCData, if set, authenticates the user claims AWS ALB passes in
+	// the "x-amzn-oidc-data" header, of the ALBs trusted, looked up before
+	// the other tokens.
+	ALBOIDCData *ALBOIDCData `json:"alb_oidc_data,omitempty"`
+
+	// ValidationCache, if set, caches the outcomes of the signature
+	// verification of the tokens, for the high-RPS APIs where the same
+	// tokens are presented over and over.
+	ValidationCache *ValidationCache `json:"validation_cache"`
+
+	// DPoP, if set, verifies the DPoP proofs of the access tokens bound to
+	// the keys of the clients, so Caddy acts as the resource server of the
+	// sender-constrained tokens, see RFC 9449. The tokens are accepted
+	// with the "DPoP" authorization scheme as well.
+	DPoP *DPoP `json:"dpop"`
+
+	// UpstreamBasicAuth, if set, replaces the Authorization header of the
+	// request going upstream with `Basic base64(<username>:<password>)` built
+	// from the claims of the token. It's useful to front legacy services which
+	// only understand basic auth.
+	UpstreamBasicAuth *UpstreamBasicAuth `json:"upstream_basic_auth"`
+
+	// Revocation, if set, rejects the tokens whose "jti" claims are on a
+	// blocklist.
+	Revocation *Revocation `json:"revocation"`
+
+	// OneTimeTokens, if set, accepts each token once only, by its "jti"
+	// claim, e.g. for the webhook-style endpoints.
+	OneTimeTokens *OneTimeTokens `json:"one_time_tokens"`
+
+	// DenyWebhook, if set, posts a summary of each request denied for a bad
+	// token to a URL asynchronously.
+	DenyWebhook *DenyWebhook `json:"deny_webhook"`
+
+	// Maintenance, if set, is a switch under which only the tokens carrying
+	// the configured claims are admitted, and everyone else receives a
+	// maintenance page.
+	Maintenance *Maintenance `json:"maintenance"`
+
+	// FailureRateLimit, if set, responds 429 to the clients failing the
+	// authentication too often, sparing the signature verification from
+	// the brute-force and token-stuffing load.
+	FailureRateLimit *FailureRateLimit `json:"failure_rate_limit"`
+
+	// IssueSessionCookie, if set, exchanges the verified tokens for the
+	// short-lived session cookies signed by HMAC, sparing the subsequent
+	// requests the verification by the JWKs, see SessionCookie.
+	IssueSessionCookie *SessionCookie `json:"issue_session_cookie"`
+
+	// ContextToken, if set, requires a second token, e.g. a context JWT
+	// signed by a partner in a custom header, verified by its own keys and
+	// policies. Both tokens must be valid.
+	ContextToken *ContextToken `json:"context_token"`
+
+	// BearerChallenge, if set, adds the WWW-Authenticate header of RFC 6750
+	// to the responses on failures, with the error code "invalid_token" or
+	// "insufficient_scope".
+	BearerChallenge *BearerChallenge `json:"bearer_challenge"`
+
+	// FailureResponse, if set, customizes the response to the requests
+	// failing the authentication, i.e. the status code, the body and the
+	// headers.
+	FailureResponse *FailureResponse `json:"failure_response"`
+
+	// Redaction controls what of the presented tokens may be reflected in
+	// the logs. The tokens and their claims are never reflected in the
+	// responses, e.g. the .Error of FailureResponse is the generic message
+	// of the failure reason, like the error_description of BearerChallenge:
+	//
+	//   - "strict": the logs don't carry the desensitized tokens either,
+	//     e.g. for the PCI-scoped deployments
+	//   - "responses": the default, kept for the existing configs
+	Redaction string `json:"redaction"`
+
+	// Name identifies the provider in the admin API, which can patch its
+	// IssuerWhitelist, AudienceWhitelist and ClaimPolicies while running,
+	// e.g. `PATCH /jwtauth/providers/<name>/issuer_whitelist`, without
+	// reloading the config. The patches are lost on the next config reload.
+	Name string `json:"name"`
+
 	logger        *zap.Logger
-	parsedSignKey interface{} // can be []byte, *rsa.PublicKey, *ecdsa.PublicKey, etc.
+	auditLogger   *zap.Logger            // nil unless Audit
+	parsedSignKey interface{}            // can be []byte, *rsa.PublicKey, *ecdsa.PublicKey, etc.
+	keyAlgorithm  jwa.SignatureAlgorithm // inferred from parsedSignKey, empty if ambiguous
+	signKeyMu     *sync.RWMutex          // guards parsedSignKey and keyAlgorithm, swapped by SignKeyFile
+
+	parsedDecryptKey interface{} // can be []byte, *rsa.PrivateKey, *ecdsa.PrivateKey, etc.
 
 	jwkCache     *jwk.Cache
+	jwkMu        *sync.RWMutex // guards jwkURL, jwkCachedSet and jwkIndex, switched by OIDC discovery
+	jwkURL       string
 	jwkCachedSet jwk.Set
+	jwkIndex     *keyIndex // of the last fetch, or of JWKFile
+	jwkLoadErr   error     // of discovering or fetching the JWKs in use, nil once fetched
+	jwkExpiry    *jwkExpiry
+	jwkTenants   *jwkTenants       // of the JWKURL resolved per request
+	kidRefetches *kidRefetches     // of the JWKs refetched on unknown kids
+	sourceOrder  []tokenSource     // of SourcePriority
+	userIDFormat *userIDFormat     // of UserIDFormat
+	storage      certmagic.Storage // of Caddy, for SharedJWKs
+	// stopJWKLoader stops the background jobs of the JWK loader, i.e. the
+	// JWK cache, the OIDC rediscovery and the refreshes ahead of expiry, and
+	// waits for the latter two
+	stopJWKLoader context.CancelFunc
+	// stopKeyWatcher stops watching SignKeyFile or JWKFile
+	stopKeyWatcher func()
+
+	workers         chan struct{} // semaphore of VerificationWorkers
+	subjectPatterns []*regexp.Regexp
+	claimChecks     []claimCheck
+	rolesClaim      string // resolved RolesClaim
+	standardClaims  *standardClaimsValidation
+	exceptPaths     []*regexp.Regexp
+	headerPrefixes  map[string]*regexp.Regexp
+	validateProgram cel.Program // compiled ValidateExpression
+	claimsSchema    *jsonschema.Schema
+	contextOnly     bool // verifying the context token, see ContextToken
+	live            *livePolicyHolder
 }
 
 // CaddyModule implements caddy.Module interface.
@@ -151,92 +812,681 @@ func (JWTAuth) CaddyModule() caddy.ModuleInfo {
 // Provision implements caddy.Provisioner interface.
 func (ja *JWTAuth) Provision(ctx caddy.Context) error {
 	ja.logger = ctx.Logger(ja)
+	if ja.Audit {
+		ja.auditLogger = caddy.Log().Named(auditLoggerName)
+	}
+	if ja.SharedJWKs != nil && ja.storage == nil {
+		ja.storage = ctx.Storage()
+	}
+	if ja.Revocation != nil {
+		if err := ja.Revocation.provision(ctx); err != nil {
+			return fmt.Errorf("invalid revocation: %w", err)
+		}
+	}
+	if ja.OneTimeTokens != nil {
+		if err := ja.OneTimeTokens.provision(ctx); err != nil {
+			return fmt.Errorf("invalid one_time_tokens: %w", err)
+		}
+	}
+	if ja.ContextToken != nil && ja.ContextToken.Provider != nil {
+		if err := ja.ContextToken.Provider.Provision(ctx); err != nil {
+			return fmt.Errorf("invalid context_token: %w", err)
+		}
+	}
+	return nil
+}
+
+// Cleanup implements caddy.CleanerUpper interface.
+func (ja *JWTAuth) Cleanup() error {
+	if ja.Name != "" {
+		unregisterNamedProvider(ja)
+	}
+	unregisterJWKProvider(ja)
+	if ja.jwkTenants != nil {
+		ja.jwkTenants.cleanup()
+	}
+	if ja.stopJWKLoader != nil {
+		ja.stopJWKLoader()
+	}
+	if ja.stopKeyWatcher != nil {
+		ja.stopKeyWatcher()
+	}
+	if ja.Enrich != nil && ja.Enrich.cache != nil {
+		ja.Enrich.cleanup()
+	}
+	if ja.UserInfo != nil && ja.UserInfo.cache != nil {
+		ja.UserInfo.cleanup()
+	}
+	if ja.ClaimsAnomaly != nil && ja.ClaimsAnomaly.cache != nil {
+		ja.ClaimsAnomaly.cleanup()
+	}
+	for _, keys := range ja.Issuers {
+		if keys != nil && keys.provider != nil {
+			keys.provider.Cleanup()
+		}
+	}
+	if ja.DenyWebhook != nil && ja.DenyWebhook.queue != nil {
+		ja.DenyWebhook.cleanup()
+	}
+	if ja.Maintenance != nil {
+		unregisterMaintenanceSwitch(ja)
+	}
+	if ja.ContextToken != nil && ja.ContextToken.Provider != nil {
+		ja.ContextToken.Provider.Cleanup()
+	}
+	if ja.SandboxParsing != nil && ja.SandboxParsing.stop != nil {
+		ja.SandboxParsing.cleanup()
+	}
+	return nil
+}
+
+// Error implements httprc.ErrSink interface.
+// It is used to log the error message provided by other modules, e.g. jwk.
+func (ja *JWTAuth) Error(err error) {
+	ja.logger.Error("error", zap.Error(err))
+}
+
+func (ja *JWTAuth) usingJWK() bool {
+	return ja.SignKey == "" && ja.SignKeyFile == "" && (ja.JWKURL != "" || ja.OIDCIssuer != "" || ja.JWKFile != "" || ja.usingStaticJWKs() || ja.Vault != nil)
+}
+
+// usingStaticJWKs reports whether the JWKs are from JWKSets and JWKFiles.
+func (ja *JWTAuth) usingStaticJWKs() bool {
+	return len(ja.JWKSets) > 0 || len(ja.JWKFiles) > 0
+}
+
+// setupJWKLoader starts the JWK cache and the background jobs loading the
+// JWKs, which run until stopJWKLoader.
+func (ja *JWTAuth) setupJWKLoader() {
+	ctx, cancel := context.WithCancel(context.Background())
+	var jobs sync.WaitGroup
+	ja.stopJWKLoader = func() {
+		cancel()
+		jobs.Wait()
+	}
+	ja.jwkCache = jwk.NewCache(ctx, jwk.WithErrSink(ja)) // stopped with the context
+	ja.jwkMu = new(sync.RWMutex)
+	ja.jwkExpiry = newJWKExpiry()
+	if ja.SharedJWKs != nil {
+		ja.jwkExpiry.client = &sharedJWKsClient{
+			storage: ja.storage,
+			maxAge:  time.Duration(ja.SharedJWKs.MaxAge),
+			next:    ja.jwkExpiry.client,
+			logger:  ja.logger,
+		}
+	}
+	jobs.Add(1)
+	go func() {
+		defer jobs.Done()
+		ja.refreshAhead(ctx)
+	}()
+	if ja.JWKURL != "" {
+		ja.useJWKURL(ja.JWKURL)
+		return
+	}
+
+	// ignore any error discovering the JWKS endpoint now as it may not be available at startup
+	err := ja.discoverJWKURL(ctx)
+	if err != nil {
+		ja.logger.Error("failed to discover JWKs URL", zap.String("oidc_issuer", ja.OIDCIssuer), zap.Error(err))
+		ja.jwkMu.Lock()
+		ja.jwkLoadErr = err
+		ja.jwkMu.Unlock()
+	}
+	jobs.Add(1)
+	go func() {
+		defer jobs.Done()
+		ja.rediscoverJWKURL(ctx, err == nil)
+	}()
+}
+
+// useJWKURL switches to the JWKs published at the URL.
+func (ja *JWTAuth) useJWKURL(url string) {
+	if !ja.jwkCache.IsRegistered(url) {
+		options := []jwk.RegisterOption{jwk.WithHTTPClient(ja.jwkExpiry), jwk.WithPostFetcher(jwk.PostFetchFunc(ja.postFetchJWKs))}
+		if ja.JWKRefreshInterval > 0 {
+			options = append(options, jwk.WithRefreshInterval(time.Duration(ja.JWKRefreshInterval)))
+		}
+		ja.jwkCache.Register(url, options...)
+	}
+	// ignore any error loading the JWKS endpoint now as it may not be available at startup,
+	// unless RequireKeysAtStartup
+	_, err := ja.jwkCache.Refresh(context.Background(), url)
+	set := jwk.NewCachedSet(ja.jwkCache, url)
+
+	ja.jwkMu.Lock()
+	previous := ja.jwkURL
+	ja.jwkURL, ja.jwkCachedSet, ja.jwkLoadErr = url, set, err
+	ja.jwkMu.Unlock()
+	if previous != "" && previous != url {
+		_ = ja.jwkCache.Unregister(previous)
+	}
+	ja.logger.Info("using JWKs from URL", zap.String("url", url), zap.Int("loaded_keys", set.Len()))
+}
+
+// jwks returns the URL and the cached set of the JWKs in use, the set is nil
+// if not discovered yet.
+func (ja *JWTAuth) jwks() (string, jwk.Set) {
+	ja.jwkMu.RLock()
+	defer ja.jwkMu.RUnlock()
+	return ja.jwkURL, ja.jwkCachedSet
+}
+
+// postFetchJWKs indexes the JWKs fetched, after tracking their expiry.
+func (ja *JWTAuth) postFetchJWKs(url string, set jwk.Set) (jwk.Set, error) {
+	set, err := ja.jwkExpiry.PostFetch(url, set)
+	if err != nil {
+		return nil, err
+	}
+	idx := indexKeySet(url, set)
+	ja.jwkMu.Lock()
+	ja.jwkIndex, ja.jwkLoadErr = idx, nil
+	ja.jwkMu.Unlock()
+	return set, nil
+}
+
+// jwkIndexOf returns the index of the JWKs from the URL, nil if not indexed.
+func (ja *JWTAuth) jwkIndexOf(url string) *keyIndex {
+	ja.jwkMu.RLock()
+	defer ja.jwkMu.RUnlock()
+	if ja.jwkIndex == nil || ja.jwkIndex.source != url {
+		return nil
+	}
+	return ja.jwkIndex
+}
+
+// refreshJWKCache refreshes the JWK cache. It validates the JWKs from the given URL.
+func (ja *JWTAuth) refreshJWKCache() error {
+	if ja.JWKFile != "" {
+		return ja.loadJWKFile()
+	}
+	if ja.Vault != nil {
+		if !ja.Vault.refetchAllowed() {
+			return nil
+		}
+		return ja.loadVaultKeys(context.Background())
+	}
+	if ja.usingStaticJWKs() {
+		return nil // never change
+	}
+	if ja.jwkTenants != nil {
+		return ja.jwkTenants.refresh()
+	}
+	url, _ := ja.jwks()
+	if url == "" {
+		return fmt.Errorf("JWKs URL not discovered yet")
+	}
+	metrics.jwksRefreshInProgress.Inc()
+	defer metrics.jwksRefreshInProgress.Dec()
+	_, err := ja.jwkCache.Refresh(context.Background(), url)
+	return err
+}
+
+// loadSignKey parses the sign_key and checks the sign_alg against it. The
+// key is swapped in only if valid.
+func (ja *JWTAuth) loadSignKey(signKey string) error {
+	var (
+		parsed       interface{}
+		keyAlgorithm jwa.SignatureAlgorithm
+	)
+	if keyBytes, asymmetric, err := parseSignKey(signKey); err != nil {
+		// Key(step 1): base64 -> raw bytes.
+		return fmt.Errorf("invalid sign_key: %w", err)
+	} else {
+		// Key(step 2): raw bytes -> parsed key.
+		if !asymmetric {
+			parsed = keyBytes
+		} else if parsed, err = x509.ParsePKIXPublicKey(keyBytes); err != nil {
+			return fmt.Errorf("invalid sign_key (asymmetric): %w", err)
+		}
+		keyAlgorithm = inferSignAlgorithm(parsed)
+
+		if ja.SignAlgorithm != "" {
+			var alg jwa.SignatureAlgorithm
+			if err := alg.Accept(ja.SignAlgorithm); err != nil {
+				return fmt.Errorf("%w: %v", ErrInvalidSignAlgorithm, err)
+			}
+			if keyAlgorithm != "" && alg != keyAlgorithm {
+				return fmt.Errorf("%w: %s mismatches the sign_key, expect %s", ErrInvalidSignAlgorithm, alg, keyAlgorithm)
+			}
+		}
+	}
+
+	ja.signKeyMu.Lock()
+	ja.parsedSignKey, ja.keyAlgorithm = parsed, keyAlgorithm
+	ja.signKeyMu.Unlock()
+	return nil
+}
+
+// Validate implements caddy.Validator interface.
+func (ja *JWTAuth) Validate() error {
+	ja.replaceConfigPlaceholders()
+	if ja.JWKURLTenants != nil && !ja.dynamicJWKURL() {
+		return fmt.Errorf("invalid jwk_url_tenants: jwk_url %q has no placeholders to resolve per request", ja.JWKURL)
+	}
+	if ja.SignKey != "" && ja.SignKeyFile != "" {
+		return fmt.Errorf("invalid sign_key: sign_key and sign_key_file are mutually exclusive")
+	}
+	if ja.JWKFile != "" && (ja.JWKURL != "" || ja.OIDCIssuer != "") {
+		return fmt.Errorf("invalid jwk_file: jwk_file excludes jwk_url and oidc_issuer")
+	}
+	if ja.usingStaticJWKs() && (ja.JWKURL != "" || ja.OIDCIssuer != "" || ja.JWKFile != "") {
+		return fmt.Errorf("invalid jwk_sets: jwk_sets and jwk_files exclude jwk_url, oidc_issuer and jwk_file")
+	}
+	if ja.Vault != nil && (ja.SignKey != "" || ja.SignKeyFile != "" || ja.JWKURL != "" || ja.OIDCIssuer != "" || ja.JWKFile != "" || ja.usingStaticJWKs()) {
+		return fmt.Errorf("invalid vault: vault excludes the other key sources")
+	}
+	if ja.SharedJWKs != nil {
+		if err := ja.SharedJWKs.provision(); err != nil {
+			return fmt.Errorf("invalid shared_jwks: %w", err)
+		}
+		if ja.storage == nil {
+			return fmt.Errorf("invalid shared_jwks: storage unavailable")
+		}
+	}
+	if err := ja.validateAllowedAlgorithms(); err != nil {
+		return fmt.Errorf("invalid allowed_algorithms: %w", err)
+	}
+	ja.signKeyMu = new(sync.RWMutex)
+	switch {
+	case ja.usingJWK() && ja.JWKFile != "":
+		if err := ja.setupJWKFile(); err != nil {
+			return fmt.Errorf("invalid jwk_file: %w", err)
+		}
+	case ja.usingJWK() && ja.usingStaticJWKs():
+		if err := ja.setupStaticJWKs(); err != nil {
+			return err
+		}
+	case ja.usingJWK() && ja.Vault != nil:
+		if err := ja.setupVault(); err != nil {
+			return err
+		}
+	case ja.usingJWK() && ja.dynamicJWKURL():
+		if err := ja.setupJWKTenants(); err != nil {
+			return err
+		}
+	case ja.usingJWK():
+		ja.setupJWKLoader()
+	case ja.SignKeyFile != "":
+		if err := ja.setupSignKeyFile(); err != nil {
+			return fmt.Errorf("invalid sign_key_file: %w", err)
+		}
+	case ja.SignKey == "" && (len(ja.Issuers) > 0 || ja.KeyResolver != nil || ja.ALBOIDCData != nil):
+		// no default keys, see Issuers, KeyResolver and ALBOIDCData
+	default:
+		if err := ja.loadSignKey(ja.SignKey); err != nil {
+			return err
+		}
+	}
+
+	if err := loadBlockedKIDs(); err != nil {
+		return fmt.Errorf("loading blocked kids: %w", err)
+	}
+
+	if ja.MaxDecompressedSize < 0 {
+		return fmt.Errorf("invalid max_decompressed_size: %d", ja.MaxDecompressedSize)
+	}
+	if ja.JWKRefreshInterval < 0 {
+		return fmt.Errorf("invalid jwk_refresh_interval: %s", time.Duration(ja.JWKRefreshInterval))
+	}
+	if ja.KeyNotFoundBackoff < 0 {
+		return fmt.Errorf("invalid key_not_found_backoff: %s", time.Duration(ja.KeyNotFoundBackoff))
+	}
+	if ja.usingJWK() && !ja.usingStaticJWKs() {
+		backoff := time.Duration(ja.KeyNotFoundBackoff)
+		if backoff == 0 {
+			backoff = defaultKeyNotFoundBackoff
+		}
+		ja.kidRefetches = newKIDRefetches(backoff)
+	}
+	var err error
+	if ja.parsedDecryptKey, err = ja.loadDecryptKey(); err != nil {
+		return fmt.Errorf("invalid decrypt_key: %w", err)
+	}
+
+	for alias, canonical := range ja.IssuerAliases {
+		if alias == "" || canonical == "" || alias == canonical {
+			return fmt.Errorf("invalid issuer_aliases: %q -> %q", alias, canonical)
+		}
+	}
+	if ja.OIDCIssuer != "" && len(ja.IssuerWhitelist) == 0 {
+		ja.IssuerWhitelist = []string{ja.OIDCIssuer}
+	}
+	if ja.userIDFormat, err = compileUserIDFormat(ja.UserIDFormat); err != nil {
+		return fmt.Errorf("invalid user_id_format: %w", err)
+	}
+	if ja.userIDFormat != nil && len(ja.UserClaims) > 0 {
+		return fmt.Errorf("invalid user_id_format: user_id_format and user_claims are mutually exclusive")
+	}
+	if ja.userIDFormat == nil && len(ja.UserClaims) == 0 {
+		ja.UserClaims = []string{
+			"sub",
+		}
+	}
+	for claim, placeholder := range ja.MetaClaims {
+		if claim == "" || placeholder == "" {
+			return fmt.Errorf("invalid meta claim: %s -> %s", claim, placeholder)
+		}
+		if ja.MetaClaimsJSON && placeholder == metadataJSONKey {
+			return fmt.Errorf("invalid meta claim: %s -> %s: reserved by meta_claims_json", claim, placeholder)
+		}
+	}
+	if err := validateForwardClaimsHeader(ja.ForwardClaimsHeader); err != nil {
+		return fmt.Errorf("invalid forward_claims_header: %w", err)
+	}
+	if err := validateBindClaims(ja.BindClaims); err != nil {
+		return fmt.Errorf("invalid bind_claims: %w", err)
+	}
+	if err := validateForwardedClaims(ja.ForwardedClaims); err != nil {
+		return fmt.Errorf("invalid forwarded_claims: %w", err)
+	}
+	if err := validateNormalizeToken(ja.NormalizeToken); err != nil {
+		return fmt.Errorf("invalid normalize_token: %w", err)
+	}
+	if ja.headerPrefixes, err = compileHeaderPrefixes(ja.HeaderPrefix); err != nil {
+		return fmt.Errorf("invalid header_prefix: %w", err)
+	}
+	if ja.sourceOrder, err = compileSourceOrder(ja.SourcePriority); err != nil {
+		return fmt.Errorf("invalid source_priority: %w", err)
+	}
+	if err := validateQueryTokenPolicy(ja.QueryTokenPolicy); err != nil {
+		return fmt.Errorf("invalid query_token_policy: %w", err)
+	}
+	if ja.RequestIDHeader == "" {
+		ja.RequestIDHeader = "X-Request-Id"
+	}
+	if ja.ExpiredRedirect != "" && ja.ExpiredFlashCookie == "" {
+		ja.ExpiredFlashCookie = "jwt_flash"
+	}
+	if ja.UpstreamBasicAuth != nil && ja.UpstreamBasicAuth.PasswordClaim != "" && ja.UpstreamBasicAuth.Password != "" {
+		return fmt.Errorf("invalid upstream_basic_auth: password_claim and password are mutually exclusive")
+	}
+	if ja.Enrich != nil {
+		if err := ja.Enrich.provision(); err != nil {
+			return fmt.Errorf("invalid enrich: %w", err)
+		}
+	}
+	if ja.ValidationCache != nil {
+		if err := ja.ValidationCache.provision(); err != nil {
+			return fmt.Errorf("invalid validation_cache: %w", err)
+		}
+	}
+	if ja.DPoP != nil {
+		if err := ja.DPoP.provision(); err != nil {
+			return fmt.Errorf("invalid dpop: %w", err)
+		}
+	}
+	for issuer, keys := range ja.Issuers {
+		if keys == nil {
+			return fmt.Errorf("invalid issuers %q: missing keys", issuer)
+		}
+		if err := keys.provision(ja.logger.With(zap.String("issuer", issuer))); err != nil {
+			return fmt.Errorf("invalid issuers %q: %w", issuer, err)
+		}
+	}
+	if ja.RequireKeysAtStartup != nil {
+		if err := ja.checkKeysAtStartup(); err != nil {
+			return fmt.Errorf("invalid require_keys_at_startup: %w", err)
+		}
+	}
+	if ja.ClaimsAnomaly != nil {
+		if err := ja.ClaimsAnomaly.provision(); err != nil {
+			return fmt.Errorf("invalid claims_anomaly: %w", err)
+		}
+	}
+	if err := validatePrincipalType(ja.PrincipalType); err != nil {
+		return fmt.Errorf("invalid principal_type %q: %w", ja.PrincipalType, err)
+	}
+	if ja.RequireEnv != "" && ja.EnvClaim == "" {
+		ja.EnvClaim = defaultEnvClaim
+	}
+	ja.subjectPatterns = nil
+	for _, pattern := range ja.SubjectPattern {
+		re, err := compileSubjectPattern(pattern)
+		if err != nil {
+			return fmt.Errorf("invalid subject_pattern %q: %w", pattern, err)
+		}
+		ja.subjectPatterns = append(ja.subjectPatterns, re)
+	}
+	ja.exceptPaths = nil
+	for _, pattern := range ja.ExceptPaths {
+		re, err := compileSubjectPattern(pattern)
+		if err != nil {
+			return fmt.Errorf("invalid except_paths %q: %w", pattern, err)
+		}
+		ja.exceptPaths = append(ja.exceptPaths, re)
+	}
+	if ja.VerificationWorkers < 0 {
+		return fmt.Errorf("invalid verification_workers: %d", ja.VerificationWorkers)
+	}
+	if ja.VerificationWorkers > 0 {
+		ja.workers = make(chan struct{}, ja.VerificationWorkers)
+	}
+	for _, req := range ja.Require {
+		if req.Claim == "" || len(req.Values) == 0 {
+			return fmt.Errorf("invalid require: claim %q requires at least one value", req.Claim)
+		}
+	}
+	ja.claimChecks = nil
+	for _, expr := range ja.VerifyClaims {
+		c, err := compileClaimCheck(expr)
+		if err != nil {
+			return fmt.Errorf("invalid verify_claims: %w", err)
+		}
+		ja.claimChecks = append(ja.claimChecks, c)
+	}
+	if ja.claimsSchema, err = compileClaimsSchema(ja.ClaimsSchema); err != nil {
+		return fmt.Errorf("invalid claims_schema: %w", err)
+	}
+	if ja.validateProgram, err = compileExpression(ja.ValidateExpression); err != nil {
+		return fmt.Errorf("invalid validate_expression: %w", err)
+	}
+	if err := validateClaimPolicies(ja.ClaimPolicies); err != nil {
+		return fmt.Errorf("invalid claim_policies: %w", err)
+	}
+	if ja.Introspection != nil {
+		if err := ja.Introspection.provision(); err != nil {
+			return fmt.Errorf("invalid introspection: %w", err)
+		}
+	}
+	if ja.ALBOIDCData != nil {
+		if err := ja.ALBOIDCData.provision(); err != nil {
+			return fmt.Errorf("invalid alb_oidc_data: %w", err)
+		}
+	}
+	if ja.UserInfo != nil {
+		if err := ja.UserInfo.provision(); err != nil {
+			return fmt.Errorf("invalid userinfo: %w", err)
+		}
+	}
+	if ja.DenyWebhook != nil {
+		if err := ja.DenyWebhook.provision(ja.logger); err != nil {
+			return fmt.Errorf("invalid deny_webhook: %w", err)
+		}
+	}
+	if ja.FailureRateLimit != nil {
+		if err := ja.FailureRateLimit.provision(); err != nil {
+			return fmt.Errorf("invalid failure_rate_limit: %w", err)
+		}
+	}
+	if ja.OneTimeTokens != nil && ja.IssueSessionCookie != nil {
+		return fmt.Errorf("invalid one_time_tokens: excludes issue_session_cookie")
+	}
+	if ja.IssueSessionCookie != nil {
+		if err := ja.IssueSessionCookie.provision(); err != nil {
+			return fmt.Errorf("invalid issue_session_cookie: %w", err)
+		}
+	}
+	if ja.Maintenance != nil {
+		if err := ja.Maintenance.provision(); err != nil {
+			return fmt.Errorf("invalid maintenance: %w", err)
+		}
+		registerMaintenanceSwitch(ja)
+	}
+	if ja.ContextToken != nil {
+		if err := ja.ContextToken.provision(ja.logger); err != nil {
+			return fmt.Errorf("invalid context_token: %w", err)
+		}
+	}
+	if ja.FailureResponse != nil {
+		if err := ja.FailureResponse.provision(); err != nil {
+			return fmt.Errorf("invalid failure_response: %w", err)
+		}
+	}
+	if ja.PolicyTraceHeader != "" && ja.PolicyTraceSecret == "" {
+		return fmt.Errorf("invalid policy_trace_header: missing secret")
+	}
+	if err := validateRedaction(ja.Redaction); err != nil {
+		return fmt.Errorf("invalid redaction %q: %w", ja.Redaction, err)
+	}
+	if ja.MapGroups != nil {
+		if err := ja.MapGroups.provision(); err != nil {
+			return fmt.Errorf("invalid map_groups: %w", err)
+		}
+	}
+	if len(ja.RequireRole) > 0 && ja.RolesClaim == "" && ja.MapGroups == nil {
+		ja.RolesClaim = "roles"
+	}
+	ja.rolesClaim = rolesClaimOf(ja.RolesClaim)
+	ja.standardClaims = ja.compileStandardClaims()
+	switch ja.ScopeMatch {
+	case "":
+		ja.ScopeMatch = "all"
+	case "all", "any":
+	default:
+		return fmt.Errorf("invalid scope_match: %q", ja.ScopeMatch)
+	}
+	if ja.SandboxParsing != nil {
+		if err := ja.SandboxParsing.provision(ja.logger); err != nil {
+			return fmt.Errorf("invalid sandbox_parsing: %w", err)
+		}
+	}
+	if ja.Leeway < 0 {
+		return fmt.Errorf("invalid leeway: %s", time.Duration(ja.Leeway))
+	}
+	if ja.MaxTokenAge < 0 {
+		return fmt.Errorf("invalid max_token_age: %s", time.Duration(ja.MaxTokenAge))
+	}
+	if ja.ExpiredGrace < 0 {
+		return fmt.Errorf("invalid expired_grace: %s", time.Duration(ja.ExpiredGrace))
+	}
+	if ja.ExpiringWindow < 0 {
+		return fmt.Errorf("invalid expiring_window: %s", time.Duration(ja.ExpiringWindow))
+	}
+	if ja.ExpiringWindow > 0 && ja.ExpiringHeader == "" {
+		ja.ExpiringHeader = "X-Token-Expiring"
+	}
+	ja.initLivePolicy()
+	if ja.Name != "" {
+		registerNamedProvider(ja)
+	}
+	if ja.usingJWK() {
+		registerJWKProvider(ja)
+	}
 	return nil
 }
 
-// Error implements httprc.ErrSink interface.
-// It is used to log the error message provided by other modules, e.g. jwk.
-func (ja *JWTAuth) Error(err error) {
-	ja.logger.Error("error", zap.Error(err))
-}
-
-func (ja *JWTAuth) usingJWK() bool {
-	return ja.SignKey == "" && ja.JWKURL != ""
-}
-
-func (ja *JWTAuth) setupJWKLoader() {
-	cache := jwk.NewCache(context.Background(), jwk.WithErrSink(ja))
-	cache.Register(ja.JWKURL)
-	ja.jwkCache = cache
-	// ignore any error loading the JWKS endpoint now as it may not be available at startup
-	_ = ja.refreshJWKCache()
-	ja.jwkCachedSet = jwk.NewCachedSet(cache, ja.JWKURL)
-	ja.logger.Info("using JWKs from URL", zap.String("url", ja.JWKURL), zap.Int("loaded_keys", ja.jwkCachedSet.Len()))
+// keyProvenance describes the trust anchor which provided the key to verify
+// a token, for auditing purposes.
+type keyProvenance struct {
+	Source   string // "sign_key", "jwk_url", "jwk_file", "jwk_static", "vault", "alb", "key_resolver", "introspection" or "session"
+	Location string // e.g. the JWKS URL, empty for sign_key
+	KeyID    string // "kid" of the key, if any
 }
 
-// refreshJWKCache refreshes the JWK cache. It validates the JWKs from the given URL.
-func (ja *JWTAuth) refreshJWKCache() error {
-	_, err := ja.jwkCache.Refresh(context.Background(), ja.JWKURL)
-	return err
+func (kp *keyProvenance) zapFields() []zap.Field {
+	return []zap.Field{
+		zap.String("key_source", kp.Source),
+		zap.String("key_location", kp.Location),
+		zap.String("kid", kp.KeyID),
+	}
 }
 
-// Validate implements caddy.Validator interface.
-func (ja *JWTAuth) Validate() error {
-	if ja.usingJWK() {
-		ja.setupJWKLoader()
-	} else {
-		if keyBytes, asymmetric, err := parseSignKey(ja.SignKey); err != nil {
-			// Key(step 1): base64 -> raw bytes.
-			return fmt.Errorf("invalid sign_key: %w", err)
-		} else {
-			// Key(step 2): raw bytes -> parsed key.
-			if !asymmetric {
-				ja.parsedSignKey = keyBytes
-			} else if ja.parsedSignKey, err = x509.ParsePKIXPublicKey(keyBytes); err != nil {
-				return fmt.Errorf("invalid sign_key (asymmetric): %w", err)
-			}
-
-			if ja.SignAlgorithm != "" {
-				var alg jwa.SignatureAlgorithm
-				if err := alg.Accept(ja.SignAlgorithm); err != nil {
-					return fmt.Errorf("%w: %v", ErrInvalidSignAlgorithm, err)
-				}
-			}
+// keyProvider returns the key provider to verify a token of the request of
+// the context, it records the provenance of the key provided into kp.
+func (ja *JWTAuth) keyProvider(reqCtx context.Context, kp *keyProvenance) jws.KeyProviderFunc {
+	return func(ctx context.Context, sink jws.KeySink, sig *jws.Signature, msg *jws.Message) error {
+		kp.KeyID = sig.ProtectedHeaders().KeyID()
+		if err := ja.checkKID(kp.KeyID); err != nil {
+			return err
 		}
-	}
-
-	if len(ja.UserClaims) == 0 {
-		ja.UserClaims = []string{
-			"sub",
+		if err := ja.checkAlgorithm(sig.ProtectedHeaders().Algorithm()); err != nil {
+			return err
 		}
-	}
-	for claim, placeholder := range ja.MetaClaims {
-		if claim == "" || placeholder == "" {
-			return fmt.Errorf("invalid meta claim: %s -> %s", claim, placeholder)
+		if ok, err := ja.resolveKey(ctx, kp, sink, sig); err != nil || ok {
+			return err
+		}
+		if provider, ok, err := ja.issuerKeyProvider(reqCtx, kp, msg); err != nil {
+			return err
+		} else if ok {
+			return provider.FetchKeys(ctx, sink, sig, msg)
+		}
+		if ja.jwkTenants != nil {
+			tenant, err := ja.jwkTenants.providerOf(reqCtx)
+			if err != nil {
+				kp.Source, kp.Location = "jwk_url", ja.JWKURL
+				return fmt.Errorf("%w: %v", ErrKeyNotFound, err)
+			}
+			return tenant.keyProvider(reqCtx, kp)(ctx, sink, sig, msg)
 		}
-	}
-	return nil
-}
-
-func (ja *JWTAuth) keyProvider() jws.KeyProviderFunc {
-	return func(_ context.Context, sink jws.KeySink, sig *jws.Signature, _ *jws.Message) error {
 		if ja.usingJWK() {
-			kid := sig.ProtectedHeaders().KeyID()
-			key, found := ja.jwkCachedSet.LookupKeyID(kid)
-			if !found {
-				// trigger a refresh if the key is not found
+			url, set := ja.jwks()
+			kp.Source, kp.Location = "jwk_url", url
+			if ja.JWKFile != "" {
+				kp.Source = "jwk_file"
+			} else if ja.usingStaticJWKs() {
+				kp.Source, kp.Location = "jwk_static", ""
+			} else if ja.Vault != nil {
+				kp.Source = "vault"
+			}
+			idx := ja.jwkIndexOf(url)
+			if idx == nil && ja.Vault != nil {
 				go ja.refreshJWKCache()
-
+				return fmt.Errorf("%w: JWKs not fetched yet from %q", ErrKeyNotFound, url)
+			}
+			if set == nil && idx == nil {
+				return fmt.Errorf("%w: JWKs URL not discovered yet from %q", ErrKeyNotFound, ja.OIDCIssuer)
+			}
+			var (
+				kid   = kp.KeyID
+				key   jwk.Key
+				found bool
+				err   error
+			)
+			if idx != nil {
+				key, found, err = idx.lookup(kid)
+			} else {
+				key, found = set.LookupKeyID(kid)
+			}
+			if !found && err == nil && ja.refetchForKID(kid) {
+				url, set = ja.jwks()
+				if idx = ja.jwkIndexOf(url); idx != nil {
+					key, found, err = idx.lookup(kid)
+				} else if set != nil {
+					key, found = set.LookupKeyID(kid)
+				}
+			}
+			stats.recordKeyLookup(found)
+			if err != nil {
+				return fmt.Errorf("%w: key specified by kid %q is invalid: %v", ErrKeyNotFound, kid, err)
+			}
+			if !found {
 				if kid == "" {
-					return fmt.Errorf("missing kid in JWT header")
+					return fmt.Errorf("%w: missing kid in JWT header", ErrKeyNotFound)
 				}
-				return fmt.Errorf("key specified by kid %q not found in JWKs", kid)
+				return fmt.Errorf("%w: key specified by kid %q not found in JWKs", ErrKeyNotFound, kid)
 			}
-			sink.Key(ja.determineSigningAlgorithm(key.Algorithm()), key)
+			return ja.sinkKey(sink, ja.determineSigningAlgorithm(key.Algorithm()), key)
 		} else {
-			sink.Key(ja.determineSigningAlgorithm(sig.ProtectedHeaders().Algorithm()), ja.parsedSignKey)
+			kp.Source = "sign_key"
+			ja.signKeyMu.RLock()
+			key, alg := ja.parsedSignKey, ja.keyAlgorithm
+			ja.signKeyMu.RUnlock()
+			if alg == "" {
+				alg = ja.determineSigningAlgorithm(sig.ProtectedHeaders().Algorithm())
+			}
+			return ja.sinkKey(sink, alg, key)
 		}
-		return nil
 	}
 }
 
@@ -249,88 +1499,694 @@ func (ja *JWTAuth) determineSigningAlgorithm(alg jwa.KeyAlgorithm) jwa.Signature
 
 // Authenticate validates the JWT in the request and returns the user, if valid.
 func (ja *JWTAuth) Authenticate(rw http.ResponseWriter, r *http.Request) (User, bool, error) {
+	if ja.bypassed(r) {
+		ja.logger.Debug("authentication bypassed", zap.String("method", r.Method), zap.String("path", r.URL.Path))
+		return User{}, true, nil
+	}
+	result, err := ja.authenticate(r)
+	if err != nil {
+		setErrorPlaceholder(r, err)
+		if ja.ExposeRequestID && result.requestID != "" && !errors.Is(err, ErrMissingToken) {
+			rw.Header().Set(ja.RequestIDHeader, result.requestID)
+		}
+		if errors.Is(err, ErrMaintenance) {
+			ja.Maintenance.respond(rw, r)
+			return User{}, false, err
+		}
+		if errors.Is(err, ErrRateLimited) {
+			respondRateLimited(rw, r, err)
+			return User{}, false, err
+		}
+		redirected := result.cookieExpired && ja.ExpiredRedirect != ""
+		if redirected {
+			ja.redirectExpiredSession(rw, r)
+		} else if ja.BearerChallenge != nil {
+			ja.BearerChallenge.challenge(rw, err)
+		} else if errors.Is(err, ErrInsufficientScope) {
+			(&BearerChallenge{}).challenge(rw, err)
+		}
+		if errors.Is(err, ErrMissingToken) {
+			return User{}, false, nil
+		}
+		switch {
+		case redirected:
+		case ja.FailureResponse != nil:
+			ja.FailureResponse.respond(rw, r, err, result.requestID)
+		case errors.Is(err, ErrInsufficientScope):
+			respondInsufficientScope(rw, r)
+		}
+		return User{}, false, err
+	}
+	setPlaceholders(r, result)
+	ja.warnExpiring(rw, result.token)
+	ja.preventCachingQueryToken(rw, result)
+	ja.stripTokens(r)
+	ja.setUpstreamBasicAuth(r, result)
+	ja.setMatchedAudienceHeader(r, result)
+	ja.setForwardedClaimsHeaders(r, result)
+	ja.setForwardedClaims(r, result)
+	ja.issueSession(rw, result)
+	return result.user, true, nil
+}
+
+// PreValidate performs the full validation of the tokens in the request, the
+// same as Authenticate, but without writing any response. It is intended for
+// other Caddy modules, e.g. the ones proxying WebSocket upgrades, which need
+// the identity of the user before responding.
+//
+// It has no side effects, so Authenticate can follow for the same request:
+// the one-time tokens and the DPoP proofs are not used up, the failures are
+// neither counted by FailureRateLimit nor audited nor notified, and the
+// request is left intact. The bypassing requests have no user, as of
+// Authenticate.
+func (ja *JWTAuth) PreValidate(r *http.Request) (*User, error) {
+	if ja.bypassed(r) {
+		return &User{}, nil
+	}
+	release, err := ja.acquireWorker(r.Context())
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+	result, _, err := ja.verify(r, ja.candidateTokens(r), ja.logger, true)
+	if err != nil {
+		return nil, err
+	}
+	return &result.user, nil
+}
+
+// authResult is the outcome of verifying the candidate tokens of a request.
+type authResult struct {
+	user       User
+	token      Token
+	candidate  candidateToken // the accepted candidate
+	raw        string         // the accepted token, normalized
+	provenance *keyProvenance // where the key verified the token came from
+
+	// matchedAudience is the audience on AudienceWhitelist which admitted
+	// the token.
+	matchedAudience string
+	principalType   string // inferred, see inferPrincipalType
+	cookieExpired   bool   // a token from the cookies has expired
+	requestID       string // correlation ID, see RequestIDHeader
+
+	// the last rejected candidate, its token if parsed, and the kid of its
+	// key, for auditing
+	rejected      candidateToken
+	rejectedToken Token
+	rejectedKID   string
+}
+
+// authenticate verifies the candidate tokens in the request one by one and
+// accepts the first valid one. The returned result is never nil.
+func (ja *JWTAuth) authenticate(r *http.Request) (*authResult, error) {
+	requestID := ja.requestID(r)
+	setRequestIDPlaceholder(r, requestID)
+	logger := ja.logger.With(zap.String("request_id", requestID))
+
+	// extracted once, e.g. parsing the form of the body, for all the uses
+	candidates := ja.candidateTokens(r)
+	ja.applyQueryTokenPolicy(r, candidates, logger)
+	if ja.FailureRateLimit != nil {
+		for _, key := range ja.clientKeys(r, candidates) {
+			if err := ja.FailureRateLimit.check(key, time.Now()); err != nil {
+				result := &authResult{requestID: requestID}
+				stats.recordFailure(failureReason(err), "")
+				ja.audit(r, result, "", err)
+				return result, err
+			}
+		}
+	}
+
+	release, err := ja.acquireWorker(r.Context())
+	if err != nil {
+		result := &authResult{requestID: requestID}
+		ja.audit(r, result, "", err)
+		return result, err
+	}
+	defer release()
+	metrics.verificationsInFlight.Inc()
+	defer metrics.verificationsInFlight.Dec()
+
+	result, issuer, err := ja.verify(r, candidates, logger, false)
+	result.requestID = requestID
+	if err != nil {
+		stats.recordFailure(failureReason(err), issuer)
+		if ja.FailureRateLimit != nil && countsAsFailure(err) {
+			// keyed by the token failed, not any other candidate
+			failed := result.rejected.value
+			if failed == "" {
+				failed = result.candidate.value
+			}
+			ja.FailureRateLimit.record(ja.FailureRateLimit.clientKey(r, ja.trustedIssuerOf(failed)), time.Now())
+		}
+		if ja.DenyWebhook != nil && !errors.Is(err, ErrMissingToken) && !errors.Is(err, ErrRateLimited) {
+			ja.DenyWebhook.notify(r, err, issuer, requestID)
+		}
+	} else {
+		ja.setMetadataJSON(result)
+		stats.recordSuccess()
+		observeTokenLifetime(result.token, time.Now())
+		if ja.ClaimsAnomaly != nil {
+			ja.ClaimsAnomaly.observe(logger, result.user.ID, result.token)
+		}
+	}
+	ja.audit(r, result, issuer, err)
+	return result, err
+}
+
+// verify verifies the candidate tokens of the request and completes the user
+// of the accepted one, see verifyCandidates. If dryRun, nothing is used up,
+// see PreValidate.
+func (ja *JWTAuth) verify(r *http.Request, candidates []candidateToken, logger *zap.Logger, dryRun bool) (*authResult, string, error) {
+	result, issuer, err := ja.verifyCandidates(r, candidates, logger, dryRun)
+	if err == nil {
+		err = ja.verifyContextToken(r, logger, result, dryRun)
+	}
+	if err == nil {
+		err = ja.enrichUser(r.Context(), logger, result)
+	}
+	if err == nil {
+		err = ja.mergeUserInfo(r.Context(), logger, result)
+	}
+	if ja.Maintenance != nil {
+		err = ja.Maintenance.check(result.token, err)
+	}
+	return result, issuer, err
+}
+
+// acquireWorker waits for a verification worker if VerificationWorkers is
+// set. The returned function releases the worker.
+func (ja *JWTAuth) acquireWorker(ctx context.Context) (func(), error) {
+	if ja.workers == nil {
+		return func() {}, nil
+	}
+	select {
+	case ja.workers <- struct{}{}:
+		return func() { <-ja.workers }, nil
+	default:
+	}
+
+	metrics.verificationQueueDepth.Inc()
+	defer metrics.verificationQueueDepth.Dec()
+	select {
+	case ja.workers <- struct{}{}:
+		return func() { <-ja.workers }, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// verifyCandidates does the job of authenticate on the candidate tokens of
+// the request. Besides, on failure, it returns the issuer of the last
+// rejected token (unverified), if known. If dryRun, the one-time tokens and
+// the DPoP proofs are only checked, not used up, and PolicyTraceHeader is
+// left in the request.
+func (ja *JWTAuth) verifyCandidates(r *http.Request, candidates []candidateToken, logger *zap.Logger, dryRun bool) (*authResult, string, error) {
 	var (
-		gotToken   Token
-		candidates []string
-		err        error
+		gotToken Token
+		err      error
+		issuer   string
+		result   = &authResult{}
 	)
 
-	candidates = append(candidates, getTokensFromQuery(r, ja.FromQuery)...)
-	candidates = append(candidates, getTokensFromHeader(r, ja.FromHeader)...)
-	candidates = append(candidates, getTokensFromCookies(r, ja.FromCookies)...)
-
-	candidates = append(candidates, getTokensFromHeader(r, []string{"Authorization"})...)
+	if ja.QueryTokenPolicy == queryTokenDeny {
+		if name, ok := ja.deniedQueryToken(r, candidates); ok {
+			err := fmt.Errorf("%w: %q", ErrQueryTokenDenied, name)
+			logger.Error("invalid token", zap.Error(err))
+			return result, "", err
+		}
+	}
+	if len(candidates) == 0 {
+		return result, "", ErrMissingToken
+	}
+	if ja.SourcesExclusive {
+		if err := ja.checkExclusiveSources(candidates); err != nil {
+			logger.Error("invalid token", zap.Error(err))
+			return result, "", err
+		}
+	}
+	live := ja.policy()
+	policy, err := live.selectClaimPolicy(r, ja.ClaimPolicyName)
+	if err != nil {
+		logger.Error("invalid claim policy", zap.Error(err))
+		return result, "", err
+	}
+	audiences := live.selectAudiences(r, ja.Audience)
 	checked := make(map[string]struct{})
+	if ja.PolicyTraceHeader != "" && !dryRun {
+		// not for the upstream
+		defer r.Header.Del(ja.PolicyTraceHeader)
+	}
 
-	for _, candidateToken := range candidates {
-		tokenString := normToken(candidateToken)
+	for _, candidate := range candidates {
+		tokenString := ja.normalizeToken(candidate)
 		if _, ok := checked[tokenString]; ok {
 			continue
 		}
 
-		gotToken, err = jwt.ParseString(tokenString, jwt.WithKeyProvider(ja.keyProvider()))
 		checked[tokenString] = struct{}{}
+		result.rejected, result.rejectedToken, result.rejectedKID = candidate, nil, ""
+		logger := logger.With(ja.tokenField(tokenString))
+		trace := ja.tracing(r)
+		if tokenString == "" {
+			err = fmt.Errorf("%w: empty token, or of a scheme not accepted", ErrInvalidToken)
+			logger.Error("invalid token", zap.String("source", string(candidate.source)), zap.String("name", candidate.name), zap.Error(err))
+			continue
+		}
+
+		signedToken := tokenString
+		if ja.parsedDecryptKey != nil && isEncryptedToken(tokenString) {
+			signedToken, err = ja.decryptToken(tokenString)
+			trace.record("decrypt", nil, err)
+			if err != nil {
+				err = fmt.Errorf("%w: decrypting: %w", ErrInvalidToken, err)
+				logger.Error("invalid token", trace.field(), zap.Error(err))
+				continue
+			}
+		}
 
-		logger := ja.logger.With(zap.String("token_string", desensitizedTokenString(tokenString)))
+		provenance := &keyProvenance{}
+		if candidate.source == sourceSession {
+			provenance.Source = "session"
+			gotToken, err = ja.verifySession(tokenString, candidates)
+		} else if candidate.source == sourceALB {
+			provenance.Source = "alb"
+			gotToken, err = ja.ALBOIDCData.verify(r.Context(), tokenString, provenance)
+		} else if ja.Introspection != nil && isOpaqueToken(tokenString) {
+			provenance.Source, provenance.Location = "introspection", ja.Introspection.Endpoint
+			gotToken, err = ja.Introspection.introspect(r.Context(), tokenString)
+		} else {
+			gotToken, err = ja.verifySignature(r.Context(), signedToken, provenance)
+		}
+		trace.record("signature", func() interface{} {
+			return map[string]interface{}{"key_source": provenance.Source, "kid": provenance.KeyID}
+		}, err)
+		result.rejectedKID = provenance.KeyID
 		if err != nil {
+			malformed, malformedField := "", zap.Skip()
+			if isSignatureMismatch(err) {
+				err = fmt.Errorf("%w: %w: %v", ErrInvalidToken, ErrSignatureInvalid, err)
+			} else if !errors.Is(err, ErrKeyNotFound) && !errors.Is(err, ErrInvalidToken) && !errors.Is(err, ErrIntrospectionFailed) {
+				if malformed = malformedKind(signedToken); malformed != "" {
+					metrics.malformedTokens.WithLabelValues(malformed).Inc()
+					malformedField = zap.String("malformed", malformed)
+					err = fmt.Errorf("%w: %w (%s): %v", ErrInvalidToken, ErrMalformedToken, malformed, err)
+				} else {
+					err = fmt.Errorf("%w: %w", ErrInvalidToken, err)
+				}
+			}
+			if ja.SandboxParsing == nil && malformed == "" {
+				issuer = ja.canonicalIssuer(peekIssuer(signedToken)) // not out of the sandbox
+			}
+			logger.Error("invalid token", trace.field(), malformedField, zap.Error(err))
+			continue
+		}
+		if gotToken, err = ja.canonicalizeIssuer(gotToken); err != nil {
+			err = fmt.Errorf("%w: %w", ErrInvalidToken, err)
 			logger.Error("invalid token", zap.Error(err))
 			continue
 		}
+		issuer = gotToken.Issuer()
+		result.rejectedToken = gotToken
 
 		// By default, the following claims will be verified:
 		//   - "exp"
 		//   - "iat"
 		//   - "nbf"
+		err = ja.validateStandardClaims(gotToken, ja.expiredGrace(r))
+		trace.record("standard_claims", func() interface{} {
+			return map[string]interface{}{
+				"exp": gotToken.Expiration(), "nbf": gotToken.NotBefore(), "iat": gotToken.IssuedAt(),
+			}
+		}, err)
+		if err != nil {
+			if candidate.source == sourceCookie && errors.Is(err, ErrTokenExpired) {
+				result.cookieExpired = true
+			}
+			logger.Error("invalid token", trace.field(), zap.Error(err))
+			continue
+		}
+		if ja.StrictRFC9068 && provenance.Source != "introspection" && provenance.Source != "session" && provenance.Source != "alb" {
+			err = checkAccessTokenProfile(signedToken, gotToken)
+			trace.record("strict_rfc9068", func() interface{} { return headerType(signedToken) }, err)
+			if err != nil {
+				logger.Error("invalid token", trace.field(), zap.Error(err))
+				continue
+			}
+		}
+		if ja.checksHeader(signedToken) && provenance.Source != "introspection" && provenance.Source != "session" {
+			header := decodeHeader(signedToken)
+			err = ja.checkHeader(header)
+			trace.record("header", func() interface{} {
+				return map[string]interface{}{"typ": header["typ"], "crit": header["crit"]}
+			}, err)
+			if err != nil {
+				logger.Error("invalid token", trace.field(), zap.Error(err))
+				continue
+			}
+		}
+
 		// Here, if `aud_whitelist` or `iss_whitelist` were specified,
 		// continue to verify "aud" and "iss" correspondingly.
-		if len(ja.IssuerWhitelist) > 0 {
+		if len(live.IssuerWhitelist) > 0 {
 			isValidIssuer := false
-			for _, issuer := range ja.IssuerWhitelist {
-				if jwt.Validate(gotToken, jwt.WithIssuer(issuer)) == nil {
+			for _, issuer := range live.IssuerWhitelist {
+				if gotToken.Issuer() == issuer {
 					isValidIssuer = true
 					break
 				}
 			}
 			if !isValidIssuer {
 				err = ErrInvalidIssuer
-				logger.Error("invalid token", zap.Error(err))
+			}
+			trace.record("issuer", func() interface{} {
+				return map[string]interface{}{"iss": gotToken.Issuer(), "whitelist": live.IssuerWhitelist}
+			}, err)
+			if err != nil {
+				logger.Error("invalid token", trace.field(), zap.Error(err))
 				continue
 			}
 		}
 
-		if len(ja.AudienceWhitelist) > 0 {
+		var matchedAudience string
+		if len(audiences) > 0 {
 			isValidAudience := false
-			for _, audience := range ja.AudienceWhitelist {
-				if jwt.Validate(gotToken, jwt.WithAudience(audience)) == nil {
+			for _, audience := range audiences {
+				if hasAudience(gotToken, audience) {
 					isValidAudience = true
+					matchedAudience = audience
 					break
 				}
 			}
 			if !isValidAudience {
-				err = ErrInvalidAudience
-				logger.Error("invalid token", zap.Error(err))
+				err = ErrAudienceMismatch
+			}
+			trace.record("audience", func() interface{} {
+				return map[string]interface{}{"aud": gotToken.Audience(), "whitelist": audiences}
+			}, err)
+			if err != nil {
+				logger.Error("invalid token", trace.field(), zap.Error(err))
+				continue
+			}
+		}
+
+		err = ja.checkSubject(gotToken)
+		trace.record("subject", func() interface{} { return gotToken.Subject() }, err)
+		if err != nil {
+			logger.Error("invalid token", trace.field(), zap.Error(err))
+			continue
+		}
+		var principalType string
+		principalType, err = ja.checkPrincipalType(gotToken)
+		trace.record("principal_type", func() interface{} { return principalType }, err)
+		if err != nil {
+			logger.Error("invalid token", trace.field(), zap.Error(err))
+			continue
+		}
+		if ja.RequireEnv != "" {
+			err = ja.checkEnv(gotToken)
+			trace.record("env", func() interface{} { return ja.RequireEnv }, err)
+			if err != nil {
+				logger.Error("invalid token", trace.field(), zap.Error(err))
+				continue
+			}
+		}
+		if len(ja.BindClaims) > 0 {
+			err = ja.checkBindings(r, gotToken)
+			trace.record("bind_claims", func() interface{} { return ja.BindClaims }, err)
+			if err != nil {
+				logger.Error("invalid token", trace.field(), zap.Error(err))
 				continue
 			}
 		}
 
 		// The token is valid. Continue to check the user claim.
-		claimName, gotUserID := getUserID(gotToken, ja.UserClaims)
+		claimName, gotUserID := ja.userID(gotToken)
 		if gotUserID == "" {
 			err = ErrEmptyUserClaim
-			logger.Error("invalid token", zap.Strings("user_claims", ja.UserClaims), zap.Error(err))
+		}
+		trace.record("user_claims", ja.userIDSpec, err)
+		if err != nil {
+			logger.Error("invalid token", trace.field(), zap.Any("user_claims", ja.userIDSpec()), zap.Error(err))
+			continue
+		}
+
+		err = ja.checkClaimsSchema(gotToken)
+		trace.record("claims_schema", func() interface{} { return ja.ClaimsSchema }, err)
+		if err != nil {
+			logger.Error("invalid token", trace.field(), zap.Error(err))
+			continue
+		}
+		err = policy.check(gotToken)
+		trace.record("claim_policy", func() interface{} { return policy }, err)
+		if err != nil {
+			logger.Error("invalid token", trace.field(), zap.String("claim_policy", ja.ClaimPolicyName), zap.Error(err))
 			continue
 		}
+		err = checkRequirements(gotToken, ja.Require)
+		trace.record("require", func() interface{} { return ja.Require }, err)
+		if err != nil {
+			logger.Error("invalid token", trace.field(), zap.Error(err))
+			continue
+		}
+		err = ja.checkClaims(gotToken)
+		trace.record("verify_claims", func() interface{} { return ja.VerifyClaims }, err)
+		if err != nil {
+			logger.Error("invalid token", trace.field(), zap.Error(err))
+			continue
+		}
+		err = ja.checkExpression(r.Context(), gotToken)
+		trace.record("expression", func() interface{} { return ja.ValidateExpression }, err)
+		if err != nil {
+			logger.Error("invalid token", trace.field(), zap.Error(err))
+			continue
+		}
+		if ja.Revocation != nil {
+			err = ja.Revocation.check(r.Context(), gotToken)
+			trace.record("revocation", func() interface{} { return gotToken.JwtID() }, err)
+			if err != nil {
+				logger.Error("invalid token", trace.field(), zap.Error(err))
+				continue
+			}
+		}
+		if ja.DPoP != nil {
+			err = ja.DPoP.check(r, gotToken, tokenString, dryRun)
+			trace.record("dpop", func() interface{} { return r.Header.Get("DPoP") != "" }, err)
+			if err != nil {
+				logger.Error("invalid token", trace.field(), zap.Error(err))
+				continue
+			}
+		}
+		err = ja.checkScope(gotToken)
+		trace.record("scope", func() interface{} {
+			return map[string]interface{}{"scopes": tokenScopes(gotToken), "required": ja.RequireScope, "match": ja.ScopeMatch}
+		}, err)
+		if err != nil {
+			logger.Error("invalid token", trace.field(), zap.Error(err))
+			continue
+		}
+
+		var roles []string
+		if ja.rolesClaim != "" || ja.MapGroups != nil {
+			roles = ja.grantedRoles(gotToken)
+			err = ja.checkRole(roles)
+			trace.record("require_role", func() interface{} {
+				return map[string]interface{}{"roles": roles, "required": ja.RequireRole}
+			}, err)
+			if err != nil {
+				logger.Error("invalid token", trace.field(), zap.Error(err))
+				continue
+			}
+		}
+
+		if ja.OneTimeTokens != nil {
+			// last, not to use up the tokens rejected otherwise
+			err = ja.OneTimeTokens.check(r.Context(), gotToken, time.Duration(ja.Leeway)+ja.expiredGrace(r), dryRun)
+			trace.record("one_time_tokens", func() interface{} { return gotToken.JwtID() }, err)
+			if err != nil {
+				logger.Error("invalid token", trace.field(), zap.Error(err))
+				continue
+			}
+		}
 
 		// Successfully authenticated!
-		var user = User{
+		result.user = User{
 			ID:       gotUserID,
-			Metadata: getUserMetadata(gotToken, ja.MetaClaims),
+			Metadata: ja.userMetadata(gotToken),
+		}
+		if ja.rolesClaim != "" || ja.MapGroups != nil {
+			setRolesMetadata(&result.user, roles)
+		}
+		result.token = gotToken
+		result.candidate = candidate
+		result.raw = tokenString
+		result.provenance = provenance
+		result.matchedAudience = matchedAudience
+		result.principalType = principalType
+		if ce := logger.Check(zap.InfoLevel, "user authenticated"); ce != nil {
+			ce.Write(append(provenance.zapFields(), zap.String("user_claim", claimName), zap.String("id", gotUserID), trace.field())...)
+		}
+		return result, "", nil
+	}
+
+	return result, issuer, err
+}
+
+// hasAudience reports whether the audience is one of the "aud" of the token,
+// as jwt.WithAudience, without building the validation context per token.
+func hasAudience(token Token, audience string) bool {
+	for _, aud := range token.Audience() {
+		if aud == audience {
+			return true
+		}
+	}
+	return false
+}
+
+// candidateTokens returns the tokens in the request, in the order of
+// priority, the session cookie first, see IssueSessionCookie, then the user
+// claims of ALB, see ALBOIDCData.
+func (ja *JWTAuth) candidateTokens(r *http.Request) []candidateToken {
+	var candidates []candidateToken
+	if ja.IssueSessionCookie != nil && !ja.contextOnly {
+		candidates = append(candidates, ja.IssueSessionCookie.getSessionCookie(r)...)
+	}
+	if ja.ALBOIDCData != nil && !ja.contextOnly {
+		candidates = append(candidates, ja.ALBOIDCData.getToken(r)...)
+	}
+	order := ja.sourceOrder
+	if order == nil {
+		order = defaultSourceOrder
+	}
+	for _, source := range order {
+		candidates = append(candidates, ja.tokensFromSource(r, source)...)
+	}
+	return candidates
+}
+
+// peekIssuer returns the "iss" claim of the token without verifying it.
+func peekIssuer(tokenString string) string {
+	if token, err := jwt.ParseInsecure([]byte(tokenString)); err == nil {
+		return token.Issuer()
+	}
+	return ""
+}
+
+// expiredGrace returns the grace of the expired tokens for the request,
+// which only applies to the safe methods, see ExpiredGrace.
+func (ja *JWTAuth) expiredGrace(r *http.Request) time.Duration {
+	if r.Method == http.MethodGet || r.Method == http.MethodHead {
+		return time.Duration(ja.ExpiredGrace)
+	}
+	return 0
+}
+
+// standardClaimsValidation is the validation of the standard claims,
+// compiled at provision, see validateStandardClaims.
+type standardClaimsValidation struct {
+	ctx           context.Context // of the clock, Leeway and the truncation
+	leeway        time.Duration
+	iat, exp, nbf bool
+}
+
+// compileStandardClaims compiles the validation of the standard claims, so
+// the validation contexts aren't built per token.
+func (ja *JWTAuth) compileStandardClaims() *standardClaimsValidation {
+	ctx := jwt.SetValidationCtxClock(context.Background(), jwt.ClockFunc(time.Now))
+	ctx = jwt.SetValidationCtxSkew(ctx, time.Duration(ja.Leeway))
+	ctx = jwt.SetValidationCtxTruncation(ctx, time.Second)
+	return &standardClaimsValidation{
+		ctx:    ctx,
+		leeway: time.Duration(ja.Leeway),
+		iat:    boolOrDefault(ja.ValidateIat, true),
+		exp:    boolOrDefault(ja.ValidateExp, true),
+		nbf:    boolOrDefault(ja.ValidateNbf, true),
+	}
+}
+
+// validateStandardClaims verifies the "exp", "iat" and "nbf" claims of the
+// token, unless turned off by ValidateExp, ValidateIat or ValidateNbf, with
+// the tolerance of Leeway. The token is still valid within expGrace after
+// "exp" in addition. The errors are wrapped
+// with ErrTokenExpired, ErrInvalidIssuedAt and ErrTokenNotYetValid
+// correspondingly.
+func (ja *JWTAuth) validateStandardClaims(token Token, expGrace time.Duration) error {
+	v := ja.standardClaims
+	if v == nil {
+		v = ja.compileStandardClaims() // not provisioned
+	}
+	var err error
+	if v.iat {
+		err = jwt.IsIssuedAtValid().Validate(v.ctx, token)
+	}
+	if err == nil && v.exp {
+		ctx := v.ctx
+		if expGrace > 0 {
+			ctx = jwt.SetValidationCtxSkew(ctx, v.leeway+expGrace)
+		}
+		err = jwt.IsExpirationValid().Validate(ctx, token)
+	}
+	if err == nil && v.nbf {
+		err = jwt.IsNbfValid().Validate(v.ctx, token)
+	}
+	if err != nil {
+		switch {
+		case errors.Is(err, jwt.ErrTokenExpired()):
+			return fmt.Errorf("%w: %w", ErrTokenExpired, err)
+		case errors.Is(err, jwt.ErrTokenNotYetValid()):
+			return fmt.Errorf("%w: %w", ErrTokenNotYetValid, err)
+		case errors.Is(err, jwt.ErrInvalidIssuedAt()):
+			return fmt.Errorf("%w: %w", ErrInvalidIssuedAt, err)
+		}
+		return fmt.Errorf("%w: %w", ErrInvalidToken, err)
+	}
+	return ja.checkTokenLifetime(token)
+}
+
+// checkTokenLifetime enforces MaxTokenAge and RequireExp.
+func (ja *JWTAuth) checkTokenLifetime(token Token) error {
+	if ja.RequireExp && token.Expiration().IsZero() {
+		return ErrMissingExp
+	}
+	if ja.MaxTokenAge > 0 {
+		iat := token.IssuedAt()
+		if iat.IsZero() {
+			return fmt.Errorf("%w: missing iat", ErrTokenTooOld)
+		}
+		if age := time.Since(iat).Truncate(time.Second); age > time.Duration(ja.MaxTokenAge+ja.Leeway) {
+			return fmt.Errorf("%w: issued %s ago", ErrTokenTooOld, age)
 		}
-		logger.Info("user authenticated", zap.String("user_claim", claimName), zap.String("id", gotUserID))
-		return user, true, nil
 	}
+	return nil
+}
+
+func boolOrDefault(v *bool, defaultValue bool) bool {
+	if v == nil {
+		return defaultValue
+	}
+	return *v
+}
+
+// tokenSource describes where a candidate token was extracted from.
+type tokenSource string
 
-	return User{}, false, err
+const (
+	sourceQuery  tokenSource = "query"
+	sourceHeader tokenSource = "header"
+	sourceCookie tokenSource = "cookie"
+	sourceBody   tokenSource = "body"
+)
+
+// authorizationHeader is the header of the tokens always looked up, besides
+// FromHeader.
+var authorizationHeader = []string{"Authorization"}
+
+// candidateToken is a token found in the request, not verified yet.
+type candidateToken struct {
+	source tokenSource
+	name   string
+	value  string
 }
 
 func normToken(token string) string {
@@ -340,33 +2196,38 @@ func normToken(token string) string {
 	return strings.TrimSpace(token)
 }
 
-func getTokensFromHeader(r *http.Request, names []string) []string {
-	tokens := make([]string, 0)
+func getTokensFromHeader(r *http.Request, names []string) []candidateToken {
+	tokens := make([]candidateToken, 0)
 	for _, key := range names {
 		token := r.Header.Get(key)
 		if token != "" {
-			tokens = append(tokens, token)
+			tokens = append(tokens, candidateToken{sourceHeader, key, token})
 		}
 	}
 	return tokens
 }
 
-func getTokensFromQuery(r *http.Request, names []string) []string {
-	tokens := make([]string, 0)
+func getTokensFromQuery(r *http.Request, names []string) []candidateToken {
+	tokens := make([]candidateToken, 0)
+	if len(names) == 0 {
+		return tokens
+	}
+	// not r.FormValue, which would drain the form of the body, see FromBody
+	query := r.URL.Query()
 	for _, key := range names {
-		token := r.FormValue(key)
+		token := query.Get(key)
 		if token != "" {
-			tokens = append(tokens, token)
+			tokens = append(tokens, candidateToken{sourceQuery, key, token})
 		}
 	}
 	return tokens
 }
 
-func getTokensFromCookies(r *http.Request, names []string) []string {
-	tokens := make([]string, 0)
+func getTokensFromCookies(r *http.Request, names []string) []candidateToken {
+	tokens := make([]candidateToken, 0)
 	for _, key := range names {
 		if ck, err := r.Cookie(key); err == nil && ck.Value != "" {
-			tokens = append(tokens, ck.Value)
+			tokens = append(tokens, candidateToken{sourceCookie, key, ck.Value})
 		}
 	}
 	return tokens
@@ -401,28 +2262,17 @@ func queryNested(claims map[string]interface{}, path []string) (interface{}, boo
 	return object[lastKey], true
 }
 
-func getUserMetadata(token Token, placeholdersMap map[string]string) map[string]string {
-	if len(placeholdersMap) == 0 {
-		return nil
+// getClaim gets the value of the claim from the token. Nested claims can be
+// accessed by dot notation, e.g. "user_info.role".
+func getClaim(token Token, name string) (interface{}, bool) {
+	if val, ok := token.Get(name); ok {
+		return val, true
 	}
-
-	claims, _ := token.AsMap(context.Background()) // error ignored
-	metadata := make(map[string]string)
-	for claim, placeholder := range placeholdersMap {
-		claimValue, ok := token.Get(claim)
-
-		// Query nested claims.
-		if !ok && strings.Contains(claim, ".") {
-			claimValue, ok = queryNested(claims, strings.Split(claim, "."))
-		}
-		if !ok {
-			metadata[placeholder] = ""
-			continue
-		}
-		metadata[placeholder] = stringify(claimValue)
+	if !strings.Contains(name, ".") {
+		return nil, false
 	}
-
-	return metadata
+	claims, _ := token.AsMap(context.Background()) // error ignored
+	return queryNested(claims, strings.Split(name, "."))
 }
 
 func stringify(val interface{}) string {
@@ -437,6 +2287,12 @@ func stringify(val interface{}) string {
 		return strconv.FormatBool(uv)
 	case json.Number:
 		return uv.String()
+	case float64:
+		return strconv.FormatFloat(uv, 'f', -1, 64)
+	case int:
+		return strconv.Itoa(uv)
+	case int64:
+		return strconv.FormatInt(uv, 10)
 	case time.Time:
 		return uv.UTC().Format(time.RFC3339Nano)
 	}
@@ -445,13 +2301,33 @@ func stringify(val interface{}) string {
 		return stringer.String()
 	}
 
-	if slice, ok := val.([]interface{}); ok {
-		return stringifySlice(slice)
+	if items, ok := sliceItems(val); ok {
+		return stringifySlice(items)
 	}
 
 	return ""
 }
 
+// sliceItems returns the items of the value if it's a slice, e.g. of the
+// strings, numbers or bools of an array claim, other than []byte.
+func sliceItems(val interface{}) ([]interface{}, bool) {
+	if items, ok := val.([]interface{}); ok {
+		return items, true
+	}
+	if _, ok := val.([]byte); ok {
+		return nil, false
+	}
+	rv := reflect.ValueOf(val)
+	if rv.Kind() != reflect.Slice {
+		return nil, false
+	}
+	items := make([]interface{}, rv.Len())
+	for i := range items {
+		items[i] = rv.Index(i).Interface()
+	}
+	return items, true
+}
+
 func stringifySlice(slice []interface{}) string {
 	var result []string
 	for _, val := range slice {
@@ -484,6 +2360,26 @@ func parseSignKey(signKey string) (keyBytes []byte, asymmetric bool, err error)
 	return keyBytes, false, err
 }
 
+// inferSignAlgorithm returns the only signing algorithm applicable to the
+// public key, i.e. by the curve of an EC key, or EdDSA for an Ed25519 key. It
+// returns empty for the others, e.g. RSA keys apply to RS* and PS*.
+func inferSignAlgorithm(key interface{}) jwa.SignatureAlgorithm {
+	switch k := key.(type) {
+	case *ecdsa.PublicKey:
+		switch k.Curve {
+		case elliptic.P256():
+			return jwa.ES256
+		case elliptic.P384():
+			return jwa.ES384
+		case elliptic.P521():
+			return jwa.ES512
+		}
+	case ed25519.PublicKey:
+		return jwa.EdDSA
+	}
+	return ""
+}
+
 func parsePEMFormattedPublicKey(pubKey string) ([]byte, error) {
 	block, _ := pem.Decode([]byte(pubKey))
 	if block != nil && block.Type == "PUBLIC KEY" {
@@ -497,5 +2393,6 @@ func parsePEMFormattedPublicKey(pubKey string) ([]byte, error) {
 var (
 	_ caddy.Provisioner       = (*JWTAuth)(nil)
 	_ caddy.Validator         = (*JWTAuth)(nil)
+	_ caddy.CleanerUpper      = (*JWTAuth)(nil)
 	_ caddyauth.Authenticator = (*JWTAuth)(nil)
 )
@@ -1,16 +1,27 @@
 package caddyjwt
 
 import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
 	"crypto/rand"
 	"crypto/rsa"
+	"crypto/x509"
 	"encoding/base64"
 	"encoding/json"
+	"encoding/pem"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
 	"testing"
 	"time"
 
+	"github.com/caddyserver/caddy/v2"
 	"github.com/lestrrat-go/jwx/v2/jwa"
 	"github.com/lestrrat-go/jwx/v2/jwk"
 	"github.com/lestrrat-go/jwx/v2/jwt"
@@ -38,10 +49,10 @@ HekSZ090/c2V4i0ju2M814QyGERMoq+cSlmikCgRWoSZeWOSTj+rAZJyEAzlVL4z
 hwIDAQAB
 -----END PUBLIC KEY-----`
 
-	// JWK URL
-	TestJWKURL                = "http://127.0.0.1:2546/key"
-	TestJWKSetURL             = "http://127.0.0.1:2546/keys"
-	TestJWKSetURLInapplicable = "http://127.0.0.1:2546/keys_inapplicable"
+	// JWK URL, served on an ephemeral port, see publishJWKsOnLocalServer
+	TestJWKURL                string
+	TestJWKSetURL             string
+	TestJWKSetURLInapplicable string
 
 	jwkKey                   jwk.Key // private key
 	jwkPubKey                jwk.Key // public key
@@ -83,19 +94,23 @@ func generateJWK() jwk.Key {
 	return key
 }
 
+// publishJWKsOnLocalServer serves the JWKs on an ephemeral port, which is
+// listening once it returns. The server lives as long as the test binary.
 func publishJWKsOnLocalServer() {
-	go func() {
-		http.HandleFunc("/key", func(w http.ResponseWriter, r *http.Request) {
-			json.NewEncoder(w).Encode(jwkPubKey)
-		})
-		http.HandleFunc("/keys", func(w http.ResponseWriter, r *http.Request) {
-			json.NewEncoder(w).Encode(jwkPubKeySet)
-		})
-		http.HandleFunc("/keys_inapplicable", func(w http.ResponseWriter, r *http.Request) {
-			json.NewEncoder(w).Encode(jwkPubKeySetInapplicable)
-		})
-		panicOnError(http.ListenAndServe("127.0.0.1:2546", nil))
-	}()
+	mux := http.NewServeMux()
+	mux.HandleFunc("/key", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(jwkPubKey)
+	})
+	mux.HandleFunc("/keys", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(jwkPubKeySet)
+	})
+	mux.HandleFunc("/keys_inapplicable", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(jwkPubKeySetInapplicable)
+	})
+	server := httptest.NewServer(mux)
+	TestJWKURL = server.URL + "/key"
+	TestJWKSetURL = server.URL + "/keys"
+	TestJWKSetURLInapplicable = server.URL + "/keys_inapplicable"
 }
 
 func panicOnError(err error) {
@@ -365,6 +380,72 @@ func TestAuthenticate_CustomUserClaims(t *testing.T) {
 	assert.Equal(t, User{ID: "19911110"}, gotUser)
 }
 
+func TestPreValidate(t *testing.T) {
+	ja := &JWTAuth{SignKey: TestSignKey, logger: testLogger}
+	assert.Nil(t, ja.Validate())
+
+	// valid token
+	r, _ := http.NewRequest("GET", "/", nil)
+	r.Header.Add("Authorization", "Bearer "+issueTokenString(MapClaims{"sub": "ggicci"}))
+	gotUser, err := ja.PreValidate(r)
+	assert.Nil(t, err)
+	assert.Equal(t, &User{ID: "ggicci"}, gotUser)
+
+	// missing token
+	r, _ = http.NewRequest("GET", "/", nil)
+	gotUser, err = ja.PreValidate(r)
+	assert.ErrorIs(t, err, ErrMissingToken)
+	assert.Nil(t, gotUser)
+
+	// invalid token
+	r, _ = http.NewRequest("GET", "/", nil)
+	r.Header.Add("Authorization", "Bearer "+issueTokenString(MapClaims{"username": "ggicci"}))
+	gotUser, err = ja.PreValidate(r)
+	assert.ErrorIs(t, err, ErrEmptyUserClaim)
+	assert.Nil(t, gotUser)
+}
+
+func TestPreValidate_NoSideEffects(t *testing.T) {
+	ja := &JWTAuth{
+		SignKey:           TestSignKey,
+		FromQuery:         []string{"access_token"},
+		QueryTokenPolicy:  "redact",
+		PolicyTraceHeader: "X-Policy-Trace",
+		PolicyTraceSecret: "s3cret",
+		FailureRateLimit:  &FailureRateLimit{MaxFailures: 1},
+		ExceptPaths:       []string{"/healthz"},
+		logger:            testLogger,
+	}
+	assert.Nil(t, ja.Validate())
+
+	valid := issueTokenString(MapClaims{"sub": "ggicci"})
+	r, _ := http.NewRequest("GET", "/?access_token="+valid, nil)
+	r.Header.Set("X-Policy-Trace", "s3cret")
+	user, err := ja.PreValidate(r)
+	assert.Nil(t, err)
+	assert.Equal(t, "ggicci", user.ID)
+	assert.Equal(t, valid, r.URL.Query().Get("access_token"))
+	assert.Equal(t, "s3cret", r.Header.Get("X-Policy-Trace"))
+
+	// the failures are not counted
+	for i := 0; i < 3; i++ {
+		r, _ = http.NewRequest("GET", "/", nil)
+		r.Header.Add("Authorization", valid+"x")
+		_, err = ja.PreValidate(r)
+		assert.ErrorIs(t, err, ErrInvalidToken)
+	}
+	r, _ = http.NewRequest("GET", "/", nil)
+	r.Header.Add("Authorization", valid)
+	_, _, err = ja.Authenticate(httptest.NewRecorder(), r)
+	assert.Nil(t, err)
+
+	// bypassed
+	r, _ = http.NewRequest("GET", "/healthz", nil)
+	user, err = ja.PreValidate(r)
+	assert.Nil(t, err)
+	assert.Equal(t, &User{}, user)
+}
+
 func TestAuthenticate_ValidateStandardClaims(t *testing.T) {
 	ja := &JWTAuth{
 		SignKey: TestSignKey,
@@ -378,7 +459,7 @@ func TestAuthenticate_ValidateStandardClaims(t *testing.T) {
 	r, _ := http.NewRequest("GET", "/", nil)
 	r.Header.Add("Authorization", issueTokenString(expiredClaims))
 	gotUser, authenticated, err := ja.Authenticate(rw, r)
-	assert.NotNil(t, err)
+	assert.ErrorIs(t, err, ErrTokenExpired)
 	assert.False(t, authenticated)
 	assert.Empty(t, gotUser.ID)
 
@@ -388,7 +469,7 @@ func TestAuthenticate_ValidateStandardClaims(t *testing.T) {
 	r, _ = http.NewRequest("GET", "/", nil)
 	r.Header.Add("Authorization", issueTokenString(expiredClaims))
 	gotUser, authenticated, err = ja.Authenticate(rw, r)
-	assert.NotNil(t, err)
+	assert.ErrorIs(t, err, ErrInvalidIssuedAt)
 	assert.False(t, authenticated)
 	assert.Empty(t, gotUser.ID)
 
@@ -398,9 +479,243 @@ func TestAuthenticate_ValidateStandardClaims(t *testing.T) {
 	r, _ = http.NewRequest("GET", "/", nil)
 	r.Header.Add("Authorization", issueTokenString(expiredClaims))
 	gotUser, authenticated, err = ja.Authenticate(rw, r)
+	assert.ErrorIs(t, err, ErrTokenNotYetValid)
+	assert.False(t, authenticated)
+	assert.Empty(t, gotUser.ID)
+}
+
+func TestAuthenticate_ToggleStandardClaims(t *testing.T) {
+	disabled := false
+	ja := &JWTAuth{
+		SignKey:     TestSignKey,
+		ValidateIat: &disabled,
+		logger:      testLogger,
+	}
+	assert.Nil(t, ja.Validate())
+
+	// "iat" in the future is accepted
+	rw := httptest.NewRecorder()
+	r, _ := http.NewRequest("GET", "/", nil)
+	r.Header.Add("Authorization", issueTokenString(MapClaims{"sub": "ggicci", "iat": 3845462400}))
+	gotUser, authenticated, err := ja.Authenticate(rw, r)
+	assert.Nil(t, err)
+	assert.True(t, authenticated)
+	assert.Equal(t, User{ID: "ggicci"}, gotUser)
+
+	// "exp" is still verified
+	rw = httptest.NewRecorder()
+	r, _ = http.NewRequest("GET", "/", nil)
+	r.Header.Add("Authorization", issueTokenString(MapClaims{"sub": "ggicci", "exp": 689702400}))
+	_, authenticated, err = ja.Authenticate(rw, r)
+	assert.ErrorIs(t, err, jwt.ErrTokenExpired())
+	assert.False(t, authenticated)
+
+	// "exp" and "nbf" can be turned off as well
+	ja.ValidateExp = &disabled
+	ja.ValidateNbf = &disabled
+	assert.Nil(t, ja.Validate())
+	rw = httptest.NewRecorder()
+	r, _ = http.NewRequest("GET", "/", nil)
+	r.Header.Add("Authorization", issueTokenString(MapClaims{"sub": "ggicci", "exp": 689702400, "nbf": 3845462400}))
+	_, authenticated, err = ja.Authenticate(rw, r)
+	assert.Nil(t, err)
+	assert.True(t, authenticated)
+}
+
+func TestAuthenticate_ExpiredRedirect(t *testing.T) {
+	ja := &JWTAuth{
+		SignKey:         TestSignKey,
+		FromCookies:     []string{"user_session"},
+		ExpiredRedirect: "/login",
+		logger:          testLogger,
+	}
+	assert.Nil(t, ja.Validate())
+	assert.Equal(t, "jwt_flash", ja.ExpiredFlashCookie)
+
+	// expired token from cookies: redirect
+	expiredClaims := MapClaims{"sub": "ggicci", "exp": 689702400}
+	rw := httptest.NewRecorder()
+	r, _ := http.NewRequest("GET", "/", nil)
+	r.AddCookie(&http.Cookie{Name: "user_session", Value: issueTokenString(expiredClaims)})
+	gotUser, authenticated, err := ja.Authenticate(rw, r)
 	assert.NotNil(t, err)
 	assert.False(t, authenticated)
 	assert.Empty(t, gotUser.ID)
+	assert.Equal(t, http.StatusFound, rw.Code)
+	assert.Equal(t, "/login", rw.Header().Get("Location"))
+	assert.Contains(t, rw.Header().Get("Set-Cookie"), "jwt_flash=token_expired")
+
+	// expired token from header: no redirect
+	rw = httptest.NewRecorder()
+	r, _ = http.NewRequest("GET", "/", nil)
+	r.Header.Add("Authorization", issueTokenString(expiredClaims))
+	_, authenticated, err = ja.Authenticate(rw, r)
+	assert.NotNil(t, err)
+	assert.False(t, authenticated)
+	assert.Empty(t, rw.Header().Get("Location"))
+
+	// invalid token from cookies: no redirect
+	rw = httptest.NewRecorder()
+	r, _ = http.NewRequest("GET", "/", nil)
+	r.AddCookie(&http.Cookie{Name: "user_session", Value: issueTokenString(MapClaims{"sub": "ggicci"}) + "INVALID"})
+	_, authenticated, err = ja.Authenticate(rw, r)
+	assert.NotNil(t, err)
+	assert.False(t, authenticated)
+	assert.Empty(t, rw.Header().Get("Location"))
+}
+
+func TestAuthenticate_ExpiringWarning(t *testing.T) {
+	ja := &JWTAuth{
+		SignKey:        TestSignKey,
+		ExpiringWindow: caddy.Duration(2 * time.Minute),
+		logger:         testLogger,
+	}
+	assert.Nil(t, ja.Validate())
+	assert.Equal(t, "X-Token-Expiring", ja.ExpiringHeader)
+
+	// expiring soon
+	rw := httptest.NewRecorder()
+	r, _ := http.NewRequest("GET", "/", nil)
+	r.Header.Add("Authorization", issueTokenString(MapClaims{"sub": "ggicci", "exp": time.Now().Add(time.Minute).Unix()}))
+	_, authenticated, err := ja.Authenticate(rw, r)
+	assert.Nil(t, err)
+	assert.True(t, authenticated)
+	remaining, err := strconv.Atoi(rw.Header().Get("X-Token-Expiring"))
+	assert.Nil(t, err)
+	assert.InDelta(t, 60, remaining, 2)
+
+	// not expiring soon
+	rw = httptest.NewRecorder()
+	r, _ = http.NewRequest("GET", "/", nil)
+	r.Header.Add("Authorization", issueTokenString(MapClaims{"sub": "ggicci", "exp": time.Now().Add(time.Hour).Unix()}))
+	_, authenticated, err = ja.Authenticate(rw, r)
+	assert.Nil(t, err)
+	assert.True(t, authenticated)
+	assert.Empty(t, rw.Header().Get("X-Token-Expiring"))
+
+	// no "exp"
+	rw = httptest.NewRecorder()
+	r, _ = http.NewRequest("GET", "/", nil)
+	r.Header.Add("Authorization", issueTokenString(MapClaims{"sub": "ggicci"}))
+	_, authenticated, err = ja.Authenticate(rw, r)
+	assert.Nil(t, err)
+	assert.True(t, authenticated)
+	assert.Empty(t, rw.Header().Get("X-Token-Expiring"))
+}
+
+func TestAuthenticate_Leeway(t *testing.T) {
+	ja := &JWTAuth{SignKey: TestSignKey, logger: testLogger}
+	assert.Nil(t, ja.Validate())
+
+	authenticate := func(claims MapClaims) error {
+		claims["sub"] = "ggicci"
+		r, _ := http.NewRequest("POST", "/", nil)
+		r.Header.Add("Authorization", issueTokenString(claims))
+		_, _, err := ja.Authenticate(httptest.NewRecorder(), r)
+		return err
+	}
+	now := time.Now()
+	expired := MapClaims{"exp": now.Add(-10 * time.Second).Unix()}
+	notYetValid := MapClaims{"nbf": now.Add(10 * time.Second).Unix()}
+	issuedInFuture := MapClaims{"iat": now.Add(10 * time.Second).Unix()}
+
+	// strict by default
+	assert.ErrorIs(t, authenticate(expired), ErrTokenExpired)
+	assert.ErrorIs(t, authenticate(notYetValid), ErrTokenNotYetValid)
+	assert.ErrorIs(t, authenticate(issuedInFuture), ErrInvalidIssuedAt)
+
+	ja.Leeway = caddy.Duration(30 * time.Second)
+	assert.Nil(t, ja.Validate())
+	assert.Nil(t, authenticate(expired))
+	assert.Nil(t, authenticate(notYetValid))
+	assert.Nil(t, authenticate(issuedInFuture))
+	assert.ErrorIs(t, authenticate(MapClaims{"exp": now.Add(-time.Minute).Unix()}), ErrTokenExpired)
+
+	ja.Leeway = caddy.Duration(-time.Second)
+	assert.ErrorContains(t, ja.Validate(), "invalid leeway")
+}
+
+func TestAuthenticate_TokenLifetime(t *testing.T) {
+	ja := &JWTAuth{
+		SignKey:     TestSignKey,
+		MaxTokenAge: caddy.Duration(time.Hour),
+		RequireExp:  true,
+		logger:      testLogger,
+	}
+	assert.Nil(t, ja.Validate())
+
+	authenticate := func(claims MapClaims) error {
+		claims["sub"] = "ggicci"
+		r, _ := http.NewRequest("GET", "/", nil)
+		r.Header.Add("Authorization", issueTokenString(claims))
+		_, _, err := ja.Authenticate(httptest.NewRecorder(), r)
+		return err
+	}
+	now := time.Now()
+	exp := now.Add(time.Hour).Unix()
+	assert.Nil(t, authenticate(MapClaims{"iat": now.Add(-time.Minute).Unix(), "exp": exp}))
+	assert.ErrorIs(t, authenticate(MapClaims{"iat": now.Add(-2 * time.Hour).Unix(), "exp": exp}), ErrTokenTooOld)
+	assert.ErrorIs(t, authenticate(MapClaims{"exp": exp}), ErrTokenTooOld) // no iat
+	assert.ErrorIs(t, authenticate(MapClaims{"iat": now.Unix()}), ErrMissingExp)
+
+	ja.MaxTokenAge, ja.RequireExp = 0, false
+	assert.Nil(t, authenticate(MapClaims{"iat": now.Add(-2 * time.Hour).Unix()}))
+
+	ja.MaxTokenAge = caddy.Duration(-time.Second)
+	assert.ErrorContains(t, ja.Validate(), "invalid max_token_age")
+}
+
+func TestAuthenticate_ExpiredGrace(t *testing.T) {
+	ja := &JWTAuth{
+		SignKey:      TestSignKey,
+		ExpiredGrace: caddy.Duration(time.Minute),
+		logger:       testLogger,
+	}
+	assert.Nil(t, ja.Validate())
+
+	authenticate := func(method string, exp time.Time) error {
+		r, _ := http.NewRequest(method, "/", nil)
+		r.Header.Add("Authorization", issueTokenString(MapClaims{"sub": "ggicci", "exp": exp.Unix()}))
+		_, _, err := ja.Authenticate(httptest.NewRecorder(), r)
+		return err
+	}
+	recentlyExpired := time.Now().Add(-30 * time.Second)
+	assert.Nil(t, authenticate("GET", recentlyExpired))
+	assert.Nil(t, authenticate("HEAD", recentlyExpired))
+	assert.ErrorIs(t, authenticate("POST", recentlyExpired), ErrTokenExpired)
+	assert.ErrorIs(t, authenticate("DELETE", recentlyExpired), ErrTokenExpired)
+	assert.ErrorIs(t, authenticate("GET", time.Now().Add(-2*time.Minute)), ErrTokenExpired)
+
+	ja.ExpiredGrace = caddy.Duration(-time.Minute)
+	assert.ErrorContains(t, ja.Validate(), "invalid expired_grace")
+}
+
+func TestAuthenticate_QueryTokenNoStore(t *testing.T) {
+	ja := &JWTAuth{
+		SignKey:           TestSignKey,
+		FromQuery:         []string{"access_token"},
+		QueryTokenNoStore: true,
+		logger:            testLogger,
+	}
+	assert.Nil(t, ja.Validate())
+	token := issueTokenString(MapClaims{"sub": "ggicci"})
+
+	rw := httptest.NewRecorder()
+	r, _ := http.NewRequest("GET", "/?access_token="+token, nil)
+	_, authenticated, err := ja.Authenticate(rw, r)
+	assert.Nil(t, err)
+	assert.True(t, authenticated)
+	assert.Equal(t, "no-store", rw.Header().Get("Cache-Control"))
+	assert.Equal(t, "no-cache", rw.Header().Get("Pragma"))
+
+	// not from the query
+	rw = httptest.NewRecorder()
+	r, _ = http.NewRequest("GET", "/", nil)
+	r.Header.Add("Authorization", token)
+	_, authenticated, err = ja.Authenticate(rw, r)
+	assert.Nil(t, err)
+	assert.True(t, authenticated)
+	assert.Empty(t, rw.Header().Get("Cache-Control"))
 }
 
 func TestAuthenticate_VerifyIssuerWhitelist(t *testing.T) {
@@ -437,7 +752,7 @@ func TestAuthenticate_VerifyIssuerWhitelist(t *testing.T) {
 	r, _ = http.NewRequest("GET", "/", nil)
 	r.Header.Add("Authorization", issueTokenString(noIssClaims))
 	gotUser, authenticated, err = ja.Authenticate(rw, r)
-	assert.NotNil(t, err)
+	assert.ErrorIs(t, err, ErrInvalidIssuer)
 	assert.False(t, authenticated)
 	assert.Empty(t, gotUser.ID)
 
@@ -447,7 +762,7 @@ func TestAuthenticate_VerifyIssuerWhitelist(t *testing.T) {
 	r, _ = http.NewRequest("GET", "/", nil)
 	r.Header.Add("Authorization", issueTokenString(wrongIssClaims))
 	gotUser, authenticated, err = ja.Authenticate(rw, r)
-	assert.NotNil(t, err)
+	assert.ErrorIs(t, err, ErrInvalidIssuer)
 	assert.False(t, authenticated)
 	assert.Empty(t, gotUser.ID)
 }
@@ -496,7 +811,7 @@ func TestAuthenticate_VerifyAudienceWhitelist(t *testing.T) {
 	r, _ = http.NewRequest("GET", "/", nil)
 	r.Header.Add("Authorization", issueTokenString(noIssClaims))
 	gotUser, authenticated, err = ja.Authenticate(rw, r)
-	assert.NotNil(t, err)
+	assert.ErrorIs(t, err, ErrAudienceMismatch)
 	assert.False(t, authenticated)
 	assert.Empty(t, gotUser.ID)
 
@@ -510,7 +825,7 @@ func TestAuthenticate_VerifyAudienceWhitelist(t *testing.T) {
 	r, _ = http.NewRequest("GET", "/", nil)
 	r.Header.Add("Authorization", issueTokenString(wrongIssClaims))
 	gotUser, authenticated, err = ja.Authenticate(rw, r)
-	assert.NotNil(t, err)
+	assert.ErrorIs(t, err, ErrAudienceMismatch)
 	assert.False(t, authenticated)
 	assert.Empty(t, gotUser.ID)
 }
@@ -582,7 +897,10 @@ func Test_stringify(t *testing.T) {
 		{false, "false"},
 		{json.Number("1991"), "1991"},
 		{now, now.UTC().Format(time.RFC3339Nano)},
-		{[]int{1, 2, 3}, ""},                // unsupported array type
+		{1.5, "1.5"},
+		{[]int{1, 2, 3}, "1,2,3"},
+		{[]interface{}{2.0, true, "x"}, "2,true,x"},
+		{[]byte("abc"), ""},                 // unsupported bytes
 		{ThingNotStringer{}, ""},            // unsupported custom type
 		{ThingIsStringer{}, "i'm stringer"}, // support fmt.Stringer interface
 	} {
@@ -628,13 +946,52 @@ func Test_AsymmetricAlgorithm(t *testing.T) {
 	assert.Equal(t, User{ID: "ggicci"}, gotUser)
 }
 
+func Test_AsymmetricAlgorithm_ECAndEd25519(t *testing.T) {
+	ecKey := func(curve elliptic.Curve) interface{} {
+		key, err := ecdsa.GenerateKey(curve, rand.Reader)
+		panicOnError(err)
+		return key
+	}
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	panicOnError(err)
+
+	for _, tc := range []struct {
+		alg jwa.SignatureAlgorithm
+		key crypto.Signer
+	}{
+		{jwa.ES256, ecKey(elliptic.P256()).(crypto.Signer)},
+		{jwa.ES384, ecKey(elliptic.P384()).(crypto.Signer)},
+		{jwa.ES512, ecKey(elliptic.P521()).(crypto.Signer)},
+		{jwa.EdDSA, edKey},
+	} {
+		der, err := x509.MarshalPKIXPublicKey(tc.key.Public())
+		panicOnError(err)
+		ja := &JWTAuth{
+			SignKey: string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})),
+			logger:  testLogger,
+		}
+		assert.Nil(t, ja.Validate(), tc.alg)
+
+		signed, err := jwt.Sign(buildToken(MapClaims{"sub": "ggicci"}), jwt.WithKey(tc.alg, tc.key))
+		panicOnError(err)
+		r, _ := http.NewRequest("GET", "/", nil)
+		r.Header.Add("Authorization", "Bearer "+string(signed))
+		gotUser, authenticated, err := ja.Authenticate(httptest.NewRecorder(), r)
+		assert.Nil(t, err, tc.alg)
+		assert.True(t, authenticated, tc.alg)
+		assert.Equal(t, "ggicci", gotUser.ID, tc.alg)
+
+		ja.SignAlgorithm = "RS256"
+		assert.ErrorIs(t, ja.Validate(), ErrInvalidSignAlgorithm, tc.alg)
+	}
+}
+
 func Test_AsymmetricAlgorithm_InvalidPubKey(t *testing.T) {
 	ja := &JWTAuth{SignKey: `-----BEGIN PUBLIC KEY-----\nMIIBIjANBgkqhkiG9w0BAQEFAA ... invalid\n-----END PUBLIC KEY-----`, UserClaims: []string{"login"}, logger: testLogger}
 	assert.ErrorIs(t, ja.Validate(), ErrInvalidPublicKey)
 }
 
 func TestJWK(t *testing.T) {
-	time.Sleep(3 * time.Second)
 	ja := &JWTAuth{JWKURL: TestJWKURL, logger: testLogger}
 	assert.Nil(t, ja.Validate())
 	assert.Equal(t, 1, ja.jwkCachedSet.Len())
@@ -650,23 +1007,28 @@ func TestJWK(t *testing.T) {
 }
 
 func TestJWKSet(t *testing.T) {
-	time.Sleep(3 * time.Second)
 	ja := &JWTAuth{JWKURL: TestJWKSetURL, logger: testLogger}
 	assert.Nil(t, ja.Validate())
 	assert.Equal(t, 2, ja.jwkCachedSet.Len())
 
 	token := issueTokenStringJWK(MapClaims{"sub": "ggicci"})
 	rw := httptest.NewRecorder()
-	r, _ := http.NewRequest("GET", "/", nil)
+	r, repl := newRequestWithReplacer("GET", "/")
 	r.Header.Add("Authorization", "Bearer "+token)
 	gotUser, authenticated, err := ja.Authenticate(rw, r)
 	assert.Nil(t, err)
 	assert.True(t, authenticated)
 	assert.Equal(t, User{ID: "ggicci"}, gotUser)
+
+	keySource, _ := repl.Get("http.auth.jwt.key_source")
+	assert.Equal(t, "jwk_url", keySource)
+	keyLocation, _ := repl.Get("http.auth.jwt.key_location")
+	assert.Equal(t, TestJWKSetURL, keyLocation)
+	kid, _ := repl.Get("http.auth.jwt.kid")
+	assert.Equal(t, jwkKey.KeyID(), kid)
 }
 
 func TestJWKSet_KeyNotFound(t *testing.T) {
-	time.Sleep(3 * time.Second)
 	ja := &JWTAuth{JWKURL: TestJWKSetURLInapplicable, logger: testLogger}
 	assert.Nil(t, ja.Validate())
 	assert.Equal(t, 2, ja.jwkCachedSet.Len())
@@ -676,7 +1038,39 @@ func TestJWKSet_KeyNotFound(t *testing.T) {
 	r, _ := http.NewRequest("GET", "/", nil)
 	r.Header.Add("Authorization", "Bearer "+token)
 	gotUser, authenticated, err := ja.Authenticate(rw, r)
-	assert.Error(t, err)
+	assert.ErrorIs(t, err, ErrKeyNotFound)
 	assert.False(t, authenticated)
 	assert.Empty(t, gotUser.ID)
 }
+
+func TestCleanup_NoLeakedGoroutines(t *testing.T) {
+	oidc := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{"jwks_uri": TestJWKSetURL})
+	}))
+	defer oidc.Close()
+	webhook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer webhook.Close()
+	path := filepath.Join(t.TempDir(), "jwks.json")
+	data, _ := json.Marshal(jwkPubKeySet)
+	assert.Nil(t, os.WriteFile(path, data, 0600))
+
+	reload := func() {
+		for _, ja := range []*JWTAuth{
+			{JWKURL: TestJWKSetURL, DenyWebhook: &DenyWebhook{URL: webhook.URL}, SandboxParsing: &SandboxParsing{}},
+			{OIDCIssuer: oidc.URL},
+			{JWKFile: path},
+			{SignKey: TestSignKey, Issuers: map[string]*IssuerKeys{"https://a.example.com": {JWKURL: TestJWKSetURL}}},
+		} {
+			ja.logger = testLogger
+			assert.Nil(t, ja.Validate())
+			assert.Nil(t, ja.Cleanup())
+		}
+	}
+	reload() // warms up, e.g. the idle connections
+	baseline := runtime.NumGoroutine()
+	for i := 0; i < 5; i++ {
+		reload()
+	}
+	assert.Eventually(t, func() bool { return runtime.NumGoroutine() <= baseline }, 5*time.Second, 10*time.Millisecond,
+		"goroutines: %d, baseline: %d", runtime.NumGoroutine(), baseline)
+}
@@ -0,0 +1,143 @@
+package caddyjwt
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"go.uber.org/zap"
+)
+
+// setupSignKeyFile loads SignKeyFile and watches it for changes.
+func (ja *JWTAuth) setupSignKeyFile() error {
+	data, err := os.ReadFile(ja.SignKeyFile)
+	if err != nil {
+		return err
+	}
+	if err := ja.loadSignKeyData(data); err != nil {
+		return err
+	}
+	return ja.watchKeyFile(ja.SignKeyFile, data, ja.loadSignKeyData)
+}
+
+func (ja *JWTAuth) loadSignKeyData(data []byte) error {
+	return ja.loadSignKey(strings.TrimSpace(string(data)))
+}
+
+// setupJWKFile loads JWKFile and watches it for changes.
+func (ja *JWTAuth) setupJWKFile() error {
+	ja.jwkMu = new(sync.RWMutex)
+	data, err := os.ReadFile(ja.JWKFile)
+	if err != nil {
+		return err
+	}
+	if err := ja.loadJWKData(data); err != nil {
+		return err
+	}
+	return ja.watchKeyFile(ja.JWKFile, data, ja.loadJWKData)
+}
+
+// loadJWKFile reloads JWKFile, e.g. when a key is not found in it.
+func (ja *JWTAuth) loadJWKFile() error {
+	data, err := os.ReadFile(ja.JWKFile)
+	if err != nil {
+		return err
+	}
+	return ja.loadJWKData(data)
+}
+
+func (ja *JWTAuth) loadJWKData(data []byte) error {
+	idx, err := parseKeyIndex(ja.JWKFile, data) // the keys are parsed on demand
+	if err != nil {
+		return err
+	}
+	ja.jwkMu.Lock()
+	ja.jwkURL, ja.jwkIndex = ja.JWKFile, idx
+	ja.jwkMu.Unlock()
+	ja.logger.Info("using JWKs from file", zap.String("path", ja.JWKFile), zap.Int("loaded_keys", idx.len()))
+	return nil
+}
+
+// staticJWKsSource is the source of the index of JWKSets and JWKFiles.
+const staticJWKsSource = "static"
+
+// setupStaticJWKs indexes the keys of JWKSets and JWKFiles, once. The keys
+// are parsed on demand.
+func (ja *JWTAuth) setupStaticJWKs() error {
+	ja.jwkMu = new(sync.RWMutex)
+	idx := &keyIndex{source: staticJWKsSource, byKID: make(map[string]*indexedKey), loadedAt: time.Now()}
+	for i, data := range ja.JWKSets {
+		set, err := parseKeyIndex(staticJWKsSource, data)
+		if err != nil {
+			return fmt.Errorf("invalid jwk_sets #%d: %w", i, err)
+		}
+		idx.merge(set)
+	}
+	for _, path := range ja.JWKFiles {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return fmt.Errorf("invalid jwk_files: %w", err)
+		}
+		set, err := parseKeyIndex(path, data)
+		if err != nil {
+			return fmt.Errorf("invalid jwk_files %q: %w", path, err)
+		}
+		idx.merge(set)
+	}
+	ja.jwkURL, ja.jwkIndex = staticJWKsSource, idx
+	ja.logger.Info("using static JWKs", zap.Int("jwk_sets", len(ja.JWKSets)), zap.Strings("jwk_files", ja.JWKFiles), zap.Int("loaded_keys", idx.len()))
+	return nil
+}
+
+// watchKeyFile calls load with the content of the key file whenever it
+// changes, until Cleanup. The directory is watched rather than the file, so
+// the file being replaced is noticed, e.g. Kubernetes swaps the symlinks of
+// the mounted secrets. A content failing to load, e.g. partially written, is
+// logged and the previous key stays in use.
+func (ja *JWTAuth) watchKeyFile(path string, loaded []byte, load func([]byte) error) error {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	if err := watcher.Add(filepath.Dir(path)); err != nil {
+		watcher.Close()
+		return err
+	}
+	ja.stopKeyWatcher = func() { watcher.Close() }
+
+	go func() {
+		logger := ja.logger.With(zap.String("path", path))
+		for {
+			select {
+			case event, ok := <-watcher.Events:
+				if !ok {
+					return
+				}
+				if event.Has(fsnotify.Chmod) {
+					continue
+				}
+				data, err := os.ReadFile(path)
+				if err != nil || bytes.Equal(data, loaded) {
+					continue // removed or not changed, wait for the next event
+				}
+				if err := load(data); err != nil {
+					logger.Error("failed to reload key file", zap.Error(err))
+					continue
+				}
+				loaded = data
+				logger.Info("reloaded key file")
+			case err, ok := <-watcher.Errors:
+				if !ok {
+					return
+				}
+				logger.Error("watching key file", zap.Error(err))
+			}
+		}
+	}()
+	return nil
+}
@@ -0,0 +1,106 @@
+package caddyjwt
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/lestrrat-go/jwx/v2/jwa"
+	"github.com/lestrrat-go/jwx/v2/jwt"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAuthenticate_SignKeyFile(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "sign_key")
+	assert.Nil(t, os.WriteFile(path, []byte(TestSignKey+"\n"), 0600))
+
+	ja := &JWTAuth{SignKeyFile: path, logger: testLogger}
+	assert.Nil(t, ja.Validate())
+	defer ja.Cleanup()
+
+	authenticate := func(key []byte) error {
+		signed, err := jwt.Sign(buildToken(MapClaims{"sub": "ggicci"}), jwt.WithKey(jwa.HS256, key))
+		panicOnError(err)
+		r, _ := http.NewRequest("GET", "/", nil)
+		r.Header.Add("Authorization", string(signed))
+		_, _, err = ja.Authenticate(httptest.NewRecorder(), r)
+		return err
+	}
+	assert.Nil(t, authenticate(RawTestSignKey))
+
+	// rotated by replacing the file
+	rotated := []byte("M1dRGUOobp8xC2EWzrJf7DSBDPSdOpwE")
+	tmp := filepath.Join(dir, "sign_key.tmp")
+	assert.Nil(t, os.WriteFile(tmp, []byte(base64.StdEncoding.EncodeToString(rotated)), 0600))
+	assert.Nil(t, os.Rename(tmp, path))
+	assert.Eventually(t, func() bool { return authenticate(rotated) == nil }, 5*time.Second, 10*time.Millisecond)
+	assert.ErrorIs(t, authenticate(RawTestSignKey), ErrInvalidToken)
+
+	// a broken file keeps the previous key
+	assert.Nil(t, os.WriteFile(path, []byte("-----BEGIN PUBLIC KEY-----"), 0600))
+	time.Sleep(100 * time.Millisecond)
+	assert.Nil(t, authenticate(rotated))
+}
+
+func TestAuthenticate_JWKFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "jwks.json")
+	data, _ := json.Marshal(jwkPubKeySetInapplicable)
+	assert.Nil(t, os.WriteFile(path, data, 0600))
+
+	ja := &JWTAuth{JWKFile: path, logger: testLogger}
+	assert.Nil(t, ja.Validate())
+	defer ja.Cleanup()
+
+	authenticate := func() error {
+		r, _ := newRequestWithReplacer("GET", "/")
+		r.Header.Add("Authorization", issueTokenStringJWK(MapClaims{"sub": "ggicci"}))
+		_, _, err := ja.Authenticate(httptest.NewRecorder(), r)
+		return err
+	}
+	assert.ErrorIs(t, authenticate(), ErrKeyNotFound)
+
+	data, _ = json.Marshal(jwkPubKeySet)
+	assert.Nil(t, os.WriteFile(path, data, 0600))
+	assert.Eventually(t, func() bool { return authenticate() == nil }, 5*time.Second, 10*time.Millisecond)
+}
+
+func TestAuthenticate_StaticJWKs(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "jwks.json")
+	data, _ := json.Marshal(jwkPubKeySet)
+	assert.Nil(t, os.WriteFile(path, data, 0600))
+	inapplicable, _ := json.Marshal(jwkPubKeySetInapplicable)
+
+	authenticate := func(ja *JWTAuth) error {
+		assert.Nil(t, ja.Validate())
+		defer ja.Cleanup()
+		r, _ := newRequestWithReplacer("GET", "/")
+		r.Header.Add("Authorization", issueTokenStringJWK(MapClaims{"sub": "ggicci"}))
+		_, _, err := ja.Authenticate(httptest.NewRecorder(), r)
+		return err
+	}
+	assert.Nil(t, authenticate(&JWTAuth{JWKSets: []json.RawMessage{inapplicable, data}, logger: testLogger}))
+	assert.Nil(t, authenticate(&JWTAuth{JWKSets: []json.RawMessage{inapplicable}, JWKFiles: []string{path}, logger: testLogger}))
+	assert.ErrorIs(t, authenticate(&JWTAuth{JWKSets: []json.RawMessage{inapplicable}, logger: testLogger}), ErrKeyNotFound)
+
+	ja := &JWTAuth{JWKSets: []json.RawMessage{json.RawMessage(`{"keys": 1}`)}, logger: testLogger}
+	assert.ErrorContains(t, ja.Validate(), "invalid jwk_sets #0")
+	ja = &JWTAuth{JWKFiles: []string{filepath.Join(t.TempDir(), "missing")}, logger: testLogger}
+	assert.ErrorContains(t, ja.Validate(), "invalid jwk_files")
+	ja = &JWTAuth{JWKFiles: []string{path}, JWKURL: TestJWKSetURL, logger: testLogger}
+	assert.ErrorContains(t, ja.Validate(), "exclude jwk_url")
+}
+
+func TestValidate_KeyFileConflicts(t *testing.T) {
+	ja := &JWTAuth{SignKey: TestSignKey, SignKeyFile: "/etc/caddy/sign_key", logger: testLogger}
+	assert.ErrorContains(t, ja.Validate(), "mutually exclusive")
+	ja = &JWTAuth{JWKFile: "/etc/caddy/jwks.json", JWKURL: TestJWKSetURL, logger: testLogger}
+	assert.ErrorContains(t, ja.Validate(), "jwk_file excludes")
+	ja = &JWTAuth{SignKeyFile: filepath.Join(t.TempDir(), "missing"), logger: testLogger}
+	assert.ErrorContains(t, ja.Validate(), "invalid sign_key_file")
+}
@@ -0,0 +1,150 @@
+package caddyjwt
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/lestrrat-go/jwx/v2/jwk"
+)
+
+// keyIndex indexes the JWKs by kid, so a lookup is O(1) rather than a linear
+// scan of the set, which matters to the federations trusting thousands of
+// keys. The keys loaded from JWKFile are kept raw and parsed on their first
+// lookup only, and at most once.
+type keyIndex struct {
+	source   string // the URL or the path the keys were loaded from
+	byKID    map[string]*indexedKey
+	size     int
+	loadedAt time.Time
+}
+
+type indexedKey struct {
+	raw  json.RawMessage
+	once sync.Once
+	key  jwk.Key
+	err  error
+}
+
+func (ik *indexedKey) get() (jwk.Key, error) {
+	ik.once.Do(func() {
+		if ik.key == nil {
+			ik.key, ik.err = jwk.ParseKey(ik.raw)
+			ik.raw = nil
+		}
+	})
+	return ik.key, ik.err
+}
+
+// indexKeySet indexes the parsed keys of the set. As jwk.Set.LookupKeyID,
+// the first of the keys sharing a kid wins.
+func indexKeySet(source string, set jwk.Set) *keyIndex {
+	idx := &keyIndex{source: source, byKID: make(map[string]*indexedKey, set.Len()), loadedAt: time.Now()}
+	for i := 0; i < set.Len(); i++ {
+		key, _ := set.Key(i)
+		idx.add(key.KeyID(), &indexedKey{key: key})
+	}
+	return idx
+}
+
+// parseKeyIndex indexes the raw JWKS, or the single JWK, without parsing the
+// keys of the set, only their kids.
+func parseKeyIndex(source string, data []byte) (*keyIndex, error) {
+	var jwks struct {
+		Keys []json.RawMessage `json:"keys"`
+	}
+	if err := json.Unmarshal(data, &jwks); err != nil {
+		return nil, err
+	}
+	if jwks.Keys == nil {
+		key, err := jwk.ParseKey(data)
+		if err != nil {
+			return nil, err
+		}
+		return indexKeySet(source, singleKeySet(key)), nil
+	}
+
+	idx := &keyIndex{source: source, byKID: make(map[string]*indexedKey, len(jwks.Keys)), loadedAt: time.Now()}
+	for i, raw := range jwks.Keys {
+		var header struct {
+			KID string `json:"kid"`
+		}
+		if err := json.Unmarshal(raw, &header); err != nil {
+			return nil, fmt.Errorf("key #%d: %w", i, err)
+		}
+		idx.add(header.KID, &indexedKey{raw: raw})
+	}
+	return idx, nil
+}
+
+func singleKeySet(key jwk.Key) jwk.Set {
+	set := jwk.NewSet()
+	_ = set.AddKey(key)
+	return set
+}
+
+func (idx *keyIndex) add(kid string, ik *indexedKey) {
+	idx.size++
+	if _, ok := idx.byKID[kid]; !ok {
+		idx.byKID[kid] = ik
+	}
+}
+
+// merge adds the keys of the other index. The keys already indexed win over
+// the ones of the same kid.
+func (idx *keyIndex) merge(other *keyIndex) {
+	idx.size += other.size
+	for kid, ik := range other.byKID {
+		if _, ok := idx.byKID[kid]; !ok {
+			idx.byKID[kid] = ik
+		}
+	}
+}
+
+// lookup returns the key of the kid, parsing it if not yet. The error is
+// non-nil if the raw key is broken.
+func (idx *keyIndex) lookup(kid string) (jwk.Key, bool, error) {
+	ik, ok := idx.byKID[kid]
+	if !ok {
+		return nil, false, nil
+	}
+	key, err := ik.get()
+	if err != nil {
+		return nil, false, err
+	}
+	return key, true, nil
+}
+
+// len returns the number of the keys indexed, including the ones shadowed
+// by a duplicated kid.
+func (idx *keyIndex) len() int {
+	return idx.size
+}
+
+// keyInfo describes an indexed key, see keys.
+type keyInfo struct {
+	KID       string `json:"kid"`
+	Algorithm string `json:"alg,omitempty"`
+	KeyType   string `json:"kty,omitempty"`
+	Use       string `json:"use,omitempty"`
+	Error     string `json:"error,omitempty"` // of parsing the key
+}
+
+// keys describes the indexed keys, sorted by kid, excluding the ones
+// shadowed by a duplicated kid.
+func (idx *keyIndex) keys() []keyInfo {
+	infos := make([]keyInfo, 0, len(idx.byKID))
+	for kid, ik := range idx.byKID {
+		info := keyInfo{KID: kid}
+		if key, err := ik.get(); err != nil {
+			info.Error = err.Error()
+		} else {
+			info.Algorithm, info.KeyType, info.Use = key.Algorithm().String(), key.KeyType().String(), key.KeyUsage()
+		}
+		infos = append(infos, info)
+	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].KID < infos[j].KID })
+	return infos
+}
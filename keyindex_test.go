@@ -0,0 +1,72 @@
+package caddyjwt
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseKeyIndex(t *testing.T) {
+	pub, _ := json.Marshal(jwkPubKey)
+	data := []byte(fmt.Sprintf(`{"keys": [{"kid": "broken", "kty": "nope"}, %s, {"kid": %q, "kty": "oct", "k": "c2VjcmV0"}]}`, pub, jwkPubKey.KeyID()))
+	idx, err := parseKeyIndex("jwks.json", data)
+	assert.Nil(t, err)
+	assert.Equal(t, 3, idx.len())
+
+	// parsed on demand
+	assert.NotNil(t, idx.byKID[jwkPubKey.KeyID()].raw)
+	key, found, err := idx.lookup(jwkPubKey.KeyID())
+	assert.Nil(t, err)
+	assert.True(t, found)
+	assert.Equal(t, jwkPubKey.KeyID(), key.KeyID()) // the first one wins
+	assert.Nil(t, idx.byKID[jwkPubKey.KeyID()].raw)
+
+	_, found, err = idx.lookup("missing")
+	assert.Nil(t, err)
+	assert.False(t, found)
+	_, found, err = idx.lookup("broken")
+	assert.NotNil(t, err)
+	assert.False(t, found)
+
+	// a single key
+	idx, err = parseKeyIndex("jwk.json", pub)
+	assert.Nil(t, err)
+	_, found, _ = idx.lookup(jwkPubKey.KeyID())
+	assert.True(t, found)
+
+	_, err = parseKeyIndex("jwks.json", []byte(`{"keys": [1]}`))
+	assert.NotNil(t, err)
+}
+
+func TestAuthenticate_JWKFileBrokenKey(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "jwks.json")
+	data := []byte(fmt.Sprintf(`{"keys": [{"kid": %q, "kty": "RSA"}]}`, jwkPubKey.KeyID()))
+	assert.Nil(t, os.WriteFile(path, data, 0600))
+
+	ja := &JWTAuth{JWKFile: path, logger: testLogger}
+	assert.Nil(t, ja.Validate())
+	defer ja.Cleanup()
+
+	r, _ := http.NewRequest("GET", "/", nil)
+	r.Header.Add("Authorization", issueTokenStringJWK(MapClaims{"sub": "ggicci"}))
+	_, _, err := ja.Authenticate(httptest.NewRecorder(), r)
+	assert.ErrorIs(t, err, ErrKeyNotFound)
+	assert.ErrorContains(t, err, "is invalid")
+}
+
+func TestValidate_JWKURLIndexed(t *testing.T) {
+	ja := &JWTAuth{JWKURL: TestJWKSetURL, logger: testLogger}
+	assert.Nil(t, ja.Validate())
+	defer ja.Cleanup()
+
+	idx := ja.jwkIndexOf(TestJWKSetURL)
+	assert.NotNil(t, idx)
+	assert.Equal(t, 2, idx.len())
+	assert.Nil(t, ja.jwkIndexOf(TestJWKURL))
+}
@@ -0,0 +1,77 @@
+package caddyjwt
+
+import (
+	"sync"
+	"time"
+)
+
+// jwkProviders are the JWT providers verifying the tokens by the JWKs in
+// this process, which can be inspected via the admin API.
+var jwkProviders = struct {
+	mu        sync.Mutex
+	providers map[*JWTAuth]struct{}
+}{providers: make(map[*JWTAuth]struct{})}
+
+func registerJWKProvider(ja *JWTAuth) {
+	jwkProviders.mu.Lock()
+	defer jwkProviders.mu.Unlock()
+	jwkProviders.providers[ja] = struct{}{}
+}
+
+func unregisterJWKProvider(ja *JWTAuth) {
+	jwkProviders.mu.Lock()
+	defer jwkProviders.mu.Unlock()
+	delete(jwkProviders.providers, ja)
+}
+
+// lookupJWKProviders returns the JWK providers of the name, or all of them
+// if the name is empty.
+func lookupJWKProviders(name string) []*JWTAuth {
+	jwkProviders.mu.Lock()
+	defer jwkProviders.mu.Unlock()
+	var providers []*JWTAuth
+	for ja := range jwkProviders.providers {
+		if name == "" || ja.Name == name {
+			providers = append(providers, ja)
+		}
+	}
+	return providers
+}
+
+// keysReport describes the JWKs loaded by a provider.
+type keysReport struct {
+	Provider string     `json:"provider"`
+	Source   string     `json:"source"`    // "jwk_url", "jwk_file" or "jwk_static"
+	Location string     `json:"location"`  // e.g. the JWKS URL
+	LoadedAt *time.Time `json:"loaded_at"` // of the last refresh, nil if never loaded
+	Keys     []keyInfo  `json:"keys"`
+}
+
+// keysReport reports the JWKs loaded.
+func (ja *JWTAuth) keysReport() keysReport {
+	report := keysReport{Provider: ja.Name, Source: "jwk_url", Keys: []keyInfo{}}
+	switch {
+	case ja.JWKFile != "":
+		report.Source = "jwk_file"
+	case ja.usingStaticJWKs():
+		report.Source = "jwk_static"
+	}
+	ja.jwkMu.RLock()
+	location, idx := ja.jwkURL, ja.jwkIndex
+	ja.jwkMu.RUnlock()
+	report.Location = location
+	if idx != nil && idx.source == location {
+		loadedAt := idx.loadedAt
+		report.LoadedAt, report.Keys = &loadedAt, idx.keys()
+	}
+	return report
+}
+
+// flushValidationCache drops the cached verifications, see ValidationCache,
+// and returns their number.
+func (ja *JWTAuth) flushValidationCache() int {
+	if ja.ValidationCache == nil || ja.ValidationCache.cache == nil {
+		return 0
+	}
+	return ja.ValidationCache.cache.DeleteFunc(func(string) bool { return true })
+}
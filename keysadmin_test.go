@@ -0,0 +1,58 @@
+package caddyjwt
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAdminKeys(t *testing.T) {
+	ja := &JWTAuth{
+		Name:            "keys",
+		JWKURL:          TestJWKSetURL,
+		ValidationCache: &ValidationCache{},
+		logger:          testLogger,
+	}
+	assert.Nil(t, ja.Validate())
+	defer ja.Cleanup()
+
+	r, _ := http.NewRequest("GET", "/", nil)
+	r.Header.Add("Authorization", issueTokenStringJWK(MapClaims{"sub": "ggicci"}))
+	_, err := ja.authenticate(r)
+	assert.Nil(t, err)
+
+	serve := func(method, query string) (*httptest.ResponseRecorder, error) {
+		rw := httptest.NewRecorder()
+		r, _ := http.NewRequest(method, "/jwtauth/keys?"+query, nil)
+		return rw, adminAPI{}.handleKeys(rw, r)
+	}
+
+	rw, err := serve("GET", "provider=keys")
+	assert.Nil(t, err)
+	var reports []keysReport
+	assert.Nil(t, json.Unmarshal(rw.Body.Bytes(), &reports))
+	assert.Len(t, reports, 1)
+	assert.Equal(t, "jwk_url", reports[0].Source)
+	assert.Equal(t, TestJWKSetURL, reports[0].Location)
+	assert.NotNil(t, reports[0].LoadedAt)
+	assert.NotEmpty(t, reports[0].Keys)
+
+	rw, err = serve("POST", "provider=keys&action=flush")
+	assert.Nil(t, err)
+	assert.JSONEq(t, `{"flushed": 1}`, rw.Body.String())
+
+	rw, err = serve("POST", "provider=keys&action=refresh")
+	assert.Nil(t, err)
+	assert.JSONEq(t, `{"refreshed": 1}`, rw.Body.String())
+
+	_, err = serve("POST", "provider=keys&action=rotate")
+	assert.NotNil(t, err)
+	_, err = serve("GET", "provider=unknown")
+	assert.NotNil(t, err)
+
+	assert.Nil(t, ja.Cleanup())
+	assert.Empty(t, lookupJWKProviders("keys"))
+}
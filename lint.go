@@ -0,0 +1,135 @@
+package caddyjwt
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// lintSeverity is the severity level of a lint issue.
+type lintSeverity int
+
+const (
+	lintLow lintSeverity = iota
+	lintMedium
+	lintHigh
+)
+
+func (s lintSeverity) String() string {
+	switch s {
+	case lintHigh:
+		return "high"
+	case lintMedium:
+		return "medium"
+	default:
+		return "low"
+	}
+}
+
+func parseLintSeverity(s string) (lintSeverity, error) {
+	switch strings.ToLower(s) {
+	case "high":
+		return lintHigh, nil
+	case "medium":
+		return lintMedium, nil
+	case "low":
+		return lintLow, nil
+	}
+	return lintLow, fmt.Errorf("unknown severity %q", s)
+}
+
+// lintIssue is a security smell found in the configuration of a provider.
+type lintIssue struct {
+	Severity lintSeverity
+	Option   string
+	Message  string
+}
+
+func (li lintIssue) String() string {
+	return fmt.Sprintf("[%s] %s: %s", strings.ToUpper(li.Severity.String()), li.Option, li.Message)
+}
+
+// minSymmetricKeySize is the minimum size of the key for HMAC algorithms,
+// see https://www.rfc-editor.org/rfc/rfc7518#section-3.2
+const minSymmetricKeySize = 32
+
+// lint reports the security smells in the configuration. It doesn't
+// validate the configuration, call Validate for that, but it checks the
+// values in effect once validated, e.g. the key of SignKeyFile, or the
+// issuer of OIDCIssuer.
+func (ja *JWTAuth) lint() []lintIssue {
+	var issues []lintIssue
+	report := func(severity lintSeverity, option, format string, args ...interface{}) {
+		issues = append(issues, lintIssue{severity, option, fmt.Sprintf(format, args...)})
+	}
+
+	signKey, signKeyOption := ja.SignKey, "sign_key"
+	if signKey == "" && ja.SignKeyFile != "" {
+		// unreadable here, e.g. by the permissions, the key is not linted
+		if data, err := os.ReadFile(ja.SignKeyFile); err == nil {
+			signKey, signKeyOption = strings.TrimSpace(string(data)), "sign_key_file"
+		}
+	}
+	if signKey != "" {
+		keyBytes, asymmetric, err := parseSignKey(signKey)
+		if err == nil && !asymmetric && len(keyBytes) < minSymmetricKeySize {
+			report(lintHigh, signKeyOption, "symmetric key is %d bytes, at least %d bytes are recommended", len(keyBytes), minSymmetricKeySize)
+		}
+	}
+	if len(ja.AudienceWhitelist) == 0 && ja.Audience == "" {
+		report(lintMedium, "audience_whitelist", "no audience check, tokens issued for other services will be accepted")
+	}
+	if len(ja.IssuerWhitelist) == 0 && ja.OIDCIssuer == "" {
+		report(lintMedium, "issuer_whitelist", "no issuer check, tokens from any issuer trusting the same keys will be accepted")
+	}
+	if len(ja.FromQuery) > 0 && ja.QueryTokenPolicy != queryTokenDeny {
+		report(lintMedium, "from_query", "tokens in query strings leak via logs and Referer headers")
+	}
+	if !ja.RequireExp {
+		report(lintLow, "exp", "tokens without an \"exp\" claim never expire and will be accepted")
+	}
+	return issues
+}
+
+// lintConfig finds all the JWT providers in the given Caddy JSON config and
+// lints them. The returned map is keyed by the JSON path of each provider.
+func lintConfig(config []byte) (map[string][]lintIssue, error) {
+	var root interface{}
+	if err := json.Unmarshal(config, &root); err != nil {
+		return nil, fmt.Errorf("decode config: %w", err)
+	}
+
+	results := make(map[string][]lintIssue)
+	var lintErr error
+	walkProviders(root, "", func(path string, raw interface{}) {
+		data, _ := json.Marshal(raw)
+		var ja JWTAuth
+		if err := json.Unmarshal(data, &ja); err != nil {
+			lintErr = fmt.Errorf("decode provider at %s: %w", path, err)
+			return
+		}
+		results[path] = ja.lint()
+	})
+	return results, lintErr
+}
+
+// walkProviders calls fn on every "jwt" object under a "providers" object.
+func walkProviders(node interface{}, path string, fn func(path string, raw interface{})) {
+	switch v := node.(type) {
+	case map[string]interface{}:
+		for key, child := range v {
+			childPath := path + "/" + key
+			if providers, ok := child.(map[string]interface{}); ok && key == "providers" {
+				if provider, ok := providers["jwt"]; ok {
+					fn(childPath+"/jwt", provider)
+				}
+			}
+			walkProviders(child, childPath, fn)
+		}
+	case []interface{}:
+		for i, child := range v {
+			walkProviders(child, fmt.Sprintf("%s/%d", path, i), fn)
+		}
+	}
+}
@@ -0,0 +1,96 @@
+package caddyjwt
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func hasLintIssue(issues []lintIssue, option string, severity lintSeverity) bool {
+	for _, issue := range issues {
+		if issue.Option == option && issue.Severity == severity {
+			return true
+		}
+	}
+	return false
+}
+
+func TestLint(t *testing.T) {
+	// weak configuration
+	ja := &JWTAuth{
+		SignKey:   "c2hvcnQta2V5", // "short-key"
+		FromQuery: []string{"access_token"},
+	}
+	issues := ja.lint()
+	assert.True(t, hasLintIssue(issues, "sign_key", lintHigh))
+	assert.True(t, hasLintIssue(issues, "audience_whitelist", lintMedium))
+	assert.True(t, hasLintIssue(issues, "issuer_whitelist", lintMedium))
+	assert.True(t, hasLintIssue(issues, "from_query", lintMedium))
+
+	// hardened configuration
+	ja = &JWTAuth{
+		SignKey:           TestSignKey,
+		IssuerWhitelist:   []string{"https://api.example.com"},
+		AudienceWhitelist: []string{"https://api.example.io"},
+	}
+	issues = ja.lint()
+	assert.False(t, hasLintIssue(issues, "sign_key", lintHigh))
+	assert.False(t, hasLintIssue(issues, "audience_whitelist", lintMedium))
+	assert.False(t, hasLintIssue(issues, "issuer_whitelist", lintMedium))
+	assert.False(t, hasLintIssue(issues, "from_query", lintMedium))
+	assert.True(t, hasLintIssue(issues, "exp", lintLow))
+	ja.RequireExp = true
+	assert.False(t, hasLintIssue(ja.lint(), "exp", lintLow))
+}
+
+func TestLint_EffectiveValues(t *testing.T) {
+	keyFile := filepath.Join(t.TempDir(), "key")
+	assert.Nil(t, os.WriteFile(keyFile, []byte("c2hvcnQta2V5\n"), 0o600)) // "short-key"
+	ja := &JWTAuth{
+		SignKeyFile:      keyFile,
+		Audience:         "{http.vars.jwt_aud}",
+		OIDCIssuer:       "https://idp.example.com",
+		FromQuery:        []string{"access_token"},
+		QueryTokenPolicy: "deny",
+	}
+	issues := ja.lint()
+	assert.True(t, hasLintIssue(issues, "sign_key_file", lintHigh))
+	assert.False(t, hasLintIssue(issues, "audience_whitelist", lintMedium))
+	assert.False(t, hasLintIssue(issues, "issuer_whitelist", lintMedium))
+	assert.False(t, hasLintIssue(issues, "from_query", lintMedium))
+
+	ja.QueryTokenPolicy = "redact"
+	assert.True(t, hasLintIssue(ja.lint(), "from_query", lintMedium))
+}
+
+func TestLintConfig(t *testing.T) {
+	config := []byte(`{
+		"apps": {"http": {"servers": {"srv0": {"routes": [{
+			"handle": [{
+				"handler": "authentication",
+				"providers": {"jwt": {"sign_key": "c2hvcnQta2V5", "from_query": ["token"]}}
+			}]
+		}]}}}}
+	}`)
+	results, err := lintConfig(config)
+	assert.Nil(t, err)
+	assert.Len(t, results, 1)
+	issues, ok := results["/apps/http/servers/srv0/routes/0/handle/0/providers/jwt"]
+	assert.True(t, ok)
+	assert.True(t, hasLintIssue(issues, "sign_key", lintHigh))
+	assert.True(t, hasLintIssue(issues, "from_query", lintMedium))
+
+	_, err = lintConfig([]byte(`{"apps": `))
+	assert.NotNil(t, err)
+}
+
+func TestParseLintSeverity(t *testing.T) {
+	severity, err := parseLintSeverity("Medium")
+	assert.Nil(t, err)
+	assert.Equal(t, lintMedium, severity)
+
+	_, err = parseLintSeverity("critical")
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,152 @@
+package caddyjwt
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+	"sync/atomic"
+)
+
+// livePolicy is the part of the config of a provider which can be swapped
+// via the admin API while running, without re-provisioning the keys.
+type livePolicy struct {
+	IssuerWhitelist   []string               `json:"issuer_whitelist"`
+	AudienceWhitelist []string               `json:"audience_whitelist"`
+	ClaimPolicies     map[string]ClaimPolicy `json:"claim_policies"`
+}
+
+// livePolicyHolder holds the live policy of a provider. The policy is
+// replaced as a whole, so a request always sees a consistent one.
+type livePolicyHolder struct {
+	current atomic.Pointer[livePolicy]
+}
+
+// policyPatches serializes the patches of the live policies, see
+// patchPolicies.
+var policyPatches sync.Mutex
+
+// initLivePolicy takes the policy from the config of the provider.
+func (ja *JWTAuth) initLivePolicy() {
+	ja.live = new(livePolicyHolder)
+	ja.live.current.Store(&livePolicy{
+		IssuerWhitelist:   ja.IssuerWhitelist,
+		AudienceWhitelist: ja.AudienceWhitelist,
+		ClaimPolicies:     ja.ClaimPolicies,
+	})
+}
+
+// policy returns the live policy of the provider.
+func (ja *JWTAuth) policy() *livePolicy {
+	if ja.live == nil {
+		return &livePolicy{
+			IssuerWhitelist:   ja.IssuerWhitelist,
+			AudienceWhitelist: ja.AudienceWhitelist,
+			ClaimPolicies:     ja.ClaimPolicies,
+		}
+	}
+	return ja.live.current.Load()
+}
+
+// patchPolicies replaces the field of the live policies of the providers at
+// path with the JSON value, of all the providers or none: the patch is
+// validated against every provider before any is swapped. The path is one
+// of:
+//
+//   - "issuer_whitelist"
+//   - "audience_whitelist"
+//   - "claim_policies"
+//   - "claim_policies/<name>"
+func patchPolicies(providers []*JWTAuth, path []string, value json.RawMessage) error {
+	policyPatches.Lock()
+	defer policyPatches.Unlock()
+
+	patched := make([]*livePolicy, len(providers))
+	for i, ja := range providers {
+		policy, err := ja.patchedPolicy(path, value)
+		if err != nil {
+			return err
+		}
+		patched[i] = policy
+	}
+	for i, ja := range providers {
+		ja.live.current.Store(patched[i])
+	}
+	return nil
+}
+
+// patchedPolicy returns the live policy of the provider patched, see
+// patchPolicies.
+func (ja *JWTAuth) patchedPolicy(path []string, value json.RawMessage) (*livePolicy, error) {
+	// the slices and maps of the current policy are shared with the config
+	// and the requests in flight, never unmarshal into them
+	patched := *ja.live.current.Load()
+	var err error
+	switch {
+	case len(path) == 1 && path[0] == "issuer_whitelist":
+		patched.IssuerWhitelist = nil
+		err = json.Unmarshal(value, &patched.IssuerWhitelist)
+	case len(path) == 1 && path[0] == "audience_whitelist":
+		patched.AudienceWhitelist = nil
+		err = json.Unmarshal(value, &patched.AudienceWhitelist)
+	case len(path) == 1 && path[0] == "claim_policies":
+		patched.ClaimPolicies = nil
+		err = json.Unmarshal(value, &patched.ClaimPolicies)
+	case len(path) == 2 && path[0] == "claim_policies":
+		var policy ClaimPolicy
+		if err = json.Unmarshal(value, &policy); err != nil {
+			break
+		}
+		policies := make(map[string]ClaimPolicy, len(patched.ClaimPolicies)+1)
+		for name, p := range patched.ClaimPolicies {
+			policies[name] = p
+		}
+		policies[path[1]] = policy
+		patched.ClaimPolicies = policies
+	default:
+		return nil, fmt.Errorf("unknown policy path: %q", path)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := validateClaimPolicies(patched.ClaimPolicies); err != nil {
+		return nil, fmt.Errorf("invalid claim_policies: %w", err)
+	}
+	return &patched, nil
+}
+
+// namedProviders are the providers in this process which have a Name, so
+// their policies can be patched via the admin API. A name can be shared by
+// several providers, e.g. the ones imported from the same Caddyfile snippet.
+var namedProviders = struct {
+	mu        sync.Mutex
+	providers map[string]map[*JWTAuth]struct{}
+}{providers: make(map[string]map[*JWTAuth]struct{})}
+
+func registerNamedProvider(ja *JWTAuth) {
+	namedProviders.mu.Lock()
+	defer namedProviders.mu.Unlock()
+	if namedProviders.providers[ja.Name] == nil {
+		namedProviders.providers[ja.Name] = make(map[*JWTAuth]struct{})
+	}
+	namedProviders.providers[ja.Name][ja] = struct{}{}
+}
+
+func unregisterNamedProvider(ja *JWTAuth) {
+	namedProviders.mu.Lock()
+	defer namedProviders.mu.Unlock()
+	delete(namedProviders.providers[ja.Name], ja)
+	if len(namedProviders.providers[ja.Name]) == 0 {
+		delete(namedProviders.providers, ja.Name)
+	}
+}
+
+// lookupNamedProviders returns the providers of the name.
+func lookupNamedProviders(name string) []*JWTAuth {
+	namedProviders.mu.Lock()
+	defer namedProviders.mu.Unlock()
+	var providers []*JWTAuth
+	for ja := range namedProviders.providers[name] {
+		providers = append(providers, ja)
+	}
+	return providers
+}
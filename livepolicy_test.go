@@ -0,0 +1,83 @@
+package caddyjwt
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAdminAPI_PatchProviderPolicy(t *testing.T) {
+	ja := &JWTAuth{
+		SignKey:         TestSignKey,
+		Name:            "api",
+		IssuerWhitelist: []string{"https://api.example.com"},
+		logger:          testLogger,
+	}
+	assert.Nil(t, ja.Validate())
+	defer ja.Cleanup()
+
+	token := issueTokenString(MapClaims{"sub": "ggicci", "iss": "https://api.github.com", "roles": "member"})
+	authenticate := func() error {
+		r, _ := http.NewRequest("GET", "/", nil)
+		r.Header.Add("Authorization", token)
+		_, _, err := ja.Authenticate(httptest.NewRecorder(), r)
+		return err
+	}
+	patch := func(path, body string) error {
+		r, _ := http.NewRequest("PATCH", "/jwtauth/providers/"+path, strings.NewReader(body))
+		return adminAPI{}.handleProviderPolicy(httptest.NewRecorder(), r)
+	}
+
+	assert.ErrorIs(t, authenticate(), ErrInvalidIssuer)
+	assert.Nil(t, patch("api/issuer_whitelist", `["https://api.github.com"]`))
+	assert.Nil(t, authenticate())
+	assert.Equal(t, []string{"https://api.example.com"}, ja.IssuerWhitelist, "config untouched")
+
+	ja.ClaimPolicyName = "admin"
+	assert.ErrorIs(t, authenticate(), ErrClaimPolicy) // unknown policy
+	assert.Nil(t, patch("api/claim_policies/admin", `{"roles": ["admin"]}`))
+	assert.ErrorIs(t, authenticate(), ErrClaimPolicy)
+	assert.Nil(t, patch("api/claim_policies/admin", `{"roles": ["admin", "member"]}`))
+	assert.Nil(t, authenticate())
+
+	// invalid patches are refused as a whole
+	assert.NotNil(t, patch("api/claim_policies/admin", `{"roles": []}`))
+	assert.NotNil(t, patch("api/sign_key", `"secret"`))
+	assert.NotNil(t, patch("unknown/issuer_whitelist", `[]`))
+	assert.Nil(t, authenticate())
+
+	rw := httptest.NewRecorder()
+	r, _ := http.NewRequest("GET", "/jwtauth/providers/api", nil)
+	assert.Nil(t, adminAPI{}.handleProviderPolicy(rw, r))
+	assert.Contains(t, rw.Body.String(), `"https://api.github.com"`)
+
+	assert.Nil(t, ja.Cleanup())
+	assert.NotNil(t, patch("api/issuer_whitelist", `[]`))
+}
+
+func TestPatchPolicies_AllOrNone(t *testing.T) {
+	providers := make([]*JWTAuth, 2)
+	for i := range providers {
+		providers[i] = &JWTAuth{
+			SignKey:       TestSignKey,
+			ClaimPolicies: map[string]ClaimPolicy{"member": {"roles": {"member"}}},
+			logger:        testLogger,
+		}
+		assert.Nil(t, providers[i].Validate())
+		defer providers[i].Cleanup()
+	}
+	// the second provider can't take the patch
+	providers[1].live.current.Store(&livePolicy{ClaimPolicies: map[string]ClaimPolicy{"broken": {"roles": nil}}})
+
+	assert.NotNil(t, patchPolicies(providers, []string{"claim_policies", "admin"}, []byte(`{"roles": ["admin"]}`)))
+	assert.NotContains(t, providers[0].policy().ClaimPolicies, "admin", "first provider untouched")
+	assert.NotContains(t, providers[1].policy().ClaimPolicies, "admin")
+
+	assert.Nil(t, patchPolicies(providers, []string{"claim_policies"}, []byte(`{"admin": {"roles": ["admin"]}}`)))
+	for _, ja := range providers {
+		assert.Equal(t, map[string]ClaimPolicy{"admin": {"roles": {"admin"}}}, ja.policy().ClaimPolicies)
+	}
+}
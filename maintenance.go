@@ -0,0 +1,130 @@
+package caddyjwt
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+	"go.uber.org/zap"
+)
+
+// Maintenance is an identity-aware kill switch. While it's on, only the
+// tokens satisfying Allow are admitted, everyone else, including the
+// requests without any token, receives the maintenance page. It's switched
+// via the admin API, see /jwtauth/maintenance.
+type Maintenance struct {
+	// Enabled is the initial state of the switch.
+	Enabled bool `json:"enabled"`
+
+	// Allow defines the claims of the tokens admitted during maintenance,
+	// in the same way as JWTAuth.Require, e.g. role=operator. Required.
+	Allow []ClaimRequirement `json:"allow"`
+
+	// StatusCode of the maintenance page. Defaults to 503.
+	StatusCode int `json:"status_code"`
+
+	// Body of the maintenance page. Defaults to the status text.
+	Body string `json:"body"`
+
+	// ContentType of the maintenance page. Defaults to "text/html;
+	// charset=utf-8" if Body is set, otherwise "text/plain; charset=utf-8".
+	ContentType string `json:"content_type"`
+
+	// RetryAfter, if set, is sent as the Retry-After header in seconds.
+	RetryAfter caddy.Duration `json:"retry_after"`
+
+	active atomic.Bool
+}
+
+func (m *Maintenance) provision() error {
+	if len(m.Allow) == 0 {
+		return fmt.Errorf("missing allow")
+	}
+	for _, req := range m.Allow {
+		if req.Claim == "" || len(req.Values) == 0 {
+			return fmt.Errorf("invalid allow: claim %q requires at least one value", req.Claim)
+		}
+	}
+	if m.StatusCode == 0 {
+		m.StatusCode = http.StatusServiceUnavailable
+	}
+	if m.StatusCode < 400 || m.StatusCode > 599 {
+		return fmt.Errorf("invalid status_code: %d", m.StatusCode)
+	}
+	if m.ContentType == "" {
+		m.ContentType = "text/plain; charset=utf-8"
+		if m.Body != "" {
+			m.ContentType = "text/html; charset=utf-8"
+		}
+	}
+	if m.Body == "" {
+		m.Body = http.StatusText(m.StatusCode) + "\n"
+	}
+	m.active.Store(m.Enabled)
+	return nil
+}
+
+// check returns ErrMaintenance if the switch is on and the request is not
+// admitted, i.e. it failed the authentication with err, or its token doesn't
+// satisfy Allow.
+func (m *Maintenance) check(token Token, err error) error {
+	if !m.active.Load() {
+		return err
+	}
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrMaintenance, err)
+	}
+	if err := checkRequirements(token, m.Allow); err != nil {
+		return fmt.Errorf("%w: %w", ErrMaintenance, err)
+	}
+	return nil
+}
+
+// respond writes the maintenance page.
+func (m *Maintenance) respond(rw http.ResponseWriter, r *http.Request) {
+	rw.Header().Set("Content-Type", m.ContentType)
+	if m.RetryAfter > 0 {
+		rw.Header().Set("Retry-After", strconv.FormatInt(int64(time.Duration(m.RetryAfter)/time.Second), 10))
+	}
+	writeFailure(rw, r, m.StatusCode, []byte(m.Body))
+}
+
+// maintenanceSwitches are the maintenance switches of all the JWT providers
+// in this process, which can be toggled via the admin API.
+var maintenanceSwitches = struct {
+	mu       sync.Mutex
+	switches map[*JWTAuth]struct{}
+}{switches: make(map[*JWTAuth]struct{})}
+
+func registerMaintenanceSwitch(ja *JWTAuth) {
+	maintenanceSwitches.mu.Lock()
+	defer maintenanceSwitches.mu.Unlock()
+	maintenanceSwitches.switches[ja] = struct{}{}
+}
+
+func unregisterMaintenanceSwitch(ja *JWTAuth) {
+	maintenanceSwitches.mu.Lock()
+	defer maintenanceSwitches.mu.Unlock()
+	delete(maintenanceSwitches.switches, ja)
+}
+
+// switchMaintenance turns the maintenance switches of the providers of the
+// name, or all of them if the name is empty, on or off, and returns their
+// number.
+func switchMaintenance(name string, on bool) int {
+	maintenanceSwitches.mu.Lock()
+	defer maintenanceSwitches.mu.Unlock()
+	n := 0
+	for ja := range maintenanceSwitches.switches {
+		if name == "" || ja.Name == name {
+			ja.Maintenance.active.Store(on)
+			ja.logger.Warn("maintenance switched", zap.Bool("enabled", on))
+			n++
+		}
+	}
+	return n
+}
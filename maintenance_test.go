@@ -0,0 +1,79 @@
+package caddyjwt
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAuthenticate_Maintenance(t *testing.T) {
+	ja := &JWTAuth{
+		Name:    "maintenance-test",
+		SignKey: TestSignKey,
+		Maintenance: &Maintenance{
+			Allow:      []ClaimRequirement{{Claim: "role", Values: []string{"operator"}}},
+			Body:       "<h1>Back soon</h1>",
+			RetryAfter: caddy.Duration(10 * time.Minute),
+		},
+		logger: testLogger,
+	}
+	assert.Nil(t, ja.Validate())
+	defer ja.Cleanup()
+
+	authenticate := func(claims MapClaims) (*httptest.ResponseRecorder, bool, error) {
+		rw := httptest.NewRecorder()
+		r, _ := http.NewRequest("GET", "/", nil)
+		if claims != nil {
+			r.Header.Add("Authorization", issueTokenString(claims))
+		}
+		_, ok, err := ja.Authenticate(rw, r)
+		return rw, ok, err
+	}
+	switchOn := func(enabled string) {
+		r, _ := http.NewRequest("POST", "/jwtauth/maintenance?provider=maintenance-test&enabled="+enabled, nil)
+		assert.Nil(t, adminAPI{}.handleMaintenance(httptest.NewRecorder(), r))
+	}
+
+	_, ok, err := authenticate(MapClaims{"sub": "ggicci"})
+	assert.Nil(t, err)
+	assert.True(t, ok)
+
+	switchOn("true")
+	rw, ok, err := authenticate(MapClaims{"sub": "ggicci"})
+	assert.ErrorIs(t, err, ErrMaintenance)
+	assert.False(t, ok)
+	assert.Equal(t, http.StatusServiceUnavailable, rw.Code)
+	assert.Equal(t, "<h1>Back soon</h1>", rw.Body.String())
+	assert.Equal(t, "text/html; charset=utf-8", rw.Header().Get("Content-Type"))
+	assert.Equal(t, "600", rw.Header().Get("Retry-After"))
+
+	rw, _, err = authenticate(nil)
+	assert.ErrorIs(t, err, ErrMaintenance)
+	assert.Equal(t, http.StatusServiceUnavailable, rw.Code)
+
+	_, ok, err = authenticate(MapClaims{"sub": "ggicci", "role": "operator"})
+	assert.Nil(t, err)
+	assert.True(t, ok)
+
+	switchOn("false")
+	_, ok, err = authenticate(MapClaims{"sub": "ggicci"})
+	assert.Nil(t, err)
+	assert.True(t, ok)
+}
+
+func TestAdminAPI_HandleMaintenance(t *testing.T) {
+	r, _ := http.NewRequest("POST", "/jwtauth/maintenance?enabled=maybe", nil)
+	assert.ErrorContains(t, adminAPI{}.handleMaintenance(httptest.NewRecorder(), r), "invalid enabled")
+	r, _ = http.NewRequest("POST", "/jwtauth/maintenance?enabled=true&provider=nobody", nil)
+	assert.ErrorContains(t, adminAPI{}.handleMaintenance(httptest.NewRecorder(), r), "unknown provider")
+}
+
+func TestMaintenance_Provision(t *testing.T) {
+	assert.ErrorContains(t, (&Maintenance{}).provision(), "missing allow")
+	m := &Maintenance{Allow: []ClaimRequirement{{Claim: "role", Values: []string{"operator"}}}, StatusCode: 200}
+	assert.ErrorContains(t, m.provision(), "invalid status_code")
+}
@@ -0,0 +1,49 @@
+package caddyjwt
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"strings"
+)
+
+// The kinds of the malformed tokens, see malformedKind.
+const (
+	malformedSegments = "segments" // not of three segments
+	malformedBase64   = "base64"   // a segment not base64url encoded
+	malformedJSON     = "json"     // the header or the payload not a JSON object
+)
+
+// malformedKind classifies the token failing to parse as malformed, so the
+// garbage, e.g. of scanners, can be told from the tokens of the broken
+// clients failing the verification. It returns empty if the token is well
+// formed, i.e. failed for other reasons.
+func malformedKind(token string) string {
+	segments := strings.Split(token, ".")
+	if len(segments) != 3 {
+		return malformedSegments
+	}
+	for i, segment := range segments {
+		decoded, err := decodeSegment(segment)
+		if err != nil {
+			return malformedBase64
+		}
+		if i == 2 {
+			break // the signature
+		}
+		var object map[string]json.RawMessage
+		if json.Unmarshal(decoded, &object) != nil {
+			return malformedJSON
+		}
+	}
+	return ""
+}
+
+// decodeSegment decodes a segment of a token as leniently as jwx does, i.e.
+// either base64url or base64, padded or not.
+func decodeSegment(segment string) ([]byte, error) {
+	segment = strings.TrimRight(segment, "=")
+	if strings.ContainsAny(segment, "+/") {
+		return base64.RawStdEncoding.DecodeString(segment)
+	}
+	return base64.RawURLEncoding.DecodeString(segment)
+}
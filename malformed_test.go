@@ -0,0 +1,52 @@
+package caddyjwt
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMalformedKind(t *testing.T) {
+	var testCases = []struct {
+		Token string
+		Kind  string
+	}{
+		{issueTokenString(MapClaims{"sub": "ggicci"}), ""},
+		{"Bearer", malformedSegments},
+		{"a.b", malformedSegments},
+		{"a.b.c.d", malformedSegments},
+		{"eyJhbGciOiJIUzI1NiJ9.!!!.c2ln", malformedBase64},
+		{"eyJhbGciOiJIUzI1NiJ9.bm90IGpzb24.c2ln", malformedJSON}, // "not json"
+		{"eyJhbGciOiJIUzI1NiJ9.WzEsMl0.c2ln", malformedJSON},     // [1,2]
+		{"eyJhbGciOiJIUzI1NiJ9.eyJzdWIiOiJnIn0.c2ln", ""},        // {"sub":"g"}
+		{"eyJhbGciOiJIUzI1NiJ9=.eyJzdWIiOiJnIn0=.c2ln", ""},      // padded
+	}
+	for _, c := range testCases {
+		assert.Equal(t, c.Kind, malformedKind(c.Token), c.Token)
+	}
+}
+
+func TestAuthenticate_MalformedToken(t *testing.T) {
+	ja := &JWTAuth{SignKey: TestSignKey, logger: testLogger}
+	assert.Nil(t, ja.Validate())
+
+	authenticate := func(token string) error {
+		r, _ := http.NewRequest("GET", "/", nil)
+		r.Header.Add("Authorization", token)
+		_, _, err := ja.Authenticate(httptest.NewRecorder(), r)
+		return err
+	}
+	segments := testutil.ToFloat64(metrics.malformedTokens.WithLabelValues(malformedSegments))
+	err := authenticate("Bearer a.b")
+	assert.ErrorIs(t, err, ErrInvalidToken)
+	assert.ErrorIs(t, err, ErrMalformedToken)
+	assert.Equal(t, segments+1, testutil.ToFloat64(metrics.malformedTokens.WithLabelValues(malformedSegments)))
+
+	// well formed, but signed by another key
+	err = authenticate(issueTokenStringJWK(MapClaims{"sub": "ggicci"}))
+	assert.ErrorIs(t, err, ErrInvalidToken)
+	assert.NotErrorIs(t, err, ErrMalformedToken)
+}
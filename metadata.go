@@ -0,0 +1,128 @@
+package caddyjwt
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+
+	"go.uber.org/zap"
+)
+
+// metadataJSONKey is the metadata of the whole metadata as a JSON object,
+// i.e. {http.auth.user.metadata_json}, see MetaClaimsJSON.
+const metadataJSONKey = "metadata_json"
+
+// getMetaClaimValues returns the values of the claims of placeholdersMap
+// present in the token, by the placeholders, as is.
+func getMetaClaimValues(token Token, placeholdersMap map[string]string) map[string]interface{} {
+	claims, _ := token.AsMap(context.Background()) // error ignored
+	values := make(map[string]interface{}, len(placeholdersMap))
+	for claim, placeholder := range placeholdersMap {
+		claimValue, ok := token.Get(claim)
+
+		// Query nested claims.
+		if !ok && strings.Contains(claim, ".") {
+			claimValue, ok = queryNested(claims, strings.Split(claim, "."))
+		}
+		if ok {
+			values[placeholder] = claimValue
+		}
+	}
+	return values
+}
+
+// userMetadata returns the metadata of the user of the token, see
+// MetaClaims, "" for the claims absent. The arrays and the objects are
+// compact JSON if MetaClaimsJSON, rather than flattened by ArrayFormat.
+func (ja *JWTAuth) userMetadata(token Token) map[string]string {
+	if len(ja.MetaClaims) == 0 {
+		return nil
+	}
+	metadata := make(map[string]string, len(ja.MetaClaims)+1)
+	values := getMetaClaimValues(token, ja.MetaClaims)
+	for _, placeholder := range ja.MetaClaims {
+		if ja.MetaClaimsJSON {
+			metadata[placeholder] = stringifyJSON(values[placeholder])
+		} else {
+			metadata[placeholder] = ja.stringifyClaim(values[placeholder])
+		}
+	}
+	return metadata
+}
+
+// ArrayFormat formats the array claims into strings, see
+// JWTAuth.ArrayFormat.
+type ArrayFormat struct {
+	// Separator joins the elements, e.g. " " or ";". Defaults to ",".
+	Separator string `json:"separator,omitempty"`
+
+	// FirstOnly takes the first element only, e.g. the primary group.
+	FirstOnly bool `json:"first_only,omitempty"`
+}
+
+// stringifyClaim stringifies the value of a claim as stringify does, but
+// the arrays by ArrayFormat.
+func (ja *JWTAuth) stringifyClaim(val interface{}) string {
+	af := ja.ArrayFormat
+	if af == nil {
+		return stringify(val)
+	}
+	items, ok := sliceItems(val)
+	if !ok {
+		return stringify(val)
+	}
+	if af.FirstOnly {
+		if len(items) == 0 {
+			return ""
+		}
+		return stringify(items[0])
+	}
+	separator := af.Separator
+	if separator == "" {
+		separator = ","
+	}
+	strs := make([]string, len(items))
+	for i, item := range items {
+		strs[i] = stringify(item)
+	}
+	return strings.Join(strs, separator)
+}
+
+// setMetadataJSON sets the metadata_json metadata of the user, the whole
+// metadata as a JSON object, whose values of the claims keep their types,
+// if MetaClaimsJSON.
+func (ja *JWTAuth) setMetadataJSON(result *authResult) {
+	if !ja.MetaClaimsJSON {
+		return
+	}
+	values := getMetaClaimValues(result.token, ja.MetaClaims)
+	whole := make(map[string]interface{}, len(result.user.Metadata))
+	for key, value := range result.user.Metadata {
+		if typed, ok := values[key]; ok {
+			whole[key] = typed
+		} else {
+			whole[key] = value
+		}
+	}
+	data, err := json.Marshal(whole)
+	if err != nil {
+		ja.logger.Warn("failed to encode metadata_json", zap.Error(err))
+		return
+	}
+	if result.user.Metadata == nil {
+		result.user.Metadata = make(map[string]string, 1)
+	}
+	result.user.Metadata[metadataJSONKey] = string(data)
+}
+
+// stringifyJSON stringifies the value as stringify does, but the arrays, the
+// objects and the numbers as compact JSON.
+func stringifyJSON(val interface{}) string {
+	switch val.(type) {
+	case []interface{}, []string, map[string]interface{}, float64:
+		if data, err := json.Marshal(val); err == nil {
+			return string(data)
+		}
+	}
+	return stringify(val)
+}
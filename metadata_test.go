@@ -0,0 +1,112 @@
+package caddyjwt
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAuthenticate_MetaClaimsJSON(t *testing.T) {
+	ja := &JWTAuth{
+		SignKey: TestSignKey,
+		MetaClaims: map[string]string{
+			"groups":   "groups",
+			"settings": "settings",
+			"level":    "level",
+			"IsAdmin":  "is_admin",
+			"name":     "name",
+			"absent":   "absent",
+		},
+		MetaClaimsJSON: true,
+		logger:         testLogger,
+	}
+	assert.Nil(t, ja.Validate())
+
+	r, _ := http.NewRequest("GET", "/", nil)
+	r.Header.Add("Authorization", issueTokenString(MapClaims{
+		"sub":      "ggicci",
+		"groups":   []string{"csgo", "dota2"},
+		"settings": map[string]interface{}{"role": "admin", "quota": 5},
+		"level":    3,
+		"IsAdmin":  true,
+		"name":     "Ggicci",
+	}))
+	user, authenticated, err := ja.Authenticate(httptest.NewRecorder(), r)
+	assert.Nil(t, err)
+	assert.True(t, authenticated)
+	assert.Equal(t, `["csgo","dota2"]`, user.Metadata["groups"])
+	assert.Equal(t, `{"quota":5,"role":"admin"}`, user.Metadata["settings"])
+	assert.Equal(t, "3", user.Metadata["level"])
+	assert.Equal(t, "true", user.Metadata["is_admin"])
+	assert.Equal(t, "Ggicci", user.Metadata["name"])
+	assert.Equal(t, "", user.Metadata["absent"])
+
+	var whole map[string]interface{}
+	assert.Nil(t, json.Unmarshal([]byte(user.Metadata["metadata_json"]), &whole))
+	assert.Equal(t, map[string]interface{}{
+		"groups":   []interface{}{"csgo", "dota2"},
+		"settings": map[string]interface{}{"role": "admin", "quota": 5.0},
+		"level":    3.0,
+		"is_admin": true,
+		"name":     "Ggicci",
+		"absent":   "",
+	}, whole)
+
+	// reserved
+	ja.MetaClaims["other"] = "metadata_json"
+	assert.ErrorContains(t, ja.Validate(), "reserved by meta_claims_json")
+}
+
+func Test_stringifyJSON(t *testing.T) {
+	assert.Equal(t, "", stringifyJSON(nil))
+	assert.Equal(t, "abc", stringifyJSON("abc"))
+	assert.Equal(t, "1.5", stringifyJSON(1.5))
+	assert.Equal(t, `[1,"a"]`, stringifyJSON([]interface{}{1.0, "a"}))
+	assert.Equal(t, `{"a":{"b":null}}`, stringifyJSON(map[string]interface{}{"a": map[string]interface{}{"b": nil}}))
+}
+
+func TestAuthenticate_ArrayFormat(t *testing.T) {
+	ja := &JWTAuth{
+		SignKey:             TestSignKey,
+		MetaClaims:          map[string]string{"groups": "groups", "levels": "levels", "name": "name"},
+		ForwardClaimsHeader: map[string]string{"groups": "X-User-Groups"},
+		ArrayFormat:         &ArrayFormat{Separator: " "},
+		logger:              testLogger,
+	}
+	assert.Nil(t, ja.Validate())
+
+	authenticate := func() (User, *http.Request) {
+		r, _ := http.NewRequest("GET", "/", nil)
+		r.Header.Add("Authorization", issueTokenString(MapClaims{
+			"sub":    "ggicci",
+			"groups": []string{"csgo", "dota2"},
+			"levels": []int{1, 2},
+			"name":   "Ggicci",
+		}))
+		user, authenticated, err := ja.Authenticate(httptest.NewRecorder(), r)
+		assert.Nil(t, err)
+		assert.True(t, authenticated)
+		return user, r
+	}
+
+	user, r := authenticate()
+	assert.Equal(t, "csgo dota2", user.Metadata["groups"])
+	assert.Equal(t, "1 2", user.Metadata["levels"])
+	assert.Equal(t, "Ggicci", user.Metadata["name"])
+	assert.Equal(t, "csgo dota2", r.Header.Get("X-User-Groups"))
+
+	ja.ArrayFormat = &ArrayFormat{FirstOnly: true}
+	user, r = authenticate()
+	assert.Equal(t, "csgo", user.Metadata["groups"])
+	assert.Equal(t, "1", user.Metadata["levels"])
+	assert.Equal(t, "csgo", r.Header.Get("X-User-Groups"))
+
+	// commas by default
+	ja.ArrayFormat = nil
+	user, _ = authenticate()
+	assert.Equal(t, "csgo,dota2", user.Metadata["groups"])
+	assert.Equal(t, "1,2", user.Metadata["levels"])
+}
@@ -0,0 +1,88 @@
+package caddyjwt
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// metrics are exported via the Caddy metrics endpoint, along with the
+// caddy_http_* metrics.
+var metrics = struct {
+	tokenRemainingLifetime prometheus.Histogram
+	tokensWithoutExp       prometheus.Counter
+	verificationsInFlight  prometheus.Gauge
+	verificationQueueDepth prometheus.Gauge
+	jwksRefreshInProgress  prometheus.Gauge
+	denyWebhookDropped     prometheus.Counter
+	sandboxPanics          prometheus.Counter
+	claimsAnomalies        prometheus.Counter
+	malformedTokens        *prometheus.CounterVec
+}{
+	tokenRemainingLifetime: promauto.NewHistogram(prometheus.HistogramOpts{
+		Namespace: "caddy",
+		Subsystem: "http_jwt",
+		Name:      "token_remaining_lifetime_seconds",
+		Help:      "Remaining lifetime (exp - now) of the accepted tokens.",
+		Buckets:   []float64{10, 60, 300, 900, 3600, 4 * 3600, 24 * 3600, 7 * 24 * 3600, 30 * 24 * 3600},
+	}),
+	tokensWithoutExp: promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: "caddy",
+		Subsystem: "http_jwt",
+		Name:      "tokens_without_exp_total",
+		Help:      "Count of the accepted tokens without the exp claim.",
+	}),
+	verificationsInFlight: promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: "caddy",
+		Subsystem: "http_jwt",
+		Name:      "verifications_in_flight",
+		Help:      "Number of the requests whose tokens are being verified.",
+	}),
+	verificationQueueDepth: promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: "caddy",
+		Subsystem: "http_jwt",
+		Name:      "verification_queue_depth",
+		Help:      "Number of the requests waiting for a verification worker, see verification_workers.",
+	}),
+	jwksRefreshInProgress: promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: "caddy",
+		Subsystem: "http_jwt",
+		Name:      "jwks_refresh_in_progress",
+		Help:      "Number of the JWKS refreshes in progress.",
+	}),
+	denyWebhookDropped: promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: "caddy",
+		Subsystem: "http_jwt",
+		Name:      "deny_webhook_dropped_total",
+		Help:      "Count of the deny events not delivered to the deny_webhook, for a full queue or a failed POST.",
+	}),
+	sandboxPanics: promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: "caddy",
+		Subsystem: "http_jwt",
+		Name:      "sandbox_panics_total",
+		Help:      "Count of the panics of the token parser recovered by sandbox_parsing.",
+	}),
+	claimsAnomalies: promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: "caddy",
+		Subsystem: "http_jwt",
+		Name:      "claims_anomalies_total",
+		Help:      "Count of the subjects showing up with a combination of claims never seen before, see claims_anomaly.",
+	}),
+	malformedTokens: promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "caddy",
+		Subsystem: "http_jwt",
+		Name:      "malformed_tokens_total",
+		Help:      "Count of the malformed tokens, by kind: segments, base64 or json.",
+	}, []string{"kind"}),
+}
+
+// observeTokenLifetime records the remaining lifetime of an accepted token.
+func observeTokenLifetime(token Token, now time.Time) {
+	exp := token.Expiration()
+	if exp.IsZero() {
+		metrics.tokensWithoutExp.Inc()
+		return
+	}
+	metrics.tokenRemainingLifetime.Observe(exp.Sub(now).Seconds())
+}
@@ -0,0 +1,63 @@
+package caddyjwt
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestObserveTokenLifetime(t *testing.T) {
+	now := time.Now()
+	histogram := func() *dto.Histogram {
+		var m dto.Metric
+		assert.Nil(t, metrics.tokenRemainingLifetime.Write(&m))
+		return m.GetHistogram()
+	}
+	before := histogram()
+	withoutExp := testutil.ToFloat64(metrics.tokensWithoutExp)
+
+	observeTokenLifetime(buildToken(MapClaims{"sub": "ggicci", "exp": now.Add(30 * time.Second).Unix()}), now)
+	observeTokenLifetime(buildToken(MapClaims{"sub": "ggicci"}), now)
+
+	after := histogram()
+	assert.Equal(t, before.GetSampleCount()+1, after.GetSampleCount())
+	assert.InDelta(t, before.GetSampleSum()+30, after.GetSampleSum(), 1)
+	assert.Equal(t, withoutExp+1, testutil.ToFloat64(metrics.tokensWithoutExp))
+}
+
+func TestAcquireWorker(t *testing.T) {
+	ja := &JWTAuth{SignKey: TestSignKey, VerificationWorkers: 1, logger: testLogger}
+	assert.Nil(t, ja.Validate())
+
+	release, err := ja.acquireWorker(context.Background())
+	assert.Nil(t, err)
+	assert.Equal(t, float64(0), testutil.ToFloat64(metrics.verificationQueueDepth))
+
+	// the second one waits in the queue
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		_, err := ja.acquireWorker(ctx)
+		done <- err
+	}()
+	assert.Eventually(t, func() bool {
+		return testutil.ToFloat64(metrics.verificationQueueDepth) == 1
+	}, time.Second, 10*time.Millisecond)
+	cancel()
+	assert.ErrorIs(t, <-done, context.Canceled)
+	assert.Equal(t, float64(0), testutil.ToFloat64(metrics.verificationQueueDepth))
+
+	release()
+	release, err = ja.acquireWorker(context.Background())
+	assert.Nil(t, err)
+	release()
+}
+
+func TestValidate_InvalidVerificationWorkers(t *testing.T) {
+	ja := &JWTAuth{SignKey: TestSignKey, VerificationWorkers: -1, logger: testLogger}
+	assert.ErrorContains(t, ja.Validate(), "verification_workers")
+}
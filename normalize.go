@@ -0,0 +1,138 @@
+package caddyjwt
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// Token normalization rules, see JWTAuth.NormalizeToken.
+const (
+	normalizeTrim      = "trim"
+	normalizeUnquote   = "unquote"
+	normalizeURLDecode = "urldecode"
+)
+
+// maxURLDecodeRounds limits how many times a token will be URL-decoded, to
+// tolerate double-encoded tokens.
+const maxURLDecodeRounds = 2
+
+func validateNormalizeToken(rules map[string][]string) error {
+	for source, list := range rules {
+		switch tokenSource(source) {
+		case sourceQuery, sourceHeader, sourceCookie, sourceBody:
+		default:
+			return fmt.Errorf("unknown token source %q", source)
+		}
+		for _, rule := range list {
+			switch rule {
+			case normalizeTrim, normalizeUnquote, normalizeURLDecode:
+			default:
+				return fmt.Errorf("unknown rule %q for source %q", rule, source)
+			}
+		}
+	}
+	return nil
+}
+
+// normalizeToken cleans up the envelope of a candidate token by the rules
+// configured for its source. The rules are always applied in the order of:
+// trim, unquote, urldecode, regardless of the order they were configured.
+func (ja *JWTAuth) normalizeToken(candidate candidateToken) string {
+	token := candidate.value
+	rules := ja.NormalizeToken[string(candidate.source)]
+	if len(rules) == 0 {
+		return ja.unwrapToken(candidate, token)
+	}
+
+	enabled := make(map[string]bool, len(rules))
+	for _, rule := range rules {
+		enabled[rule] = true
+	}
+
+	if enabled[normalizeTrim] {
+		token = strings.TrimSpace(token)
+	}
+	if enabled[normalizeUnquote] {
+		token = unquoteToken(token)
+	}
+	if enabled[normalizeURLDecode] {
+		token = urlDecodeToken(token)
+	}
+	return ja.unwrapToken(candidate, token)
+}
+
+// schemeNone is the scheme of HeaderScheme accepting the bare tokens.
+const schemeNone = "none"
+
+// unwrapToken strips the prefix (HeaderPrefix) and the scheme
+// (HeaderScheme) off a token from a header, or the "Bearer " prefix off a
+// token from the other sources. A token of a scheme not accepted is
+// unwrapped to empty, and rejected. The "DPoP" scheme is accepted as well
+// if DPoP is set.
+func (ja *JWTAuth) unwrapToken(candidate candidateToken, token string) string {
+	if candidate.source != sourceHeader {
+		return normToken(token)
+	}
+	if re, ok := ja.headerPrefixes[http.CanonicalHeaderKey(candidate.name)]; ok {
+		if loc := re.FindStringIndex(token); loc != nil && loc[0] == 0 {
+			token = token[loc[1]:]
+		}
+	}
+	scheme, credentials, found := strings.Cut(strings.TrimSpace(token), " ")
+	if found && ja.DPoP != nil && strings.EqualFold(scheme, "DPoP") {
+		return strings.TrimSpace(credentials)
+	}
+	if len(ja.HeaderScheme) == 0 {
+		return normToken(token)
+	}
+	for _, accepted := range ja.HeaderScheme {
+		if !found && strings.EqualFold(accepted, schemeNone) {
+			return scheme
+		}
+		if found && strings.EqualFold(accepted, scheme) {
+			return strings.TrimSpace(credentials)
+		}
+	}
+	return ""
+}
+
+func compileHeaderPrefixes(prefixes map[string]string) (map[string]*regexp.Regexp, error) {
+	compiled := make(map[string]*regexp.Regexp, len(prefixes))
+	for header, pattern := range prefixes {
+		if header == "" || pattern == "" {
+			return nil, fmt.Errorf("empty header or pattern: %q -> %q", header, pattern)
+		}
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", header, err)
+		}
+		compiled[http.CanonicalHeaderKey(header)] = re
+	}
+	return compiled, nil
+}
+
+// unquoteToken strips a pair of surrounding quotes (either " or ').
+func unquoteToken(token string) string {
+	if len(token) >= 2 {
+		first, last := token[0], token[len(token)-1]
+		if (first == '"' || first == '\'') && first == last {
+			return token[1 : len(token)-1]
+		}
+	}
+	return token
+}
+
+// urlDecodeToken decodes the token if percent-encoding is detected.
+func urlDecodeToken(token string) string {
+	for i := 0; i < maxURLDecodeRounds && strings.Contains(token, "%"); i++ {
+		decoded, err := url.PathUnescape(token)
+		if err != nil {
+			break
+		}
+		token = decoded
+	}
+	return token
+}
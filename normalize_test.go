@@ -0,0 +1,100 @@
+package caddyjwt
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNormalizeToken(t *testing.T) {
+	ja := &JWTAuth{
+		NormalizeToken: map[string][]string{
+			"cookie": {"trim", "unquote"},
+			"query":  {"urldecode"},
+		},
+	}
+	assert.Nil(t, validateNormalizeToken(ja.NormalizeToken))
+
+	for _, c := range []struct {
+		Candidate candidateToken
+		Expected  string
+	}{
+		{candidateToken{sourceCookie, "sess", ` "abc.def.ghi"` + "\n"}, "abc.def.ghi"},
+		{candidateToken{sourceCookie, "sess", `'abc.def.ghi'`}, "abc.def.ghi"},
+		{candidateToken{sourceCookie, "sess", `"abc.def.ghi'`}, `"abc.def.ghi'`},
+		{candidateToken{sourceQuery, "token", "abc%2Edef%252Eghi"}, "abc.def.ghi"},
+		{candidateToken{sourceQuery, "token", "abc%zz"}, "abc%zz"},
+		{candidateToken{sourceHeader, "X-Token", ` "Bearer abc.def.ghi"`}, `"Bearer abc.def.ghi"`},
+		{candidateToken{sourceHeader, "Authorization", "Bearer abc.def.ghi"}, "abc.def.ghi"},
+	} {
+		assert.Equal(t, c.Expected, ja.normalizeToken(c.Candidate))
+	}
+}
+
+func TestValidateNormalizeToken(t *testing.T) {
+	assert.ErrorContains(t, validateNormalizeToken(map[string][]string{"path": {"trim"}}), "source")
+	assert.ErrorContains(t, validateNormalizeToken(map[string][]string{"query": {"base64"}}), "rule")
+}
+
+func TestAuthenticate_NormalizeToken(t *testing.T) {
+	ja := &JWTAuth{
+		SignKey:        TestSignKey,
+		FromCookies:    []string{"user_session"},
+		NormalizeToken: map[string][]string{"cookie": {"unquote", "urldecode"}},
+		logger:         testLogger,
+	}
+	assert.Nil(t, ja.Validate())
+
+	rw := httptest.NewRecorder()
+	r, _ := http.NewRequest("GET", "/", nil)
+	tokenString := issueTokenString(MapClaims{"sub": "ggicci"})
+	r.AddCookie(&http.Cookie{Name: "user_session", Value: `"` + url.QueryEscape(url.QueryEscape(tokenString)) + `"`})
+	gotUser, authenticated, err := ja.Authenticate(rw, r)
+	assert.Nil(t, err)
+	assert.True(t, authenticated)
+	assert.Equal(t, User{ID: "ggicci"}, gotUser)
+}
+
+func TestAuthenticate_HeaderScheme(t *testing.T) {
+	ja := &JWTAuth{
+		SignKey:      TestSignKey,
+		FromHeader:   []string{"X-Grpc-Auth"},
+		HeaderScheme: []string{"JWT", "Token"},
+		HeaderPrefix: map[string]string{"x-grpc-auth": `^v[0-9]+:`},
+		logger:       testLogger,
+	}
+	assert.Nil(t, ja.Validate())
+	tokenString := issueTokenString(MapClaims{"sub": "ggicci"})
+
+	var testCases = []struct {
+		Header        string
+		Value         string
+		Authenticated bool
+	}{
+		{"Authorization", "JWT " + tokenString, true},
+		{"Authorization", "token " + tokenString, true},
+		{"Authorization", "Bearer " + tokenString, false},
+		{"Authorization", tokenString, false},
+		{"X-Grpc-Auth", "v2:Token " + tokenString, true},
+		{"X-Grpc-Auth", "Token v2:" + tokenString, false},
+	}
+	for _, c := range testCases {
+		r, _ := http.NewRequest("GET", "/", nil)
+		r.Header.Set(c.Header, c.Value)
+		_, authenticated, _ := ja.Authenticate(httptest.NewRecorder(), r)
+		assert.Equal(t, c.Authenticated, authenticated, c.Value)
+	}
+
+	ja.HeaderScheme = []string{"none"}
+	assert.Nil(t, ja.Validate())
+	r, _ := http.NewRequest("GET", "/", nil)
+	r.Header.Set("Authorization", tokenString)
+	_, authenticated, _ := ja.Authenticate(httptest.NewRecorder(), r)
+	assert.True(t, authenticated)
+
+	ja.HeaderPrefix = map[string]string{"X-Grpc-Auth": "("}
+	assert.ErrorContains(t, ja.Validate(), "header_prefix")
+}
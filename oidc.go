@@ -0,0 +1,95 @@
+package caddyjwt
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+const (
+	// oidcRediscoverInterval is how often the OIDC discovery document of
+	// OIDCIssuer is fetched again, to follow the rotation of the JWKS
+	// endpoint.
+	oidcRediscoverInterval = time.Hour
+
+	// oidcRetryInterval is how often the discovery is retried after a
+	// failure.
+	oidcRetryInterval = 30 * time.Second
+)
+
+// oidcConfiguration is the part of the OIDC discovery document we use, see
+// https://openid.net/specs/openid-connect-discovery-1_0.html#ProviderMetadata.
+type oidcConfiguration struct {
+	Issuer           string `json:"issuer"`
+	JWKSURI          string `json:"jwks_uri"`
+	UserInfoEndpoint string `json:"userinfo_endpoint"`
+}
+
+// fetchOIDCConfiguration fetches "<issuer>/.well-known/openid-configuration".
+func fetchOIDCConfiguration(ctx context.Context, client *http.Client, issuer string) (*oidcConfiguration, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, strings.TrimSuffix(issuer, "/")+"/.well-known/openid-configuration", nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status: %s", resp.Status)
+	}
+
+	var config oidcConfiguration
+	if err := json.NewDecoder(io.LimitReader(resp.Body, maxEnrichResponseSize)).Decode(&config); err != nil {
+		return nil, fmt.Errorf("decode discovery document: %w", err)
+	}
+	return &config, nil
+}
+
+// discoverJWKURL fetches the discovery document of OIDCIssuer and switches
+// to its "jwks_uri" if changed.
+func (ja *JWTAuth) discoverJWKURL(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	config, err := fetchOIDCConfiguration(ctx, http.DefaultClient, ja.OIDCIssuer)
+	if err != nil {
+		return err
+	}
+	if config.JWKSURI == "" {
+		return fmt.Errorf("issuer %q publishes no jwks_uri", ja.OIDCIssuer)
+	}
+	if current, _ := ja.jwks(); current != config.JWKSURI {
+		ja.logger.Info("discovered JWKs URL", zap.String("oidc_issuer", ja.OIDCIssuer), zap.String("url", config.JWKSURI))
+		ja.useJWKURL(config.JWKSURI)
+	}
+	return nil
+}
+
+// rediscoverJWKURL keeps discovering the JWKS endpoint of OIDCIssuer until
+// the context is done.
+func (ja *JWTAuth) rediscoverJWKURL(ctx context.Context, discovered bool) {
+	for {
+		interval := oidcRediscoverInterval
+		if !discovered {
+			interval = oidcRetryInterval
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(interval):
+		}
+		err := ja.discoverJWKURL(ctx)
+		if err != nil {
+			ja.logger.Error("failed to discover JWKs URL", zap.String("oidc_issuer", ja.OIDCIssuer), zap.Error(err))
+		}
+		discovered = err == nil
+	}
+}
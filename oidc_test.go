@@ -0,0 +1,100 @@
+package caddyjwt
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	"github.com/ggicci/caddy-jwt/idptest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAuthenticate_OIDCIssuer(t *testing.T) {
+	var jwksURI atomic.Value
+	jwksURI.Store(TestJWKSetURL)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/.well-known/openid-configuration", r.URL.Path)
+		json.NewEncoder(w).Encode(map[string]string{"jwks_uri": jwksURI.Load().(string)})
+	}))
+	defer server.Close()
+
+	ja := &JWTAuth{OIDCIssuer: server.URL, logger: testLogger}
+	assert.Nil(t, ja.Validate())
+	defer ja.Cleanup()
+	assert.Equal(t, []string{server.URL}, ja.IssuerWhitelist)
+	url, set := ja.jwks()
+	assert.Equal(t, TestJWKSetURL, url)
+	assert.Equal(t, 2, set.Len())
+
+	authenticate := func(claims MapClaims) error {
+		r, _ := http.NewRequest("GET", "/", nil)
+		r.Header.Add("Authorization", issueTokenStringJWK(claims))
+		_, _, err := ja.Authenticate(httptest.NewRecorder(), r)
+		return err
+	}
+	assert.Nil(t, authenticate(MapClaims{"sub": "ggicci", "iss": server.URL}))
+	assert.ErrorIs(t, authenticate(MapClaims{"sub": "ggicci", "iss": "https://evil.example.com"}), ErrInvalidIssuer)
+
+	// the IdP rotates its JWKS endpoint
+	jwksURI.Store(TestJWKSetURLInapplicable)
+	assert.Nil(t, ja.discoverJWKURL(context.Background()))
+	url, _ = ja.jwks()
+	assert.Equal(t, TestJWKSetURLInapplicable, url)
+	assert.ErrorIs(t, authenticate(MapClaims{"sub": "ggicci", "iss": server.URL}), ErrKeyNotFound)
+}
+
+func TestAuthenticate_OIDCIssuerUnavailable(t *testing.T) {
+	server := httptest.NewServer(http.NotFoundHandler())
+	defer server.Close()
+
+	ja := &JWTAuth{OIDCIssuer: server.URL, logger: testLogger}
+	assert.Nil(t, ja.Validate())
+	defer ja.Cleanup()
+
+	r, _ := http.NewRequest("GET", "/", nil)
+	r.Header.Add("Authorization", issueTokenStringJWK(MapClaims{"sub": "ggicci", "iss": server.URL}))
+	_, authenticated, err := ja.Authenticate(httptest.NewRecorder(), r)
+	assert.ErrorIs(t, err, ErrKeyNotFound)
+	assert.False(t, authenticated)
+}
+
+func TestAuthenticate_FakeIdP(t *testing.T) {
+	idp := idptest.NewServer()
+	defer idp.Close()
+	idp.AddClient("caddy", "s3cr3t")
+
+	ja := &JWTAuth{
+		OIDCIssuer: idp.URL,
+		Introspection: &Introspection{
+			Endpoint:     idp.IntrospectionURL(),
+			ClientID:     "caddy",
+			ClientSecret: "s3cr3t",
+		},
+		logger: testLogger,
+	}
+	assert.Nil(t, ja.Validate())
+	defer ja.Cleanup()
+
+	authenticate := func(token string) error {
+		r, _ := http.NewRequest("GET", "/", nil)
+		r.Header.Add("Authorization", "Bearer "+token)
+		_, _, err := ja.Authenticate(httptest.NewRecorder(), r)
+		return err
+	}
+	assert.Nil(t, authenticate(idp.Issue(idptest.Claims{"sub": "ggicci"})))
+	assert.Nil(t, authenticate(idp.IssueOpaque(idptest.Claims{"sub": "ggicci"})))
+
+	// tokens signed by a new key refetch the JWKs at once
+	idp.Rotate(false)
+	assert.Nil(t, authenticate(idp.Issue(idptest.Claims{"sub": "ggicci"})))
+
+	// but not again within jwkMinRefetch, rejected until the JWKs are refreshed
+	idp.Rotate(false)
+	token := idp.Issue(idptest.Claims{"sub": "ggicci"})
+	assert.ErrorIs(t, authenticate(token), ErrKeyNotFound)
+	assert.Nil(t, ja.refreshJWKCache())
+	assert.Nil(t, authenticate(token))
+}
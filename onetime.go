@@ -0,0 +1,194 @@
+package caddyjwt
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+)
+
+func init() {
+	caddy.RegisterModule(new(MemoryReplayStore))
+}
+
+// ReplayStore remembers the "jti" claims of the one-time tokens presented.
+// External modules can supply the backends shared by the instances of Caddy,
+// e.g. Redis, by registering a Caddy module in the namespace
+// "http.authentication.providers.jwt.replay" implementing it.
+type ReplayStore interface {
+	// Seen remembers the key, the jti scoped by the issuer, see replayKey,
+	// until the time, and reports whether it was remembered already,
+	// atomically. A store which can't remember it, e.g. being full, must
+	// return an error rather than forget another key early.
+	Seen(ctx context.Context, key string, until time.Time) (bool, error)
+}
+
+// ReplayPeeker is optionally implemented by a ReplayStore which can tell
+// whether a key has been remembered without remembering it, for
+// JWTAuth.PreValidate. The one-time tokens are not checked by PreValidate
+// against the stores not implementing it.
+type ReplayPeeker interface {
+	Peek(ctx context.Context, key string) (bool, error)
+}
+
+// replayKey is the key of the jti of the issuer in a ReplayStore, so the
+// jtis of different issuers never collide. The quoted issuer can't run into
+// the jti.
+func replayKey(iss, jti string) string {
+	return strconv.Quote(iss) + "|" + jti
+}
+
+// OneTimeTokens accepts each token once only, e.g. for the webhook-style
+// endpoints whose tokens are single-use by contract, by remembering their
+// "jti" claims until they expire. The tokens must carry both "jti" and
+// "exp", the others are rejected.
+//
+// It excludes IssueSessionCookie, whose sessions would let a token in again.
+type OneTimeTokens struct {
+	// StoreRaw is the backend remembering the "jti" claims. Defaults to the
+	// "memory" store, i.e. of this instance only.
+	StoreRaw json.RawMessage `json:"store,omitempty" caddy:"namespace=http.authentication.providers.jwt.replay inline_key=backend"`
+
+	// FailOpen accepts the tokens when the backend fails to tell whether
+	// they have been presented. By default, they are rejected.
+	FailOpen bool `json:"fail_open,omitempty"`
+
+	store ReplayStore
+}
+
+func (ot *OneTimeTokens) provision(ctx caddy.Context) error {
+	if ot.StoreRaw == nil {
+		ot.StoreRaw = json.RawMessage(`{"backend": "memory"}`)
+	}
+	mod, err := loadInlineModule(ctx, "http.authentication.providers.jwt.replay", "backend", ot.StoreRaw)
+	if err != nil {
+		return fmt.Errorf("loading backend: %w", err)
+	}
+	store, ok := mod.(ReplayStore)
+	if !ok {
+		return fmt.Errorf("backend %T is not a ReplayStore", mod)
+	}
+	ot.store = store
+	return nil
+}
+
+// check remembers the token, rejecting it if presented before. leeway is
+// how long after "exp" the token is still accepted, so it's remembered as
+// long. If dryRun, the token is only checked, not remembered, see
+// ReplayPeeker.
+func (ot *OneTimeTokens) check(ctx context.Context, token Token, leeway time.Duration, dryRun bool) error {
+	jti, exp := token.JwtID(), token.Expiration()
+	if jti == "" || exp.IsZero() {
+		return fmt.Errorf("%w: one-time token without jti or exp", ErrInvalidToken)
+	}
+	var (
+		seen bool
+		err  error
+	)
+	if !dryRun {
+		seen, err = ot.store.Seen(ctx, replayKey(token.Issuer(), jti), exp.Add(leeway))
+	} else if peeker, ok := ot.store.(ReplayPeeker); ok {
+		seen, err = peeker.Peek(ctx, replayKey(token.Issuer(), jti))
+	}
+	if err != nil {
+		if ot.FailOpen {
+			return nil
+		}
+		return fmt.Errorf("%w: jti %q: %v", ErrReplayUnavailable, jti, err)
+	}
+	if seen {
+		return fmt.Errorf("%w: jti %q", ErrTokenReplayed, jti)
+	}
+	return nil
+}
+
+// MemoryReplayStore is an in-memory ReplayStore, of this instance only. The
+// entries are lost on config reload.
+type MemoryReplayStore struct {
+	// MaxEntries bounds the number of the "jti" claims remembered. Defaults
+	// to 10000. Only the expired ones are forgotten to make room, so when
+	// it's full of live ones, the new tokens are rejected, or accepted
+	// without being remembered if FailOpen, until some expire.
+	MaxEntries int `json:"max_entries,omitempty"`
+
+	mu   sync.Mutex // makes checking and remembering a jti atomic
+	seen *ttlCache
+}
+
+// CaddyModule implements caddy.Module interface.
+func (*MemoryReplayStore) CaddyModule() caddy.ModuleInfo {
+	return caddy.ModuleInfo{
+		ID:  "http.authentication.providers.jwt.replay.memory",
+		New: func() caddy.Module { return new(MemoryReplayStore) },
+	}
+}
+
+// Provision implements caddy.Provisioner interface.
+func (ms *MemoryReplayStore) Provision(caddy.Context) error {
+	if ms.MaxEntries < 0 {
+		return fmt.Errorf("invalid max_entries: %d", ms.MaxEntries)
+	}
+	ms.seen = newTTLCache(ms.MaxEntries, 0)
+	return nil
+}
+
+// Seen implements ReplayStore interface.
+func (ms *MemoryReplayStore) Seen(_ context.Context, key string, until time.Time) (bool, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	if _, seen := ms.seen.Get(key); seen {
+		return true, nil
+	}
+	if ttl := time.Until(until); ttl > 0 && !ms.seen.TrySet(key, struct{}{}, ttl) {
+		return false, fmt.Errorf("full of %d unexpired jtis", ms.seen.Len())
+	}
+	return false, nil
+}
+
+// Peek implements ReplayPeeker interface.
+func (ms *MemoryReplayStore) Peek(_ context.Context, key string) (bool, error) {
+	_, seen := ms.seen.Get(key)
+	return seen, nil
+}
+
+// UnmarshalCaddyfile implements caddyfile.Unmarshaler interface. Syntax:
+//
+//	backend memory {
+//	    max_entries <n>
+//	}
+func (ms *MemoryReplayStore) UnmarshalCaddyfile(d *caddyfile.Dispenser) error {
+	for d.Next() {
+		if d.NextArg() {
+			return d.ArgErr()
+		}
+		for d.NextBlock(0) {
+			switch d.Val() {
+			case "max_entries":
+				var raw string
+				if !d.AllArgs(&raw) {
+					return d.ArgErr()
+				}
+				n, err := strconv.Atoi(raw)
+				if err != nil {
+					return d.Errf("invalid max_entries: %v", err)
+				}
+				ms.MaxEntries = n
+			default:
+				return d.Errf("unrecognized memory option: %s", d.Val())
+			}
+		}
+	}
+	return nil
+}
+
+// Interface guards
+var (
+	_ ReplayStore           = (*MemoryReplayStore)(nil)
+	_ caddy.Provisioner     = (*MemoryReplayStore)(nil)
+	_ caddyfile.Unmarshaler = (*MemoryReplayStore)(nil)
+)
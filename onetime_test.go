@@ -0,0 +1,189 @@
+package caddyjwt
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"github.com/caddyserver/caddy/v2/caddyconfig/httpcaddyfile"
+	"github.com/caddyserver/caddy/v2/modules/caddyhttp/caddyauth"
+	"github.com/stretchr/testify/assert"
+)
+
+type failingReplayStore struct{}
+
+func (failingReplayStore) Seen(context.Context, string, time.Time) (bool, error) {
+	return false, errors.New("connection refused")
+}
+
+func TestPreValidate_OneTimeTokens(t *testing.T) {
+	ctx, cancel := caddy.NewContext(caddy.Context{Context: context.Background()})
+	defer cancel()
+	ja := &JWTAuth{
+		SignKey:       TestSignKey,
+		OneTimeTokens: &OneTimeTokens{},
+		logger:        testLogger,
+	}
+	assert.Nil(t, ja.Provision(ctx))
+	assert.Nil(t, ja.Validate())
+
+	token := issueTokenString(MapClaims{"sub": "ggicci", "jti": "a", "exp": time.Now().Add(time.Minute).Unix()})
+	request := func() *http.Request {
+		r, _ := http.NewRequest("POST", "/webhook", nil)
+		r.Header.Add("Authorization", token)
+		return r
+	}
+
+	// not used up by PreValidate
+	for i := 0; i < 2; i++ {
+		user, err := ja.PreValidate(request())
+		assert.Nil(t, err)
+		assert.Equal(t, "ggicci", user.ID)
+	}
+	_, _, err := ja.Authenticate(httptest.NewRecorder(), request())
+	assert.Nil(t, err)
+
+	// but used up by Authenticate
+	_, err = ja.PreValidate(request())
+	assert.ErrorIs(t, err, ErrTokenReplayed)
+	_, _, err = ja.Authenticate(httptest.NewRecorder(), request())
+	assert.ErrorIs(t, err, ErrTokenReplayed)
+}
+
+func TestAuthenticate_OneTimeTokens(t *testing.T) {
+	ctx, cancel := caddy.NewContext(caddy.Context{Context: context.Background()})
+	defer cancel()
+	ja := &JWTAuth{
+		SignKey:       TestSignKey,
+		OneTimeTokens: &OneTimeTokens{},
+	}
+	assert.Nil(t, ja.Provision(ctx))
+	assert.Nil(t, ja.Validate())
+
+	authenticate := func(claims MapClaims) error {
+		r, _ := http.NewRequest("POST", "/webhook", nil)
+		r.Header.Add("Authorization", issueTokenString(claims))
+		_, _, err := ja.Authenticate(httptest.NewRecorder(), r)
+		return err
+	}
+	exp := time.Now().Add(time.Minute).Unix()
+
+	assert.Nil(t, authenticate(MapClaims{"sub": "ggicci", "jti": "a", "exp": exp}))
+	assert.ErrorIs(t, authenticate(MapClaims{"sub": "ggicci", "jti": "a", "exp": exp}), ErrTokenReplayed)
+	assert.Nil(t, authenticate(MapClaims{"sub": "ggicci", "jti": "b", "exp": exp}))
+	// the same jti of another issuer
+	assert.Nil(t, authenticate(MapClaims{"sub": "ggicci", "iss": "https://other.example.com", "jti": "a", "exp": exp}))
+	assert.ErrorIs(t, authenticate(MapClaims{"sub": "ggicci", "iss": "https://other.example.com", "jti": "a", "exp": exp}), ErrTokenReplayed)
+	assert.ErrorIs(t, authenticate(MapClaims{"sub": "ggicci", "exp": exp}), ErrInvalidToken, "no jti")
+	assert.ErrorIs(t, authenticate(MapClaims{"sub": "ggicci", "jti": "c"}), ErrInvalidToken, "no exp")
+
+	// not used up if rejected otherwise
+	ja.RequireRole = []string{"admin"}
+	assert.Nil(t, ja.Validate())
+	assert.Error(t, authenticate(MapClaims{"sub": "ggicci", "jti": "d", "exp": exp}))
+	ja.RequireRole = nil
+	assert.Nil(t, ja.Validate())
+	assert.Nil(t, authenticate(MapClaims{"sub": "ggicci", "jti": "d", "exp": exp}))
+
+	ja.OneTimeTokens.store = failingReplayStore{}
+	assert.ErrorIs(t, authenticate(MapClaims{"sub": "ggicci", "jti": "e", "exp": exp}), ErrReplayUnavailable)
+	ja.OneTimeTokens.FailOpen = true
+	assert.Nil(t, authenticate(MapClaims{"sub": "ggicci", "jti": "e", "exp": exp}))
+}
+
+func TestOneTimeTokens_ExcludesSessionCookie(t *testing.T) {
+	ja := &JWTAuth{
+		SignKey:            TestSignKey,
+		OneTimeTokens:      &OneTimeTokens{},
+		IssueSessionCookie: &SessionCookie{},
+	}
+	assert.ErrorContains(t, ja.Validate(), "invalid one_time_tokens")
+}
+
+func TestMemoryReplayStore_Seen(t *testing.T) {
+	ms := &MemoryReplayStore{}
+	assert.Nil(t, ms.Provision(caddy.Context{}))
+	ctx := context.Background()
+
+	seen, err := ms.Seen(ctx, "a", time.Now().Add(time.Minute))
+	assert.Nil(t, err)
+	assert.False(t, seen)
+	seen, _ = ms.Seen(ctx, "a", time.Now().Add(time.Minute))
+	assert.True(t, seen)
+
+	// already expired, nothing to remember
+	seen, _ = ms.Seen(ctx, "b", time.Now().Add(-time.Minute))
+	assert.False(t, seen)
+	assert.NotContains(t, ms.seen.entries, "b")
+
+	// never forgets a live jti to make room
+	ms = &MemoryReplayStore{MaxEntries: 10}
+	assert.Nil(t, ms.Provision(caddy.Context{}))
+	ms.seen.pressure = func() bool { return false }
+	seen, err = ms.Seen(ctx, "victim", time.Now().Add(time.Hour))
+	assert.Nil(t, err)
+	assert.False(t, seen)
+	for i := 0; i < 50; i++ {
+		_, _ = ms.Seen(ctx, strconv.Itoa(i), time.Now().Add(time.Hour))
+	}
+	seen, _ = ms.Seen(ctx, "victim", time.Now().Add(time.Hour))
+	assert.True(t, seen)
+	_, err = ms.Seen(ctx, "new", time.Now().Add(time.Hour))
+	assert.ErrorContains(t, err, "full")
+
+	// but the expired ones
+	now := time.Now().Add(2 * time.Hour)
+	ms.seen.now = func() time.Time { return now }
+	seen, err = ms.Seen(ctx, "new", now.Add(time.Hour))
+	assert.Nil(t, err)
+	assert.False(t, seen)
+
+	assert.NotNil(t, (&MemoryReplayStore{MaxEntries: -1}).Provision(caddy.Context{}))
+}
+
+func TestParsingCaddyfileOneTimeTokens(t *testing.T) {
+	helper := httpcaddyfile.Helper{
+		Dispenser: caddyfile.NewTestDispenser(`
+	jwtauth {
+		sign_key "TkZMNSowQmMjOVU2RUB0bm1DJkU3U1VONkd3SGZMbVk="
+		one_time_tokens {
+			backend memory {
+				max_entries 500
+			}
+			fail_open
+		}
+	}
+	`),
+	}
+	h, err := parseCaddyfile(helper)
+	assert.Nil(t, err)
+	var ja JWTAuth
+	assert.Nil(t, json.Unmarshal(h.(caddyauth.Authentication).ProvidersRaw["jwt"], &ja))
+	assert.True(t, ja.OneTimeTokens.FailOpen)
+	assert.JSONEq(t, `{"backend": "memory", "max_entries": 500}`, string(ja.OneTimeTokens.StoreRaw))
+
+	for _, body := range []string{
+		`jwtauth {
+			one_time_tokens {
+				backend unknown
+			}
+		}`,
+		`jwtauth {
+			one_time_tokens {
+				backend memory {
+					max_entries lots
+				}
+			}
+		}`,
+	} {
+		_, err = parseCaddyfile(httpcaddyfile.Helper{Dispenser: caddyfile.NewTestDispenser(body)})
+		assert.NotNil(t, err)
+	}
+}
@@ -0,0 +1,45 @@
+package caddyjwt
+
+import (
+	"net/http"
+
+	"github.com/caddyserver/caddy/v2"
+)
+
+// setPlaceholders populates the {http.auth.jwt.*} placeholders of an
+// authenticated request:
+//
+//   - {http.auth.jwt.key_source}: where the key came from, one of
+//     "sign_key", "jwk_url", "jwk_file", "jwk_static", "vault", "alb",
+//     "key_resolver", "introspection" and "session"
+//   - {http.auth.jwt.key_location}: the JWKs URL or file, the key URL of
+//     alb, or the introspection endpoint, empty for the others
+//   - {http.auth.jwt.kid}: "kid" of the key verified the token
+//   - {http.auth.jwt.matched_aud}: the audience on the whitelist which
+//     admitted the token, empty if audience_whitelist is not set
+//   - {http.auth.jwt.principal_type}: "human", "service" or "unknown",
+//     inferred from the claims
+func setPlaceholders(r *http.Request, result *authResult) {
+	repl, ok := r.Context().Value(caddy.ReplacerCtxKey).(*caddy.Replacer)
+	if !ok {
+		return
+	}
+	if kp := result.provenance; kp != nil {
+		repl.Set("http.auth.jwt.key_source", kp.Source)
+		repl.Set("http.auth.jwt.key_location", kp.Location)
+		repl.Set("http.auth.jwt.kid", kp.KeyID)
+	}
+	repl.Set("http.auth.jwt.matched_aud", result.matchedAudience)
+	repl.Set("http.auth.jwt.principal_type", result.principalType)
+}
+
+// setErrorPlaceholder populates the {http.auth.jwt.error} placeholder of a
+// request failing the authentication with the failure reason, e.g.
+// "token_expired" or "signature_invalid", so the handle_errors routes and
+// the logs can branch on it. The status of the response of the provider, if
+// any, is in {http.auth.jwt.status}, see writeFailure.
+func setErrorPlaceholder(r *http.Request, err error) {
+	if repl, ok := r.Context().Value(caddy.ReplacerCtxKey).(*caddy.Replacer); ok {
+		repl.Set("http.auth.jwt.error", failureReason(err))
+	}
+}
@@ -0,0 +1,76 @@
+package caddyjwt
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/stretchr/testify/assert"
+)
+
+// newRequestWithReplacer creates a request carrying a Caddy replacer, as
+// what Caddy does for every request.
+func newRequestWithReplacer(method, target string) (*http.Request, *caddy.Replacer) {
+	repl := caddy.NewReplacer()
+	r, _ := http.NewRequest(method, target, nil)
+	r = r.WithContext(context.WithValue(r.Context(), caddy.ReplacerCtxKey, repl))
+	return r, repl
+}
+
+func TestPlaceholders_KeyProvenance(t *testing.T) {
+	ja := &JWTAuth{SignKey: TestSignKey, logger: testLogger}
+	assert.Nil(t, ja.Validate())
+
+	r, repl := newRequestWithReplacer("GET", "/")
+	r.Header.Add("Authorization", issueTokenString(MapClaims{"sub": "ggicci"}))
+	_, authenticated, err := ja.Authenticate(httptest.NewRecorder(), r)
+	assert.Nil(t, err)
+	assert.True(t, authenticated)
+
+	keySource, _ := repl.Get("http.auth.jwt.key_source")
+	assert.Equal(t, "sign_key", keySource)
+	keyLocation, _ := repl.Get("http.auth.jwt.key_location")
+	assert.Equal(t, "", keyLocation)
+}
+
+func TestPlaceholders_Error(t *testing.T) {
+	ja := &JWTAuth{SignKey: TestSignKey, IssuerWhitelist: []string{"https://issuer.example.com"}, logger: testLogger}
+	assert.Nil(t, ja.Validate())
+
+	authenticate := func(token string) (string, error) {
+		r, repl := newRequestWithReplacer("GET", "/")
+		if token != "" {
+			r.Header.Add("Authorization", token)
+		}
+		_, _, err := ja.Authenticate(httptest.NewRecorder(), r)
+		reason, _ := repl.Get("http.auth.jwt.error")
+		return reason.(string), err
+	}
+
+	reason, err := authenticate("")
+	assert.Nil(t, err) // left to the other providers
+	assert.Equal(t, "missing_token", reason)
+
+	reason, err = authenticate(issueTokenString(MapClaims{"sub": "ggicci", "iss": "https://issuer.example.com", "exp": 1}))
+	assert.ErrorIs(t, err, ErrTokenExpired)
+	assert.Equal(t, "token_expired", reason)
+
+	reason, err = authenticate(issueTokenString(MapClaims{"sub": "ggicci", "iss": "https://evil.example.com"}))
+	assert.ErrorIs(t, err, ErrInvalidIssuer)
+	assert.ErrorIs(t, err, ErrClaimMismatch)
+	assert.Equal(t, "invalid_issuer", reason)
+
+	token := issueTokenString(MapClaims{"sub": "ggicci", "iss": "https://issuer.example.com"})
+	reason, err = authenticate(token[:len(token)-4] + "AAAA")
+	assert.ErrorIs(t, err, ErrSignatureInvalid)
+	assert.ErrorIs(t, err, ErrInvalidToken)
+	assert.NotErrorIs(t, err, ErrClaimMismatch)
+	assert.Equal(t, "signature_invalid", reason)
+
+	reason, err = authenticate("not-a-jwt")
+	assert.ErrorIs(t, err, ErrInvalidToken)
+	assert.NotErrorIs(t, err, ErrSignatureInvalid)
+	assert.Equal(t, "invalid_token", reason)
+}
@@ -0,0 +1,124 @@
+package caddyjwt
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/caddyserver/caddy/v2"
+)
+
+// ClaimPolicy requires the claims of a token to have certain values. It maps
+// a claim name (nested claims can be accessed by dot notation) to the accepted
+// values. A token satisfies the policy if, for each claim, the value of the
+// claim, or any element of it if it's an array, is one of the accepted
+// values.
+type ClaimPolicy map[string][]string
+
+// check verifies the claims of the token against the policy.
+func (p ClaimPolicy) check(token Token) error {
+	for claim, accepted := range p {
+		val, ok := getClaim(token, claim)
+		if !ok {
+			return fmt.Errorf("%w: missing claim %q", ErrClaimPolicy, claim)
+		}
+		if !claimMatches(val, accepted) {
+			return fmt.Errorf("%w: claim %q has no accepted value", ErrClaimPolicy, claim)
+		}
+	}
+	return nil
+}
+
+// ClaimRequirement requires a claim of a token to have one of the values,
+// the same as an entry of ClaimPolicy. The "scope" claim, a space-separated
+// string as per https://www.rfc-editor.org/rfc/rfc8693#section-4.2, is
+// matched by its individual scopes.
+type ClaimRequirement struct {
+	Claim  string   `json:"claim"`
+	Values []string `json:"values"`
+}
+
+// checkRequirements verifies the claims of the token against all the
+// requirements.
+func checkRequirements(token Token, requirements []ClaimRequirement) error {
+	for _, req := range requirements {
+		val, ok := getClaim(token, req.Claim)
+		if !ok {
+			return fmt.Errorf("%w: missing required claim %q", ErrClaimPolicy, req.Claim)
+		}
+		if s, isString := val.(string); isString && req.Claim == "scope" {
+			val = splitScopes(s)
+		}
+		if !claimMatches(val, req.Values) {
+			return fmt.Errorf("%w: required claim %q has no accepted value", ErrClaimPolicy, req.Claim)
+		}
+	}
+	return nil
+}
+
+func splitScopes(scope string) []interface{} {
+	var scopes []interface{}
+	for _, s := range strings.Fields(scope) {
+		scopes = append(scopes, s)
+	}
+	return scopes
+}
+
+func claimMatches(val interface{}, accepted []string) bool {
+	values, ok := val.([]interface{})
+	if !ok {
+		values = []interface{}{val}
+	}
+	for _, v := range values {
+		got := stringify(v)
+		for _, want := range accepted {
+			if got == want {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// selectClaimPolicy returns the claim policy selected for the request by
+// name (ClaimPolicyName), nil if none.
+func (lp *livePolicy) selectClaimPolicy(r *http.Request, name string) (ClaimPolicy, error) {
+	if repl, ok := r.Context().Value(caddy.ReplacerCtxKey).(*caddy.Replacer); ok {
+		name = repl.ReplaceAll(name, "")
+	}
+	if name == "" {
+		return nil, nil
+	}
+	policy, ok := lp.ClaimPolicies[name]
+	if !ok {
+		return nil, fmt.Errorf("%w: unknown claim policy %q", ErrClaimPolicy, name)
+	}
+	return policy, nil
+}
+
+// selectAudiences returns the audiences accepted for the request: the ones
+// the audience (Audience) is replaced to, space separated, or
+// AudienceWhitelist if it's empty or replaced to empty.
+func (lp *livePolicy) selectAudiences(r *http.Request, audience string) []string {
+	if repl, ok := r.Context().Value(caddy.ReplacerCtxKey).(*caddy.Replacer); ok {
+		audience = repl.ReplaceAll(audience, "")
+	}
+	if audiences := strings.Fields(audience); len(audiences) > 0 {
+		return audiences
+	}
+	return lp.AudienceWhitelist
+}
+
+func validateClaimPolicies(policies map[string]ClaimPolicy) error {
+	for name, policy := range policies {
+		if name == "" {
+			return fmt.Errorf("empty name")
+		}
+		for claim, accepted := range policy {
+			if claim == "" || len(accepted) == 0 {
+				return fmt.Errorf("%s: claim %q requires at least one value", name, claim)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,125 @@
+package caddyjwt
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAuthenticate_ClaimPolicies(t *testing.T) {
+	ja := &JWTAuth{
+		SignKey: TestSignKey,
+		ClaimPolicies: map[string]ClaimPolicy{
+			"admin":  {"roles": {"admin"}},
+			"member": {"roles": {"admin", "member"}, "org.id": {"codelet"}},
+		},
+		ClaimPolicyName: "{http.vars.jwt_policy}",
+		logger:          testLogger,
+	}
+	assert.Nil(t, ja.Validate())
+
+	memberToken := issueTokenString(MapClaims{
+		"sub":   "ggicci",
+		"roles": []string{"member"},
+		"org":   map[string]interface{}{"id": "codelet"},
+	})
+
+	var testCases = []struct {
+		Policy        string
+		Token         string
+		Authenticated bool
+	}{
+		{"", memberToken, true}, // no policy selected
+		{"member", memberToken, true},
+		{"admin", memberToken, false},
+		{"member", issueTokenString(MapClaims{"sub": "ggicci", "roles": "admin"}), false}, // missing org.id
+		{"unknown", memberToken, false},
+	}
+
+	for _, c := range testCases {
+		r, repl := newRequestWithReplacer("GET", "/")
+		repl.Set("http.vars.jwt_policy", c.Policy)
+		r.Header.Add("Authorization", c.Token)
+		_, authenticated, err := ja.Authenticate(httptest.NewRecorder(), r)
+		assert.Equal(t, c.Authenticated, authenticated, c.Policy)
+		if !c.Authenticated {
+			assert.ErrorIs(t, err, ErrClaimPolicy)
+		}
+	}
+}
+
+func TestAuthenticate_AudienceOverride(t *testing.T) {
+	ja := &JWTAuth{
+		SignKey:           TestSignKey,
+		AudienceWhitelist: []string{"https://api.example.com"},
+		Audience:          "{http.vars.jwt_aud}",
+		logger:            testLogger,
+	}
+	assert.Nil(t, ja.Validate())
+
+	token := issueTokenString(MapClaims{"sub": "ggicci", "aud": []string{"https://billing.example.com"}})
+	var testCases = []struct {
+		Audience      string
+		Authenticated bool
+	}{
+		{"", false}, // audience_whitelist
+		{"https://billing.example.com", true},
+		{"https://orders.example.com https://billing.example.com", true},
+		{"https://orders.example.com", false},
+	}
+
+	for _, c := range testCases {
+		r, repl := newRequestWithReplacer("GET", "/")
+		repl.Set("http.vars.jwt_aud", c.Audience)
+		r.Header.Add("Authorization", token)
+		_, authenticated, err := ja.Authenticate(httptest.NewRecorder(), r)
+		assert.Equal(t, c.Authenticated, authenticated, c.Audience)
+		if !c.Authenticated {
+			assert.ErrorIs(t, err, ErrAudienceMismatch)
+		}
+	}
+}
+
+func TestValidate_InvalidClaimPolicies(t *testing.T) {
+	ja := &JWTAuth{
+		SignKey:       TestSignKey,
+		ClaimPolicies: map[string]ClaimPolicy{"admin": {"roles": {}}},
+		logger:        testLogger,
+	}
+	assert.ErrorContains(t, ja.Validate(), "claim_policies")
+}
+
+func TestAuthenticate_Require(t *testing.T) {
+	ja := &JWTAuth{
+		SignKey: TestSignKey,
+		Require: []ClaimRequirement{
+			{Claim: "scope", Values: []string{"admin:read", "admin:write"}},
+			{Claim: "org.id", Values: []string{"codelet"}},
+		},
+		logger: testLogger,
+	}
+	assert.Nil(t, ja.Validate())
+
+	var testCases = []struct {
+		Claims        MapClaims
+		Authenticated bool
+	}{
+		{MapClaims{"sub": "ggicci", "scope": "openid admin:read", "org": map[string]interface{}{"id": "codelet"}}, true},
+		{MapClaims{"sub": "ggicci", "scope": []string{"admin:write"}, "org": map[string]interface{}{"id": "codelet"}}, true},
+		{MapClaims{"sub": "ggicci", "scope": "openid", "org": map[string]interface{}{"id": "codelet"}}, false},
+		{MapClaims{"sub": "ggicci", "scope": "admin:read"}, false}, // missing org.id
+	}
+	for _, c := range testCases {
+		r := httptest.NewRequest("GET", "/", nil)
+		r.Header.Add("Authorization", issueTokenString(c.Claims))
+		_, authenticated, err := ja.Authenticate(httptest.NewRecorder(), r)
+		assert.Equal(t, c.Authenticated, authenticated, c.Claims)
+		if !c.Authenticated {
+			assert.ErrorIs(t, err, ErrClaimPolicy)
+		}
+	}
+
+	ja = &JWTAuth{SignKey: TestSignKey, Require: []ClaimRequirement{{Claim: "scope"}}, logger: testLogger}
+	assert.ErrorContains(t, ja.Validate(), "require")
+}
@@ -0,0 +1,138 @@
+package caddyjwt
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"github.com/caddyserver/caddy/v2/caddyconfig/httpcaddyfile"
+)
+
+// providerPreset configures the options of the tokens of a platform, of the
+// arguments of the preset option, e.g. the project ID.
+type providerPreset struct {
+	usage   string // of the arguments
+	minArgs int
+	maxArgs int // -1 if unbounded
+	options func(args []string) ([][]string, error)
+}
+
+// providerPresets are the presets of the preset option, which expand to the
+// options they stand for at adaptation, so `caddy adapt` shows them in
+// full. Syntax:
+//
+//	preset google_iap <audience>
+//	preset cloud_run <audience...>
+//	preset firebase <project_id>
+//	preset azure_ad <tenant_id> <audience...>
+var providerPresets = map[string]providerPreset{
+	// the signed headers of Identity-Aware Proxy, of the audience
+	// "/projects/<number>/global/backendServices/<id>", or
+	// "/projects/<number>/apps/<project_id>" of App Engine
+	"google_iap": {
+		usage: "<audience>", minArgs: 1, maxArgs: 1,
+		options: func(args []string) ([][]string, error) {
+			return [][]string{
+				{"jwk_url", "https://www.gstatic.com/iap/verify/public_key-jwk"},
+				{"allowed_algorithms", "ES256"},
+				{"issuer_whitelist", "https://cloud.google.com/iap"},
+				{"audience_whitelist", args[0]},
+				{"from_header", "X-Goog-IAP-JWT-Assertion"},
+			}, nil
+		},
+	},
+	// the ID tokens of Google, e.g. of the service accounts invoking Cloud
+	// Run, of the audience of the URL of the service
+	"cloud_run": {
+		usage: "<audience...>", minArgs: 1, maxArgs: -1,
+		options: func(args []string) ([][]string, error) {
+			return [][]string{
+				{"jwk_url", "https://www.googleapis.com/oauth2/v3/certs"},
+				{"allowed_algorithms", "RS256"},
+				{"issuer_whitelist", "https://accounts.google.com", "accounts.google.com"},
+				append([]string{"audience_whitelist"}, args...),
+			}, nil
+		},
+	},
+	// the ID tokens of Firebase Authentication
+	"firebase": {
+		usage: "<project_id>", minArgs: 1, maxArgs: 1,
+		options: func(args []string) ([][]string, error) {
+			project := args[0]
+			if !jwkTenantValue.MatchString(project) {
+				return nil, fmt.Errorf("invalid project_id %q", project)
+			}
+			return [][]string{
+				{"jwk_url", "https://www.googleapis.com/service_accounts/v1/jwk/securetoken@system.gserviceaccount.com"},
+				{"allowed_algorithms", "RS256"},
+				{"issuer_whitelist", "https://securetoken.google.com/" + project},
+				{"audience_whitelist", project},
+			}, nil
+		},
+	},
+	// the tokens of Microsoft Entra ID (Azure AD) of a tenant, both of v1.0
+	// and v2.0, of the audiences of the client ID or the App ID URI
+	"azure_ad": {
+		usage: "<tenant_id> <audience...>", minArgs: 2, maxArgs: -1,
+		options: func(args []string) ([][]string, error) {
+			tenant := args[0]
+			switch strings.ToLower(tenant) {
+			case "common", "organizations", "consumers":
+				return nil, fmt.Errorf("tenant %q has no issuer of its own, expect a tenant ID", tenant)
+			}
+			if !jwkTenantValue.MatchString(tenant) {
+				return nil, fmt.Errorf("invalid tenant_id %q", tenant)
+			}
+			return [][]string{
+				{"jwk_url", "https://login.microsoftonline.com/" + tenant + "/discovery/v2.0/keys"},
+				{"allowed_algorithms", "RS256"},
+				{"issuer_whitelist", "https://login.microsoftonline.com/" + tenant + "/v2.0", "https://sts.windows.net/" + tenant + "/"},
+				append([]string{"audience_whitelist"}, args[1:]...),
+			}, nil
+		},
+	},
+}
+
+// expandPreset parses the options the current preset option stands for, as
+// if given on its line, so they can't be given again.
+func expandPreset(h httpcaddyfile.Helper, ja *JWTAuth, seen optionLines) error {
+	args := h.RemainingArgs()
+	if len(args) == 0 {
+		return h.Errf("invalid preset: expect <name> [<args...>], one of %s", strings.Join(presetNames(), ", "))
+	}
+	name, args := args[0], args[1:]
+	preset, ok := providerPresets[name]
+	if !ok {
+		return h.Errf("unrecognized preset: %s, expect one of %s", name, strings.Join(presetNames(), ", "))
+	}
+	if len(args) < preset.minArgs || preset.maxArgs >= 0 && len(args) > preset.maxArgs {
+		return h.Errf("invalid preset %s: expect %s", name, preset.usage)
+	}
+	options, err := preset.options(args)
+	if err != nil {
+		return h.Errf("invalid preset %s: %v", name, err)
+	}
+	for _, option := range options {
+		tokens := make([]caddyfile.Token, len(option))
+		for i, text := range option {
+			tokens[i] = caddyfile.Token{File: h.File(), Line: h.Line(), Text: text}
+		}
+		expanded := h
+		expanded.Dispenser = caddyfile.NewDispenser(tokens)
+		expanded.Next()
+		if err := parseOption(expanded, ja, option[0], seen); err != nil {
+			return fmt.Errorf("preset %s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+func presetNames() []string {
+	names := make([]string, 0, len(providerPresets))
+	for name := range providerPresets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
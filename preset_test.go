@@ -0,0 +1,90 @@
+package caddyjwt
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"github.com/caddyserver/caddy/v2/caddyconfig/httpcaddyfile"
+	"github.com/caddyserver/caddy/v2/modules/caddyhttp/caddyauth"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParsingCaddyfilePreset(t *testing.T) {
+	parse := func(body string) (*JWTAuth, error) {
+		h, err := parseCaddyfile(httpcaddyfile.Helper{Dispenser: caddyfile.NewTestDispenser(body)})
+		if err != nil {
+			return nil, err
+		}
+		var ja JWTAuth
+		assert.Nil(t, json.Unmarshal(h.(caddyauth.Authentication).ProvidersRaw["jwt"], &ja))
+		return &ja, nil
+	}
+
+	ja, err := parse(`jwtauth {
+		preset google_iap /projects/123/global/backendServices/456
+		user_claims email
+	}`)
+	assert.Nil(t, err)
+	assert.Equal(t, "https://www.gstatic.com/iap/verify/public_key-jwk", ja.JWKURL)
+	assert.Equal(t, []string{"ES256"}, ja.AllowedAlgorithms)
+	assert.Equal(t, []string{"https://cloud.google.com/iap"}, ja.IssuerWhitelist)
+	assert.Equal(t, []string{"/projects/123/global/backendServices/456"}, ja.AudienceWhitelist)
+	assert.Equal(t, []string{"X-Goog-IAP-JWT-Assertion"}, ja.FromHeader)
+	assert.Equal(t, []string{"email"}, ja.UserClaims)
+
+	ja, err = parse(`jwtauth {
+		preset cloud_run https://hello-abc123-uc.a.run.app
+	}`)
+	assert.Nil(t, err)
+	assert.Equal(t, "https://www.googleapis.com/oauth2/v3/certs", ja.JWKURL)
+	assert.Equal(t, []string{"https://accounts.google.com", "accounts.google.com"}, ja.IssuerWhitelist)
+	assert.Equal(t, []string{"https://hello-abc123-uc.a.run.app"}, ja.AudienceWhitelist)
+
+	ja, err = parse(`jwtauth {
+		preset firebase my-project
+	}`)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"https://securetoken.google.com/my-project"}, ja.IssuerWhitelist)
+	assert.Equal(t, []string{"my-project"}, ja.AudienceWhitelist)
+
+	ja, err = parse(`jwtauth {
+		preset azure_ad 72f988bf-86f1-41af-91ab-2d7cd011db47 api://my-api 6e74172b-be56-4843-9ff4-e66a39bb12e3
+	}`)
+	assert.Nil(t, err)
+	assert.Equal(t, "https://login.microsoftonline.com/72f988bf-86f1-41af-91ab-2d7cd011db47/discovery/v2.0/keys", ja.JWKURL)
+	assert.Equal(t, []string{
+		"https://login.microsoftonline.com/72f988bf-86f1-41af-91ab-2d7cd011db47/v2.0",
+		"https://sts.windows.net/72f988bf-86f1-41af-91ab-2d7cd011db47/",
+	}, ja.IssuerWhitelist)
+	assert.Equal(t, []string{"api://my-api", "6e74172b-be56-4843-9ff4-e66a39bb12e3"}, ja.AudienceWhitelist)
+
+	for body, wantErr := range map[string]string{
+		`jwtauth {
+			preset
+		}`: "invalid preset: expect <name>",
+		`jwtauth {
+			preset okta
+		}`: "unrecognized preset: okta",
+		`jwtauth {
+			preset google_iap
+		}`: "invalid preset google_iap: expect <audience>",
+		`jwtauth {
+			preset azure_ad common api://my-api
+		}`: "has no issuer of its own",
+		`jwtauth {
+			preset firebase ../evil
+		}`: "invalid project_id",
+		`jwtauth {
+			jwk_url https://example.com/jwks
+			preset firebase my-project
+		}`: "duplicate option: jwk_url, already given at line 2",
+		`jwtauth {
+			preset firebase my-project
+			audience_whitelist other
+		}`: "duplicate option: audience_whitelist, already given at line 2",
+	} {
+		_, err := parse(body)
+		assert.ErrorContains(t, err, wantErr, body)
+	}
+}
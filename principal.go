@@ -0,0 +1,75 @@
+package caddyjwt
+
+import (
+	"fmt"
+	"strings"
+)
+
+// The principal types, see PrincipalType.
+const (
+	principalHuman   = "human"
+	principalService = "service"
+	principalAny     = "any"
+	principalUnknown = "unknown"
+)
+
+// serviceSubjectPrefixes are the prefixes of "sub" commonly used by the
+// service accounts.
+var serviceSubjectPrefixes = []string{
+	"system:serviceaccount:", // Kubernetes
+	"service-account-",       // Keycloak
+	"spiffe://",              // SPIFFE
+}
+
+// inferPrincipalType infers whether the token was issued to a service
+// (machine) or a human from the shape of its claims:
+//
+//   - service: "gty" is "client-credentials", or "sub" looks like a service
+//     account (see serviceSubjectPrefixes, or a GCP service account email),
+//     or "client_id" is present without "email"
+//   - human: "sub" and "email" are present
+//   - unknown: otherwise
+func inferPrincipalType(token Token) string {
+	sub := token.Subject()
+	if gty, _ := getClaim(token, "gty"); gty == "client-credentials" {
+		return principalService
+	}
+	for _, prefix := range serviceSubjectPrefixes {
+		if strings.HasPrefix(sub, prefix) {
+			return principalService
+		}
+	}
+	if strings.HasSuffix(sub, ".iam.gserviceaccount.com") {
+		return principalService
+	}
+
+	_, hasEmail := getClaim(token, "email")
+	if _, hasClientID := getClaim(token, "client_id"); hasClientID && !hasEmail {
+		return principalService
+	}
+	if sub != "" && hasEmail {
+		return principalHuman
+	}
+	return principalUnknown
+}
+
+// checkPrincipalType verifies the inferred principal type of the token
+// against PrincipalType, and returns the inferred type.
+func (ja *JWTAuth) checkPrincipalType(token Token) (string, error) {
+	principalType := inferPrincipalType(token)
+	switch ja.PrincipalType {
+	case "", principalAny:
+		return principalType, nil
+	case principalType:
+		return principalType, nil
+	}
+	return principalType, fmt.Errorf("%w: expect %s, got %s", ErrPrincipalType, ja.PrincipalType, principalType)
+}
+
+func validatePrincipalType(principalType string) error {
+	switch principalType {
+	case "", principalHuman, principalService, principalAny:
+		return nil
+	}
+	return fmt.Errorf("expect %s, %s or %s", principalHuman, principalService, principalAny)
+}
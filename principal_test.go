@@ -0,0 +1,48 @@
+package caddyjwt
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestInferPrincipalType(t *testing.T) {
+	var testCases = []struct {
+		Claims MapClaims
+		Type   string
+	}{
+		{MapClaims{"sub": "ggicci", "email": "ggicci@example.com"}, principalHuman},
+		{MapClaims{"sub": "system:serviceaccount:default:api"}, principalService},
+		{MapClaims{"sub": "spiffe://prod/ns/default/sa/api", "email": "api@example.com"}, principalService},
+		{MapClaims{"sub": "api@project.iam.gserviceaccount.com", "email": "api@project.iam.gserviceaccount.com"}, principalService},
+		{MapClaims{"sub": "abc@clients", "gty": "client-credentials"}, principalService},
+		{MapClaims{"sub": "abc", "client_id": "abc"}, principalService},
+		{MapClaims{"sub": "ggicci"}, principalUnknown},
+	}
+	for _, c := range testCases {
+		assert.Equal(t, c.Type, inferPrincipalType(buildToken(c.Claims)), c.Claims["sub"])
+	}
+}
+
+func TestAuthenticate_PrincipalType(t *testing.T) {
+	ja := &JWTAuth{SignKey: TestSignKey, PrincipalType: principalHuman, logger: testLogger}
+	assert.Nil(t, ja.Validate())
+
+	r, repl := newRequestWithReplacer("GET", "/")
+	r.Header.Add("Authorization", issueTokenString(MapClaims{"sub": "ggicci", "email": "ggicci@example.com"}))
+	_, authenticated, err := ja.Authenticate(httptest.NewRecorder(), r)
+	assert.Nil(t, err)
+	assert.True(t, authenticated)
+	principalType, _ := repl.Get("http.auth.jwt.principal_type")
+	assert.Equal(t, principalHuman, principalType)
+
+	r, _ = newRequestWithReplacer("GET", "/")
+	r.Header.Add("Authorization", issueTokenString(MapClaims{"sub": "system:serviceaccount:default:api"}))
+	_, authenticated, err = ja.Authenticate(httptest.NewRecorder(), r)
+	assert.ErrorIs(t, err, ErrPrincipalType)
+	assert.False(t, authenticated)
+
+	ja = &JWTAuth{SignKey: TestSignKey, PrincipalType: "robot", logger: testLogger}
+	assert.ErrorContains(t, ja.Validate(), "principal_type")
+}
@@ -0,0 +1,61 @@
+package caddyjwt
+
+import (
+	"fmt"
+	"net/http"
+
+	"go.uber.org/zap"
+)
+
+// The values of QueryTokenPolicy.
+const (
+	queryTokenAllow  = "allow"
+	queryTokenWarn   = "warn"
+	queryTokenRedact = "redact"
+	queryTokenDeny   = "deny"
+)
+
+func validateQueryTokenPolicy(policy string) error {
+	switch policy {
+	case "", queryTokenAllow, queryTokenWarn, queryTokenRedact, queryTokenDeny:
+		return nil
+	}
+	return fmt.Errorf("%q, expect allow, warn, redact or deny", policy)
+}
+
+// applyQueryTokenPolicy warns of or redacts the tokens in the query of the
+// request, of the candidates extracted, see QueryTokenPolicy.
+func (ja *JWTAuth) applyQueryTokenPolicy(r *http.Request, candidates []candidateToken, logger *zap.Logger) {
+	if ja.QueryTokenPolicy != queryTokenWarn && ja.QueryTokenPolicy != queryTokenRedact {
+		return
+	}
+	var names []string
+	for _, candidate := range candidates {
+		if candidate.source == sourceQuery {
+			names = append(names, candidate.name)
+		}
+	}
+	if len(names) == 0 {
+		return
+	}
+	if ja.QueryTokenPolicy == queryTokenWarn {
+		logger.Warn("token in the query, which leaks via the logs and the Referer headers",
+			zap.Strings("params", names), zap.String("path", r.URL.Path))
+		return
+	}
+	stripQueryParams(r, names)
+}
+
+// deniedQueryToken returns the name of the first query parameter of the
+// request presenting a token, of FromQuery or "access_token" if none.
+func (ja *JWTAuth) deniedQueryToken(r *http.Request, candidates []candidateToken) (string, bool) {
+	for _, candidate := range candidates {
+		if candidate.source == sourceQuery {
+			return candidate.name, true
+		}
+	}
+	if len(ja.FromQuery) == 0 && r.URL.Query().Get("access_token") != "" {
+		return "access_token", true
+	}
+	return "", false
+}
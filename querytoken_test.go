@@ -0,0 +1,89 @@
+package caddyjwt
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"github.com/caddyserver/caddy/v2/caddyconfig/httpcaddyfile"
+	"github.com/caddyserver/caddy/v2/modules/caddyhttp/caddyauth"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+func TestAuthenticate_QueryTokenPolicy(t *testing.T) {
+	token := issueTokenString(MapClaims{"sub": "ggicci"})
+	core, logs := observer.New(zap.WarnLevel)
+	newJWTAuth := func(policy string, fromQuery ...string) *JWTAuth {
+		ja := &JWTAuth{SignKey: TestSignKey, FromQuery: fromQuery, QueryTokenPolicy: policy, logger: zap.New(core)}
+		assert.Nil(t, ja.Validate())
+		return ja
+	}
+	authenticate := func(ja *JWTAuth, query string) (*http.Request, User, error) {
+		r, _ := http.NewRequest("GET", "/path?"+query, nil)
+		r.RequestURI = r.URL.RequestURI()
+		user, _, err := ja.Authenticate(httptest.NewRecorder(), r)
+		return r, user, err
+	}
+
+	// allow
+	r, user, err := authenticate(newJWTAuth("", "token"), "token="+token+"&page=2")
+	assert.Nil(t, err)
+	assert.Equal(t, "ggicci", user.ID)
+	assert.Contains(t, r.URL.RawQuery, token)
+	assert.Equal(t, 0, logs.Len())
+
+	// warn
+	_, user, err = authenticate(newJWTAuth("warn", "token"), "token="+token)
+	assert.Nil(t, err)
+	assert.Equal(t, "ggicci", user.ID)
+	assert.Equal(t, 1, logs.FilterMessageSnippet("token in the query").Len())
+
+	// redact, accepted or not
+	ja := newJWTAuth("redact", "token")
+	r, user, err = authenticate(ja, "token="+token+"&page=2")
+	assert.Nil(t, err)
+	assert.Equal(t, "ggicci", user.ID)
+	assert.Equal(t, "page=2", r.URL.RawQuery)
+	assert.Equal(t, "/path?page=2", r.RequestURI)
+	r, _, err = authenticate(ja, "token=garbage&page=2")
+	assert.ErrorIs(t, err, ErrInvalidToken)
+	assert.Equal(t, "page=2", r.URL.RawQuery)
+
+	// deny, of from_query
+	ja = newJWTAuth("deny", "token")
+	_, _, err = authenticate(ja, "token="+token)
+	assert.ErrorIs(t, err, ErrQueryTokenDenied)
+	assert.Equal(t, "query_token_denied", failureReason(err))
+
+	// deny, of access_token
+	ja = newJWTAuth("deny")
+	_, _, err = authenticate(ja, "access_token="+token)
+	assert.ErrorIs(t, err, ErrQueryTokenDenied)
+	_, _, err = authenticate(ja, "page=2")
+	assert.Nil(t, err, "missing token")
+}
+
+func TestValidate_QueryTokenPolicy(t *testing.T) {
+	ja := &JWTAuth{SignKey: TestSignKey, QueryTokenPolicy: "strip"}
+	assert.ErrorContains(t, ja.Validate(), `invalid query_token_policy: "strip"`)
+}
+
+func TestParsingCaddyfileQueryTokenPolicy(t *testing.T) {
+	h, err := parseCaddyfile(httpcaddyfile.Helper{Dispenser: caddyfile.NewTestDispenser(`jwtauth {
+		from_query token
+		query_token_policy redact
+	}`)})
+	assert.Nil(t, err)
+	var ja JWTAuth
+	assert.Nil(t, json.Unmarshal(h.(caddyauth.Authentication).ProvidersRaw["jwt"], &ja))
+	assert.Equal(t, "redact", ja.QueryTokenPolicy)
+
+	_, err = parseCaddyfile(httpcaddyfile.Helper{Dispenser: caddyfile.NewTestDispenser(`jwtauth {
+		query_token_policy
+	}`)})
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,200 @@
+package caddyjwt
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+)
+
+// The keys of FailureRateLimit.
+const (
+	rateLimitByClientIP = "client_ip"
+	rateLimitByToken    = "token"
+)
+
+// FailureRateLimit throttles the clients failing the authentication over
+// and over, e.g. brute-forcing or stuffing the tokens. A client failing
+// MaxFailures times within the sliding Window receives 429 Too Many
+// Requests, without its tokens verified, until the oldest of the failures
+// falls out of the window. The requests without any token don't count.
+type FailureRateLimit struct {
+	// MaxFailures is the number of the failures allowed within Window.
+	// Required.
+	MaxFailures int `json:"max_failures"`
+
+	// Window is the sliding window of the failures. Defaults to 1m.
+	Window caddy.Duration `json:"window,omitempty"`
+
+	// Key identifies the clients, either "client_ip", the IP of the client
+	// as determined by Caddy, or "token", the IP and the issuer of the
+	// token failed, for the users of the different IdPs behind a shared IP.
+	// The issuer counts only if trusted, i.e. on IssuerWhitelist or
+	// Issuers, so a run of tokens can't spread over the buckets by making
+	// up the issuers, nor by the tokens themselves, which it never repeats.
+	// Defaults to "client_ip".
+	Key string `json:"key,omitempty"`
+
+	// MaxClients bounds the number of the clients tracked. Defaults to
+	// 10000.
+	MaxClients int `json:"max_clients,omitempty"`
+
+	mu       sync.Mutex // makes updating the failures of a client atomic
+	failures *ttlCache  // key -> []time.Time, the failures within Window, oldest first
+}
+
+func (rl *FailureRateLimit) provision() error {
+	if rl.MaxFailures <= 0 {
+		return fmt.Errorf("invalid max_failures: %d", rl.MaxFailures)
+	}
+	if rl.Window < 0 {
+		return fmt.Errorf("invalid window: %s", time.Duration(rl.Window))
+	}
+	if rl.Window == 0 {
+		rl.Window = caddy.Duration(time.Minute)
+	}
+	switch rl.Key {
+	case "":
+		rl.Key = rateLimitByClientIP
+	case rateLimitByClientIP, rateLimitByToken:
+	default:
+		return fmt.Errorf("invalid key: %q", rl.Key)
+	}
+	if rl.MaxClients < 0 {
+		return fmt.Errorf("invalid max_clients: %d", rl.MaxClients)
+	}
+	rl.failures = newTTLCache(rl.MaxClients, 0)
+	return nil
+}
+
+// clientKey identifies the client of the request presenting a token of the
+// trusted issuer, empty if untrusted, see trustedIssuerOf.
+func (rl *FailureRateLimit) clientKey(r *http.Request, issuer string) string {
+	if rl.Key == rateLimitByClientIP {
+		return clientIP(r)
+	}
+	return clientIP(r) + "|" + issuer
+}
+
+// clientKeys returns the keys of the client of the request, one per issuer
+// of the candidate tokens, to check before verifying any.
+func (ja *JWTAuth) clientKeys(r *http.Request, candidates []candidateToken) []string {
+	rl := ja.FailureRateLimit
+	if rl.Key == rateLimitByClientIP {
+		return []string{rl.clientKey(r, "")}
+	}
+	var keys []string
+	seen := make(map[string]bool)
+	for _, candidate := range candidates {
+		if key := rl.clientKey(r, ja.trustedIssuerOf(candidate.value)); !seen[key] {
+			seen[key] = true
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+// trustedIssuerOf returns the canonical issuer of the token, without
+// verifying it, if on IssuerWhitelist or Issuers, or empty.
+func (ja *JWTAuth) trustedIssuerOf(token string) string {
+	issuer := peekIssuer(normToken(token))
+	if issuer == "" {
+		return ""
+	}
+	issuer = ja.canonicalIssuer(issuer)
+	if _, ok := ja.Issuers[issuer]; ok {
+		return issuer
+	}
+	for _, trusted := range ja.policy().IssuerWhitelist {
+		if issuer == trusted {
+			return issuer
+		}
+	}
+	return ""
+}
+
+// check returns ErrRateLimited if the client has failed MaxFailures times
+// within Window.
+func (rl *FailureRateLimit) check(key string, now time.Time) error {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+	failures := rl.recent(key, now)
+	if len(failures) < rl.MaxFailures {
+		return nil
+	}
+	return &rateLimitedError{retryAfter: failures[0].Add(time.Duration(rl.Window)).Sub(now)}
+}
+
+// record records a failure of the client.
+func (rl *FailureRateLimit) record(key string, now time.Time) {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+	failures := append(rl.recent(key, now), now)
+	if len(failures) > rl.MaxFailures {
+		failures = failures[len(failures)-rl.MaxFailures:]
+	}
+	rl.failures.Set(key, failures, time.Duration(rl.Window))
+}
+
+// recent returns a copy of the failures of the client within Window.
+func (rl *FailureRateLimit) recent(key string, now time.Time) []time.Time {
+	cached, ok := rl.failures.Get(key)
+	if !ok {
+		return nil
+	}
+	var failures []time.Time
+	for _, t := range cached.([]time.Time) {
+		if now.Sub(t) < time.Duration(rl.Window) {
+			failures = append(failures, t)
+		}
+	}
+	return failures
+}
+
+// countsAsFailure reports whether the failed authentication counts against
+// the client, i.e. it's of the tokens, rather than of the absence of any
+// token, or of the backends.
+func countsAsFailure(err error) bool {
+	switch {
+	case errors.Is(err, ErrMissingToken),
+		errors.Is(err, ErrRateLimited),
+		errors.Is(err, ErrMaintenance),
+		errors.Is(err, ErrRevocationUnavailable),
+		errors.Is(err, ErrReplayUnavailable),
+		errors.Is(err, ErrEnrichmentFailed),
+		errors.Is(err, ErrUserInfoFailed),
+		errors.Is(err, ErrIntrospectionFailed),
+		errors.Is(err, context.Canceled),
+		errors.Is(err, context.DeadlineExceeded):
+		return false
+	}
+	return true
+}
+
+// rateLimitedError is ErrRateLimited, telling when to retry.
+type rateLimitedError struct {
+	retryAfter time.Duration
+}
+
+func (e *rateLimitedError) Error() string {
+	return fmt.Sprintf("%v, retry after %s", ErrRateLimited, e.retryAfter.Round(time.Second))
+}
+
+func (e *rateLimitedError) Is(target error) bool {
+	return target == ErrRateLimited
+}
+
+// respondRateLimited writes the 429 response of the rate limited request.
+func respondRateLimited(rw http.ResponseWriter, r *http.Request, err error) {
+	var limited *rateLimitedError
+	if errors.As(err, &limited) {
+		seconds := int64((limited.retryAfter + time.Second - 1) / time.Second)
+		rw.Header().Set("Retry-After", strconv.FormatInt(seconds, 10))
+	}
+	writeFailure(rw, r, http.StatusTooManyRequests, nil)
+}
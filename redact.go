@@ -0,0 +1,37 @@
+package caddyjwt
+
+import (
+	"fmt"
+	"strings"
+
+	"go.uber.org/zap"
+)
+
+// The redaction modes, see JWTAuth.Redaction.
+const (
+	redactResponses = "responses"
+	redactStrict    = "strict"
+)
+
+func validateRedaction(redaction string) error {
+	switch redaction {
+	case "", redactResponses, redactStrict:
+		return nil
+	}
+	return fmt.Errorf("expect %s or %s", redactResponses, redactStrict)
+}
+
+// tokenField returns the log field of the desensitized token, skipped in
+// the strict redaction mode.
+func (ja *JWTAuth) tokenField(token string) zap.Field {
+	if ja.Redaction == redactStrict {
+		return zap.Skip()
+	}
+	return zap.String("token_string", desensitizedTokenString(token))
+}
+
+// genericFailureMessage describes the failure by its reason only, e.g.
+// "token expired", without any detail of the token, for the responses.
+func genericFailureMessage(err error) string {
+	return strings.ReplaceAll(failureReason(err), "_", " ")
+}
@@ -0,0 +1,59 @@
+package caddyjwt
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+func TestAuthenticate_Redaction(t *testing.T) {
+	core, logs := observer.New(zap.InfoLevel)
+	ja := &JWTAuth{
+		SignKey:         TestSignKey,
+		RequireEnv:      "prod",
+		FailureResponse: &FailureResponse{Body: "{{.Error}}"},
+		BearerChallenge: &BearerChallenge{},
+		logger:          zap.New(core),
+	}
+	assert.Nil(t, ja.Validate())
+
+	token := issueTokenString(MapClaims{"sub": "ggicci", "env": "staging"})
+	authenticate := func() *httptest.ResponseRecorder {
+		rw := httptest.NewRecorder()
+		r, _ := http.NewRequest("GET", "/", nil)
+		r.Header.Add("Authorization", token)
+		_, _, err := ja.Authenticate(rw, r)
+		assert.ErrorIs(t, err, ErrEnvMismatch)
+		return rw
+	}
+
+	// never reflected by default
+	rw := authenticate()
+	assert.Equal(t, "env mismatch", rw.Body.String())
+	assert.Contains(t, logs.TakeAll()[0].ContextMap(), "token_string")
+
+	ja.Redaction = redactResponses
+	rw = authenticate()
+	assert.Equal(t, "env mismatch", rw.Body.String())
+	assert.Contains(t, logs.TakeAll()[0].ContextMap(), "token_string")
+
+	ja.Redaction = redactStrict
+	rw = authenticate()
+	assert.Equal(t, "env mismatch", rw.Body.String())
+	for _, values := range rw.Header() {
+		for _, value := range values {
+			assert.NotContains(t, value, "staging")
+			assert.NotContains(t, value, token[:16])
+		}
+	}
+	for _, entry := range logs.TakeAll() {
+		assert.NotContains(t, entry.ContextMap(), "token_string")
+	}
+
+	ja = &JWTAuth{SignKey: TestSignKey, Redaction: "paranoid", logger: testLogger}
+	assert.ErrorContains(t, ja.Validate(), "invalid redaction")
+}
@@ -0,0 +1,286 @@
+package caddyjwt
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+	"go.uber.org/zap"
+	"golang.org/x/sync/singleflight"
+)
+
+// defaultMaxJWKTenants is the default of JWKURLTenants.MaxTenants.
+const defaultMaxJWKTenants = 100
+
+// jwkTenantFailureTTL is how long a JWKs URL failing to fetch is not tried
+// again, so the requests of a bogus tenant can't fetch over and over.
+const jwkTenantFailureTTL = 10 * time.Second
+
+// jwkTenantValue is what a placeholder of JWKURL may be replaced to per
+// request, so the values from the requests, e.g. of the headers, can't
+// point the URL to another host or path.
+var jwkTenantValue = regexp.MustCompile(`^[A-Za-z0-9_~-][A-Za-z0-9._~-]*$`)
+
+// replaceConfigPlaceholders replaces the global placeholders of the
+// configuration values, e.g. {env.JWT_SECRET}, at provision, so the secrets
+// needn't be inlined in the config. The other placeholders, e.g. of the
+// requests, are kept, for the fields resolved per request, see JWKURL.
+func (ja *JWTAuth) replaceConfigPlaceholders() {
+	repl := caddy.NewReplacer()
+	for _, field := range []*string{
+		&ja.SignKey, &ja.SignKeyFile, &ja.SignAlgorithm,
+		&ja.JWKURL, &ja.JWKFile, &ja.OIDCIssuer,
+		&ja.DecryptKey, &ja.DecryptKeyFile,
+		&ja.RequireEnv,
+	} {
+		*field = repl.ReplaceKnown(*field, "")
+	}
+	for _, list := range [][]string{ja.JWKFiles, ja.IssuerWhitelist, ja.AudienceWhitelist} {
+		for i := range list {
+			list[i] = repl.ReplaceKnown(list[i], "")
+		}
+	}
+}
+
+// dynamicJWKURL reports whether JWKURL has the placeholders of the requests
+// left, i.e. it's resolved per request.
+func (ja *JWTAuth) dynamicJWKURL() bool {
+	return strings.Contains(ja.JWKURL, "{")
+}
+
+// JWKURLTenants restricts and bounds the JWKs URLs resolved per request, see
+// JWKURL, e.g. of the tenants of a multi-tenant SaaS.
+type JWKURLTenants struct {
+	// Allow lists the values the placeholders of JWKURL may be replaced to,
+	// e.g. the tenant IDs, or the patterns of them, either globs, e.g.
+	// "acme-*", or regular expressions, starting with "^" or ending with
+	// "$". The requests of the other values are rejected without fetching.
+	// Required, as the requests would point the fetches elsewhere
+	// otherwise; "*" allows any value explicitly. Either way, the values
+	// must consist of letters, digits, ".", "_", "~" and "-" only.
+	Allow []string `json:"allow,omitempty"`
+
+	// MaxTenants bounds the number of the URLs whose JWKs are kept, beyond
+	// which the least recently used are dropped, and fetched again on their
+	// next requests. Defaults to 100.
+	MaxTenants int `json:"max_tenants,omitempty"`
+
+	allow []*regexp.Regexp
+}
+
+func (jt *JWKURLTenants) provision() error {
+	if jt.MaxTenants < 0 {
+		return fmt.Errorf("invalid max_tenants: %d", jt.MaxTenants)
+	}
+	if jt.MaxTenants == 0 {
+		jt.MaxTenants = defaultMaxJWKTenants
+	}
+	if len(jt.Allow) == 0 {
+		return fmt.Errorf("missing allow, e.g. the tenant IDs, or \"*\" for any")
+	}
+	jt.allow = nil
+	for _, pattern := range jt.Allow {
+		re, err := compileSubjectPattern(pattern)
+		if err != nil {
+			return fmt.Errorf("invalid allow %q: %w", pattern, err)
+		}
+		jt.allow = append(jt.allow, re)
+	}
+	return nil
+}
+
+// allowed reports whether the value of a placeholder is on Allow.
+func (jt *JWKURLTenants) allowed(value string) bool {
+	for _, re := range jt.allow {
+		if re.MatchString(value) {
+			return true
+		}
+	}
+	return false
+}
+
+// jwkTenants are the providers of the JWKs URLs resolved from JWKURL per
+// request, created on the first requests of the URLs, once per URL at a
+// time.
+type jwkTenants struct {
+	template    string
+	config      *JWKURLTenants
+	newProvider func(url string) (*JWTAuth, error)
+	group       singleflight.Group
+	failures    *ttlCache // URL -> error, of the URLs failed recently
+
+	mu      sync.Mutex
+	tenants map[string]*jwkTenant // by the resolved URL
+}
+type jwkTenant struct {
+	provider *JWTAuth
+	lastUsed time.Time
+}
+
+// setupJWKTenants sets up the providers of the JWKs URLs resolved from
+// JWKURL, which inherit the algorithms and the SharedJWKs.
+func (ja *JWTAuth) setupJWKTenants() error {
+	if scheme := strings.ToLower(ja.JWKURL); !strings.HasPrefix(scheme, "https://") && !strings.HasPrefix(scheme, "http://") {
+		return fmt.Errorf("invalid jwk_url: %q is not an HTTP(S) URL", ja.JWKURL)
+	}
+	config := ja.JWKURLTenants
+	if config == nil {
+		config = &JWKURLTenants{}
+	}
+	if err := config.provision(); err != nil {
+		return fmt.Errorf("invalid jwk_url_tenants: %w", err)
+	}
+	ja.jwkMu = new(sync.RWMutex)
+	ja.jwkURL = ja.JWKURL
+	ja.jwkTenants = &jwkTenants{
+		template: ja.JWKURL,
+		config:   config,
+		newProvider: func(url string) (*JWTAuth, error) {
+			p := &JWTAuth{
+				JWKURL:             url,
+				SignAlgorithm:      ja.SignAlgorithm,
+				AllowedAlgorithms:  ja.AllowedAlgorithms,
+				JWKRefreshInterval: ja.JWKRefreshInterval,
+				KeyNotFoundBackoff: ja.KeyNotFoundBackoff,
+				SharedJWKs:         ja.SharedJWKs,
+				storage:            ja.storage,
+				logger:             ja.logger.With(zap.String("jwk_url", url)),
+			}
+			if err := p.Validate(); err != nil {
+				return p, err
+			}
+			// not kept if unavailable, so the bogus URLs can't take up the slots
+			if ready, err := p.keysReady(); !ready {
+				return p, fmt.Errorf("JWKs unavailable from %q: %v", url, err)
+			}
+			return p, nil
+		},
+		failures: newTTLCache(config.MaxTenants, 0), // LRU, as the URLs are many under "*"
+		tenants:  make(map[string]*jwkTenant),
+	}
+	return nil
+}
+
+// resolve resolves the JWKs URL of the request.
+func (t *jwkTenants) resolve(ctx context.Context) (string, error) {
+	repl, ok := ctx.Value(caddy.ReplacerCtxKey).(*caddy.Replacer)
+	if !ok {
+		return "", fmt.Errorf("no replacer to resolve %q", t.template)
+	}
+	return repl.ReplaceFunc(t.template, func(variable string, val any) (any, error) {
+		s := stringify(val)
+		if !jwkTenantValue.MatchString(s) {
+			return nil, fmt.Errorf("invalid value %q of {%s} in %q", s, variable, t.template)
+		}
+		if !t.config.allowed(s) {
+			return nil, fmt.Errorf("value %q of {%s} not allowed in %q", s, variable, t.template)
+		}
+		return val, nil
+	})
+}
+
+// providerOf returns the provider of the JWKs URL of the request, created
+// if new, dropping the least recently used one if there are MaxTenants. The
+// concurrent first requests of a URL share one fetch, and a URL failing is
+// not fetched again for jwkTenantFailureTTL.
+func (t *jwkTenants) providerOf(ctx context.Context) (*JWTAuth, error) {
+	url, err := t.resolve(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if p := t.use(url); p != nil {
+		return p, nil
+	}
+	if failed, ok := t.failures.Get(url); ok {
+		return nil, failed.(error)
+	}
+
+	// fetched outside the lock, not to block the other URLs
+	v, err, _ := t.group.Do(url, func() (interface{}, error) {
+		if p := t.use(url); p != nil {
+			return p, nil // created just before
+		}
+		created, err := t.newProvider(url)
+		if err != nil {
+			created.Cleanup()
+			t.failures.Set(url, err, jwkTenantFailureTTL)
+			return nil, err
+		}
+		t.mu.Lock()
+		var dropped *JWTAuth
+		if len(t.tenants) >= t.config.MaxTenants {
+			dropped = t.dropLeastRecentlyUsed()
+		}
+		t.tenants[url] = &jwkTenant{provider: created, lastUsed: time.Now()}
+		t.mu.Unlock()
+		if dropped != nil {
+			dropped.Cleanup()
+		}
+		return created, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return v.(*JWTAuth), nil
+}
+
+// use returns the provider of the URL, if any, marking it used.
+func (t *jwkTenants) use(url string) *JWTAuth {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	tenant, ok := t.tenants[url]
+	if !ok {
+		return nil
+	}
+	tenant.lastUsed = time.Now()
+	return tenant.provider
+}
+
+// dropLeastRecentlyUsed removes the least recently used provider and
+// returns it, to clean up. The caller must hold the lock.
+func (t *jwkTenants) dropLeastRecentlyUsed() *JWTAuth {
+	var (
+		oldest string
+		lru    *jwkTenant
+	)
+	for url, tenant := range t.tenants {
+		if lru == nil || tenant.lastUsed.Before(lru.lastUsed) {
+			oldest, lru = url, tenant
+		}
+	}
+	if lru == nil {
+		return nil
+	}
+	delete(t.tenants, oldest)
+	return lru.provider
+}
+
+// refresh refreshes the JWKs of all the URLs resolved so far.
+func (t *jwkTenants) refresh() error {
+	for _, p := range t.snapshot() {
+		if err := p.refreshJWKCache(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (t *jwkTenants) cleanup() {
+	for _, p := range t.snapshot() {
+		p.Cleanup()
+	}
+}
+
+func (t *jwkTenants) snapshot() []*JWTAuth {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	providers := make([]*JWTAuth, 0, len(t.tenants))
+	for _, tenant := range t.tenants {
+		providers = append(providers, tenant.provider)
+	}
+	return providers
+}
@@ -0,0 +1,181 @@
+package caddyjwt
+
+import (
+	"errors"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidate_ConfigPlaceholders(t *testing.T) {
+	t.Setenv("JWT_TEST_SIGN_KEY", TestSignKey)
+	t.Setenv("JWT_TEST_ISSUER", "https://api.example.com")
+	ja := &JWTAuth{
+		SignKey:         "{env.JWT_TEST_SIGN_KEY}",
+		IssuerWhitelist: []string{"{env.JWT_TEST_ISSUER}"},
+		logger:          testLogger,
+	}
+	assert.Nil(t, ja.Validate())
+	assert.Equal(t, TestSignKey, ja.SignKey)
+	assert.Equal(t, []string{"https://api.example.com"}, ja.IssuerWhitelist)
+
+	r, _ := newRequestWithReplacer("GET", "/")
+	r.Header.Add("Authorization", issueTokenString(MapClaims{"sub": "ggicci", "iss": "https://api.example.com"}))
+	user, _, err := ja.Authenticate(nil, r)
+	assert.Nil(t, err)
+	assert.Equal(t, "ggicci", user.ID)
+
+	// unset
+	ja = &JWTAuth{SignKey: "{env.JWT_TEST_UNSET}", logger: testLogger}
+	assert.ErrorContains(t, ja.Validate(), "invalid sign_key")
+}
+
+func TestAuthenticate_JWKURLPerRequest(t *testing.T) {
+	ja := &JWTAuth{
+		JWKURL:        strings.TrimSuffix(TestJWKSetURL, "/keys") + "/{tenant}",
+		JWKURLTenants: &JWKURLTenants{Allow: []string{"*"}},
+		logger:        testLogger,
+	}
+	assert.Nil(t, ja.Validate())
+	defer ja.Cleanup()
+	ready, _ := ja.keysReady()
+	assert.True(t, ready)
+
+	authenticate := func(tenant string) (User, string, error) {
+		r, repl := newRequestWithReplacer("GET", "/")
+		repl.Set("tenant", tenant)
+		r.Header.Add("Authorization", issueTokenStringJWK(MapClaims{"sub": "ggicci"}))
+		user, _, err := ja.Authenticate(nil, r)
+		location, _ := repl.GetString("http.auth.jwt.key_location")
+		return user, location, err
+	}
+
+	user, location, err := authenticate("keys")
+	assert.Nil(t, err)
+	assert.Equal(t, "ggicci", user.ID)
+	assert.Equal(t, TestJWKSetURL, location)
+	assert.Len(t, ja.jwkTenants.snapshot(), 1)
+
+	_, _, err = authenticate("keys_inapplicable")
+	assert.ErrorIs(t, err, ErrKeyNotFound)
+	assert.Len(t, ja.jwkTenants.snapshot(), 2)
+
+	// not kept if unavailable
+	_, _, err = authenticate("missing")
+	assert.ErrorIs(t, err, ErrKeyNotFound)
+	assert.Len(t, ja.jwkTenants.snapshot(), 2)
+
+	for _, tenant := range []string{"", "..", "keys/../keys", "evil.example.com?", "a@b"} {
+		_, _, err = authenticate(tenant)
+		assert.ErrorIs(t, err, ErrKeyNotFound, tenant)
+	}
+	assert.Len(t, ja.jwkTenants.snapshot(), 2)
+	assert.Nil(t, ja.refreshJWKCache())
+}
+
+func TestAuthenticate_JWKURLTenants(t *testing.T) {
+	ja := &JWTAuth{
+		JWKURL:        strings.TrimSuffix(TestJWKSetURL, "/keys") + "/{tenant}",
+		JWKURLTenants: &JWKURLTenants{Allow: []string{"key", "keys*"}, MaxTenants: 2},
+		logger:        testLogger,
+	}
+	assert.Nil(t, ja.Validate())
+	defer ja.Cleanup()
+
+	authenticate := func(tenant string) error {
+		r, repl := newRequestWithReplacer("GET", "/")
+		repl.Set("tenant", tenant)
+		r.Header.Add("Authorization", issueTokenStringJWK(MapClaims{"sub": "ggicci"}))
+		_, _, err := ja.Authenticate(nil, r)
+		return err
+	}
+	tenantURLs := func() []string {
+		var urls []string
+		for _, p := range ja.jwkTenants.snapshot() {
+			urls = append(urls, p.JWKURL)
+		}
+		return urls
+	}
+	base := strings.TrimSuffix(TestJWKSetURL, "/keys")
+
+	assert.Nil(t, authenticate("keys"))
+	assert.Nil(t, authenticate("key"))
+	assert.Nil(t, authenticate("keys"))
+
+	// the least recently used, "key", is dropped
+	assert.ErrorIs(t, authenticate("keys_inapplicable"), ErrKeyNotFound)
+	assert.ElementsMatch(t, []string{base + "/keys", base + "/keys_inapplicable"}, tenantURLs())
+
+	// not allowed, never fetched
+	assert.ErrorIs(t, authenticate("other"), ErrKeyNotFound)
+	assert.ElementsMatch(t, []string{base + "/keys", base + "/keys_inapplicable"}, tenantURLs())
+}
+
+func TestJWKTenants_ProviderOf(t *testing.T) {
+	ja := &JWTAuth{
+		JWKURL:        strings.TrimSuffix(TestJWKSetURL, "/keys") + "/{tenant}",
+		JWKURLTenants: &JWKURLTenants{Allow: []string{"*"}},
+		logger:        testLogger,
+	}
+	assert.Nil(t, ja.Validate())
+	defer ja.Cleanup()
+
+	var fetches int32
+	release := make(chan struct{})
+	ja.jwkTenants.newProvider = func(url string) (*JWTAuth, error) {
+		atomic.AddInt32(&fetches, 1)
+		<-release
+		if strings.HasSuffix(url, "/missing") {
+			return &JWTAuth{}, errors.New("JWKs unavailable")
+		}
+		return &JWTAuth{JWKURL: url}, nil
+	}
+	providerOf := func(tenant string) (*JWTAuth, error) {
+		r, repl := newRequestWithReplacer("GET", "/")
+		repl.Set("tenant", tenant)
+		return ja.jwkTenants.providerOf(r.Context())
+	}
+
+	// the concurrent first requests share one fetch
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			p, err := providerOf("keys")
+			assert.Nil(t, err)
+			assert.NotNil(t, p)
+		}()
+	}
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	wg.Wait()
+	assert.EqualValues(t, 1, atomic.LoadInt32(&fetches))
+
+	// failures are cached for a while
+	_, err := providerOf("missing")
+	assert.ErrorContains(t, err, "JWKs unavailable")
+	_, err = providerOf("missing")
+	assert.ErrorContains(t, err, "JWKs unavailable")
+	assert.EqualValues(t, 2, atomic.LoadInt32(&fetches))
+
+	ja.jwkTenants.failures.now = func() time.Time { return time.Now().Add(jwkTenantFailureTTL) }
+	_, err = providerOf("missing")
+	assert.ErrorContains(t, err, "JWKs unavailable")
+	assert.EqualValues(t, 3, atomic.LoadInt32(&fetches))
+}
+
+func TestValidate_JWKURLTenants(t *testing.T) {
+	ja := &JWTAuth{JWKURL: TestJWKSetURL, JWKURLTenants: &JWKURLTenants{}}
+	assert.ErrorContains(t, ja.Validate(), "invalid jwk_url_tenants")
+	ja = &JWTAuth{JWKURL: "https://{tenant}.example.com/jwks"}
+	assert.ErrorContains(t, ja.Validate(), "missing allow")
+	ja = &JWTAuth{JWKURL: "https://{tenant}.example.com/jwks", JWKURLTenants: &JWKURLTenants{Allow: []string{"*"}, MaxTenants: -1}}
+	assert.ErrorContains(t, ja.Validate(), "invalid jwk_url_tenants")
+	ja = &JWTAuth{JWKURL: "https://{tenant}.example.com/jwks", JWKURLTenants: &JWKURLTenants{Allow: []string{"^(a$"}}}
+	assert.ErrorContains(t, ja.Validate(), "invalid jwk_url_tenants")
+}
@@ -0,0 +1,47 @@
+package caddyjwt
+
+import (
+	"net/http"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
+)
+
+// maxRequestIDLength bounds the length of a client supplied request ID.
+const maxRequestIDLength = 128
+
+// requestID returns the correlation ID of the request: the RequestIDHeader
+// if supplied and well-formed, otherwise Caddy's request UUID, i.e. the
+// {http.request.uuid} placeholder. It's empty outside a Caddy server.
+func (ja *JWTAuth) requestID(r *http.Request) string {
+	if id := r.Header.Get(ja.RequestIDHeader); isValidRequestID(id) {
+		return id
+	}
+	repl, ok := r.Context().Value(caddy.ReplacerCtxKey).(*caddy.Replacer)
+	if !ok || caddyhttp.GetVar(r.Context(), "uuid") == nil {
+		return ""
+	}
+	return repl.ReplaceAll("{http.request.uuid}", "")
+}
+
+// isValidRequestID tells whether a client supplied request ID is safe to
+// log and echo, i.e. a short string of visible ASCII characters.
+func isValidRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		if id[i] <= ' ' || id[i] > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+// setRequestIDPlaceholder populates the {http.auth.jwt.request_id}
+// placeholder, which error routes can put in the response body.
+func setRequestIDPlaceholder(r *http.Request, id string) {
+	if repl, ok := r.Context().Value(caddy.ReplacerCtxKey).(*caddy.Replacer); ok {
+		repl.Set("http.auth.jwt.request_id", id)
+	}
+}
@@ -0,0 +1,63 @@
+package caddyjwt
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+func TestAuthenticate_RequestID(t *testing.T) {
+	core, logs := observer.New(zap.InfoLevel)
+	ja := &JWTAuth{SignKey: TestSignKey, ExposeRequestID: true, logger: zap.New(core)}
+	assert.Nil(t, ja.Validate())
+	assert.Equal(t, "X-Request-Id", ja.RequestIDHeader)
+
+	// supplied by the client
+	rw := httptest.NewRecorder()
+	r, repl := newRequestWithReplacer("GET", "/")
+	r.Header.Set("X-Request-Id", "req-42")
+	r.Header.Add("Authorization", issueTokenString(MapClaims{"sub": "ggicci", "exp": 689702400}))
+	_, _, err := ja.Authenticate(rw, r)
+	assert.ErrorIs(t, err, ErrTokenExpired)
+	assert.Equal(t, "req-42", rw.Header().Get("X-Request-Id"))
+	id, _ := repl.Get("http.auth.jwt.request_id")
+	assert.Equal(t, "req-42", id)
+	assert.Equal(t, 1, logs.FilterField(zap.String("request_id", "req-42")).Len())
+
+	// not exposed on success
+	rw = httptest.NewRecorder()
+	r, _ = newRequestWithReplacer("GET", "/")
+	r.Header.Set("X-Request-Id", "req-43")
+	r.Header.Add("Authorization", issueTokenString(MapClaims{"sub": "ggicci"}))
+	_, _, err = ja.Authenticate(rw, r)
+	assert.Nil(t, err)
+	assert.Empty(t, rw.Header().Get("X-Request-Id"))
+	assert.Equal(t, 1, logs.FilterMessage("user authenticated").FilterField(zap.String("request_id", "req-43")).Len())
+
+	// malformed, falls back to Caddy's request UUID
+	rw = httptest.NewRecorder()
+	r, _ = http.NewRequest("GET", "/", nil)
+	r = r.WithContext(context.WithValue(r.Context(), caddyhttp.VarsCtxKey, map[string]interface{}{}))
+	repl = caddyhttp.NewTestReplacer(r)
+	r.Header.Set("X-Request-Id", "bad id\n"+strings.Repeat("x", 200))
+	r.Header.Add("Authorization", "invalid")
+	_, _, err = ja.Authenticate(rw, r)
+	assert.NotNil(t, err)
+	uuid, _ := repl.Get("http.request.uuid")
+	assert.NotEmpty(t, uuid)
+	assert.Equal(t, uuid, rw.Header().Get("X-Request-Id"))
+}
+
+func TestIsValidRequestID(t *testing.T) {
+	assert.True(t, isValidRequestID("0b5e4a1c-7f1e-4b8a-9d3c-2e1f0a9b8c7d"))
+	assert.False(t, isValidRequestID(""))
+	assert.False(t, isValidRequestID("with space"))
+	assert.False(t, isValidRequestID(strings.Repeat("x", maxRequestIDLength+1)))
+}
@@ -0,0 +1,43 @@
+package caddyjwt
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/lestrrat-go/jwx/v2/jwa"
+	"github.com/lestrrat-go/jwx/v2/jwk"
+	"github.com/lestrrat-go/jwx/v2/jws"
+)
+
+// KeyResolverFunc resolves the key to verify a token by the "kid" and "alg"
+// headers of the token, e.g. looking it up in a database or a hardware
+// module. It's for the programs embedding the module, see
+// JWTAuth.KeyResolver. The key is a raw key, e.g. []byte or
+// *rsa.PublicKey, or a jwk.Key, whose "alg", if any, overrides the one of
+// the token. A nil key, with a nil error, defers to the configured keys, an
+// error rejects the token.
+type KeyResolverFunc func(ctx context.Context, kid, alg string) (interface{}, error)
+
+// resolveKey provides the key resolved by KeyResolver, if any. It returns
+// false if the configured keys apply.
+func (ja *JWTAuth) resolveKey(ctx context.Context, kp *keyProvenance, sink jws.KeySink, sig *jws.Signature) (bool, error) {
+	if ja.KeyResolver == nil {
+		return false, nil
+	}
+	alg := sig.ProtectedHeaders().Algorithm()
+	key, err := ja.KeyResolver(ctx, kp.KeyID, alg.String())
+	if err != nil {
+		return false, fmt.Errorf("%w: resolving key %q: %v", ErrKeyNotFound, kp.KeyID, err)
+	}
+	if key == nil {
+		if !ja.hasDefaultKeys() && len(ja.Issuers) == 0 {
+			return false, fmt.Errorf("%w: key %q not resolved", ErrKeyNotFound, kp.KeyID)
+		}
+		return false, nil
+	}
+	if jwkKey, ok := key.(jwk.Key); ok && jwkKey.Algorithm().String() != "" {
+		alg = jwa.SignatureAlgorithm(jwkKey.Algorithm().String())
+	}
+	kp.Source, kp.Location = "key_resolver", ""
+	return true, ja.sinkKey(sink, alg, key)
+}
@@ -0,0 +1,56 @@
+package caddyjwt
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAuthenticate_KeyResolver(t *testing.T) {
+	resolver := func(ctx context.Context, kid, alg string) (interface{}, error) {
+		switch {
+		case kid == "broken":
+			return nil, errors.New("database unavailable")
+		case alg == "HS256":
+			return RawTestSignKey, nil
+		}
+		return nil, nil
+	}
+	authenticate := func(ja *JWTAuth, token string) (*authResult, error) {
+		r, _ := http.NewRequest("GET", "/", nil)
+		r.Header.Add("Authorization", token)
+		return ja.authenticate(r)
+	}
+
+	// no configured keys
+	ja := &JWTAuth{KeyResolver: resolver, logger: testLogger}
+	assert.Nil(t, ja.Validate())
+	result, err := authenticate(ja, issueTokenString(MapClaims{"sub": "ggicci"}))
+	assert.Nil(t, err)
+	assert.Equal(t, "key_resolver", result.provenance.Source)
+	_, err = authenticate(ja, issueTokenStringJWK(MapClaims{"sub": "ggicci"}))
+	assert.ErrorIs(t, err, ErrKeyNotFound)
+
+	// deferring to the configured keys
+	ja = &JWTAuth{KeyResolver: resolver, JWKURL: TestJWKSetURL, logger: testLogger}
+	assert.Nil(t, ja.Validate())
+	defer ja.Cleanup()
+	result, err = authenticate(ja, issueTokenStringJWK(MapClaims{"sub": "ggicci"}))
+	assert.Nil(t, err)
+	assert.Equal(t, "jwk_url", result.provenance.Source)
+
+	// failing
+	ja = &JWTAuth{
+		KeyResolver: func(ctx context.Context, kid, alg string) (interface{}, error) {
+			return resolver(ctx, "broken", alg)
+		},
+		SignKey: TestSignKey,
+		logger:  testLogger,
+	}
+	assert.Nil(t, ja.Validate())
+	_, err = authenticate(ja, issueTokenString(MapClaims{"sub": "ggicci"}))
+	assert.ErrorIs(t, err, ErrKeyNotFound)
+}
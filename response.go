@@ -0,0 +1,89 @@
+package caddyjwt
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
+)
+
+// flashReasonTokenExpired is the value of the flash cookie when the session
+// token has expired.
+const flashReasonTokenExpired = "token_expired"
+
+// handledByErrorRoutes reports whether the server of the request has error
+// routes (handle_errors). They handle the 401 caddyauth returns once the
+// providers reject a request, so a response written by a provider would be
+// written over, or followed by a superfluous WriteHeader.
+func handledByErrorRoutes(r *http.Request) bool {
+	srv, ok := r.Context().Value(caddyhttp.ServerCtxKey).(*caddyhttp.Server)
+	return ok && srv.Errors != nil && len(srv.Errors.Routes) > 0
+}
+
+// writeFailure writes the status and the body of the response to a request
+// failing the authentication, of the headers set already, and sets the
+// {http.auth.jwt.status} placeholder to the status. If the server has error
+// routes, see handledByErrorRoutes, it leaves the response to them instead,
+// of the headers set but Content-Type, so they render it by the
+// placeholders, e.g.
+//
+//	handle_errors {
+//	    respond "{http.auth.jwt.error}" {http.auth.jwt.status}
+//	}
+func writeFailure(rw http.ResponseWriter, r *http.Request, status int, body []byte) {
+	if repl, ok := r.Context().Value(caddy.ReplacerCtxKey).(*caddy.Replacer); ok {
+		repl.Set("http.auth.jwt.status", status)
+	}
+	if handledByErrorRoutes(r) {
+		rw.Header().Del("Content-Type")
+		return
+	}
+	rw.WriteHeader(status)
+	if len(body) > 0 {
+		_, _ = rw.Write(body)
+	}
+}
+
+// redirectExpiredSession responds with a 302 to ExpiredRedirect, and sets a
+// short-lived flash cookie describing the reason.
+func (ja *JWTAuth) redirectExpiredSession(rw http.ResponseWriter, r *http.Request) {
+	http.SetCookie(rw, &http.Cookie{
+		Name:     ja.ExpiredFlashCookie,
+		Value:    flashReasonTokenExpired,
+		Path:     "/",
+		MaxAge:   60,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+	if handledByErrorRoutes(r) {
+		rw.Header().Set("Location", ja.ExpiredRedirect)
+		writeFailure(rw, r, http.StatusFound, nil)
+		return
+	}
+	http.Redirect(rw, r, ja.ExpiredRedirect, http.StatusFound)
+}
+
+// warnExpiring adds the ExpiringHeader to the response if the token will
+// expire within ExpiringWindow.
+func (ja *JWTAuth) warnExpiring(rw http.ResponseWriter, token Token) {
+	if ja.ExpiringWindow <= 0 || token.Expiration().IsZero() {
+		return
+	}
+	remaining := time.Until(token.Expiration())
+	if remaining < 0 || remaining > time.Duration(ja.ExpiringWindow) {
+		return
+	}
+	rw.Header().Set(ja.ExpiringHeader, strconv.FormatInt(int64(remaining/time.Second), 10))
+}
+
+// preventCachingQueryToken adds the no-cache headers to the response if the
+// token was accepted from the query and QueryTokenNoStore is set.
+func (ja *JWTAuth) preventCachingQueryToken(rw http.ResponseWriter, result *authResult) {
+	if !ja.QueryTokenNoStore || result.candidate.source != sourceQuery {
+		return
+	}
+	rw.Header().Add("Cache-Control", "no-store")
+	rw.Header().Set("Pragma", "no-cache")
+}
@@ -0,0 +1,218 @@
+package caddyjwt
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+)
+
+func init() {
+	caddy.RegisterModule(new(MemoryBlocklist))
+}
+
+// RevocationChecker tells whether a token has been revoked by its "jti"
+// claim. External modules can supply the backends, e.g. Redis or SQL, by
+// registering a Caddy module in the namespace
+// "http.authentication.providers.jwt.revocation" implementing it.
+type RevocationChecker interface {
+	IsRevoked(ctx context.Context, jti string) (bool, error)
+}
+
+// Revocation rejects the revoked tokens, even if their signatures and claims
+// are valid, by checking their "jti" claims against a blocklist.
+type Revocation struct {
+	// CheckerRaw is the backend of the blocklist. Defaults to an empty
+	// "memory" blocklist.
+	CheckerRaw json.RawMessage `json:"checker,omitempty" caddy:"namespace=http.authentication.providers.jwt.revocation inline_key=backend"`
+
+	// RequireJTI rejects the tokens without a "jti" claim, which can't be
+	// revoked. By default, they are accepted.
+	RequireJTI bool `json:"require_jti"`
+
+	// FailOpen accepts the tokens when the backend fails to tell whether
+	// they have been revoked. By default, they are rejected.
+	FailOpen bool `json:"fail_open"`
+
+	checker RevocationChecker
+}
+
+func (rv *Revocation) provision(ctx caddy.Context) error {
+	if rv.CheckerRaw == nil {
+		rv.CheckerRaw = json.RawMessage(`{"backend": "memory"}`)
+	}
+	mod, err := loadInlineModule(ctx, "http.authentication.providers.jwt.revocation", "backend", rv.CheckerRaw)
+	if err != nil {
+		return fmt.Errorf("loading backend: %w", err)
+	}
+	checker, ok := mod.(RevocationChecker)
+	if !ok {
+		return fmt.Errorf("backend %T is not a RevocationChecker", mod)
+	}
+	rv.checker = checker
+	return nil
+}
+
+// loadInlineModule loads the module whose name is given inline by the key
+// of the raw config. It works around ctx.LoadModule, which doesn't recognize
+// json.RawMessage fields on the toolchains where json.RawMessage is an alias
+// of jsontext.Value.
+func loadInlineModule(ctx caddy.Context, namespace, key string, raw json.RawMessage) (interface{}, error) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(raw, &fields); err != nil {
+		return nil, err
+	}
+	var name string
+	if err := json.Unmarshal(fields[key], &name); err != nil || name == "" {
+		return nil, fmt.Errorf("missing or invalid %q", key)
+	}
+	delete(fields, key)
+	raw, err := json.Marshal(fields)
+	if err != nil {
+		return nil, err
+	}
+	return ctx.LoadModuleByID(namespace+"."+name, raw)
+}
+
+// check verifies the token has not been revoked.
+func (rv *Revocation) check(ctx context.Context, token Token) error {
+	jti := token.JwtID()
+	if jti == "" {
+		if rv.RequireJTI {
+			return fmt.Errorf("%w: missing jti", ErrInvalidToken)
+		}
+		return nil
+	}
+	revoked, err := rv.checker.IsRevoked(ctx, jti)
+	if err != nil {
+		if rv.FailOpen {
+			return nil
+		}
+		return fmt.Errorf("%w: jti %q: %v", ErrRevocationUnavailable, jti, err)
+	}
+	if revoked {
+		return fmt.Errorf("%w: jti %q", ErrRevoked, jti)
+	}
+	return nil
+}
+
+// MemoryBlocklist is an in-memory RevocationChecker. More entries can be
+// added while running by Revoke, e.g. via the admin API
+// `POST /jwtauth/revocations`. The entries are lost on config reload.
+type MemoryBlocklist struct {
+	// JTIs is the initial list of the revoked "jti" claims.
+	JTIs []string `json:"jtis"`
+
+	mu      sync.RWMutex
+	revoked map[string]time.Time // jti -> until, zero for ever
+}
+
+// CaddyModule implements caddy.Module interface.
+func (*MemoryBlocklist) CaddyModule() caddy.ModuleInfo {
+	return caddy.ModuleInfo{
+		ID:  "http.authentication.providers.jwt.revocation.memory",
+		New: func() caddy.Module { return new(MemoryBlocklist) },
+	}
+}
+
+// Provision implements caddy.Provisioner interface.
+func (mb *MemoryBlocklist) Provision(caddy.Context) error {
+	mb.revoked = make(map[string]time.Time, len(mb.JTIs))
+	for _, jti := range mb.JTIs {
+		mb.revoked[jti] = time.Time{}
+	}
+	registerMemoryBlocklist(mb)
+	return nil
+}
+
+// Cleanup implements caddy.CleanerUpper interface.
+func (mb *MemoryBlocklist) Cleanup() error {
+	unregisterMemoryBlocklist(mb)
+	return nil
+}
+
+// IsRevoked implements RevocationChecker interface.
+func (mb *MemoryBlocklist) IsRevoked(_ context.Context, jti string) (bool, error) {
+	mb.mu.RLock()
+	until, ok := mb.revoked[jti]
+	mb.mu.RUnlock()
+	return ok && (until.IsZero() || time.Now().Before(until)), nil
+}
+
+// Revoke adds the jti to the blocklist until the given time, usually the
+// expiry of the token, or for ever if zero.
+func (mb *MemoryBlocklist) Revoke(jti string, until time.Time) {
+	mb.mu.Lock()
+	defer mb.mu.Unlock()
+	now := time.Now()
+	for id, t := range mb.revoked {
+		if !t.IsZero() && now.After(t) {
+			delete(mb.revoked, id)
+		}
+	}
+	mb.revoked[jti] = until
+}
+
+// UnmarshalCaddyfile implements caddyfile.Unmarshaler interface. Syntax:
+//
+//	backend memory {
+//	    jti <jti...>
+//	}
+func (mb *MemoryBlocklist) UnmarshalCaddyfile(d *caddyfile.Dispenser) error {
+	for d.Next() {
+		if d.NextArg() {
+			return d.ArgErr()
+		}
+		for d.NextBlock(0) {
+			switch d.Val() {
+			case "jti":
+				mb.JTIs = append(mb.JTIs, d.RemainingArgs()...)
+			default:
+				return d.Errf("unrecognized memory option: %s", d.Val())
+			}
+		}
+	}
+	return nil
+}
+
+// memoryBlocklists are the in-memory blocklists of all the JWT providers in
+// this process, which can be appended via the admin API.
+var memoryBlocklists = struct {
+	mu    sync.Mutex
+	lists map[*MemoryBlocklist]struct{}
+}{lists: make(map[*MemoryBlocklist]struct{})}
+
+func registerMemoryBlocklist(mb *MemoryBlocklist) {
+	memoryBlocklists.mu.Lock()
+	defer memoryBlocklists.mu.Unlock()
+	memoryBlocklists.lists[mb] = struct{}{}
+}
+
+func unregisterMemoryBlocklist(mb *MemoryBlocklist) {
+	memoryBlocklists.mu.Lock()
+	defer memoryBlocklists.mu.Unlock()
+	delete(memoryBlocklists.lists, mb)
+}
+
+// revokeInMemoryBlocklists adds the jti to all the in-memory blocklists, and
+// returns their number.
+func revokeInMemoryBlocklists(jti string, until time.Time) int {
+	memoryBlocklists.mu.Lock()
+	defer memoryBlocklists.mu.Unlock()
+	for mb := range memoryBlocklists.lists {
+		mb.Revoke(jti, until)
+	}
+	return len(memoryBlocklists.lists)
+}
+
+// Interface guards
+var (
+	_ RevocationChecker     = (*MemoryBlocklist)(nil)
+	_ caddy.Provisioner     = (*MemoryBlocklist)(nil)
+	_ caddy.CleanerUpper    = (*MemoryBlocklist)(nil)
+	_ caddyfile.Unmarshaler = (*MemoryBlocklist)(nil)
+)
@@ -0,0 +1,117 @@
+package caddyjwt
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"github.com/caddyserver/caddy/v2/caddyconfig/httpcaddyfile"
+	"github.com/caddyserver/caddy/v2/modules/caddyhttp/caddyauth"
+	"github.com/stretchr/testify/assert"
+)
+
+type failingChecker struct{}
+
+func (failingChecker) IsRevoked(context.Context, string) (bool, error) {
+	return false, errors.New("connection refused")
+}
+
+func TestAuthenticate_Revocation(t *testing.T) {
+	ctx, cancel := caddy.NewContext(caddy.Context{Context: context.Background()})
+	defer cancel()
+	ja := &JWTAuth{
+		SignKey: TestSignKey,
+		Revocation: &Revocation{
+			CheckerRaw: caddyconfig.JSONModuleObject(&MemoryBlocklist{JTIs: []string{"revoked"}}, "backend", "memory", nil),
+		},
+	}
+	assert.Nil(t, ja.Provision(ctx))
+	assert.Nil(t, ja.Validate())
+
+	authenticate := func(claims MapClaims) error {
+		r, _ := http.NewRequest("GET", "/", nil)
+		r.Header.Add("Authorization", issueTokenString(claims))
+		_, _, err := ja.Authenticate(httptest.NewRecorder(), r)
+		return err
+	}
+
+	assert.Nil(t, authenticate(MapClaims{"sub": "ggicci"}), "no jti")
+	assert.Nil(t, authenticate(MapClaims{"sub": "ggicci", "jti": "fresh"}))
+	assert.ErrorIs(t, authenticate(MapClaims{"sub": "ggicci", "jti": "revoked"}), ErrRevoked)
+
+	// revoked at runtime via the admin API
+	r, _ := http.NewRequest("POST", "/jwtauth/revocations?jti=fresh", nil)
+	assert.Nil(t, adminAPI{}.handleRevoke(httptest.NewRecorder(), r))
+	assert.ErrorIs(t, authenticate(MapClaims{"sub": "ggicci", "jti": "fresh"}), ErrRevoked)
+
+	// entries expire
+	r, _ = http.NewRequest("POST", "/jwtauth/revocations?jti=expired&exp=689702400", nil)
+	assert.Nil(t, adminAPI{}.handleRevoke(httptest.NewRecorder(), r))
+	assert.Nil(t, authenticate(MapClaims{"sub": "ggicci", "jti": "expired"}))
+
+	ja.Revocation.RequireJTI = true
+	assert.ErrorIs(t, authenticate(MapClaims{"sub": "ggicci"}), ErrInvalidToken)
+
+	ja.Revocation.checker = failingChecker{}
+	assert.ErrorIs(t, authenticate(MapClaims{"sub": "ggicci", "jti": "fresh"}), ErrRevocationUnavailable)
+	ja.Revocation.FailOpen = true
+	assert.Nil(t, authenticate(MapClaims{"sub": "ggicci", "jti": "fresh"}))
+}
+
+func TestMemoryBlocklist_Revoke(t *testing.T) {
+	mb := &MemoryBlocklist{}
+	assert.Nil(t, mb.Provision(caddy.Context{}))
+	defer mb.Cleanup()
+
+	mb.Revoke("a", time.Now().Add(-time.Second))
+	mb.Revoke("b", time.Time{})
+	revoked, _ := mb.IsRevoked(context.Background(), "a")
+	assert.False(t, revoked)
+	revoked, _ = mb.IsRevoked(context.Background(), "b")
+	assert.True(t, revoked)
+
+	mb.Revoke("c", time.Now().Add(time.Minute))
+	assert.NotContains(t, mb.revoked, "a", "purged on revoke")
+}
+
+func TestParsingCaddyfileRevocation(t *testing.T) {
+	helper := httpcaddyfile.Helper{
+		Dispenser: caddyfile.NewTestDispenser(`
+	jwtauth {
+		sign_key "TkZMNSowQmMjOVU2RUB0bm1DJkU3U1VONkd3SGZMbVk="
+		revocation {
+			backend memory {
+				jti a b
+			}
+			require_jti
+		}
+	}
+	`),
+	}
+	h, err := parseCaddyfile(helper)
+	assert.Nil(t, err)
+	var ja JWTAuth
+	assert.Nil(t, json.Unmarshal(h.(caddyauth.Authentication).ProvidersRaw["jwt"], &ja))
+	assert.True(t, ja.Revocation.RequireJTI)
+	assert.False(t, ja.Revocation.FailOpen)
+	assert.JSONEq(t, `{"backend": "memory", "jtis": ["a", "b"]}`, string(ja.Revocation.CheckerRaw))
+
+	helper = httpcaddyfile.Helper{
+		Dispenser: caddyfile.NewTestDispenser(`
+	jwtauth {
+		revocation {
+			backend unknown
+		}
+	}
+	`),
+	}
+	_, err = parseCaddyfile(helper)
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,27 @@
+package caddyjwt
+
+import (
+	"fmt"
+	"strings"
+)
+
+// accessTokenClaims are the claims required of the access tokens, see RFC
+// 9068 2.2.
+var accessTokenClaims = []string{"iss", "exp", "aud", "sub", "client_id", "iat", "jti"}
+
+// checkAccessTokenProfile enforces the JWT profile of the access tokens (RFC
+// 9068) on the verified token, see StrictRFC9068. The "typ" header must be
+// "at+jwt", which tells the access tokens from the ID tokens of the same
+// issuer, typed "JWT" or not at all.
+func checkAccessTokenProfile(signedToken string, token Token) error {
+	typ := headerType(signedToken)
+	if !strings.EqualFold(typ, "at+jwt") && !strings.EqualFold(typ, "application/at+jwt") {
+		return fmt.Errorf("%w: typ %q, expect \"at+jwt\"", ErrNotAccessToken, typ)
+	}
+	for _, claim := range accessTokenClaims {
+		if _, ok := token.Get(claim); !ok {
+			return fmt.Errorf("%w: missing claim %q", ErrNotAccessToken, claim)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,63 @@
+package caddyjwt
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/lestrrat-go/jwx/v2/jwa"
+	"github.com/lestrrat-go/jwx/v2/jws"
+	"github.com/lestrrat-go/jwx/v2/jwt"
+	"github.com/stretchr/testify/assert"
+)
+
+// issueTokenStringTyped issues a HS256 token of the "typ" header.
+func issueTokenStringTyped(typ string, claims MapClaims) string {
+	headers := jws.NewHeaders()
+	headers.Set(jws.TypeKey, typ)
+	tokenBytes, err := jwt.Sign(buildToken(claims), jwt.WithKey(jwa.HS256, RawTestSignKey, jws.WithProtectedHeaders(headers)))
+	panicOnError(err)
+	return string(tokenBytes)
+}
+
+func TestAuthenticate_StrictRFC9068(t *testing.T) {
+	ja := &JWTAuth{SignKey: TestSignKey, StrictRFC9068: true, logger: testLogger}
+	assert.Nil(t, ja.Validate())
+
+	authenticate := func(token string) error {
+		r, _ := http.NewRequest("GET", "/", nil)
+		r.Header.Add("Authorization", token)
+		_, _, err := ja.Authenticate(httptest.NewRecorder(), r)
+		return err
+	}
+	claims := func(without string) MapClaims {
+		claims := MapClaims{
+			"iss":       "https://auth.example.com",
+			"exp":       time.Now().Add(time.Hour).Unix(),
+			"aud":       "https://api.example.com",
+			"sub":       "ggicci",
+			"client_id": "app",
+			"iat":       time.Now().Unix(),
+			"jti":       "abc",
+		}
+		delete(claims, without)
+		return claims
+	}
+
+	assert.Nil(t, authenticate(issueTokenStringTyped("at+jwt", claims(""))))
+	assert.Nil(t, authenticate(issueTokenStringTyped("application/AT+JWT", claims(""))))
+
+	// ID tokens
+	err := authenticate(issueTokenStringTyped("JWT", claims("")))
+	assert.ErrorIs(t, err, ErrNotAccessToken)
+	assert.Equal(t, "not_access_token", failureReason(err))
+	assert.ErrorIs(t, authenticate(issueTokenString(claims(""))), ErrNotAccessToken)
+
+	for _, claim := range accessTokenClaims {
+		assert.ErrorIs(t, authenticate(issueTokenStringTyped("at+jwt", claims(claim))), ErrNotAccessToken, claim)
+	}
+
+	ja.StrictRFC9068 = false
+	assert.Nil(t, authenticate(issueTokenStringTyped("JWT", claims("client_id"))))
+}
@@ -0,0 +1,141 @@
+package caddyjwt
+
+import (
+	"fmt"
+	"strings"
+)
+
+// rolesClaimPresets are the claims of the roles by the layouts of the
+// common IdPs, see RolesClaim.
+var rolesClaimPresets = map[string]string{
+	"azure":    "roles",
+	"keycloak": "realm_access.roles",
+	"cognito":  "cognito:groups",
+}
+
+// rolesClaimOf resolves RolesClaim, a preset or a claim, to the claim.
+func rolesClaimOf(rolesClaim string) string {
+	if claim, ok := rolesClaimPresets[rolesClaim]; ok {
+		return claim
+	}
+	return rolesClaim
+}
+
+// tokenRoles returns the roles granted by the token per the claim, either an
+// array or a space-delimited string.
+func tokenRoles(token Token, claim string) []string {
+	val, ok := getClaim(token, claim)
+	if !ok {
+		return nil
+	}
+	switch v := val.(type) {
+	case string:
+		return strings.Fields(v)
+	case []string:
+		return v
+	case []interface{}:
+		roles := make([]string, 0, len(v))
+		for _, role := range v {
+			roles = append(roles, stringify(role))
+		}
+		return roles
+	}
+	return nil
+}
+
+// checkRole verifies the roles of the token against RequireRole, any of
+// which suffices.
+func (ja *JWTAuth) checkRole(roles []string) error {
+	if len(ja.RequireRole) == 0 {
+		return nil
+	}
+	for _, role := range roles {
+		for _, required := range ja.RequireRole {
+			if role == required {
+				return nil
+			}
+		}
+	}
+	return fmt.Errorf("%w: requires any of roles %q", ErrClaimPolicy, ja.RequireRole)
+}
+
+// setRolesMetadata flattens the roles into the "roles" metadata of the
+// user, i.e. the {http.auth.user.roles} placeholder, joined by commas.
+func setRolesMetadata(user *User, roles []string) {
+	if user.Metadata == nil {
+		user.Metadata = make(map[string]string)
+	}
+	user.Metadata["roles"] = strings.Join(roles, ",")
+}
+
+// GroupMapping maps the groups of the tokens to the roles, e.g. the group
+// object IDs of Azure AD to the role names, so the upstreams authorize by
+// the roles rather than the identifiers of an IdP. The mapped roles join
+// the roles of RolesClaim, i.e. {http.auth.user.roles} and RequireRole.
+type GroupMapping struct {
+	// Claim is the claim (nested ones by dots) of the groups, either an
+	// array or a space-delimited string. Defaults to "groups".
+	Claim string `json:"claim,omitempty"`
+
+	// Groups maps each group to its roles. Several groups can map to the
+	// same role.
+	Groups map[string][]string `json:"groups"`
+
+	// KeepUnmapped, if true, passes the groups not in Groups through as
+	// roles as they are. They are dropped by default.
+	KeepUnmapped bool `json:"keep_unmapped,omitempty"`
+}
+
+func (gm *GroupMapping) provision() error {
+	if gm.Claim == "" {
+		gm.Claim = "groups"
+	}
+	if len(gm.Groups) == 0 {
+		return fmt.Errorf("missing groups")
+	}
+	for group, roles := range gm.Groups {
+		if group == "" || len(roles) == 0 {
+			return fmt.Errorf("%q -> %q", group, roles)
+		}
+		for _, role := range roles {
+			if role == "" || strings.ContainsAny(role, ", ") {
+				return fmt.Errorf("%q -> invalid role %q", group, role)
+			}
+		}
+	}
+	return nil
+}
+
+// roles returns the roles of the groups of the token.
+func (gm *GroupMapping) roles(token Token) []string {
+	var roles []string
+	for _, group := range tokenRoles(token, gm.Claim) {
+		if mapped, ok := gm.Groups[group]; ok {
+			roles = append(roles, mapped...)
+		} else if gm.KeepUnmapped {
+			roles = append(roles, group)
+		}
+	}
+	return roles
+}
+
+// grantedRoles returns the roles of the token, of RolesClaim and MapGroups,
+// deduplicated in order.
+func (ja *JWTAuth) grantedRoles(token Token) []string {
+	var roles []string
+	if ja.rolesClaim != "" {
+		roles = tokenRoles(token, ja.rolesClaim)
+	}
+	if ja.MapGroups == nil {
+		return roles
+	}
+	seen := make(map[string]bool, len(roles))
+	granted := make([]string, 0, len(roles))
+	for _, role := range append(roles, ja.MapGroups.roles(token)...) {
+		if !seen[role] {
+			seen[role] = true
+			granted = append(granted, role)
+		}
+	}
+	return granted
+}
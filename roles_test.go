@@ -0,0 +1,146 @@
+package caddyjwt
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"github.com/caddyserver/caddy/v2/caddyconfig/httpcaddyfile"
+	"github.com/caddyserver/caddy/v2/modules/caddyhttp/caddyauth"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAuthenticate_Roles(t *testing.T) {
+	var testCases = []struct {
+		RolesClaim    string
+		RequireRole   []string
+		Claims        MapClaims
+		Authenticated bool
+		Roles         string
+	}{
+		{"azure", nil, MapClaims{"sub": "ggicci", "roles": []string{"Task.Read", "Task.Write"}}, true, "Task.Read,Task.Write"},
+		{"keycloak", []string{"admin"}, MapClaims{"sub": "ggicci", "realm_access": map[string]interface{}{"roles": []string{"user", "admin"}}}, true, "user,admin"},
+		{"keycloak", []string{"admin"}, MapClaims{"sub": "ggicci", "realm_access": map[string]interface{}{"roles": []string{"user"}}}, false, ""},
+		{"cognito", []string{"editors", "admins"}, MapClaims{"sub": "ggicci", "cognito:groups": []string{"admins"}}, true, "admins"},
+		{"groups", nil, MapClaims{"sub": "ggicci", "groups": "dev ops"}, true, "dev,ops"},
+		{"", []string{"admin"}, MapClaims{"sub": "ggicci", "roles": "admin"}, true, "admin"}, // defaults to "roles"
+		{"", []string{"admin"}, MapClaims{"sub": "ggicci"}, false, ""},
+	}
+
+	for _, c := range testCases {
+		ja := &JWTAuth{SignKey: TestSignKey, RolesClaim: c.RolesClaim, RequireRole: c.RequireRole, logger: testLogger}
+		assert.Nil(t, ja.Validate())
+
+		r, _ := http.NewRequest("GET", "/", nil)
+		r.Header.Add("Authorization", issueTokenString(c.Claims))
+		user, authenticated, err := ja.Authenticate(httptest.NewRecorder(), r)
+		assert.Equal(t, c.Authenticated, authenticated, c.Claims)
+		if c.Authenticated {
+			assert.Equal(t, c.Roles, user.Metadata["roles"])
+		} else {
+			assert.ErrorIs(t, err, ErrClaimPolicy)
+		}
+	}
+}
+
+func TestAuthenticate_MapGroups(t *testing.T) {
+	const adminsGroup = "S-1-5-21-1004336348-1177238915-682003330-512"
+	ja := &JWTAuth{
+		SignKey: TestSignKey,
+		MapGroups: &GroupMapping{Groups: map[string][]string{
+			adminsGroup:                            {"admins"},
+			"6e74172b-be56-4843-9ff4-e66a39bb12e3": {"editors", "viewers"},
+			"9a5e2c1d-0000-4000-8000-000000000001": {"viewers"},
+		}},
+		RequireRole: []string{"admins", "viewers"},
+		logger:      testLogger,
+	}
+	assert.Nil(t, ja.Validate())
+	assert.Equal(t, "groups", ja.MapGroups.Claim)
+	assert.Empty(t, ja.rolesClaim, "roles claim not defaulted")
+
+	authenticate := func(claims MapClaims) (User, error) {
+		r, _ := http.NewRequest("GET", "/", nil)
+		r.Header.Add("Authorization", issueTokenString(claims))
+		user, _, err := ja.Authenticate(httptest.NewRecorder(), r)
+		return user, err
+	}
+
+	user, err := authenticate(MapClaims{"sub": "ggicci", "groups": []string{adminsGroup, "unknown"}})
+	assert.Nil(t, err)
+	assert.Equal(t, "admins", user.Metadata["roles"])
+
+	user, err = authenticate(MapClaims{"sub": "ggicci", "groups": []string{
+		"6e74172b-be56-4843-9ff4-e66a39bb12e3", "9a5e2c1d-0000-4000-8000-000000000001",
+	}})
+	assert.Nil(t, err)
+	assert.Equal(t, "editors,viewers", user.Metadata["roles"], "deduplicated")
+
+	_, err = authenticate(MapClaims{"sub": "ggicci", "groups": []string{"unknown"}})
+	assert.ErrorIs(t, err, ErrClaimPolicy)
+
+	// joining the roles claim, passing the unmapped groups through
+	ja.RolesClaim = "roles"
+	ja.MapGroups.KeepUnmapped = true
+	assert.Nil(t, ja.Validate())
+	user, err = authenticate(MapClaims{"sub": "ggicci", "roles": []string{"viewers"}, "groups": []string{adminsGroup, "ops"}})
+	assert.Nil(t, err)
+	assert.Equal(t, "viewers,admins,ops", user.Metadata["roles"])
+
+	for _, gm := range []*GroupMapping{
+		{},
+		{Groups: map[string][]string{"g": nil}},
+		{Groups: map[string][]string{"g": {"a,b"}}},
+	} {
+		ja := &JWTAuth{SignKey: TestSignKey, MapGroups: gm}
+		assert.ErrorContains(t, ja.Validate(), "invalid map_groups")
+	}
+}
+
+func TestParsingCaddyfileMapGroups(t *testing.T) {
+	h, err := parseCaddyfile(httpcaddyfile.Helper{Dispenser: caddyfile.NewTestDispenser(`jwtauth {
+		map_groups cognito:groups {
+			"S-1-5-21-1004336348-1177238915-682003330-512" => admins
+			6e74172b-be56-4843-9ff4-e66a39bb12e3 => editors viewers
+			keep_unmapped
+		}
+	}`)})
+	assert.Nil(t, err)
+	var ja JWTAuth
+	assert.Nil(t, json.Unmarshal(h.(caddyauth.Authentication).ProvidersRaw["jwt"], &ja))
+	assert.Equal(t, &GroupMapping{
+		Claim: "cognito:groups",
+		Groups: map[string][]string{
+			"S-1-5-21-1004336348-1177238915-682003330-512": {"admins"},
+			"6e74172b-be56-4843-9ff4-e66a39bb12e3":         {"editors", "viewers"},
+		},
+		KeepUnmapped: true,
+	}, ja.MapGroups)
+
+	for _, body := range []string{
+		`jwtauth {
+			map_groups
+		}`,
+		`jwtauth {
+			map_groups groups extra {
+				g => r
+			}
+		}`,
+		`jwtauth {
+			map_groups {
+				g r
+			}
+		}`,
+		`jwtauth {
+			map_groups {
+				g => r
+				g => s
+			}
+		}`,
+	} {
+		_, err := parseCaddyfile(httpcaddyfile.Helper{Dispenser: caddyfile.NewTestDispenser(body)})
+		assert.NotNil(t, err, body)
+	}
+}
@@ -0,0 +1,214 @@
+package caddyjwt
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"runtime"
+	"sync"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/lestrrat-go/jwx/v2/jwa"
+	"github.com/lestrrat-go/jwx/v2/jws"
+	"go.uber.org/zap"
+)
+
+// errSandboxBusy is the transient failure of the sandbox to parse a token
+// in time, regardless of the token.
+var errSandboxBusy = errors.New("sandbox busy")
+
+// SandboxParsing parses the untrusted tokens in a dedicated pool of worker
+// goroutines, isolated from the request goroutines: a panic of the parser is
+// recovered and rejects the token only, a pathological token taking too long
+// is abandoned after Timeout, and the oversized tokens are rejected before
+// parsing.
+//
+// The keys are provided outside of the sandbox, between parsing the token
+// and verifying it by them in the workers, so fetching the JWKs, however
+// slow, never holds a worker. A job is skipped if abandoned before a worker
+// takes it, but Go can't preempt a job running, so an abandoned one holds
+// its worker until done. Neither can Go cap the memory of a goroutine: the
+// allocations of the parser grow with the size of the token, bounded by
+// MaxTokenSize, and with the payload inflated, bounded by
+// JWTAuth.MaxDecompressedSize, but they are not capped.
+type SandboxParsing struct {
+	// Workers is the number of the worker goroutines. Defaults to the number
+	// of CPUs.
+	Workers int `json:"workers"`
+
+	// MaxTokenSize is the maximum size of a token in bytes. Defaults to
+	// 16KiB.
+	MaxTokenSize int `json:"max_token_size"`
+
+	// Timeout of each of parsing a token and verifying it, including the
+	// wait for a worker. Defaults to 1s.
+	Timeout caddy.Duration `json:"timeout"`
+
+	jobs   chan sandboxJob
+	stop   chan struct{}
+	done   sync.WaitGroup // of the workers
+	logger *zap.Logger
+}
+
+type sandboxJob struct {
+	ctx  context.Context // done if abandoned
+	fn   func(context.Context) (interface{}, error)
+	done chan sandboxResult // buffered, so an abandoned job won't block
+}
+
+type sandboxResult struct {
+	value interface{}
+	err   error
+}
+
+func (s *SandboxParsing) provision(logger *zap.Logger) error {
+	if s.Workers == 0 {
+		s.Workers = runtime.NumCPU()
+	}
+	if s.MaxTokenSize == 0 {
+		s.MaxTokenSize = 16 << 10
+	}
+	if s.Timeout == 0 {
+		s.Timeout = caddy.Duration(time.Second)
+	}
+	if s.Workers < 0 {
+		return fmt.Errorf("invalid workers: %d", s.Workers)
+	}
+	if s.MaxTokenSize < 0 {
+		return fmt.Errorf("invalid max_token_size: %d", s.MaxTokenSize)
+	}
+	if s.Timeout < 0 {
+		return fmt.Errorf("invalid timeout: %s", time.Duration(s.Timeout))
+	}
+	s.logger = logger
+	s.jobs = make(chan sandboxJob)
+	s.stop = make(chan struct{})
+	s.done.Add(s.Workers)
+	for i := 0; i < s.Workers; i++ {
+		go s.work()
+	}
+	return nil
+}
+
+// cleanup stops the workers, after their jobs in progress.
+func (s *SandboxParsing) cleanup() {
+	close(s.stop)
+	s.done.Wait()
+	s.stop = nil
+}
+
+func (s *SandboxParsing) work() {
+	defer s.done.Done()
+	for {
+		select {
+		case <-s.stop:
+			return
+		case job := <-s.jobs:
+			if err := job.ctx.Err(); err != nil {
+				job.done <- sandboxResult{err: err}
+				continue
+			}
+			job.done <- s.do(job)
+		}
+	}
+}
+
+// do runs the job, recovering from a panic.
+func (s *SandboxParsing) do(job sandboxJob) (result sandboxResult) {
+	defer func() {
+		if v := recover(); v != nil {
+			metrics.sandboxPanics.Inc()
+			s.logger.Error("token parser panicked", zap.Any("panic", v), zap.Stack("stack"))
+			result = sandboxResult{err: fmt.Errorf("%w: parser panicked: %v", ErrInvalidToken, v)}
+		}
+	}()
+	result.value, result.err = job.fn(job.ctx)
+	return result
+}
+
+// run runs fn in a worker within Timeout. fn is given the context of the
+// job, done once abandoned.
+func (s *SandboxParsing) run(ctx context.Context, fn func(context.Context) (interface{}, error)) (interface{}, error) {
+	jobCtx, cancel := context.WithTimeout(ctx, time.Duration(s.Timeout))
+	defer cancel()
+
+	job := sandboxJob{ctx: jobCtx, fn: fn, done: make(chan sandboxResult, 1)}
+	select {
+	case s.jobs <- job:
+	case <-jobCtx.Done():
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		return nil, fmt.Errorf("%w: no parser available (%w)", ErrInvalidToken, errSandboxBusy)
+	}
+	select {
+	case result := <-job.done:
+		return result.value, result.err
+	case <-jobCtx.Done():
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		return nil, fmt.Errorf("%w: parsing timed out (%w)", ErrInvalidToken, errSandboxBusy)
+	}
+}
+
+// parse parses the token in a worker, provides its keys by provider outside
+// of the sandbox, and verifies the token by the keys provided and parses its
+// claims by verify in a worker again.
+func (s *SandboxParsing) parse(ctx context.Context, tokenString string, provider jws.KeyProvider,
+	verify func(ctx context.Context, tokenString string, provider jws.KeyProvider) (Token, error)) (Token, error) {
+	if len(tokenString) > s.MaxTokenSize {
+		return nil, fmt.Errorf("%w: token of %d bytes exceeds max_token_size", ErrInvalidToken, len(tokenString))
+	}
+	parsed, err := s.run(ctx, func(context.Context) (interface{}, error) {
+		return jws.ParseString(tokenString)
+	})
+	if err != nil {
+		return nil, err
+	}
+	provided, err := provideKeys(ctx, provider, parsed.(*jws.Message))
+	if err != nil {
+		return nil, err
+	}
+	verified, err := s.run(ctx, func(ctx context.Context) (interface{}, error) {
+		return verify(ctx, tokenString, provided)
+	})
+	token, _ := verified.(Token)
+	return token, err
+}
+
+// providedKey is a key provided to a jws.KeySink.
+type providedKey struct {
+	alg jwa.SignatureAlgorithm
+	key interface{}
+}
+
+type providedKeys []providedKey
+
+func (pk *providedKeys) Key(alg jwa.SignatureAlgorithm, key interface{}) {
+	*pk = append(*pk, providedKey{alg: alg, key: key})
+}
+
+// provideKeys provides the keys of the signatures of the message by
+// provider, and returns the provider of them only, which provides them
+// again without fetching, e.g. to verify the same token in the sandbox.
+func provideKeys(ctx context.Context, provider jws.KeyProvider, msg *jws.Message) (jws.KeyProvider, error) {
+	keys := make([]providedKeys, len(msg.Signatures()))
+	for i, sig := range msg.Signatures() {
+		if err := provider.FetchKeys(ctx, &keys[i], sig, msg); err != nil {
+			return nil, err
+		}
+	}
+	return jws.KeyProviderFunc(func(_ context.Context, sink jws.KeySink, sig *jws.Signature, msg *jws.Message) error {
+		for i, s := range msg.Signatures() {
+			if s != sig || i >= len(keys) {
+				continue
+			}
+			for _, k := range keys[i] {
+				sink.Key(k.alg, k.key)
+			}
+		}
+		return nil
+	}), nil
+}
@@ -0,0 +1,109 @@
+package caddyjwt
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/lestrrat-go/jwx/v2/jws"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAuthenticate_SandboxParsing(t *testing.T) {
+	ja := &JWTAuth{
+		SignKey:        TestSignKey,
+		SandboxParsing: &SandboxParsing{Workers: 2, MaxTokenSize: 1 << 10},
+		logger:         testLogger,
+	}
+	assert.Nil(t, ja.Validate())
+	defer ja.Cleanup()
+
+	authenticate := func(token string) error {
+		r, _ := http.NewRequest("GET", "/", nil)
+		r.Header.Add("Authorization", token)
+		_, _, err := ja.Authenticate(httptest.NewRecorder(), r)
+		return err
+	}
+	assert.Nil(t, authenticate(issueTokenString(MapClaims{"sub": "ggicci"})))
+	assert.ErrorIs(t, authenticate("not-a-jwt"), ErrInvalidToken)
+	err := authenticate(issueTokenString(MapClaims{"sub": "ggicci", "padding": strings.Repeat("x", 1<<10)}))
+	assert.ErrorIs(t, err, ErrInvalidToken)
+	assert.ErrorContains(t, err, "exceeds max_token_size")
+}
+
+func TestSandboxParsing_Isolation(t *testing.T) {
+	s := &SandboxParsing{Workers: 1, Timeout: caddy.Duration(50 * time.Millisecond)}
+	assert.Nil(t, s.provision(testLogger))
+	defer s.cleanup()
+	assert.Equal(t, 16<<10, s.MaxTokenSize)
+
+	// a panic is recovered
+	_, err := s.run(context.Background(), func(context.Context) (interface{}, error) {
+		panic("boom")
+	})
+	assert.ErrorIs(t, err, ErrInvalidToken)
+	assert.ErrorContains(t, err, "parser panicked: boom")
+
+	// the worker survives
+	v, err := s.run(context.Background(), func(context.Context) (interface{}, error) { return "parsed", nil })
+	assert.Nil(t, err)
+	assert.Equal(t, "parsed", v)
+
+	// a pathological token is abandoned, and told so
+	release := make(chan struct{})
+	defer close(release)
+	abandoned := make(chan error, 1)
+	_, err = s.run(context.Background(), func(ctx context.Context) (interface{}, error) {
+		<-release
+		abandoned <- ctx.Err()
+		return nil, nil
+	})
+	assert.ErrorContains(t, err, "parsing timed out")
+	// while the only worker is stuck
+	_, err = s.run(context.Background(), func(context.Context) (interface{}, error) { return nil, nil })
+	assert.ErrorContains(t, err, "no parser available")
+	release <- struct{}{}
+	assert.ErrorIs(t, <-abandoned, context.DeadlineExceeded)
+
+	// canceled by the request
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = s.run(ctx, func(context.Context) (interface{}, error) { return nil, nil })
+	assert.ErrorIs(t, err, context.Canceled)
+}
+
+func TestSandboxParsing_KeysOutside(t *testing.T) {
+	ja := &JWTAuth{
+		SignKey:        TestSignKey,
+		SandboxParsing: &SandboxParsing{Workers: 1, Timeout: caddy.Duration(50 * time.Millisecond)},
+		logger:         testLogger,
+	}
+	assert.Nil(t, ja.Validate())
+	defer ja.Cleanup()
+	token := issueTokenString(MapClaims{"sub": "ggicci"})
+
+	// a slow key provider, e.g. fetching the JWKs, holds no worker
+	fetching, release := make(chan struct{}), make(chan struct{})
+	slow := jws.KeyProviderFunc(func(ctx context.Context, sink jws.KeySink, sig *jws.Signature, msg *jws.Message) error {
+		close(fetching)
+		<-release
+		return ja.keyProvider(ctx, &keyProvenance{})(ctx, sink, sig, msg)
+	})
+	done := make(chan error, 1)
+	go func() {
+		_, err := ja.SandboxParsing.parse(context.Background(), token, slow, ja.parseVerified)
+		done <- err
+	}()
+	<-fetching
+	kp := &keyProvenance{}
+	parsed, err := ja.parseSigned(context.Background(), token, kp)
+	assert.Nil(t, err)
+	assert.Equal(t, "ggicci", parsed.Subject())
+	assert.Equal(t, "sign_key", kp.Source)
+	close(release)
+	assert.Nil(t, <-done)
+}
@@ -0,0 +1,41 @@
+package caddyjwt
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+
+	"github.com/santhosh-tekuri/jsonschema/v5"
+)
+
+// compileClaimsSchema compiles the JSON Schema document at ClaimsSchema, a
+// file path or a URL. It returns nil if not set.
+func compileClaimsSchema(location string) (*jsonschema.Schema, error) {
+	if location == "" {
+		return nil, nil
+	}
+	return jsonschema.Compile(location)
+}
+
+// checkClaimsSchema validates the full payload of the token against the
+// ClaimsSchema.
+func (ja *JWTAuth) checkClaimsSchema(token Token) error {
+	if ja.claimsSchema == nil {
+		return nil
+	}
+	// validate the claims as in JSON, e.g. "exp" as a number
+	payload, err := json.Marshal(token)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidToken, err)
+	}
+	decoder := json.NewDecoder(bytes.NewReader(payload))
+	decoder.UseNumber()
+	var claims interface{}
+	if err := decoder.Decode(&claims); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidToken, err)
+	}
+	if err := ja.claimsSchema.Validate(claims); err != nil {
+		return fmt.Errorf("%w: %v", ErrClaimsSchema, err)
+	}
+	return nil
+}
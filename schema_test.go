@@ -0,0 +1,55 @@
+package caddyjwt
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAuthenticate_ClaimsSchema(t *testing.T) {
+	schemaFile := filepath.Join(t.TempDir(), "claims.schema.json")
+	assert.Nil(t, os.WriteFile(schemaFile, []byte(`{
+		"type": "object",
+		"required": ["sub", "tenant"],
+		"properties": {
+			"sub": {"type": "string"},
+			"tenant": {"type": "string", "pattern": "^t-[0-9]+$"},
+			"exp": {"type": "integer"}
+		}
+	}`), 0600))
+
+	ja := &JWTAuth{
+		SignKey:      TestSignKey,
+		ClaimsSchema: schemaFile,
+		logger:       testLogger,
+	}
+	assert.Nil(t, ja.Validate())
+
+	authenticate := func(claims MapClaims) error {
+		r, _ := http.NewRequest("GET", "/", nil)
+		r.Header.Add("Authorization", issueTokenString(claims))
+		_, _, err := ja.Authenticate(httptest.NewRecorder(), r)
+		return err
+	}
+	assert.Nil(t, authenticate(MapClaims{"sub": "ggicci", "tenant": "t-42", "exp": 9999999999}))
+	assert.ErrorIs(t, authenticate(MapClaims{"sub": "ggicci"}), ErrClaimsSchema)
+	assert.ErrorIs(t, authenticate(MapClaims{"sub": "ggicci", "tenant": "acme"}), ErrClaimsSchema)
+}
+
+func TestValidate_ClaimsSchema(t *testing.T) {
+	ja := &JWTAuth{
+		SignKey:      TestSignKey,
+		ClaimsSchema: filepath.Join(t.TempDir(), "missing.json"),
+		logger:       testLogger,
+	}
+	assert.ErrorContains(t, ja.Validate(), "invalid claims_schema")
+
+	schemaFile := filepath.Join(t.TempDir(), "claims.schema.json")
+	assert.Nil(t, os.WriteFile(schemaFile, []byte(`{"type": 42}`), 0600))
+	ja.ClaimsSchema = schemaFile
+	assert.ErrorContains(t, ja.Validate(), "invalid claims_schema")
+}
@@ -0,0 +1,67 @@
+package caddyjwt
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// tokenScopes returns the scopes granted by the token: the "scope" claim, or
+// else the "scp" claim, either a space-delimited string or an array.
+func tokenScopes(token Token) []string {
+	for _, claim := range []string{"scope", "scp"} {
+		val, ok := token.Get(claim)
+		if !ok {
+			continue
+		}
+		switch v := val.(type) {
+		case string:
+			return strings.Fields(v)
+		case []string:
+			return v
+		case []interface{}:
+			scopes := make([]string, 0, len(v))
+			for _, s := range v {
+				scopes = append(scopes, stringify(s))
+			}
+			return scopes
+		}
+	}
+	return nil
+}
+
+// checkScope verifies the scopes of the token against RequireScope, matched
+// by ScopeMatch.
+func (ja *JWTAuth) checkScope(token Token) error {
+	if len(ja.RequireScope) == 0 {
+		return nil
+	}
+	granted := make(map[string]struct{})
+	for _, scope := range tokenScopes(token) {
+		granted[scope] = struct{}{}
+	}
+	var missing []string
+	for _, scope := range ja.RequireScope {
+		_, ok := granted[scope]
+		if ok && ja.ScopeMatch == "any" {
+			return nil
+		}
+		if !ok {
+			missing = append(missing, scope)
+		}
+	}
+	if len(missing) == 0 {
+		return nil
+	}
+	if ja.ScopeMatch == "any" {
+		return fmt.Errorf("%w: requires any of %q", ErrInsufficientScope, ja.RequireScope)
+	}
+	return fmt.Errorf("%w: missing %q", ErrInsufficientScope, missing)
+}
+
+// respondInsufficientScope writes the 403 response of RFC 6750 section 3.1
+// to the token lacking the scopes.
+func respondInsufficientScope(rw http.ResponseWriter, r *http.Request) {
+	rw.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	writeFailure(rw, r, http.StatusForbidden, []byte(http.StatusText(http.StatusForbidden)+"\n"))
+}
@@ -0,0 +1,73 @@
+package caddyjwt
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAuthenticate_RequireScope(t *testing.T) {
+	ja := &JWTAuth{
+		SignKey:      TestSignKey,
+		RequireScope: []string{"orders:read", "orders:write"},
+		logger:       testLogger,
+	}
+	assert.Nil(t, ja.Validate())
+	assert.Equal(t, "all", ja.ScopeMatch)
+
+	authenticate := func(claims MapClaims) (*httptest.ResponseRecorder, error) {
+		claims["sub"] = "ggicci"
+		rw := httptest.NewRecorder()
+		r, _ := http.NewRequest("GET", "/", nil)
+		r.Header.Add("Authorization", issueTokenString(claims))
+		_, _, err := ja.Authenticate(rw, r)
+		return rw, err
+	}
+
+	_, err := authenticate(MapClaims{"scope": "orders:read orders:write profile"})
+	assert.Nil(t, err)
+	_, err = authenticate(MapClaims{"scp": []string{"orders:write", "orders:read"}})
+	assert.Nil(t, err)
+
+	rw, err := authenticate(MapClaims{"scope": "orders:read"})
+	assert.ErrorIs(t, err, ErrInsufficientScope)
+	assert.Equal(t, http.StatusForbidden, rw.Code)
+	assert.Equal(t, `Bearer error="insufficient_scope", error_description="insufficient scope"`, rw.Header().Get("WWW-Authenticate"))
+
+	// an invalid token is still 401
+	rw, err = authenticate(MapClaims{"scope": "orders:read orders:write", "exp": 1})
+	assert.ErrorIs(t, err, ErrTokenExpired)
+	assert.Equal(t, http.StatusOK, rw.Code) // untouched, Caddy responds 401
+	assert.Empty(t, rw.Header().Get("WWW-Authenticate"))
+
+	ja.ScopeMatch = "any"
+	_, err = authenticate(MapClaims{"scope": "orders:read"})
+	assert.Nil(t, err)
+	_, err = authenticate(MapClaims{"scp": "profile"})
+	assert.ErrorIs(t, err, ErrInsufficientScope)
+	_, err = authenticate(MapClaims{})
+	assert.ErrorIs(t, err, ErrInsufficientScope)
+
+	ja.ScopeMatch = "some"
+	assert.ErrorContains(t, ja.Validate(), "invalid scope_match")
+}
+
+func TestAuthenticate_RequireScopeFailureResponse(t *testing.T) {
+	ja := &JWTAuth{
+		SignKey:         TestSignKey,
+		RequireScope:    []string{"admin"},
+		FailureResponse: &FailureResponse{Body: "{{.Status}} {{.Reason}}"},
+		logger:          testLogger,
+	}
+	assert.Nil(t, ja.Validate())
+
+	rw := httptest.NewRecorder()
+	r, _ := http.NewRequest("GET", "/", nil)
+	r.Header.Add("Authorization", issueTokenString(MapClaims{"sub": "ggicci", "scope": "user"}))
+	_, _, err := ja.Authenticate(rw, r)
+	assert.ErrorIs(t, err, ErrInsufficientScope)
+	assert.Equal(t, http.StatusForbidden, rw.Code)
+	assert.Equal(t, "403 insufficient_scope", rw.Body.String())
+}
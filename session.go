@@ -0,0 +1,178 @@
+package caddyjwt
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/lestrrat-go/jwx/v2/jwa"
+	"github.com/lestrrat-go/jwx/v2/jwt"
+	"go.uber.org/zap"
+)
+
+// sourceSession is the source of the session cookies, see SessionCookie.
+const sourceSession tokenSource = "session"
+
+// sessionTokenHashClaim is the claim of a session binding it to the token
+// it was exchanged for, the base64url encoded SHA-256 of the token.
+const sessionTokenHashClaim = "sth"
+
+// SessionCookie exchanges a verified token for a session cookie, a JWT of
+// the same claims signed by HMAC-SHA256, so the subsequent requests are
+// verified by the cheap HMAC rather than the JWKs. The claims of a session
+// are checked on every request just as the ones of the token. A session
+// expires after TTL, or with the token if earlier, and the token presented
+// along is then verified again, for a new session.
+//
+// A session is only accepted for the token it was exchanged for, i.e. if the
+// request presents another token, e.g. refreshed, the token is verified
+// instead. The tokens bound to the keys of the clients, of the "cnf" claim,
+// e.g. DPoP, are never exchanged.
+type SessionCookie struct {
+	// Name of the cookie. Defaults to "jwt_session".
+	Name string `json:"name,omitempty"`
+
+	// TTL of the sessions. Defaults to 5m.
+	TTL caddy.Duration `json:"ttl,omitempty"`
+
+	// Secret is the HMAC key of the sessions, at least 32 bytes, which
+	// supports placeholders, e.g. {env.JWT_SESSION_SECRET}. Defaults to a
+	// random key, i.e. the sessions are neither shared by the instances of
+	// Caddy nor survive a config reload, the tokens are verified again
+	// then.
+	Secret string `json:"secret,omitempty"`
+
+	// SameSite of the cookie: "lax", "strict" or "none". Defaults to "lax".
+	SameSite string `json:"same_site,omitempty"`
+
+	// Secure marks the cookie as Secure. Defaults to true.
+	Secure *bool `json:"secure,omitempty"`
+
+	key      []byte
+	sameSite http.SameSite
+}
+
+func (sc *SessionCookie) provision() error {
+	if sc.Name == "" {
+		sc.Name = "jwt_session"
+	}
+	if sc.TTL < 0 {
+		return fmt.Errorf("invalid ttl: %s", time.Duration(sc.TTL))
+	}
+	if sc.TTL == 0 {
+		sc.TTL = caddy.Duration(5 * time.Minute)
+	}
+	switch strings.ToLower(sc.SameSite) {
+	case "", "lax":
+		sc.sameSite = http.SameSiteLaxMode
+	case "strict":
+		sc.sameSite = http.SameSiteStrictMode
+	case "none":
+		sc.sameSite = http.SameSiteNoneMode
+	default:
+		return fmt.Errorf("invalid same_site: %q", sc.SameSite)
+	}
+	secret := caddy.NewReplacer().ReplaceAll(sc.Secret, "")
+	if sc.Secret == "" {
+		sc.key = make([]byte, 32)
+		_, err := rand.Read(sc.key)
+		return err
+	}
+	if len(secret) < 32 {
+		return fmt.Errorf("invalid secret: at least 32 bytes")
+	}
+	sc.key = []byte(secret)
+	return nil
+}
+
+// getSessionCookie returns the session cookie of the request, if any.
+func (sc *SessionCookie) getSessionCookie(r *http.Request) []candidateToken {
+	if ck, err := r.Cookie(sc.Name); err == nil && ck.Value != "" {
+		return []candidateToken{{sourceSession, sc.Name, ck.Value}}
+	}
+	return nil
+}
+
+// verifySession verifies the session, which must not have expired and must
+// be of one of the other candidate tokens of the request, if any.
+func (ja *JWTAuth) verifySession(session string, candidates []candidateToken) (Token, error) {
+	token, err := jwt.ParseString(session, jwt.WithKey(jwa.HS256, ja.IssueSessionCookie.key), jwt.WithValidate(false))
+	if err != nil {
+		return nil, fmt.Errorf("%w: session: %v", ErrInvalidToken, err)
+	}
+	if exp := token.Expiration(); exp.IsZero() || time.Now().After(exp) {
+		return nil, fmt.Errorf("%w: session expired", ErrTokenExpired)
+	}
+	sth, _ := token.Get(sessionTokenHashClaim)
+	bound := true
+	for _, candidate := range candidates {
+		if candidate.source == sourceSession {
+			continue
+		}
+		if bound = sth == sessionTokenHash(ja.normalizeToken(candidate)); bound {
+			break
+		}
+	}
+	if !bound {
+		return nil, fmt.Errorf("%w: session of another token", ErrInvalidToken)
+	}
+	return token, nil
+}
+
+// issueSession sets the session cookie for the token accepted, unless it's
+// from the session itself.
+func (ja *JWTAuth) issueSession(rw http.ResponseWriter, result *authResult) {
+	sc := ja.IssueSessionCookie
+	if sc == nil || result.candidate.source == sourceSession {
+		return
+	}
+	if _, bound := result.token.Get("cnf"); bound {
+		return
+	}
+	now := time.Now()
+	exp := now.Add(time.Duration(sc.TTL))
+	if tokenExp := result.token.Expiration(); !tokenExp.IsZero() && tokenExp.Before(exp) {
+		exp = tokenExp
+	}
+	if !exp.After(now) {
+		return
+	}
+	claims, err := result.token.AsMap(context.Background())
+	var signed []byte
+	if err == nil {
+		builder := jwt.NewBuilder()
+		for name, value := range claims {
+			builder = builder.Claim(name, value)
+		}
+		var session Token
+		session, err = builder.Expiration(exp).Claim(sessionTokenHashClaim, sessionTokenHash(result.raw)).Build()
+		if err == nil {
+			signed, err = jwt.Sign(session, jwt.WithKey(jwa.HS256, sc.key))
+		}
+	}
+	if err != nil {
+		ja.logger.Error("failed to issue session", zap.Error(err))
+		return
+	}
+	http.SetCookie(rw, &http.Cookie{
+		Name:     sc.Name,
+		Value:    string(signed),
+		Path:     "/",
+		Expires:  exp,
+		MaxAge:   int(time.Until(exp) / time.Second),
+		HttpOnly: true,
+		Secure:   boolOrDefault(sc.Secure, true),
+		SameSite: sc.sameSite,
+	})
+}
+
+func sessionTokenHash(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
@@ -0,0 +1,93 @@
+package caddyjwt
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/lestrrat-go/jwx/v2/jwa"
+	"github.com/lestrrat-go/jwx/v2/jwt"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAuthenticate_IssueSessionCookie(t *testing.T) {
+	ja := &JWTAuth{JWKURL: TestJWKSetURL, IssueSessionCookie: &SessionCookie{}, logger: testLogger}
+	assert.Nil(t, ja.Validate())
+	defer ja.Cleanup()
+
+	authenticate := func(token string, session *http.Cookie) (*httptest.ResponseRecorder, User, string, error) {
+		rw := httptest.NewRecorder()
+		r, repl := newRequestWithReplacer("GET", "/")
+		if token != "" {
+			r.Header.Add("Authorization", token)
+		}
+		if session != nil {
+			r.AddCookie(session)
+		}
+		user, _, err := ja.Authenticate(rw, r)
+		source, _ := repl.GetString("http.auth.jwt.key_source")
+		return rw, user, source, err
+	}
+	sessionOf := func(rw *httptest.ResponseRecorder) *http.Cookie {
+		for _, ck := range rw.Result().Cookies() {
+			if ck.Name == "jwt_session" {
+				return ck
+			}
+		}
+		return nil
+	}
+
+	token := issueTokenStringJWK(MapClaims{"sub": "ggicci", "exp": time.Now().Add(time.Hour).Unix()})
+	rw, _, source, err := authenticate(token, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, "jwk_url", source)
+	session := sessionOf(rw)
+	assert.NotNil(t, session)
+	assert.True(t, session.HttpOnly)
+	assert.True(t, session.Secure)
+	assert.Equal(t, http.SameSiteLaxMode, session.SameSite)
+	assert.InDelta(t, 5*time.Minute.Seconds(), session.MaxAge, 2)
+
+	// the session alone, or along with its token
+	for _, token := range []string{"", token} {
+		rw, user, source, err := authenticate(token, session)
+		assert.Nil(t, err)
+		assert.Equal(t, "ggicci", user.ID)
+		assert.Equal(t, "session", source)
+		assert.Nil(t, sessionOf(rw))
+	}
+
+	// another token is verified instead, for a new session
+	other := issueTokenStringJWK(MapClaims{"sub": "someone"})
+	rw, user, source, err := authenticate(other, session)
+	assert.Nil(t, err)
+	assert.Equal(t, "someone", user.ID)
+	assert.Equal(t, "jwk_url", source)
+	assert.NotNil(t, sessionOf(rw))
+
+	// tampered
+	_, _, _, err = authenticate("", &http.Cookie{Name: "jwt_session", Value: session.Value + "x"})
+	assert.ErrorIs(t, err, ErrInvalidToken)
+
+	// expired
+	expired, _ := jwt.NewBuilder().Subject("ggicci").Expiration(time.Now().Add(-time.Second)).Build()
+	signed, _ := jwt.Sign(expired, jwt.WithKey(jwa.HS256, ja.IssueSessionCookie.key))
+	_, _, _, err = authenticate("", &http.Cookie{Name: "jwt_session", Value: string(signed)})
+	assert.ErrorIs(t, err, ErrTokenExpired)
+
+	// the sender-constrained tokens are never exchanged
+	rw, _, _, err = authenticate(issueTokenStringJWK(MapClaims{"sub": "ggicci", "cnf": map[string]interface{}{"jkt": "abc"}}), nil)
+	assert.Nil(t, err)
+	assert.Nil(t, sessionOf(rw))
+}
+
+func TestValidate_IssueSessionCookie(t *testing.T) {
+	for _, sc := range []*SessionCookie{{Secret: "short"}, {SameSite: "loose"}, {TTL: -1}} {
+		ja := &JWTAuth{SignKey: TestSignKey, IssueSessionCookie: sc}
+		assert.ErrorContains(t, ja.Validate(), "invalid issue_session_cookie")
+	}
+	ja := &JWTAuth{SignKey: TestSignKey, IssueSessionCookie: &SessionCookie{Secret: "0123456789abcdef0123456789abcdef"}}
+	assert.Nil(t, ja.Validate())
+	assert.Equal(t, "jwt_session", ja.IssueSessionCookie.Name)
+}
@@ -0,0 +1,87 @@
+package caddyjwt
+
+import (
+	"fmt"
+	"net/http"
+)
+
+// sourceAuthorization is the name of the Authorization header in
+// SourcePriority, which is of sourceHeader otherwise.
+const sourceAuthorization tokenSource = "authorization"
+
+// defaultSourceOrder is the order of the sources of the tokens, unless
+// SourcePriority.
+var defaultSourceOrder = []tokenSource{sourceQuery, sourceHeader, sourceCookie, sourceBody, sourceAuthorization}
+
+// compileSourceOrder returns the order of the sources by SourcePriority,
+// the sources not listed following in the default order.
+func compileSourceOrder(priority []string) ([]tokenSource, error) {
+	if len(priority) == 0 {
+		return defaultSourceOrder, nil
+	}
+	known := make(map[tokenSource]bool, len(defaultSourceOrder))
+	for _, source := range defaultSourceOrder {
+		known[source] = true
+	}
+	order := make([]tokenSource, 0, len(defaultSourceOrder))
+	listed := make(map[tokenSource]bool, len(priority))
+	for _, name := range priority {
+		source := tokenSource(name)
+		if !known[source] {
+			return nil, fmt.Errorf("unknown source %q, expect query, header, cookie, body or authorization", name)
+		}
+		if listed[source] {
+			return nil, fmt.Errorf("duplicate source %q", name)
+		}
+		listed[source] = true
+		order = append(order, source)
+	}
+	for _, source := range defaultSourceOrder {
+		if !listed[source] {
+			order = append(order, source)
+		}
+	}
+	return order, nil
+}
+
+// tokensFromSource returns the tokens of the request from the source.
+func (ja *JWTAuth) tokensFromSource(r *http.Request, source tokenSource) []candidateToken {
+	switch source {
+	case sourceQuery:
+		return getTokensFromQuery(r, ja.FromQuery)
+	case sourceHeader:
+		return getTokensFromHeader(r, ja.FromHeader)
+	case sourceCookie:
+		return getTokensFromCookies(r, ja.FromCookies)
+	case sourceBody:
+		return getTokensFromBody(r, ja.FromBody)
+	case sourceAuthorization:
+		if !ja.contextOnly {
+			return getTokensFromHeader(r, authorizationHeader)
+		}
+	}
+	return nil
+}
+
+// checkExclusiveSources returns ErrConflictingTokens if the request presents
+// different tokens, see SourcesExclusive. The session cookie doesn't count,
+// as it's bound to the token it was exchanged for.
+func (ja *JWTAuth) checkExclusiveSources(candidates []candidateToken) error {
+	var first *candidateToken
+	firstToken := ""
+	for i := range candidates {
+		candidate := &candidates[i]
+		if candidate.source == sourceSession {
+			continue
+		}
+		token := ja.normalizeToken(*candidate)
+		if first == nil {
+			first, firstToken = candidate, token
+			continue
+		}
+		if token != firstToken {
+			return fmt.Errorf("%w: of %s %q and of %s %q", ErrConflictingTokens, first.source, first.name, candidate.source, candidate.name)
+		}
+	}
+	return nil
+}
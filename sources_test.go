@@ -0,0 +1,120 @@
+package caddyjwt
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"github.com/caddyserver/caddy/v2/caddyconfig/httpcaddyfile"
+	"github.com/caddyserver/caddy/v2/modules/caddyhttp/caddyauth"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCompileSourceOrder(t *testing.T) {
+	order, err := compileSourceOrder(nil)
+	assert.Nil(t, err)
+	assert.Equal(t, defaultSourceOrder, order)
+
+	order, err = compileSourceOrder([]string{"authorization", "cookie"})
+	assert.Nil(t, err)
+	assert.Equal(t, []tokenSource{sourceAuthorization, sourceCookie, sourceQuery, sourceHeader, sourceBody}, order)
+
+	_, err = compileSourceOrder([]string{"path"})
+	assert.ErrorContains(t, err, `unknown source "path"`)
+	_, err = compileSourceOrder([]string{"query", "query"})
+	assert.ErrorContains(t, err, `duplicate source "query"`)
+
+	ja := &JWTAuth{SignKey: TestSignKey, SourcePriority: []string{"session"}}
+	assert.ErrorContains(t, ja.Validate(), "invalid source_priority")
+}
+
+func TestAuthenticate_SourcePriority(t *testing.T) {
+	ja := &JWTAuth{
+		SignKey:        TestSignKey,
+		FromQuery:      []string{"access_token"},
+		SourcePriority: []string{"authorization"},
+		logger:         testLogger,
+	}
+	assert.Nil(t, ja.Validate())
+
+	r, _ := http.NewRequest("GET", "/?access_token=q", nil)
+	r.Header.Set("Authorization", "Bearer a")
+	candidates := ja.candidateTokens(r)
+	assert.Equal(t, []candidateToken{
+		{sourceHeader, "Authorization", "Bearer a"},
+		{sourceQuery, "access_token", "q"},
+	}, candidates)
+}
+
+func TestAuthenticate_SourcesExclusive(t *testing.T) {
+	ja := &JWTAuth{
+		SignKey:          TestSignKey,
+		FromQuery:        []string{"access_token"},
+		SourcesExclusive: true,
+		logger:           testLogger,
+	}
+	assert.Nil(t, ja.Validate())
+
+	authenticate := func(query, authorization string) (User, error) {
+		r, _ := http.NewRequest("GET", "/", nil)
+		if query != "" {
+			r.URL.RawQuery = "access_token=" + query
+		}
+		if authorization != "" {
+			r.Header.Set("Authorization", "Bearer "+authorization)
+		}
+		user, _, err := ja.Authenticate(httptest.NewRecorder(), r)
+		return user, err
+	}
+	token := issueTokenString(MapClaims{"sub": "ggicci"})
+	other := issueTokenString(MapClaims{"sub": "someone"})
+
+	user, err := authenticate(token, "")
+	assert.Nil(t, err)
+	assert.Equal(t, "ggicci", user.ID)
+
+	// the same token twice
+	user, err = authenticate(token, token)
+	assert.Nil(t, err)
+	assert.Equal(t, "ggicci", user.ID)
+
+	_, err = authenticate(token, other)
+	assert.ErrorIs(t, err, ErrConflictingTokens)
+	assert.Equal(t, "conflicting_tokens", failureReason(err))
+
+	// even if one of them is invalid
+	_, err = authenticate("garbage", token)
+	assert.ErrorIs(t, err, ErrConflictingTokens)
+
+	ja.SourcesExclusive = false
+	user, err = authenticate("garbage", token)
+	assert.Nil(t, err)
+	assert.Equal(t, "ggicci", user.ID)
+}
+
+func TestParsingCaddyfileSources(t *testing.T) {
+	h, err := parseCaddyfile(httpcaddyfile.Helper{Dispenser: caddyfile.NewTestDispenser(`jwtauth {
+		from_query access_token
+		source_priority authorization header
+		sources_exclusive
+	}`)})
+	assert.Nil(t, err)
+	var ja JWTAuth
+	assert.Nil(t, json.Unmarshal(h.(caddyauth.Authentication).ProvidersRaw["jwt"], &ja))
+	assert.Equal(t, []string{"authorization", "header"}, ja.SourcePriority)
+	assert.True(t, ja.SourcesExclusive)
+
+	for _, body := range []string{
+		`jwtauth {
+			source_priority
+		}`,
+		`jwtauth {
+			sources_exclusive yes
+		}`,
+	} {
+		_, err := parseCaddyfile(httpcaddyfile.Helper{Dispenser: caddyfile.NewTestDispenser(body)})
+		assert.NotNil(t, err, body)
+	}
+}
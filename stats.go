@@ -0,0 +1,150 @@
+package caddyjwt
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// statsBuckets is the number of one-minute buckets kept by authStats, i.e.
+// the maximum rolling window is one hour.
+const statsBuckets = 60
+
+// stats collects the outcomes of all the JWT providers in this process.
+var stats = newAuthStats()
+
+// statsBucket holds the counts of one minute.
+type statsBucket struct {
+	minute         int64 // unix minute of the bucket
+	successes      int
+	failures       map[string]int // by reason
+	failingIssuers map[string]int
+	keyCacheHits   int
+	keyCacheMisses int
+}
+
+// authStats keeps rolling-window counts of authentication outcomes.
+type authStats struct {
+	mu      sync.Mutex
+	buckets [statsBuckets]statsBucket
+	now     func() time.Time
+}
+
+func newAuthStats() *authStats {
+	return &authStats{now: time.Now}
+}
+
+// bucket returns the bucket of the current minute, resetting it if stale.
+// The caller must hold the lock.
+func (s *authStats) bucket() *statsBucket {
+	minute := s.now().Unix() / 60
+	b := &s.buckets[minute%statsBuckets]
+	if b.minute != minute {
+		*b = statsBucket{
+			minute:         minute,
+			failures:       make(map[string]int),
+			failingIssuers: make(map[string]int),
+		}
+	}
+	return b
+}
+
+func (s *authStats) recordSuccess() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.bucket().successes++
+}
+
+func (s *authStats) recordFailure(reason, issuer string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	b := s.bucket()
+	b.failures[reason]++
+	if issuer != "" {
+		b.failingIssuers[issuer]++
+	}
+}
+
+func (s *authStats) recordKeyLookup(hit bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if hit {
+		s.bucket().keyCacheHits++
+	} else {
+		s.bucket().keyCacheMisses++
+	}
+}
+
+// issuerCount is the number of failures of an issuer.
+type issuerCount struct {
+	Issuer string `json:"issuer"`
+	Count  int    `json:"count"`
+}
+
+// statsSnapshot is the summary of the outcomes in a rolling window.
+type statsSnapshot struct {
+	Window            string         `json:"window"`
+	Successes         int            `json:"successes"`
+	Failures          map[string]int `json:"failures"`
+	TopFailingIssuers []issuerCount  `json:"top_failing_issuers"`
+	KeyCache          struct {
+		Hits     int     `json:"hits"`
+		Misses   int     `json:"misses"`
+		HitRatio float64 `json:"hit_ratio"`
+	} `json:"key_cache"`
+}
+
+// snapshot sums up the buckets within the given window (rounded up to
+// minutes, at most one hour), keeping the topN failing issuers.
+func (s *authStats) snapshot(window time.Duration, topN int) statsSnapshot {
+	minutes := int64((window + time.Minute - 1) / time.Minute)
+	if minutes < 1 {
+		minutes = 1
+	}
+	if minutes > statsBuckets {
+		minutes = statsBuckets
+	}
+
+	snap := statsSnapshot{
+		Window:            (time.Duration(minutes) * time.Minute).String(),
+		Failures:          make(map[string]int),
+		TopFailingIssuers: []issuerCount{},
+	}
+	issuers := make(map[string]int)
+
+	s.mu.Lock()
+	current := s.now().Unix() / 60
+	for _, b := range s.buckets {
+		if b.minute == 0 || b.minute <= current-minutes || b.minute > current {
+			continue
+		}
+		snap.Successes += b.successes
+		for reason, n := range b.failures {
+			snap.Failures[reason] += n
+		}
+		for issuer, n := range b.failingIssuers {
+			issuers[issuer] += n
+		}
+		snap.KeyCache.Hits += b.keyCacheHits
+		snap.KeyCache.Misses += b.keyCacheMisses
+	}
+	s.mu.Unlock()
+
+	for issuer, n := range issuers {
+		snap.TopFailingIssuers = append(snap.TopFailingIssuers, issuerCount{issuer, n})
+	}
+	sort.Slice(snap.TopFailingIssuers, func(i, j int) bool {
+		a, b := snap.TopFailingIssuers[i], snap.TopFailingIssuers[j]
+		if a.Count != b.Count {
+			return a.Count > b.Count
+		}
+		return a.Issuer < b.Issuer
+	})
+	if len(snap.TopFailingIssuers) > topN {
+		snap.TopFailingIssuers = snap.TopFailingIssuers[:topN]
+	}
+	if lookups := snap.KeyCache.Hits + snap.KeyCache.Misses; lookups > 0 {
+		snap.KeyCache.HitRatio = float64(snap.KeyCache.Hits) / float64(lookups)
+	}
+	return snap
+}
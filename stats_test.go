@@ -0,0 +1,69 @@
+package caddyjwt
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAuthStats_Snapshot(t *testing.T) {
+	now := time.Date(2023, 10, 1, 12, 0, 0, 0, time.UTC)
+	s := newAuthStats()
+	s.now = func() time.Time { return now }
+
+	s.recordSuccess()
+	s.recordFailure("token_expired", "https://api.example.com")
+	s.recordKeyLookup(true)
+
+	now = now.Add(10 * time.Minute)
+	s.recordSuccess()
+	s.recordFailure("token_expired", "https://api.github.com")
+	s.recordFailure("invalid_issuer", "https://api.github.com")
+	s.recordKeyLookup(true)
+	s.recordKeyLookup(true)
+	s.recordKeyLookup(false)
+
+	snap := s.snapshot(5*time.Minute, 10)
+	assert.Equal(t, "5m0s", snap.Window)
+	assert.Equal(t, 1, snap.Successes)
+	assert.Equal(t, map[string]int{"token_expired": 1, "invalid_issuer": 1}, snap.Failures)
+	assert.Equal(t, []issuerCount{{"https://api.github.com", 2}}, snap.TopFailingIssuers)
+	assert.InDelta(t, 2.0/3, snap.KeyCache.HitRatio, 1e-9)
+
+	snap = s.snapshot(time.Hour, 1)
+	assert.Equal(t, 2, snap.Successes)
+	assert.Equal(t, map[string]int{"token_expired": 2, "invalid_issuer": 1}, snap.Failures)
+	assert.Equal(t, []issuerCount{{"https://api.github.com", 2}}, snap.TopFailingIssuers)
+	assert.Equal(t, 0.75, snap.KeyCache.HitRatio)
+
+	// stale buckets are dropped after an hour
+	now = now.Add(time.Hour)
+	snap = s.snapshot(time.Hour, 10)
+	assert.Equal(t, 0, snap.Successes)
+	assert.Empty(t, snap.Failures)
+}
+
+func TestAdminAPI_Stats(t *testing.T) {
+	ja := &JWTAuth{SignKey: TestSignKey, logger: testLogger}
+	assert.Nil(t, ja.Validate())
+	r, _ := http.NewRequest("GET", "/", nil)
+	r.Header.Add("Authorization", issueTokenString(MapClaims{"sub": "ggicci", "iss": "https://api.example.com", "exp": 689702400}))
+	_, _, err := ja.Authenticate(httptest.NewRecorder(), r)
+	assert.NotNil(t, err)
+
+	rw := httptest.NewRecorder()
+	r, _ = http.NewRequest("GET", "/jwtauth/stats?window=1m", nil)
+	assert.Nil(t, adminAPI{}.handleStats(rw, r))
+	assert.Equal(t, "application/json", rw.Header().Get("Content-Type"))
+	assert.Contains(t, rw.Body.String(), `"token_expired"`)
+	assert.Contains(t, rw.Body.String(), `"https://api.example.com"`)
+
+	r, _ = http.NewRequest("GET", "/jwtauth/stats?window=abc", nil)
+	assert.NotNil(t, adminAPI{}.handleStats(httptest.NewRecorder(), r))
+
+	r, _ = http.NewRequest("POST", "/jwtauth/stats", nil)
+	assert.NotNil(t, adminAPI{}.handleStats(httptest.NewRecorder(), r))
+}
@@ -0,0 +1,39 @@
+package caddyjwt
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// compileSubjectPattern compiles a pattern of SubjectPattern, or of
+// ExceptPaths. A pattern
+// starting with "^" or ending with "$" is a regular expression, otherwise
+// it's a glob pattern, in which "*" matches any sequence of characters,
+// including "/", and "?" matches any single character.
+func compileSubjectPattern(pattern string) (*regexp.Regexp, error) {
+	if pattern == "" {
+		return nil, fmt.Errorf("empty pattern")
+	}
+	if strings.HasPrefix(pattern, "^") || strings.HasSuffix(pattern, "$") {
+		return regexp.Compile(pattern)
+	}
+	expr := regexp.QuoteMeta(pattern)
+	expr = strings.ReplaceAll(expr, `\*`, ".*")
+	expr = strings.ReplaceAll(expr, `\?`, ".")
+	return regexp.Compile("^" + expr + "$")
+}
+
+// checkSubject verifies the "sub" claim of the token against SubjectPattern.
+func (ja *JWTAuth) checkSubject(token Token) error {
+	if len(ja.subjectPatterns) == 0 {
+		return nil
+	}
+	sub := token.Subject()
+	for _, pattern := range ja.subjectPatterns {
+		if pattern.MatchString(sub) {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: %q", ErrSubjectMismatch, sub)
+}
@@ -0,0 +1,55 @@
+package caddyjwt
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCompileSubjectPattern(t *testing.T) {
+	var testCases = []struct {
+		Pattern string
+		Subject string
+		Match   bool
+	}{
+		{"spiffe://prod/*", "spiffe://prod/ns/default/sa/api", true},
+		{"spiffe://prod/*", "spiffe://staging/ns/default/sa/api", false},
+		{"user-?", "user-1", true},
+		{"user-?", "user-12", false},
+		{"a.b", "axb", false}, // not a regexp
+		{"^[0-9]+$", "3406327963516932", true},
+		{"^[0-9]+$", "ggicci", false},
+	}
+	for _, c := range testCases {
+		re, err := compileSubjectPattern(c.Pattern)
+		assert.Nil(t, err)
+		assert.Equal(t, c.Match, re.MatchString(c.Subject), c.Pattern+" "+c.Subject)
+	}
+
+	_, err := compileSubjectPattern("^[0-9+$")
+	assert.Error(t, err)
+}
+
+func TestAuthenticate_SubjectPattern(t *testing.T) {
+	ja := &JWTAuth{
+		SignKey:        TestSignKey,
+		SubjectPattern: []string{"^[0-9]+$"},
+		logger:         testLogger,
+	}
+	assert.Nil(t, ja.Validate())
+
+	r, _ := http.NewRequest("GET", "/", nil)
+	r.Header.Add("Authorization", issueTokenString(MapClaims{"sub": "3406327963516932"}))
+	gotUser, authenticated, err := ja.Authenticate(httptest.NewRecorder(), r)
+	assert.Nil(t, err)
+	assert.True(t, authenticated)
+	assert.Equal(t, "3406327963516932", gotUser.ID)
+
+	r, _ = http.NewRequest("GET", "/", nil)
+	r.Header.Add("Authorization", issueTokenString(MapClaims{"sub": "ggicci"}))
+	_, authenticated, err = ja.Authenticate(httptest.NewRecorder(), r)
+	assert.ErrorIs(t, err, ErrSubjectMismatch)
+	assert.False(t, authenticated)
+}
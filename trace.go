@@ -0,0 +1,65 @@
+package caddyjwt
+
+import (
+	"crypto/subtle"
+	"net/http"
+
+	"go.uber.org/zap"
+)
+
+// traceStep is a check run on a token, see JWTAuth.PolicyTrace.
+type traceStep struct {
+	Check   string      `json:"check"`
+	Input   interface{} `json:"input,omitempty"`
+	Outcome string      `json:"outcome"` // "pass" or "fail"
+	Error   string      `json:"error,omitempty"`
+}
+
+// policyTrace records the checks run on a token in order. A nil trace
+// records nothing.
+type policyTrace struct {
+	steps []traceStep
+}
+
+// tracing returns a new trace for a token of the request if PolicyTrace is
+// on, or the request carries the PolicyTraceHeader with the secret,
+// otherwise nil.
+func (ja *JWTAuth) tracing(r *http.Request) *policyTrace {
+	if ja.PolicyTrace || ja.privilegedTrace(r) {
+		return &policyTrace{}
+	}
+	return nil
+}
+
+func (ja *JWTAuth) privilegedTrace(r *http.Request) bool {
+	if ja.PolicyTraceHeader == "" {
+		return false
+	}
+	secret := r.Header.Get(ja.PolicyTraceHeader)
+	return secret != "" && subtle.ConstantTimeCompare([]byte(secret), []byte(ja.PolicyTraceSecret)) == 1
+}
+
+// record appends a step of the check with the input and the outcome err.
+// The input is only evaluated if tracing, to keep the untraced requests free
+// of its allocations.
+func (t *policyTrace) record(check string, input func() interface{}, err error) {
+	if t == nil {
+		return
+	}
+	step := traceStep{Check: check, Outcome: "pass"}
+	if input != nil {
+		step.Input = input()
+	}
+	if err != nil {
+		step.Outcome, step.Error = "fail", err.Error()
+	}
+	t.steps = append(t.steps, step)
+}
+
+// field returns the log field of the trace, or a no-op one if nil.
+func (t *policyTrace) field() zap.Field {
+	if t == nil {
+		return zap.Skip()
+	}
+	return zap.Any("policy_trace", t.steps)
+}
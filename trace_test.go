@@ -0,0 +1,78 @@
+package caddyjwt
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+func TestAuthenticate_PolicyTrace(t *testing.T) {
+	core, logs := observer.New(zap.InfoLevel)
+	ja := &JWTAuth{
+		SignKey:           TestSignKey,
+		IssuerWhitelist:   []string{"https://issuer.example.com"},
+		Require:           []ClaimRequirement{{Claim: "role", Values: []string{"admin"}}},
+		PolicyTraceHeader: "X-Debug-Trace",
+		PolicyTraceSecret: "s3cr3t",
+		logger:            zap.New(core),
+	}
+	assert.Nil(t, ja.Validate())
+
+	authenticate := func(secret string) *http.Request {
+		r, _ := http.NewRequest("GET", "/", nil)
+		r.Header.Add("Authorization", issueTokenString(MapClaims{"sub": "ggicci", "iss": "https://issuer.example.com", "role": "user"}))
+		if secret != "" {
+			r.Header.Set("X-Debug-Trace", secret)
+		}
+		ja.Authenticate(httptest.NewRecorder(), r)
+		return r
+	}
+
+	// not privileged
+	authenticate("guess")
+	entries := logs.TakeAll()
+	assert.NotEmpty(t, entries)
+	for _, entry := range entries {
+		assert.NotContains(t, entry.ContextMap(), "policy_trace")
+	}
+
+	r := authenticate("s3cr3t")
+	assert.Empty(t, r.Header.Get("X-Debug-Trace"))
+	entries = logs.FilterMessage("invalid token").All()
+	logs.TakeAll()
+	assert.Len(t, entries, 1)
+	var steps []traceStep
+	for _, field := range entries[0].Context {
+		if field.Key == "policy_trace" {
+			steps = field.Interface.([]traceStep)
+		}
+	}
+	var checks []string
+	for _, step := range steps {
+		checks = append(checks, step.Check+":"+step.Outcome)
+	}
+	assert.Equal(t, []string{
+		"signature:pass", "standard_claims:pass", "issuer:pass", "subject:pass",
+		"principal_type:pass", "user_claims:pass", "claims_schema:pass",
+		"claim_policy:pass", "require:fail",
+	}, checks)
+	assert.Equal(t, []ClaimRequirement{{Claim: "role", Values: []string{"admin"}}}, steps[8].Input)
+	assert.Contains(t, steps[8].Error, "claim policy not satisfied")
+
+	// on for all
+	ja.PolicyTraceHeader = ""
+	ja.PolicyTrace = true
+	authenticate("")
+	entries = logs.FilterMessage("invalid token").TakeAll()
+	assert.Len(t, entries, 1)
+	assert.Contains(t, entries[0].ContextMap(), "policy_trace")
+}
+
+func TestValidate_PolicyTraceHeader(t *testing.T) {
+	ja := &JWTAuth{SignKey: TestSignKey, PolicyTraceHeader: "X-Debug-Trace", logger: testLogger}
+	assert.ErrorContains(t, ja.Validate(), "missing secret")
+}
@@ -0,0 +1,172 @@
+package caddyjwt
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"golang.org/x/net/http/httpguts"
+)
+
+// UpstreamBasicAuth defines how to build the basic auth credentials for the
+// upstream from the claims of an authenticated token.
+type UpstreamBasicAuth struct {
+	// UsernameClaim is the claim whose value is used as the username.
+	// Defaults to the ID of the authenticated user.
+	UsernameClaim string `json:"username_claim"`
+
+	// PasswordClaim is the claim whose value is used as the password.
+	PasswordClaim string `json:"password_claim"`
+
+	// Password is a static password, used when PasswordClaim is not set.
+	Password string `json:"password"`
+}
+
+// setUpstreamBasicAuth replaces the Authorization header of the request with
+// the basic auth credentials defined by UpstreamBasicAuth.
+func (ja *JWTAuth) setUpstreamBasicAuth(r *http.Request, result *authResult) {
+	ba := ja.UpstreamBasicAuth
+	if ba == nil {
+		return
+	}
+
+	username := result.user.ID
+	if ba.UsernameClaim != "" {
+		val, _ := getClaim(result.token, ba.UsernameClaim)
+		username = stringify(val)
+	}
+	password := ba.Password
+	if ba.PasswordClaim != "" {
+		val, _ := getClaim(result.token, ba.PasswordClaim)
+		password = stringify(val)
+	}
+	r.SetBasicAuth(username, password)
+}
+
+// setMatchedAudienceHeader sets the MatchedAudienceHeader of the request.
+func (ja *JWTAuth) setMatchedAudienceHeader(r *http.Request, result *authResult) {
+	if ja.MatchedAudienceHeader == "" {
+		return
+	}
+	if result.matchedAudience == "" {
+		r.Header.Del(ja.MatchedAudienceHeader)
+		return
+	}
+	r.Header.Set(ja.MatchedAudienceHeader, result.matchedAudience)
+}
+
+// validateForwardClaimsHeader checks that each header of ForwardClaimsHeader
+// is a valid name and is mapped from one claim only.
+func validateForwardClaimsHeader(headers map[string]string) error {
+	claimOf := make(map[string]string)
+	for claim, header := range headers {
+		if claim == "" || header == "" {
+			return fmt.Errorf("%s -> %s", claim, header)
+		}
+		if !httpguts.ValidHeaderFieldName(header) {
+			return fmt.Errorf("invalid header name %q", header)
+		}
+		canonical := http.CanonicalHeaderKey(header)
+		if other, ok := claimOf[canonical]; ok {
+			return fmt.Errorf("header %q mapped from both %q and %q", header, other, claim)
+		}
+		claimOf[canonical] = claim
+	}
+	return nil
+}
+
+// setForwardedClaimsHeaders replaces the headers of ForwardClaimsHeader of
+// the request with the values of the claims.
+func (ja *JWTAuth) setForwardedClaimsHeaders(r *http.Request, result *authResult) {
+	for claim, header := range ja.ForwardClaimsHeader {
+		r.Header.Del(header)
+		val, ok := getClaim(result.token, claim)
+		if !ok {
+			continue
+		}
+		if value := ja.stringifyClaim(val); value != "" {
+			r.Header.Set(header, value)
+		}
+	}
+}
+
+// stripTokens removes the tokens from the request, i.e. the headers, the
+// query parameters, the cookies and the form fields of all the sources, see
+// StripToken.
+func (ja *JWTAuth) stripTokens(r *http.Request) {
+	if !ja.StripToken {
+		return
+	}
+	headers := append([]string{"Authorization"}, ja.FromHeader...)
+	if ja.ContextToken != nil {
+		headers = append(headers, ja.ContextToken.Header)
+	}
+	if ja.ALBOIDCData != nil {
+		headers = append(headers, ja.ALBOIDCData.Header)
+	}
+	for _, name := range headers {
+		r.Header.Del(name)
+	}
+
+	stripQueryParams(r, ja.FromQuery)
+
+	cookies := append([]string{}, ja.FromCookies...)
+	if ja.IssueSessionCookie != nil {
+		cookies = append(cookies, ja.IssueSessionCookie.Name)
+	}
+	stripCookies(r, cookies)
+	stripTokensFromBody(r, ja.FromBody)
+}
+
+// stripQueryParams removes the query parameters of the names from the
+// request, also from the form if parsed. It reports whether any was present.
+func stripQueryParams(r *http.Request, names []string) bool {
+	if len(names) == 0 {
+		return false
+	}
+	query := r.URL.Query()
+	stripped := false
+	for _, name := range names {
+		if query.Has(name) {
+			query.Del(name)
+			stripped = true
+		}
+		if r.Form != nil {
+			r.Form.Del(name)
+		}
+	}
+	if stripped {
+		r.URL.RawQuery = query.Encode()
+		r.RequestURI = r.URL.RequestURI()
+	}
+	return stripped
+}
+
+// stripCookies removes the cookies of the names from the Cookie header of
+// the request.
+func stripCookies(r *http.Request, names []string) {
+	if len(names) == 0 {
+		return
+	}
+	strip := make(map[string]struct{}, len(names))
+	for _, name := range names {
+		strip[name] = struct{}{}
+	}
+	var kept []string
+	stripped := false
+	for _, ck := range r.Cookies() {
+		if _, ok := strip[ck.Name]; ok {
+			stripped = true
+			continue
+		}
+		kept = append(kept, ck.Name+"="+ck.Value)
+	}
+	if !stripped {
+		return
+	}
+	if len(kept) == 0 {
+		r.Header.Del("Cookie")
+		return
+	}
+	r.Header.Set("Cookie", strings.Join(kept, "; "))
+}
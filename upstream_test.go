@@ -0,0 +1,162 @@
+package caddyjwt
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAuthenticate_UpstreamBasicAuth(t *testing.T) {
+	ja := &JWTAuth{
+		SignKey:           TestSignKey,
+		UpstreamBasicAuth: &UpstreamBasicAuth{Password: "secret"},
+		logger:            testLogger,
+	}
+	assert.Nil(t, ja.Validate())
+
+	// static password, username defaults to the user ID
+	r, _ := http.NewRequest("GET", "/", nil)
+	r.Header.Add("Authorization", issueTokenString(MapClaims{"sub": "ggicci"}))
+	_, authenticated, err := ja.Authenticate(httptest.NewRecorder(), r)
+	assert.Nil(t, err)
+	assert.True(t, authenticated)
+	username, password, ok := r.BasicAuth()
+	assert.True(t, ok)
+	assert.Equal(t, "ggicci", username)
+	assert.Equal(t, "secret", password)
+
+	// from claims
+	ja.UpstreamBasicAuth = &UpstreamBasicAuth{UsernameClaim: "legacy.user", PasswordClaim: "legacy.pass"}
+	assert.Nil(t, ja.Validate())
+	r, _ = http.NewRequest("GET", "/", nil)
+	r.Header.Add("Authorization", issueTokenString(MapClaims{
+		"sub":    "ggicci",
+		"legacy": map[string]interface{}{"user": "admin", "pass": "p@ss"},
+	}))
+	_, authenticated, err = ja.Authenticate(httptest.NewRecorder(), r)
+	assert.Nil(t, err)
+	assert.True(t, authenticated)
+	username, password, ok = r.BasicAuth()
+	assert.True(t, ok)
+	assert.Equal(t, "admin", username)
+	assert.Equal(t, "p@ss", password)
+
+	// mutually exclusive
+	ja.UpstreamBasicAuth = &UpstreamBasicAuth{PasswordClaim: "pass", Password: "secret"}
+	assert.ErrorContains(t, ja.Validate(), "upstream_basic_auth")
+}
+
+func TestAuthenticate_MatchedAudience(t *testing.T) {
+	ja := &JWTAuth{
+		SignKey:               TestSignKey,
+		AudienceWhitelist:     []string{"https://api.codelet.io", "https://api.copilot.codelet.io"},
+		MatchedAudienceHeader: "X-Matched-Aud",
+		logger:                testLogger,
+	}
+	assert.Nil(t, ja.Validate())
+
+	r, repl := newRequestWithReplacer("GET", "/")
+	r.Header.Add("Authorization", issueTokenString(MapClaims{
+		"sub": "ggicci",
+		"aud": []string{"https://api.learn.codelet.io", "https://api.copilot.codelet.io"},
+	}))
+	r.Header.Add("X-Matched-Aud", "spoofed")
+	_, authenticated, err := ja.Authenticate(httptest.NewRecorder(), r)
+	assert.Nil(t, err)
+	assert.True(t, authenticated)
+	assert.Equal(t, "https://api.copilot.codelet.io", r.Header.Get("X-Matched-Aud"))
+	matchedAud, _ := repl.Get("http.auth.jwt.matched_aud")
+	assert.Equal(t, "https://api.copilot.codelet.io", matchedAud)
+}
+
+func TestAuthenticate_ForwardClaimsHeader(t *testing.T) {
+	ja := &JWTAuth{
+		SignKey:             TestSignKey,
+		ForwardClaimsHeader: map[string]string{"sub": "X-User-Id", "org.roles": "X-User-Roles", "aud": "X-User-Aud", "email": "X-User-Email"},
+		logger:              testLogger,
+	}
+	assert.Nil(t, ja.Validate())
+
+	r, _ := http.NewRequest("GET", "/", nil)
+	r.Header.Add("Authorization", issueTokenString(MapClaims{
+		"sub": "ggicci",
+		"aud": []string{"api", "web"},
+		"org": map[string]interface{}{"roles": []interface{}{"admin", "dev"}},
+	}))
+	r.Header.Set("X-User-Id", "spoofed")
+	r.Header.Set("X-User-Email", "spoofed@example.com")
+	_, authenticated, err := ja.Authenticate(httptest.NewRecorder(), r)
+	assert.Nil(t, err)
+	assert.True(t, authenticated)
+	assert.Equal(t, "ggicci", r.Header.Get("X-User-Id"))
+	assert.Equal(t, "admin,dev", r.Header.Get("X-User-Roles"))
+	assert.Equal(t, "api,web", r.Header.Get("X-User-Aud"))
+	_, ok := r.Header["X-User-Email"]
+	assert.False(t, ok, "client supplied header of an absent claim is removed")
+}
+
+func TestValidateForwardClaimsHeader(t *testing.T) {
+	assert.Nil(t, validateForwardClaimsHeader(map[string]string{"sub": "X-User-Id"}))
+	assert.ErrorContains(t, validateForwardClaimsHeader(map[string]string{"sub": "X User"}), "invalid header name")
+	assert.ErrorContains(t, validateForwardClaimsHeader(map[string]string{"sub": "X-User", "uid": "x-user"}), "mapped from both")
+}
+
+func TestAuthenticate_StripToken(t *testing.T) {
+	ja := &JWTAuth{
+		SignKey:     TestSignKey,
+		FromQuery:   []string{"access_token"},
+		FromHeader:  []string{"X-Api-Token"},
+		FromCookies: []string{"user_session"},
+		FromBody:    []string{"token"},
+		StripToken:  true,
+		logger:      testLogger,
+	}
+	assert.Nil(t, ja.Validate())
+	token := issueTokenString(MapClaims{"sub": "ggicci"})
+
+	r, _ := http.NewRequest("GET", "/?access_token="+token+"&page=2", nil)
+	r.Header.Set("Authorization", token)
+	r.Header.Set("X-Api-Token", token)
+	r.Header.Set("Cookie", "theme=dark; user_session="+token)
+	_, authenticated, err := ja.Authenticate(httptest.NewRecorder(), r)
+	assert.Nil(t, err)
+	assert.True(t, authenticated)
+	assert.Empty(t, r.Header.Get("Authorization"))
+	assert.Empty(t, r.Header.Get("X-Api-Token"))
+	assert.Equal(t, "page=2", r.URL.RawQuery)
+	assert.Equal(t, "theme=dark", r.Header.Get("Cookie"))
+
+	ja.FromQuery = nil
+	assert.Nil(t, ja.Validate())
+	r, _ = http.NewRequest("POST", "/", strings.NewReader("token="+token+"&name=ggicci"))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	_, authenticated, err = ja.Authenticate(httptest.NewRecorder(), r)
+	assert.Nil(t, err)
+	assert.True(t, authenticated)
+	body, _ := io.ReadAll(r.Body)
+	assert.Equal(t, "name=ggicci", string(body))
+	assert.Equal(t, int64(len(body)), r.ContentLength)
+
+	// the basic auth for the upstream survives
+	ja.UpstreamBasicAuth = &UpstreamBasicAuth{Password: "secret"}
+	assert.Nil(t, ja.Validate())
+	r, _ = http.NewRequest("GET", "/", nil)
+	r.Header.Set("Authorization", token)
+	_, authenticated, err = ja.Authenticate(httptest.NewRecorder(), r)
+	assert.Nil(t, err)
+	assert.True(t, authenticated)
+	username, _, ok := r.BasicAuth()
+	assert.True(t, ok)
+	assert.Equal(t, "ggicci", username)
+
+	// kept on failures
+	r, _ = http.NewRequest("GET", "/", nil)
+	r.Header.Set("Authorization", "invalid")
+	_, authenticated, _ = ja.Authenticate(httptest.NewRecorder(), r)
+	assert.False(t, authenticated)
+	assert.Equal(t, "invalid", r.Header.Get("Authorization"))
+}
@@ -0,0 +1,98 @@
+package caddyjwt
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// userIDFormat is the compiled UserIDFormat, of the literal text around the
+// claims, i.e. one more literal than claims.
+type userIDFormat struct {
+	literals []string
+	claims   []string
+}
+
+// compileUserIDFormat compiles the format of the placeholders of the claims,
+// e.g. "{iss}|{sub}". Returns nil if the format is empty.
+func compileUserIDFormat(format string) (*userIDFormat, error) {
+	if format == "" {
+		return nil, nil
+	}
+	f := &userIDFormat{}
+	rest := format
+	for {
+		open := strings.IndexByte(rest, '{')
+		if open < 0 {
+			if strings.IndexByte(rest, '}') >= 0 {
+				return nil, fmt.Errorf("%q: unopened }", format)
+			}
+			f.literals = append(f.literals, rest)
+			break
+		}
+		if strings.IndexByte(rest[:open], '}') >= 0 {
+			return nil, fmt.Errorf("%q: unopened }", format)
+		}
+		end := strings.IndexByte(rest[open:], '}')
+		if end < 0 {
+			return nil, fmt.Errorf("%q: unclosed {", format)
+		}
+		claim := rest[open+1 : open+end]
+		if claim == "" || strings.IndexByte(claim, '{') >= 0 {
+			return nil, fmt.Errorf("%q: invalid placeholder {%s}", format, claim)
+		}
+		f.literals = append(f.literals, rest[:open])
+		f.claims = append(f.claims, claim)
+		rest = rest[open+end+1:]
+	}
+	if len(f.claims) == 0 {
+		return nil, fmt.Errorf("%q: no claim placeholder, e.g. {sub}", format)
+	}
+	return f, nil
+}
+
+// format returns the user ID of the token, or "" if any of the claims is
+// missing or empty, or not a string or a number.
+func (f *userIDFormat) format(token Token) string {
+	var sb strings.Builder
+	for i, claim := range f.claims {
+		val, _ := getClaim(token, claim)
+		var s string
+		switch v := val.(type) {
+		case string:
+			s = v
+		case float64:
+			s = strconv.FormatFloat(v, 'f', -1, 64)
+		case json.Number:
+			s = v.String()
+		}
+		if s == "" {
+			return ""
+		}
+		sb.WriteString(f.literals[i])
+		sb.WriteString(s)
+	}
+	sb.WriteString(f.literals[len(f.claims)])
+	return sb.String()
+}
+
+// userID returns the ID of the user of the token, by UserIDFormat or else
+// by UserClaims, and the claim or the format it came from.
+func (ja *JWTAuth) userID(token Token) (string, string) {
+	if ja.userIDFormat != nil {
+		if id := ja.userIDFormat.format(token); id != "" {
+			return ja.UserIDFormat, id
+		}
+		return "", ""
+	}
+	return getUserID(token, ja.UserClaims)
+}
+
+// userIDSpec is what the user ID is looked up by, for the logs.
+func (ja *JWTAuth) userIDSpec() interface{} {
+	if ja.userIDFormat != nil {
+		return ja.UserIDFormat
+	}
+	return ja.UserClaims
+}
@@ -0,0 +1,80 @@
+package caddyjwt
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"github.com/caddyserver/caddy/v2/caddyconfig/httpcaddyfile"
+	"github.com/caddyserver/caddy/v2/modules/caddyhttp/caddyauth"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCompileUserIDFormat(t *testing.T) {
+	f, err := compileUserIDFormat("tenant:{tid}:{oid}")
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"tenant:", ":", ""}, f.literals)
+	assert.Equal(t, []string{"tid", "oid"}, f.claims)
+
+	f, err = compileUserIDFormat("")
+	assert.Nil(t, err)
+	assert.Nil(t, f)
+
+	for format, wantErr := range map[string]string{
+		"sub":        "no claim placeholder",
+		"{iss}|{sub": "unclosed {",
+		"{iss}}":     "unopened }",
+		"}{sub}":     "unopened }",
+		"{}":         "invalid placeholder",
+		"{{sub}}":    "invalid placeholder",
+	} {
+		_, err := compileUserIDFormat(format)
+		assert.ErrorContains(t, err, wantErr, format)
+	}
+}
+
+func TestAuthenticate_UserIDFormat(t *testing.T) {
+	ja := &JWTAuth{SignKey: TestSignKey, UserIDFormat: "{iss}|{sub}:{org.id}", logger: testLogger}
+	assert.Nil(t, ja.Validate())
+	assert.Empty(t, ja.UserClaims)
+
+	authenticate := func(claims MapClaims) (User, bool, error) {
+		r, _ := http.NewRequest("GET", "/", nil)
+		r.Header.Set("Authorization", "Bearer "+issueTokenString(claims))
+		return ja.Authenticate(httptest.NewRecorder(), r)
+	}
+
+	user, authenticated, err := authenticate(MapClaims{"iss": "https://idp.example.com", "sub": "ggicci", "org": map[string]interface{}{"id": 42}})
+	assert.Nil(t, err)
+	assert.True(t, authenticated)
+	assert.Equal(t, "https://idp.example.com|ggicci:42", user.ID)
+
+	// any claim missing or empty
+	_, authenticated, err = authenticate(MapClaims{"iss": "https://idp.example.com", "sub": "ggicci"})
+	assert.False(t, authenticated)
+	assert.ErrorIs(t, err, ErrEmptyUserClaim)
+	_, _, err = authenticate(MapClaims{"iss": "", "sub": "ggicci", "org": map[string]interface{}{"id": 42}})
+	assert.ErrorIs(t, err, ErrEmptyUserClaim)
+
+	ja = &JWTAuth{SignKey: TestSignKey, UserIDFormat: "{sub}", UserClaims: []string{"uid"}}
+	assert.ErrorContains(t, ja.Validate(), "mutually exclusive")
+	ja = &JWTAuth{SignKey: TestSignKey, UserIDFormat: "{sub"}
+	assert.ErrorContains(t, ja.Validate(), "invalid user_id_format")
+}
+
+func TestParsingCaddyfileUserIDFormat(t *testing.T) {
+	h, err := parseCaddyfile(httpcaddyfile.Helper{Dispenser: caddyfile.NewTestDispenser(`jwtauth {
+		user_id_format tenant:{tid}:{oid}
+	}`)})
+	assert.Nil(t, err)
+	var ja JWTAuth
+	assert.Nil(t, json.Unmarshal(h.(caddyauth.Authentication).ProvidersRaw["jwt"], &ja))
+	assert.Equal(t, "tenant:{tid}:{oid}", ja.UserIDFormat)
+
+	_, err = parseCaddyfile(httpcaddyfile.Helper{Dispenser: caddyfile.NewTestDispenser(`jwtauth {
+		user_id_format {iss} {sub}
+	}`)})
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,186 @@
+package caddyjwt
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+	"go.uber.org/zap"
+	"golang.org/x/sync/singleflight"
+)
+
+// UserInfoEnrichment calls the OIDC userinfo endpoint of the issuer with the
+// bearer token, see https://openid.net/specs/openid-connect-core-1_0.html#UserInfo,
+// and merges the selected claims into the user metadata.
+type UserInfoEnrichment struct {
+	// Endpoint is the URL of the userinfo endpoint. If empty, it will be
+	// discovered from "<iss>/.well-known/openid-configuration" of the
+	// issuer of the token.
+	Endpoint string `json:"endpoint"`
+
+	// Claims defines a map to populate the metadata from the returned
+	// claims, the same as MetaClaims. Nested claims can be accessed by dot
+	// notation. Required.
+	Claims map[string]string `json:"claims"`
+
+	// Timeout is the timeout of each call. Defaults to 2s.
+	Timeout caddy.Duration `json:"timeout"`
+
+	// CacheTTL is how long the claims of a subject will be cached.
+	// Defaults to 5m.
+	CacheTTL caddy.Duration `json:"cache_ttl"`
+
+	// CacheMaxBytes bounds the approximate bytes taken by the cache of the
+	// claims. Defaults to 0, bounded by the number of entries only.
+	CacheMaxBytes int `json:"cache_max_bytes"`
+
+	// Required makes the authentication fail if the userinfo request fails.
+	Required bool `json:"required"`
+
+	client    *http.Client
+	cache     *ttlCache // keyed by "<iss>|<sub>"
+	group     singleflight.Group
+	endpoints *ttlCache // discovered endpoints, keyed by issuer
+}
+
+func (u *UserInfoEnrichment) provision() error {
+	if len(u.Claims) == 0 {
+		return fmt.Errorf("missing claims")
+	}
+	for claim, placeholder := range u.Claims {
+		if claim == "" || placeholder == "" {
+			return fmt.Errorf("invalid claim: %s -> %s", claim, placeholder)
+		}
+	}
+	if u.Timeout == 0 {
+		u.Timeout = caddy.Duration(2 * time.Second)
+	}
+	if u.CacheTTL == 0 {
+		u.CacheTTL = caddy.Duration(5 * time.Minute)
+	}
+	if u.CacheMaxBytes < 0 {
+		return fmt.Errorf("invalid cache_max_bytes: %d", u.CacheMaxBytes)
+	}
+	u.client = &http.Client{Timeout: time.Duration(u.Timeout)}
+	u.cache = newTTLCache(0, u.CacheMaxBytes)
+	registerSubjectCache(u.cache, func(key, sub string) bool { return strings.HasSuffix(key, "|"+sub) })
+	u.endpoints = newTTLCache(0, 0)
+	return nil
+}
+
+// mergeUserInfo merges the claims from the userinfo endpoint into the user
+// metadata.
+func (ja *JWTAuth) mergeUserInfo(ctx context.Context, logger *zap.Logger, result *authResult) error {
+	u := ja.UserInfo
+	if u == nil {
+		return nil
+	}
+	claims, err := u.claims(ctx, result)
+	if err != nil {
+		logger.Error("userinfo request failed", zap.String("id", result.user.ID), zap.Error(err))
+		if u.Required {
+			return fmt.Errorf("%w: %v", ErrUserInfoFailed, err)
+		}
+		return nil
+	}
+	if result.user.Metadata == nil {
+		result.user.Metadata = make(map[string]string)
+	}
+	for placeholder, value := range claims {
+		result.user.Metadata[placeholder] = value
+	}
+	return nil
+}
+
+func (u *UserInfoEnrichment) cleanup() {
+	unregisterSubjectCache(u.cache)
+}
+
+// claims returns the userinfo claims of the subject, from the cache if
+// possible. Concurrent cache misses of the same subject share one call.
+func (u *UserInfoEnrichment) claims(ctx context.Context, result *authResult) (map[string]string, error) {
+	cacheKey := result.token.Issuer() + "|" + result.token.Subject()
+	if cached, ok := u.cache.Get(cacheKey); ok {
+		return cached.(map[string]string), nil
+	}
+	v, err, _ := u.group.Do(cacheKey, func() (interface{}, error) {
+		return u.fetch(ctx, result, cacheKey)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return v.(map[string]string), nil
+}
+
+func (u *UserInfoEnrichment) fetch(ctx context.Context, result *authResult, cacheKey string) (map[string]string, error) {
+	issuer := result.token.Issuer()
+
+	endpoint, err := u.endpoint(ctx, issuer)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+result.raw)
+	var fields map[string]interface{}
+	if err := u.getJSON(req, &fields); err != nil {
+		return nil, err
+	}
+
+	claims := make(map[string]string, len(u.Claims))
+	for claim, placeholder := range u.Claims {
+		val, ok := fields[claim]
+		if !ok && strings.Contains(claim, ".") {
+			val, _ = queryNested(fields, strings.Split(claim, "."))
+		}
+		claims[placeholder] = stringify(val)
+	}
+	u.cache.Set(cacheKey, claims, time.Duration(u.CacheTTL))
+	return claims, nil
+}
+
+// endpoint returns the userinfo endpoint, discovering it from the issuer if
+// not configured.
+func (u *UserInfoEnrichment) endpoint(ctx context.Context, issuer string) (string, error) {
+	if u.Endpoint != "" {
+		return u.Endpoint, nil
+	}
+	if issuer == "" {
+		return "", fmt.Errorf("missing iss to discover the userinfo endpoint")
+	}
+	if cached, ok := u.endpoints.Get(issuer); ok {
+		return cached.(string), nil
+	}
+
+	discovery, err := fetchOIDCConfiguration(ctx, u.client, issuer)
+	if err != nil {
+		return "", fmt.Errorf("discover userinfo endpoint: %w", err)
+	}
+	if discovery.UserInfoEndpoint == "" {
+		return "", fmt.Errorf("issuer %q publishes no userinfo_endpoint", issuer)
+	}
+	u.endpoints.Set(issuer, discovery.UserInfoEndpoint, time.Hour)
+	return discovery.UserInfoEndpoint, nil
+}
+
+func (u *UserInfoEnrichment) getJSON(req *http.Request, v interface{}) error {
+	req.Header.Set("Accept", "application/json")
+	resp, err := u.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status: %s", resp.Status)
+	}
+	decoder := json.NewDecoder(io.LimitReader(resp.Body, maxEnrichResponseSize))
+	decoder.UseNumber()
+	return decoder.Decode(v)
+}
@@ -0,0 +1,65 @@
+package caddyjwt
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAuthenticate_UserInfo(t *testing.T) {
+	var calls int32
+	mux := http.NewServeMux()
+	server := httptest.NewServer(mux)
+	defer server.Close()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{"userinfo_endpoint": server.URL + "/userinfo"})
+	})
+	mux.HandleFunc("/userinfo", func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		if r.Header.Get("Authorization") == "" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"sub":     "ggicci",
+			"email":   "ggicci@example.com",
+			"address": map[string]string{"country": "CN"},
+		})
+	})
+
+	ja := &JWTAuth{
+		SignKey: TestSignKey,
+		UserInfo: &UserInfoEnrichment{
+			Claims: map[string]string{"email": "email", "address.country": "country"},
+		},
+		logger: testLogger,
+	}
+	assert.Nil(t, ja.Validate())
+
+	for i := 0; i < 2; i++ {
+		r, _ := http.NewRequest("GET", "/", nil)
+		r.Header.Add("Authorization", issueTokenString(MapClaims{"sub": "ggicci", "iss": server.URL}))
+		gotUser, authenticated, err := ja.Authenticate(httptest.NewRecorder(), r)
+		assert.Nil(t, err)
+		assert.True(t, authenticated)
+		assert.Equal(t, map[string]string{"email": "ggicci@example.com", "country": "CN"}, gotUser.Metadata)
+	}
+	assert.Equal(t, int32(1), atomic.LoadInt32(&calls)) // cached
+
+	// discovery fails: required
+	ja.UserInfo.Required = true
+	r, _ := http.NewRequest("GET", "/", nil)
+	r.Header.Add("Authorization", issueTokenString(MapClaims{"sub": "ggicci"}))
+	_, authenticated, err := ja.Authenticate(httptest.NewRecorder(), r)
+	assert.ErrorIs(t, err, ErrUserInfoFailed)
+	assert.False(t, authenticated)
+}
+
+func TestUserInfoEnrichment_Provision(t *testing.T) {
+	u := &UserInfoEnrichment{}
+	assert.ErrorContains(t, u.provision(), "missing claims")
+}
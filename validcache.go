@@ -0,0 +1,171 @@
+package caddyjwt
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/lestrrat-go/jwx/v2/jws"
+	"github.com/lestrrat-go/jwx/v2/jwt"
+)
+
+// ValidationCache caches the outcomes of the signature verification of the
+// tokens, keyed by the hash of the tokens, so the same bearer token
+// presented thousands of times per minute is verified once. The claims are
+// still checked on every request.
+//
+// A verified token is cached until its "exp", at most MaxTTL, so a key
+// removed from the JWKs keeps verifying the cached tokens for up to MaxTTL.
+// The blocked key IDs apply to the cached tokens at once. A token failing
+// the verification, e.g. of a bad signature, is cached for NegativeTTL,
+// but not if its key is not found or blocked, so the rotated keys apply, nor
+// if the verification didn't complete, e.g. timed out in the sandbox.
+type ValidationCache struct {
+	// MaxEntries bounds the number of the cached tokens, the least recently
+	// used evicted first. Defaults to 10000.
+	MaxEntries int `json:"max_entries"`
+
+	// MaxTTL caps how long a verified token is cached. Defaults to 5m.
+	MaxTTL caddy.Duration `json:"max_ttl"`
+
+	// NegativeTTL is how long a token failing the verification is cached.
+	// Defaults to 10s. Negative values disable the negative caching.
+	NegativeTTL caddy.Duration `json:"negative_ttl"`
+
+	cache *ttlCache
+}
+
+// verification is the outcome of the signature verification of a token.
+type verification struct {
+	token      Token
+	provenance keyProvenance
+	err        error
+}
+
+func (vc *ValidationCache) provision() error {
+	if vc.MaxEntries < 0 {
+		return fmt.Errorf("invalid max_entries: %d", vc.MaxEntries)
+	}
+	if vc.MaxTTL < 0 {
+		return fmt.Errorf("invalid max_ttl: %s", time.Duration(vc.MaxTTL))
+	}
+	if vc.MaxTTL == 0 {
+		vc.MaxTTL = caddy.Duration(5 * time.Minute)
+	}
+	if vc.NegativeTTL == 0 {
+		vc.NegativeTTL = caddy.Duration(10 * time.Second)
+	}
+	vc.cache = newTTLCache(vc.MaxEntries, 0)
+	return nil
+}
+
+// store caches the outcome of the verification, if cacheable.
+func (vc *ValidationCache) store(key string, v verification) {
+	if v.err != nil {
+		if vc.NegativeTTL < 0 || !cacheableFailure(v.err) {
+			return
+		}
+		vc.cache.Set(key, v, time.Duration(vc.NegativeTTL))
+		return
+	}
+	ttl := time.Duration(vc.MaxTTL)
+	if exp := v.token.Expiration(); !exp.IsZero() && time.Until(exp) < ttl {
+		ttl = time.Until(exp)
+	}
+	if ttl > 0 {
+		vc.cache.Set(key, v, ttl)
+	}
+}
+
+// cacheableFailure reports whether the failure of the verification is of
+// the token itself, rather than of the keys or the sandbox.
+func cacheableFailure(err error) bool {
+	return !errors.Is(err, ErrKeyNotFound) &&
+		!errors.Is(err, ErrKeyBlocked) &&
+		!errors.Is(err, errSandboxBusy) &&
+		!errors.Is(err, context.Canceled) &&
+		!errors.Is(err, context.DeadlineExceeded)
+}
+
+// verifySignature parses the signed token and verifies its signature, in
+// the sandbox if SandboxParsing is set, and records the provenance of the
+// key into kp. The outcome is cached if ValidationCache is set.
+func (ja *JWTAuth) verifySignature(ctx context.Context, signedToken string, kp *keyProvenance) (Token, error) {
+	vc := ja.ValidationCache
+	if vc == nil {
+		return ja.parseSigned(ctx, signedToken, kp)
+	}
+	sum := sha256.Sum256([]byte(signedToken))
+	key := string(sum[:])
+	if ja.jwkTenants != nil {
+		// verified by the keys of the URL of the request
+		url, _ := ja.jwkTenants.resolve(ctx)
+		key += url
+	}
+	if cached, ok := vc.cache.Get(key); ok {
+		v := cached.(verification)
+		*kp = v.provenance
+		if v.err == nil {
+			if err := ja.checkKID(kp.KeyID); err != nil {
+				return nil, err
+			}
+		}
+		return v.token, v.err
+	}
+	token, err := ja.parseSigned(ctx, signedToken, kp)
+	vc.store(key, verification{token: token, provenance: *kp, err: err})
+	return token, err
+}
+
+func (ja *JWTAuth) parseSigned(ctx context.Context, signedToken string, kp *keyProvenance) (Token, error) {
+	if ja.SandboxParsing != nil {
+		return ja.SandboxParsing.parse(ctx, signedToken, ja.keyProvider(ctx, kp), ja.parseVerified)
+	}
+	return ja.parseVerified(ctx, signedToken, ja.keyProvider(ctx, kp))
+}
+
+// parseVerified verifies the signature of the token by the keys of provider
+// and parses its claims. The payload of "zip": "DEF" is inflated after the
+// verification, up to MaxDecompressedSize.
+func (ja *JWTAuth) parseVerified(ctx context.Context, signedToken string, provider jws.KeyProvider) (Token, error) {
+	payload, err := jws.Verify([]byte(signedToken), jws.WithKeyProvider(provider), jws.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	if compressed(signedToken) {
+		if payload, err = inflate(payload, ja.maxDecompressedSize()); err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidToken, err)
+		}
+	}
+	if err := checkNumericDates(payload); err != nil {
+		return nil, err
+	}
+	return jwt.Parse(payload, jwt.WithVerify(false), jwt.WithValidate(false))
+}
+
+// checkNumericDates rejects the "exp", "nbf" and "iat" claims of the payload
+// other than NumericDates (RFC 7519 2), e.g. the date strings, which jwx
+// would parse rather than reject.
+func checkNumericDates(payload []byte) error {
+	var dates struct {
+		Exp json.RawMessage `json:"exp"`
+		Nbf json.RawMessage `json:"nbf"`
+		Iat json.RawMessage `json:"iat"`
+	}
+	if err := json.Unmarshal(payload, &dates); err != nil {
+		return fmt.Errorf("%w: %w (claims): %v", ErrInvalidToken, ErrMalformedToken, err)
+	}
+	for _, claim := range []struct {
+		name string
+		raw  json.RawMessage
+	}{{"exp", dates.Exp}, {"nbf", dates.Nbf}, {"iat", dates.Iat}} {
+		if len(claim.raw) > 0 && claim.raw[0] != '-' && (claim.raw[0] < '0' || claim.raw[0] > '9') {
+			return fmt.Errorf("%w: %w (claims): %q is not a NumericDate", ErrInvalidToken, ErrMalformedToken, claim.name)
+		}
+	}
+	return nil
+}
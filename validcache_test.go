@@ -0,0 +1,65 @@
+package caddyjwt
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAuthenticate_ValidationCache(t *testing.T) {
+	ja := &JWTAuth{SignKey: TestSignKey, ValidationCache: &ValidationCache{}, logger: testLogger}
+	assert.Nil(t, ja.Validate())
+
+	authenticate := func(token string) error {
+		r, _ := http.NewRequest("GET", "/", nil)
+		r.Header.Add("Authorization", token)
+		_, _, err := ja.Authenticate(httptest.NewRecorder(), r)
+		return err
+	}
+	token := issueTokenString(MapClaims{"sub": "ggicci", "exp": time.Now().Add(time.Hour).Unix()})
+	assert.Nil(t, authenticate(token))
+	assert.Equal(t, 1, ja.ValidationCache.cache.Len())
+
+	// the signature isn't verified again
+	ja.parsedSignKey = []byte("another key")
+	assert.Nil(t, authenticate(token))
+
+	// but the claims are
+	expired := issueTokenString(MapClaims{"sub": "ggicci", "exp": time.Now().Add(-time.Hour).Unix()})
+	ja.parsedSignKey = RawTestSignKey
+	assert.ErrorIs(t, authenticate(expired), ErrTokenExpired)
+	assert.Equal(t, 1, ja.ValidationCache.cache.Len()) // expired, not cached
+
+	// bad signatures are cached
+	tampered := token[:len(token)-4] + "AAAA"
+	assert.ErrorIs(t, authenticate(tampered), ErrInvalidToken)
+	assert.Equal(t, 2, ja.ValidationCache.cache.Len())
+	assert.ErrorIs(t, authenticate(tampered), ErrInvalidToken)
+
+	ja = &JWTAuth{SignKey: TestSignKey, ValidationCache: &ValidationCache{MaxTTL: -1}, logger: testLogger}
+	assert.ErrorContains(t, ja.Validate(), "max_ttl")
+}
+
+func TestValidationCache_BlockedKID(t *testing.T) {
+	ja := &JWTAuth{JWKURL: TestJWKSetURL, ValidationCache: &ValidationCache{}, logger: testLogger}
+	assert.Nil(t, ja.Validate())
+	defer ja.Cleanup()
+
+	authenticate := func(token string) error {
+		r, _ := http.NewRequest("GET", "/", nil)
+		r.Header.Add("Authorization", token)
+		_, _, err := ja.Authenticate(httptest.NewRecorder(), r)
+		return err
+	}
+	token := issueTokenStringJWK(MapClaims{"sub": "ggicci"})
+	assert.Nil(t, authenticate(token))
+	ja.BlockKIDs = []string{jwkKey.KeyID()}
+	assert.ErrorIs(t, authenticate(token), ErrKeyBlocked)
+
+	// not found keys aren't cached
+	assert.ErrorIs(t, authenticate(issueTokenString(MapClaims{"sub": "ggicci"})), ErrKeyNotFound)
+	assert.Equal(t, 1, ja.ValidationCache.cache.Len())
+}
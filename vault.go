@@ -0,0 +1,403 @@
+package caddyjwt
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/lestrrat-go/jwx/v2/jwa"
+	"github.com/lestrrat-go/jwx/v2/jwk"
+	"go.uber.org/zap"
+)
+
+// vaultMinRefetch is the minimum delay between two fetches of the keys from
+// Vault on demand, e.g. of the tokens of unknown kids, so they can't flood
+// Vault.
+const vaultMinRefetch = 10 * time.Second
+
+// VaultKeys fetches the verification keys from HashiCorp Vault, so neither
+// the keys nor the credentials to fetch them live in the config. The keys
+// are either the public keys of a transit key, see TransitKey, or the JWKs
+// of the identity tokens of Vault, optionally of an OIDC provider of Vault,
+// see IdentityProvider. They are fetched again every Refresh, and on the
+// tokens of unknown kids. It excludes the other key sources.
+type VaultKeys struct {
+	// Address of Vault, e.g. "https://vault.example.com:8200". Defaults to
+	// {env.VAULT_ADDR}.
+	Address string `json:"address,omitempty"`
+
+	// Namespace of Vault Enterprise, if any.
+	Namespace string `json:"namespace,omitempty"`
+
+	// Token authenticates to Vault, unless AppRole. Defaults to
+	// {env.VAULT_TOKEN}. Not required by the identity JWKs, which Vault
+	// publishes without authentication.
+	Token string `json:"token,omitempty"`
+
+	// AppRole authenticates to Vault by logging in with an AppRole, rather
+	// than by Token, again as the token obtained expires.
+	AppRole *VaultAppRole `json:"approle,omitempty"`
+
+	// TransitKey is the name of the transit key whose public keys verify the
+	// tokens, i.e. the tokens signed by Vault's transit/sign. The keys are
+	// identified by their versions, i.e. a token must have the kid of the
+	// version of the key signing it, e.g. "1". It must be of an asymmetric
+	// type, e.g. "ecdsa-p256" or "ed25519".
+	TransitKey string `json:"transit_key,omitempty"`
+
+	// TransitMount is the mount path of the transit secrets engine. Defaults
+	// to "transit".
+	TransitMount string `json:"transit_mount,omitempty"`
+
+	// IdentityProvider is the name of the OIDC provider of Vault whose JWKs
+	// verify the tokens. If empty, and not TransitKey, the JWKs of the
+	// identity tokens of Vault are.
+	IdentityProvider string `json:"identity_provider,omitempty"`
+
+	// Refresh is the interval of fetching the keys again. Defaults to 5m.
+	Refresh caddy.Duration `json:"refresh,omitempty"`
+
+	// Timeout is the timeout of each call to Vault. Defaults to 10s.
+	Timeout caddy.Duration `json:"timeout,omitempty"`
+
+	client *http.Client
+
+	mu          sync.Mutex // guards token, tokenExpiry and fetchedAt
+	token       string
+	tokenExpiry time.Time // of the token of AppRole, zero if it never expires
+	fetchedAt   time.Time
+}
+
+// VaultAppRole is the AppRole to log in to Vault with. Both the IDs support
+// placeholders, e.g. {env.VAULT_SECRET_ID}, or {file./run/secrets/secret_id}.
+type VaultAppRole struct {
+	RoleID   string `json:"role_id"`
+	SecretID string `json:"secret_id,omitempty"`
+
+	// Mount is the mount path of the AppRole auth method. Defaults to
+	// "approle".
+	Mount string `json:"mount,omitempty"`
+}
+
+func (v *VaultKeys) provision() error {
+	repl := caddy.NewReplacer()
+	if v.Address == "" {
+		v.Address = "{env.VAULT_ADDR}"
+	}
+	v.Address = strings.TrimSuffix(repl.ReplaceKnown(v.Address, ""), "/")
+	if u, err := url.Parse(v.Address); err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+		return fmt.Errorf("invalid address: %q", v.Address)
+	}
+	v.Namespace = repl.ReplaceKnown(v.Namespace, "")
+
+	if v.TransitKey != "" && v.IdentityProvider != "" {
+		return fmt.Errorf("transit_key and identity_provider are mutually exclusive")
+	}
+	if v.TransitMount == "" {
+		v.TransitMount = "transit"
+	}
+	if v.Refresh < 0 {
+		return fmt.Errorf("invalid refresh: %s", time.Duration(v.Refresh))
+	}
+	if v.Refresh == 0 {
+		v.Refresh = caddy.Duration(5 * time.Minute)
+	}
+	if v.Timeout < 0 {
+		return fmt.Errorf("invalid timeout: %s", time.Duration(v.Timeout))
+	}
+	if v.Timeout == 0 {
+		v.Timeout = caddy.Duration(10 * time.Second)
+	}
+	v.client = &http.Client{Timeout: time.Duration(v.Timeout)}
+
+	if v.AppRole != nil {
+		if v.Token != "" {
+			return fmt.Errorf("token and approle are mutually exclusive")
+		}
+		v.AppRole.RoleID = repl.ReplaceKnown(v.AppRole.RoleID, "")
+		v.AppRole.SecretID = repl.ReplaceKnown(v.AppRole.SecretID, "")
+		if v.AppRole.RoleID == "" {
+			return fmt.Errorf("invalid approle: missing role_id")
+		}
+		if v.AppRole.Mount == "" {
+			v.AppRole.Mount = "approle"
+		}
+		return nil
+	}
+	if v.Token == "" {
+		v.Token = "{env.VAULT_TOKEN}"
+	}
+	v.token = repl.ReplaceKnown(v.Token, "")
+	if v.token == "" && v.TransitKey != "" {
+		return fmt.Errorf("missing token, or approle, to read transit_key")
+	}
+	return nil
+}
+
+// location returns the URL of the keys.
+func (v *VaultKeys) location() string {
+	switch {
+	case v.TransitKey != "":
+		return v.Address + "/v1/" + v.TransitMount + "/keys/" + url.PathEscape(v.TransitKey)
+	case v.IdentityProvider != "":
+		return v.Address + "/v1/identity/oidc/provider/" + url.PathEscape(v.IdentityProvider) + "/.well-known/keys"
+	default:
+		return v.Address + "/v1/identity/oidc/.well-known/keys"
+	}
+}
+
+// fetchKeys fetches and indexes the keys.
+func (v *VaultKeys) fetchKeys(ctx context.Context) (*keyIndex, error) {
+	v.mu.Lock()
+	v.fetchedAt = time.Now()
+	v.mu.Unlock()
+
+	loc := v.location()
+	body, err := v.call(ctx, http.MethodGet, loc, nil, v.TransitKey != "")
+	if err != nil {
+		return nil, err
+	}
+	if v.TransitKey == "" {
+		return parseKeyIndex(loc, body)
+	}
+	set, err := parseTransitKeys(body)
+	if err != nil {
+		return nil, fmt.Errorf("transit key %q: %w", v.TransitKey, err)
+	}
+	return indexKeySet(loc, set), nil
+}
+
+// refetchAllowed reports whether the keys may be fetched again on demand,
+// see vaultMinRefetch.
+func (v *VaultKeys) refetchAllowed() bool {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	return time.Since(v.fetchedAt) >= vaultMinRefetch
+}
+
+// call calls the Vault API, authenticated if auth, logging in again once if
+// the token of AppRole is rejected.
+func (v *VaultKeys) call(ctx context.Context, method, endpoint string, payload interface{}, auth bool) ([]byte, error) {
+	relogin := false
+	for {
+		var token string
+		if auth {
+			var err error
+			if token, err = v.authToken(ctx, relogin); err != nil {
+				return nil, err
+			}
+		}
+		body, status, err := v.do(ctx, method, endpoint, payload, token)
+		if status == http.StatusForbidden && auth && v.AppRole != nil && !relogin {
+			relogin = true
+			continue
+		}
+		return body, err
+	}
+}
+
+func (v *VaultKeys) do(ctx context.Context, method, endpoint string, payload interface{}, token string) ([]byte, int, error) {
+	var reqBody io.Reader
+	if payload != nil {
+		data, err := json.Marshal(payload)
+		if err != nil {
+			return nil, 0, err
+		}
+		reqBody = bytes.NewReader(data)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, endpoint, reqBody)
+	if err != nil {
+		return nil, 0, err
+	}
+	req.Header.Set("Accept", "application/json")
+	if token != "" {
+		req.Header.Set("X-Vault-Token", token)
+	}
+	if v.Namespace != "" {
+		req.Header.Set("X-Vault-Namespace", v.Namespace)
+	}
+	resp, err := v.client.Do(req)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return nil, resp.StatusCode, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		var vaultErr struct {
+			Errors []string `json:"errors"`
+		}
+		_ = json.Unmarshal(body, &vaultErr)
+		return nil, resp.StatusCode, fmt.Errorf("%s %s: %s %s", method, endpoint, resp.Status, strings.Join(vaultErr.Errors, "; "))
+	}
+	return body, resp.StatusCode, nil
+}
+
+// authToken returns the token to authenticate to Vault, of AppRole logged
+// in if there's none, or it has expired, or relogin.
+func (v *VaultKeys) authToken(ctx context.Context, relogin bool) (string, error) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if v.AppRole == nil {
+		return v.token, nil
+	}
+	if v.token != "" && !relogin && (v.tokenExpiry.IsZero() || time.Now().Before(v.tokenExpiry)) {
+		return v.token, nil
+	}
+	credentials := map[string]string{"role_id": v.AppRole.RoleID}
+	if v.AppRole.SecretID != "" {
+		credentials["secret_id"] = v.AppRole.SecretID
+	}
+	body, _, err := v.do(ctx, http.MethodPost, v.Address+"/v1/auth/"+v.AppRole.Mount+"/login", credentials, "")
+	if err != nil {
+		return "", fmt.Errorf("approle login: %w", err)
+	}
+	var login struct {
+		Auth struct {
+			ClientToken   string `json:"client_token"`
+			LeaseDuration int64  `json:"lease_duration"` // in seconds
+		} `json:"auth"`
+	}
+	if err := json.Unmarshal(body, &login); err != nil || login.Auth.ClientToken == "" {
+		return "", fmt.Errorf("approle login: no client_token in the response")
+	}
+	v.token, v.tokenExpiry = login.Auth.ClientToken, time.Time{}
+	if lease := time.Duration(login.Auth.LeaseDuration) * time.Second; lease > 0 {
+		// logged in again ahead of the expiry
+		v.tokenExpiry = time.Now().Add(lease * 2 / 3)
+	}
+	return v.token, nil
+}
+
+// transitKeyAlgorithms are the algorithms of the types of the transit keys.
+// The RSA keys are left to the "alg" of the tokens, either PKCS#1 v1.5 or
+// PSS, as Vault signs by either.
+var transitKeyAlgorithms = map[string]jwa.SignatureAlgorithm{
+	"ecdsa-p256": jwa.ES256,
+	"ecdsa-p384": jwa.ES384,
+	"ecdsa-p521": jwa.ES512,
+	"ed25519":    jwa.EdDSA,
+	"rsa-2048":   "",
+	"rsa-3072":   "",
+	"rsa-4096":   "",
+}
+
+// parseTransitKeys converts the public keys of the versions of a transit
+// key, read from transit/keys/<name>, to a JWK set, by their versions as
+// the kids.
+func parseTransitKeys(body []byte) (jwk.Set, error) {
+	var resp struct {
+		Data struct {
+			Type string                     `json:"type"`
+			Keys map[string]json.RawMessage `json:"keys"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(body, &resp); err != nil {
+		return nil, err
+	}
+	alg, ok := transitKeyAlgorithms[resp.Data.Type]
+	if !ok {
+		return nil, fmt.Errorf("type %q has no public keys", resp.Data.Type)
+	}
+	set := jwk.NewSet()
+	for version, raw := range resp.Data.Keys {
+		var entry struct {
+			PublicKey string `json:"public_key"`
+		}
+		if err := json.Unmarshal(raw, &entry); err != nil {
+			return nil, fmt.Errorf("version %s: %w", version, err)
+		}
+		var (
+			key jwk.Key
+			err error
+		)
+		if resp.Data.Type == "ed25519" {
+			var pub []byte
+			if pub, err = base64.StdEncoding.DecodeString(entry.PublicKey); err == nil {
+				if len(pub) != ed25519.PublicKeySize {
+					err = fmt.Errorf("invalid ed25519 public key size %d", len(pub))
+				} else {
+					key, err = jwk.FromRaw(ed25519.PublicKey(pub))
+				}
+			}
+		} else {
+			key, err = jwk.ParseKey([]byte(entry.PublicKey), jwk.WithPEM(true))
+		}
+		if err != nil {
+			return nil, fmt.Errorf("version %s: %w", version, err)
+		}
+		_ = key.Set(jwk.KeyIDKey, version)
+		if alg != "" {
+			_ = key.Set(jwk.AlgorithmKey, alg)
+		}
+		_ = set.AddKey(key)
+	}
+	return set, nil
+}
+
+// setupVault fetches the keys from Vault, and again every Refresh until
+// stopJWKLoader.
+func (ja *JWTAuth) setupVault() error {
+	if err := ja.Vault.provision(); err != nil {
+		return fmt.Errorf("invalid vault: %w", err)
+	}
+	ja.jwkMu = new(sync.RWMutex)
+	ja.jwkURL = ja.Vault.location()
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	ja.stopJWKLoader = func() {
+		cancel()
+		<-done
+	}
+
+	// ignore any error fetching the keys now as Vault may not be available at startup,
+	// unless RequireKeysAtStartup
+	if err := ja.loadVaultKeys(ctx); err != nil {
+		ja.logger.Error("failed to fetch JWKs from Vault", zap.String("url", ja.jwkURL), zap.Error(err))
+	} else {
+		ja.logger.Info("using JWKs from Vault", zap.String("url", ja.jwkURL), zap.Int("loaded_keys", ja.jwkIndex.len()))
+	}
+	go func() {
+		defer close(done)
+		ticker := time.NewTicker(time.Duration(ja.Vault.Refresh))
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if err := ja.loadVaultKeys(ctx); err != nil && ctx.Err() == nil {
+					ja.logger.Error("failed to refresh JWKs from Vault, the previous keys stay in use", zap.String("url", ja.jwkURL), zap.Error(err))
+				}
+			}
+		}
+	}()
+	return nil
+}
+
+// loadVaultKeys fetches the keys from Vault, swapped in only if fetched.
+func (ja *JWTAuth) loadVaultKeys(ctx context.Context) error {
+	idx, err := ja.Vault.fetchKeys(ctx)
+	ja.jwkMu.Lock()
+	defer ja.jwkMu.Unlock()
+	if err != nil {
+		if ja.jwkIndex == nil {
+			ja.jwkLoadErr = err
+		}
+		return err
+	}
+	ja.jwkIndex, ja.jwkLoadErr = idx, nil
+	return nil
+}
@@ -0,0 +1,236 @@
+package caddyjwt
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"github.com/caddyserver/caddy/v2/caddyconfig/httpcaddyfile"
+	"github.com/caddyserver/caddy/v2/modules/caddyhttp/caddyauth"
+	"github.com/lestrrat-go/jwx/v2/jwa"
+	"github.com/lestrrat-go/jwx/v2/jwk"
+	"github.com/lestrrat-go/jwx/v2/jws"
+	"github.com/lestrrat-go/jwx/v2/jwt"
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeVault serves the transit key "tokens" of the ECDSA key, the identity
+// JWKs of the key, and the AppRole login of "role"/"secret".
+type fakeVault struct {
+	key    *ecdsa.PrivateKey
+	logins atomic.Int32
+	revoke atomic.Bool // rejects the tokens logged in so far
+	server *httptest.Server
+}
+
+func newFakeVault(t *testing.T) *fakeVault {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	panicOnError(err)
+	fv := &fakeVault{key: key}
+	der, err := x509.MarshalPKIXPublicKey(key.Public())
+	panicOnError(err)
+	pub := string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}))
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v1/auth/approle/login", func(w http.ResponseWriter, r *http.Request) {
+		var creds map[string]string
+		_ = json.NewDecoder(r.Body).Decode(&creds)
+		if creds["role_id"] != "role" || creds["secret_id"] != "secret" {
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprint(w, `{"errors": ["invalid role or secret ID"]}`)
+			return
+		}
+		n := fv.logins.Add(1)
+		fv.revoke.Store(false)
+		fmt.Fprintf(w, `{"auth": {"client_token": "hvs.approle%d", "lease_duration": 3600}}`, n)
+	})
+	mux.HandleFunc("/v1/transit/keys/tokens", func(w http.ResponseWriter, r *http.Request) {
+		token := r.Header.Get("X-Vault-Token")
+		if token == "" || fv.revoke.Load() && token != "hvs.static" {
+			w.WriteHeader(http.StatusForbidden)
+			fmt.Fprint(w, `{"errors": ["permission denied"]}`)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"data": map[string]interface{}{
+				"type": "ecdsa-p256",
+				"keys": map[string]interface{}{"1": map[string]string{"public_key": pub}},
+			},
+		})
+	})
+	mux.HandleFunc("/v1/identity/oidc/provider/default/.well-known/keys", func(w http.ResponseWriter, r *http.Request) {
+		key, _ := jwk.FromRaw(key.Public())
+		_ = key.Set(jwk.KeyIDKey, "identity-key")
+		_ = key.Set(jwk.AlgorithmKey, jwa.ES256)
+		set := jwk.NewSet()
+		_ = set.AddKey(key)
+		json.NewEncoder(w).Encode(set)
+	})
+	fv.server = httptest.NewServer(mux)
+	t.Cleanup(fv.server.Close)
+	return fv
+}
+
+func (fv *fakeVault) sign(kid string, claims MapClaims) string {
+	headers := jws.NewHeaders()
+	_ = headers.Set(jws.KeyIDKey, kid)
+	signed, err := jwt.Sign(buildToken(claims), jwt.WithKey(jwa.ES256, fv.key, jws.WithProtectedHeaders(headers)))
+	panicOnError(err)
+	return string(signed)
+}
+
+func TestAuthenticate_Vault(t *testing.T) {
+	fv := newFakeVault(t)
+	authenticate := func(ja *JWTAuth, token string) (User, error) {
+		r, _ := http.NewRequest("GET", "/", nil)
+		r.Header.Set("Authorization", "Bearer "+token)
+		user, _, err := ja.Authenticate(httptest.NewRecorder(), r)
+		return user, err
+	}
+
+	// transit key, by a token
+	t.Setenv("VAULT_ADDR", fv.server.URL)
+	t.Setenv("VAULT_TOKEN", "hvs.static")
+	ja := &JWTAuth{Vault: &VaultKeys{TransitKey: "tokens"}, logger: testLogger}
+	assert.Nil(t, ja.Validate())
+	defer ja.Cleanup()
+	ready, err := ja.keysReady()
+	assert.True(t, ready)
+	assert.Nil(t, err)
+	user, err := authenticate(ja, fv.sign("1", MapClaims{"sub": "ggicci"}))
+	assert.Nil(t, err)
+	assert.Equal(t, "ggicci", user.ID)
+	_, err = authenticate(ja, fv.sign("2", MapClaims{"sub": "ggicci"}))
+	assert.ErrorIs(t, err, ErrKeyNotFound)
+
+	// transit key, by an AppRole, logging in again once the token is rejected
+	ja = &JWTAuth{
+		Vault:  &VaultKeys{Address: fv.server.URL, TransitKey: "tokens", AppRole: &VaultAppRole{RoleID: "role", SecretID: "secret"}},
+		logger: testLogger,
+	}
+	assert.Nil(t, ja.Validate())
+	defer ja.Cleanup()
+	assert.EqualValues(t, 1, fv.logins.Load())
+	fv.revoke.Store(true)
+	assert.Nil(t, ja.loadVaultKeys(context.Background()))
+	assert.EqualValues(t, 2, fv.logins.Load())
+	_, err = authenticate(ja, fv.sign("1", MapClaims{"sub": "ggicci"}))
+	assert.Nil(t, err)
+
+	// identity JWKs of an OIDC provider, without any token
+	t.Setenv("VAULT_TOKEN", "")
+	ja = &JWTAuth{Vault: &VaultKeys{IdentityProvider: "default"}, logger: testLogger}
+	assert.Nil(t, ja.Validate())
+	defer ja.Cleanup()
+	_, err = authenticate(ja, fv.sign("identity-key", MapClaims{"sub": "ggicci"}))
+	assert.Nil(t, err)
+
+	// Vault unavailable at startup
+	ja = &JWTAuth{Vault: &VaultKeys{Address: "http://127.0.0.1:1", IdentityProvider: "default"}, logger: testLogger}
+	assert.Nil(t, ja.Validate())
+	defer ja.Cleanup()
+	ready, err = ja.keysReady()
+	assert.False(t, ready)
+	assert.NotNil(t, err)
+	_, err = authenticate(ja, fv.sign("identity-key", MapClaims{"sub": "ggicci"}))
+	assert.ErrorIs(t, err, ErrKeyNotFound)
+}
+
+func TestVaultKeys_Provision(t *testing.T) {
+	t.Setenv("VAULT_ADDR", "")
+	t.Setenv("VAULT_TOKEN", "")
+	for _, tc := range []struct {
+		vault   *VaultKeys
+		wantErr string
+	}{
+		{&VaultKeys{}, "invalid address"},
+		{&VaultKeys{Address: "vault:8200"}, "invalid address"},
+		{&VaultKeys{Address: "https://vault:8200", TransitKey: "tokens"}, "missing token"},
+		{&VaultKeys{Address: "https://vault:8200", TransitKey: "tokens", IdentityProvider: "default"}, "mutually exclusive"},
+		{&VaultKeys{Address: "https://vault:8200", Token: "t", AppRole: &VaultAppRole{RoleID: "role"}}, "mutually exclusive"},
+		{&VaultKeys{Address: "https://vault:8200", AppRole: &VaultAppRole{}}, "missing role_id"},
+		{&VaultKeys{Address: "https://vault:8200", Refresh: -1}, "invalid refresh"},
+	} {
+		assert.ErrorContains(t, tc.vault.provision(), tc.wantErr, tc.vault)
+	}
+
+	vault := VaultKeys{Address: "https://vault:8200/", AppRole: &VaultAppRole{RoleID: "role"}}
+	assert.Nil(t, vault.provision())
+	assert.Equal(t, "https://vault:8200/v1/identity/oidc/.well-known/keys", vault.location())
+	assert.Equal(t, "approle", vault.AppRole.Mount)
+
+	ja := &JWTAuth{SignKey: TestSignKey, Vault: &VaultKeys{Address: "https://vault:8200"}, logger: testLogger}
+	assert.ErrorContains(t, ja.Validate(), "invalid vault")
+}
+
+func TestParseTransitKeys(t *testing.T) {
+	pub, _, err := ed25519.GenerateKey(rand.Reader)
+	panicOnError(err)
+	set, err := parseTransitKeys([]byte(fmt.Sprintf(`{"data": {"type": "ed25519", "keys": {"3": {"public_key": %q}}}}`,
+		base64.StdEncoding.EncodeToString(pub))))
+	assert.Nil(t, err)
+	key, ok := set.LookupKeyID("3")
+	assert.True(t, ok)
+	assert.Equal(t, jwa.EdDSA, key.Algorithm())
+
+	_, err = parseTransitKeys([]byte(`{"data": {"type": "aes256-gcm96", "keys": {"1": 1700000000}}}`))
+	assert.ErrorContains(t, err, "has no public keys")
+}
+
+func TestParsingCaddyfileVault(t *testing.T) {
+	helper := httpcaddyfile.Helper{
+		Dispenser: caddyfile.NewTestDispenser(`
+	jwtauth {
+		vault https://vault.example.com:8200 {
+			namespace team-a
+			approle {env.VAULT_ROLE_ID} {file./run/secrets/secret_id}
+			approle_mount ci
+			transit_key tokens
+			refresh 1m
+		}
+	}
+	`),
+	}
+	h, err := parseCaddyfile(helper)
+	assert.Nil(t, err)
+	var ja JWTAuth
+	assert.Nil(t, json.Unmarshal(h.(caddyauth.Authentication).ProvidersRaw["jwt"], &ja))
+	assert.Equal(t, "https://vault.example.com:8200", ja.Vault.Address)
+	assert.Equal(t, "team-a", ja.Vault.Namespace)
+	assert.Equal(t, &VaultAppRole{RoleID: "{env.VAULT_ROLE_ID}", SecretID: "{file./run/secrets/secret_id}", Mount: "ci"}, ja.Vault.AppRole)
+	assert.Equal(t, "tokens", ja.Vault.TransitKey)
+	assert.EqualValues(t, 60e9, ja.Vault.Refresh)
+
+	for _, body := range []string{
+		`jwtauth {
+			vault {
+				approle_mount ci
+			}
+		}`,
+		`jwtauth {
+			vault {
+				approle
+			}
+		}`,
+		`jwtauth {
+			vault {
+				unknown
+			}
+		}`,
+	} {
+		_, err = parseCaddyfile(httpcaddyfile.Helper{Dispenser: caddyfile.NewTestDispenser(body)})
+		assert.NotNil(t, err)
+	}
+}
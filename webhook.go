@@ -0,0 +1,178 @@
+package caddyjwt
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+	"go.uber.org/zap"
+)
+
+// DenyWebhook posts a summary of each request denied for a bad token to a
+// URL, e.g. of the SOC tooling, asynchronously. The summaries are queued and
+// posted in batches as a JSON array. When the queue is full, e.g. the URL is
+// down, new summaries are dropped rather than slowing down the requests.
+//
+// Requests without any token are not reported. The tokens themselves are
+// never sent.
+type DenyWebhook struct {
+	// URL receives the batches by POST. Required.
+	URL string `json:"url"`
+
+	// BatchSize is the maximum number of summaries per POST. Defaults to 100.
+	BatchSize int `json:"batch_size"`
+
+	// FlushInterval is how long a summary waits for a batch to fill up
+	// before being posted anyway. Defaults to 1s.
+	FlushInterval caddy.Duration `json:"flush_interval"`
+
+	// QueueSize bounds the number of summaries waiting to be posted.
+	// Defaults to 1000.
+	QueueSize int `json:"queue_size"`
+
+	// Timeout is the timeout of each POST. Defaults to 2s.
+	Timeout caddy.Duration `json:"timeout"`
+
+	client *http.Client
+	logger *zap.Logger
+	queue  chan denyEvent
+	stop   context.CancelFunc
+	done   sync.WaitGroup
+}
+
+// denyEvent is the summary of a denied request.
+type denyEvent struct {
+	Time      time.Time `json:"time"`
+	Reason    string    `json:"reason"`
+	Error     string    `json:"error"`
+	Issuer    string    `json:"issuer,omitempty"`
+	RequestID string    `json:"request_id,omitempty"`
+	Method    string    `json:"method"`
+	Host      string    `json:"host"`
+	Path      string    `json:"path"`
+	RemoteIP  string    `json:"remote_ip"`
+}
+
+func (wh *DenyWebhook) provision(logger *zap.Logger) error {
+	if wh.URL == "" {
+		return fmt.Errorf("missing url")
+	}
+	if _, err := url.Parse(wh.URL); err != nil {
+		return fmt.Errorf("invalid url: %w", err)
+	}
+	if wh.BatchSize < 0 || wh.QueueSize < 0 {
+		return fmt.Errorf("invalid batch_size or queue_size: %d, %d", wh.BatchSize, wh.QueueSize)
+	}
+	if wh.BatchSize == 0 {
+		wh.BatchSize = 100
+	}
+	if wh.FlushInterval == 0 {
+		wh.FlushInterval = caddy.Duration(time.Second)
+	}
+	if wh.QueueSize == 0 {
+		wh.QueueSize = 1000
+	}
+	if wh.Timeout == 0 {
+		wh.Timeout = caddy.Duration(2 * time.Second)
+	}
+	wh.client = &http.Client{Timeout: time.Duration(wh.Timeout)}
+	wh.logger = logger
+	wh.queue = make(chan denyEvent, wh.QueueSize)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	wh.stop = cancel
+	wh.done.Add(1)
+	go wh.run(ctx)
+	return nil
+}
+
+// cleanup stops the webhook after posting the queued summaries.
+func (wh *DenyWebhook) cleanup() {
+	wh.stop()
+	wh.done.Wait()
+}
+
+// notify queues the summary of the denied request without blocking.
+func (wh *DenyWebhook) notify(r *http.Request, err error, issuer, requestID string) {
+	event := denyEvent{
+		Time:      time.Now().UTC(),
+		Reason:    failureReason(err),
+		Error:     err.Error(),
+		Issuer:    issuer,
+		RequestID: requestID,
+		Method:    r.Method,
+		Host:      r.Host,
+		Path:      r.URL.Path,
+		RemoteIP:  clientIP(r),
+	}
+	select {
+	case wh.queue <- event:
+	default:
+		metrics.denyWebhookDropped.Inc()
+	}
+}
+
+// run posts the queued summaries in batches until the context is done, then
+// posts what's left in the queue.
+func (wh *DenyWebhook) run(ctx context.Context) {
+	defer wh.done.Done()
+	ticker := time.NewTicker(time.Duration(wh.FlushInterval))
+	defer ticker.Stop()
+
+	batch := make([]denyEvent, 0, wh.BatchSize)
+	flush := func() {
+		if len(batch) > 0 {
+			wh.post(batch)
+			batch = batch[:0]
+		}
+	}
+	for {
+		select {
+		case event := <-wh.queue:
+			batch = append(batch, event)
+			if len(batch) >= wh.BatchSize {
+				flush()
+			}
+		case <-ticker.C:
+			flush()
+		case <-ctx.Done():
+			for {
+				select {
+				case event := <-wh.queue:
+					batch = append(batch, event)
+					if len(batch) >= wh.BatchSize {
+						flush()
+					}
+				default:
+					flush()
+					return
+				}
+			}
+		}
+	}
+}
+
+func (wh *DenyWebhook) post(batch []denyEvent) {
+	body, err := json.Marshal(batch)
+	if err != nil {
+		wh.logger.Error("failed to encode deny events", zap.Error(err))
+		return
+	}
+	resp, err := wh.client.Post(wh.URL, "application/json", bytes.NewReader(body))
+	if err != nil {
+		metrics.denyWebhookDropped.Add(float64(len(batch)))
+		wh.logger.Warn("failed to post deny events", zap.String("url", wh.URL), zap.Int("events", len(batch)), zap.Error(err))
+		return
+	}
+	resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		metrics.denyWebhookDropped.Add(float64(len(batch)))
+		wh.logger.Warn("failed to post deny events", zap.String("url", wh.URL), zap.Int("events", len(batch)), zap.String("status", resp.Status))
+	}
+}
@@ -0,0 +1,82 @@
+package caddyjwt
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAuthenticate_DenyWebhook(t *testing.T) {
+	var (
+		mu      sync.Mutex
+		batches [][]denyEvent
+	)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var batch []denyEvent
+		assert.Nil(t, json.NewDecoder(r.Body).Decode(&batch))
+		mu.Lock()
+		batches = append(batches, batch)
+		mu.Unlock()
+	}))
+	defer server.Close()
+
+	ja := &JWTAuth{
+		SignKey: TestSignKey,
+		DenyWebhook: &DenyWebhook{
+			URL:           server.URL,
+			BatchSize:     2,
+			FlushInterval: caddy.Duration(time.Hour),
+		},
+		logger: testLogger,
+	}
+	assert.Nil(t, ja.Validate())
+
+	authenticate := func(token string) {
+		r, _ := http.NewRequest("GET", "https://example.com/admin", nil)
+		r.RemoteAddr = "192.0.2.1:4321"
+		if token != "" {
+			r.Header.Add("Authorization", "Bearer "+token)
+		}
+		ja.Authenticate(httptest.NewRecorder(), r)
+	}
+	authenticate(issueTokenString(MapClaims{"sub": "ggicci"})) // accepted
+	authenticate("")                                           // no token, not reported
+	authenticate(issueTokenString(MapClaims{"sub": "ggicci", "exp": 689702400}))
+	authenticate("invalid")
+	authenticate(issueTokenString(MapClaims{"login": "ggicci", "sub": ""}))
+
+	// the last one is posted on cleanup
+	assert.Nil(t, ja.Cleanup())
+	mu.Lock()
+	defer mu.Unlock()
+	assert.Len(t, batches, 2)
+	assert.Len(t, batches[0], 2)
+	assert.Len(t, batches[1], 1)
+	event := batches[0][0]
+	assert.Equal(t, "token_expired", event.Reason)
+	assert.Equal(t, "GET", event.Method)
+	assert.Equal(t, "example.com", event.Host)
+	assert.Equal(t, "/admin", event.Path)
+	assert.Equal(t, "192.0.2.1", event.RemoteIP)
+	assert.Equal(t, "invalid_token", batches[0][1].Reason)
+}
+
+func TestDenyWebhook_QueueFull(t *testing.T) {
+	wh := &DenyWebhook{URL: "http://127.0.0.1:0", QueueSize: 1}
+	wh.queue = make(chan denyEvent, wh.QueueSize)
+	r, _ := http.NewRequest("GET", "/", nil)
+	wh.notify(r, ErrInvalidToken, "", "")
+	wh.notify(r, ErrInvalidToken, "", "") // dropped without blocking
+	assert.Len(t, wh.queue, 1)
+}
+
+func TestDenyWebhook_Provision(t *testing.T) {
+	assert.ErrorContains(t, (&DenyWebhook{}).provision(testLogger), "missing url")
+	assert.ErrorContains(t, (&DenyWebhook{URL: "http://x", BatchSize: -1}).provision(testLogger), "invalid batch_size")
+}